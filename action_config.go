@@ -31,6 +31,10 @@ type ActionConfig interface {
 	Data() interface{}
 	ConfigData() map[string]interface{}
 	SetConfigData(map[string]interface{}) error
+	OnError() map[string]interface{}
+	SetOnError(map[string]interface{}) error
+	When() map[string]interface{}
+	SetWhen(map[string]interface{}) error
 	Name() string
 	Description() string
 	ActionType() string
@@ -87,6 +91,22 @@ var ActionConfigForm = forms.Form{
 			Validators: append([]forms.Validator{
 				forms.IsOptional{Default: ""}}, DescriptionValidators...),
 		},
+		{
+			// what happens to items the action fails on (see ErrorHandling)
+			Name: "on-error",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				IsErrorHandling{},
+			},
+		},
+		{
+			// only items matching this predicate are processed by the action
+			Name: "when",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				IsPredicate{},
+			},
+		},
 	},
 }
 
@@ -150,6 +170,13 @@ func (b *BaseActionConfig) Update(values map[string]interface{}) error {
 		if err := b.checkActionConfig(actionType, actionConfig); err != nil {
 			return err
 		}
+		onError, ok := params["on-error"].(map[string]interface{})
+		if !ok {
+			onError = b.Self.OnError()
+		}
+		if err := checkErrorHandling(onError, actionConfig); err != nil {
+			return err
+		}
 		return b.update(params)
 	}
 
@@ -165,6 +192,10 @@ func (b *BaseActionConfig) Create(values map[string]interface{}) error {
 		if err := b.checkActionConfig(actionType, actionConfig); err != nil {
 			return fmt.Errorf("error checking action config: %v", err)
 		}
+		onError, _ := params["on-error"].(map[string]interface{})
+		if err := checkErrorHandling(onError, actionConfig); err != nil {
+			return fmt.Errorf("error checking action config: %v", err)
+		}
 		return b.update(params)
 	}
 
@@ -190,6 +221,12 @@ func (b *BaseActionConfig) update(params map[string]interface{}) error {
 			err = b.Self.SetConfigData(value.(map[string]interface{}))
 		case "data":
 			err = b.Self.SetData(value)
+		case "on-error":
+			onError, _ := value.(map[string]interface{})
+			err = b.Self.SetOnError(onError)
+		case "when":
+			when, _ := value.(map[string]interface{})
+			err = b.Self.SetWhen(when)
 		}
 		if err != nil {
 			return err
@@ -210,6 +247,14 @@ func (b *BaseActionConfig) MarshalJSON() ([]byte, error) {
 		"config":      b.Self.ConfigData(),
 	}
 
+	if onError := b.Self.OnError(); onError != nil {
+		data["on-error"] = onError
+	}
+
+	if when := b.Self.When(); when != nil {
+		data["when"] = when
+	}
+
 	for k, v := range JSONData(b.Self) {
 		data[k] = v
 	}
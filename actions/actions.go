@@ -58,4 +58,9 @@ var Actions = kodex.ActionDefinitions{
 		Maker: MakeDropAction,
 		// to do: add form
 	},
+	"route": kodex.ActionDefinition{
+		Name:  "Route",
+		Maker: MakeRouteAction,
+		Form:  &RouteActionConfigForm,
+	},
 }
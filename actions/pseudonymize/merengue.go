@@ -33,6 +33,7 @@ var MerengueConfigForm = forms.Form{
 			Validators: []forms.Validator{
 				forms.IsOptional{},
 				forms.IsString{},
+				kodex.IsSecret{},
 			},
 		},
 		{
@@ -74,6 +74,7 @@ var StructuredPseudonymizerForm = forms.Form{
 			Validators: []forms.Validator{
 				forms.IsOptional{},
 				forms.IsString{},
+				kodex.IsSecret{},
 			},
 		},
 		{
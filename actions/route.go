@@ -0,0 +1,180 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package actions
+
+import (
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/kodex"
+)
+
+var RouteForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the route form",
+	Fields: []forms.Field{
+		{
+			Name: "when",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				kodex.IsPredicate{},
+			},
+		},
+		{
+			Name: "channel",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{MinLength: 1},
+			},
+		},
+	},
+}
+
+var RouteActionConfigForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the route action form",
+	Fields: []forms.Field{
+		{
+			Name: "routes",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsList{
+					Validators: []forms.Validator{
+						forms.IsStringMap{
+							Form: &RouteForm,
+						},
+					},
+				},
+			},
+		},
+		{
+			// the channel for items that match no route (if any)
+			Name: "default",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// if set, an item is written to all matching routes instead
+			// of only the first one
+			Name: "all",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: false},
+				forms.IsBoolean{},
+			},
+		},
+		{
+			// if set, routed items are processed further by the
+			// following actions as well
+			Name: "continue",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: false},
+				forms.IsBoolean{},
+			},
+		},
+	},
+}
+
+type Route struct {
+	Predicate kodex.Predicate
+	Channel   string
+}
+
+// Writes items to different channels depending on which route they match.
+// Routed items are removed from the stream (unless 'continue' is set),
+// while items that match no route are processed further.
+type RouteAction struct {
+	kodex.BaseAction
+	routes         []Route
+	defaultChannel string
+	all            bool
+	continue_      bool
+}
+
+func MakeRouteAction(spec kodex.ActionSpecification) (kodex.Action, error) {
+	params, err := RouteActionConfigForm.Validate(spec.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	routesList := params["routes"].([]interface{})
+	routes := make([]Route, len(routesList))
+
+	for i, routeParams := range routesList {
+		routeMap := routeParams.(map[string]interface{})
+		if predicate, err := kodex.MakePredicate(routeMap["when"]); err != nil {
+			return nil, err
+		} else {
+			routes[i] = Route{
+				Predicate: predicate,
+				Channel:   routeMap["channel"].(string),
+			}
+		}
+	}
+
+	return &RouteAction{
+		BaseAction:     kodex.MakeBaseAction(spec, "route"),
+		routes:         routes,
+		defaultChannel: params["default"].(string),
+		all:            params["all"].(bool),
+		continue_:      params["continue"].(bool),
+	}, nil
+}
+
+func (a *RouteAction) Params() interface{} {
+	return nil
+}
+
+func (a *RouteAction) GenerateParams(key, salt []byte) error {
+	return nil
+}
+
+func (a *RouteAction) SetParams(params interface{}) error {
+	return nil
+}
+
+func (a *RouteAction) Do(item *kodex.Item, writer kodex.ChannelWriter) (*kodex.Item, error) {
+
+	channels := make([]string, 0, 1)
+
+	for _, route := range a.routes {
+		if route.Predicate.Matches(item) {
+			channels = append(channels, route.Channel)
+			if !a.all {
+				break
+			}
+		}
+	}
+
+	if len(channels) == 0 && a.defaultChannel != "" {
+		channels = append(channels, a.defaultChannel)
+	}
+
+	if len(channels) == 0 {
+		return item, nil
+	}
+
+	for _, channel := range channels {
+		// following actions may modify the item in place
+		if err := writer.Write(channel, []*kodex.Item{item.Copy()}); err != nil {
+			return nil, err
+		}
+	}
+
+	if a.continue_ {
+		return item, nil
+	}
+
+	return nil, nil
+}
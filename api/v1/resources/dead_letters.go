@@ -0,0 +1,166 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package resources
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/api"
+	"github.com/kiprotect/kodex/api/helpers"
+)
+
+var deadLettersForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the dead letters form",
+	Fields: []forms.Field{
+		{
+			Name: "ids",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: []interface{}{}},
+				forms.IsList{
+					Validators: []forms.Validator{
+						forms.IsHex{ConvertToBinary: true, Strict: true},
+					},
+				},
+			},
+		},
+		{
+			Name: "action",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "config_version",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// required to replay all dead letters of a stream
+			Name: "all",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: false},
+				forms.IsBoolean{},
+			},
+		},
+	},
+}
+
+// Returns the stream from the context and the dead letters that match the
+// given parameters
+func deadLetters(c *gin.Context, params map[string]interface{}) (kodex.Stream, []*kodex.DeadLetter, bool) {
+
+	streamObj, ok := c.Get("stream")
+
+	if !ok {
+		api.HandleError(c, 500, fmt.Errorf("invalid stream"))
+		return nil, nil, false
+	}
+
+	stream, ok := streamObj.(kodex.Stream)
+
+	if !ok {
+		api.HandleError(c, 500, fmt.Errorf("invalid stream"))
+		return nil, nil, false
+	}
+
+	store := stream.Project().Controller().DeadLetterStore()
+
+	if store == nil {
+		api.HandleError(c, 404, fmt.Errorf("no dead letter store configured"))
+		return nil, nil, false
+	}
+
+	filter := &kodex.DeadLetterFilter{
+		ProjectID:     stream.Project().ID(),
+		StreamName:    stream.Name(),
+		Action:        params["action"].(string),
+		ConfigVersion: params["config_version"].(string),
+	}
+
+	for _, id := range params["ids"].([]interface{}) {
+		filter.IDs = append(filter.IDs, id.([]byte))
+	}
+
+	deadLetters, err := store.DeadLetters(filter)
+
+	if err != nil {
+		api.HandleError(c, 500, err)
+		return nil, nil, false
+	}
+
+	return stream, deadLetters, true
+}
+
+// Lists the dead letters of a stream
+func DeadLetters(c *gin.Context) {
+
+	params, err := deadLettersForm.Validate(map[string]interface{}{
+		"action":         c.Query("action"),
+		"config_version": c.Query("config_version"),
+	})
+
+	if err != nil {
+		api.HandleError(c, 400, err)
+		return
+	}
+
+	if _, deadLetters, ok := deadLetters(c, params); ok {
+		c.JSON(200, map[string]interface{}{"data": deadLetters})
+	}
+}
+
+// Replays the selected dead letters of a stream with the current configs
+func ReplayDeadLetters(c *gin.Context) {
+
+	data := helpers.JSONData(c)
+
+	if data == nil {
+		return
+	}
+
+	params, err := deadLettersForm.Validate(data)
+
+	if err != nil {
+		api.HandleError(c, 400, err)
+		return
+	}
+
+	if len(params["ids"].([]interface{})) == 0 && params["action"] == "" && params["config_version"] == "" && !params["all"].(bool) {
+		api.HandleError(c, 400, fmt.Errorf("please select dead letters via 'ids', 'action' or 'config_version' (or set 'all')"))
+		return
+	}
+
+	stream, deadLetters, ok := deadLetters(c, params)
+
+	if !ok {
+		return
+	}
+
+	replayed, err := kodex.ReplayDeadLetters(stream, deadLetters)
+
+	if err != nil {
+		api.HandleError(c, 500, fmt.Errorf("replayed %d of %d dead letters: %v", replayed, len(deadLetters), err))
+		return
+	}
+
+	c.JSON(200, map[string]interface{}{"data": map[string]interface{}{"replayed": replayed}})
+}
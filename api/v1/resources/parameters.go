@@ -0,0 +1,71 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package resources
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/api"
+	"github.com/kiprotect/kodex/api/helpers"
+)
+
+var parameterSetForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the parameter set form",
+	Fields: []forms.Field{
+		{
+			Name: "kip",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsHex{ConvertToBinary: true, Strict: true},
+			},
+		},
+	},
+}
+
+// Explain which actions and parameters were used to process items with the
+// given '_kip' value. Parameter values and secrets are redacted.
+func ExplainParameterSet(c *gin.Context) {
+
+	controller := helpers.Controller(c)
+
+	if controller == nil {
+		return
+	}
+
+	params, err := parameterSetForm.Validate(map[string]interface{}{
+		"kip": c.Param("kip"),
+	})
+
+	if err != nil {
+		api.HandleError(c, 400, err)
+		return
+	}
+
+	explanation, err := kodex.ExplainParameterSet(controller.ParameterStore(), params["kip"].([]byte))
+
+	if err == kodex.NotFound {
+		api.HandleError(c, 404, fmt.Errorf("parameter set not found"))
+		return
+	} else if err != nil {
+		api.HandleError(c, 500, err)
+		return
+	}
+
+	c.JSON(200, map[string]interface{}{"data": explanation})
+}
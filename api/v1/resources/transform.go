@@ -58,13 +58,54 @@ func TransformEndpoint(meter kodex.Meter) func(c *gin.Context) {
 
 		definitions := apiController.Definitions()
 
+		undo := params["undo"].(bool)
+
+		for _, actionSpec := range params["actions"].([]kodex.ActionSpecification) {
+			if actionSpec.Type == "undo" {
+				undo = true
+			}
+		}
+
+		guard, err := kodex.GetUndoGuard(apiController)
+
+		if err != nil {
+			api.HandleError(c, 500, err)
+			return
+		}
+
 		items := params["items"].([]*kodex.Item)
 		actionSpecs := params["actions"].([]kodex.ActionSpecification)
 		var key, salt []byte
 		var returnKey bool
-		if params["key"] != nil {
+		if guard != nil {
+			// with an undo guard, keys are only returned sealed with its
+			// public key, and opening them requires an unlocked undo session
+			if params["key"] != nil {
+				api.HandleError(c, 400, fmt.Errorf("with an undo guard, only sealed keys are accepted"))
+				return
+			}
+			if sealedKey, ok := params["sealed_key"].([]byte); ok {
+				sessionID, _ := params["undo_session"].([]byte)
+				if sessionID == nil {
+					api.HandleError(c, 403, fmt.Errorf("an undo session is required"))
+					return
+				}
+				user := undoUser(c)
+				if user == "" {
+					return
+				}
+				if key, err = guard.OpenWithSession(sessionID, user, sealedKey); err != nil {
+					api.HandleError(c, 403, err)
+					return
+				}
+			} else if undo {
+				api.HandleError(c, 400, fmt.Errorf("a sealed key is required"))
+				return
+			}
+		} else if params["key"] != nil {
 			key = params["key"].([]byte)
-		} else {
+		}
+		if key == nil {
 			if key, err = kodex.RandomBytes(32); err != nil {
 				api.HandleError(c, 500, err)
 				return
@@ -126,7 +167,14 @@ func TransformEndpoint(meter kodex.Meter) func(c *gin.Context) {
 				"errors": writer.Errors,
 			}
 			if returnKey {
-				data["key"] = key
+				if guard != nil {
+					if data["sealed_key"], err = guard.Seal(key); err != nil {
+						api.HandleError(c, 500, err)
+						return
+					}
+				} else {
+					data["key"] = key
+				}
 			}
 			c.JSON(200, data)
 			return
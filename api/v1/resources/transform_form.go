@@ -31,6 +31,13 @@ var TransformForm = forms.Form{
 				forms.IsBoolean{},
 			},
 		},
+		{
+			Name: "undo_session",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsHex{ConvertToBinary: true, Strict: true},
+			},
+		},
 		{
 			Name: "key",
 			Validators: []forms.Validator{
@@ -40,6 +47,15 @@ var TransformForm = forms.Form{
 				},
 			},
 		},
+		{
+			Name: "sealed_key",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsBytes{
+					Encoding: "base64",
+				},
+			},
+		},
 		{
 			Name: "salt",
 			Validators: []forms.Validator{
@@ -0,0 +1,189 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package resources
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/api"
+	"github.com/kiprotect/kodex/api/helpers"
+)
+
+var undoSessionForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the undo session form",
+	Fields: []forms.Field{
+		{
+			Name: "session_id",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsHex{ConvertToBinary: true, Strict: true},
+			},
+		},
+	},
+}
+
+var undoShareForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the undo share form",
+	Fields: []forms.Field{
+		{
+			Name: "share",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsHex{ConvertToBinary: true, Strict: true},
+			},
+		},
+	},
+}
+
+func undoGuard(c *gin.Context) *kodex.UndoGuard {
+
+	controller := helpers.Controller(c)
+
+	if controller == nil {
+		return nil
+	}
+
+	guard, err := kodex.GetUndoGuard(controller)
+
+	if err != nil {
+		api.HandleError(c, 500, err)
+		return nil
+	}
+
+	if guard == nil {
+		api.HandleError(c, 404, fmt.Errorf("no undo guard configured"))
+		return nil
+	}
+
+	return guard
+}
+
+func undoSessionID(c *gin.Context) []byte {
+
+	params, err := undoSessionForm.Validate(map[string]interface{}{
+		"session_id": c.Param("sessionID"),
+	})
+
+	if err != nil {
+		api.HandleError(c, 400, err)
+		return nil
+	}
+
+	return params["session_id"].([]byte)
+}
+
+// Identifies a user as creator of an undo session or as share holder
+func undoUser(c *gin.Context) string {
+
+	user := helpers.User(c)
+
+	if user == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%s:%s", user.Source, hex.EncodeToString(user.SourceID))
+}
+
+// Create a new undo session, to which share holders can add their shares.
+// Only the creator can use the session (once) after it has been unlocked.
+func CreateUndoSession(c *gin.Context) {
+
+	guard := undoGuard(c)
+
+	if guard == nil {
+		return
+	}
+
+	creator := undoUser(c)
+
+	if creator == "" {
+		return
+	}
+
+	c.JSON(200, map[string]interface{}{"data": guard.MakeSession(creator)})
+}
+
+func UndoSessionDetails(c *gin.Context) {
+
+	guard := undoGuard(c)
+
+	if guard == nil {
+		return
+	}
+
+	sessionID := undoSessionID(c)
+
+	if sessionID == nil {
+		return
+	}
+
+	session, err := guard.Session(sessionID)
+
+	if err != nil {
+		api.HandleError(c, 404, err)
+		return
+	}
+
+	c.JSON(200, map[string]interface{}{"data": session})
+}
+
+// Add a share to an undo session. Every user can only add a single share.
+func AddUndoSessionShare(c *gin.Context) {
+
+	guard := undoGuard(c)
+
+	if guard == nil {
+		return
+	}
+
+	holder := undoUser(c)
+
+	if holder == "" {
+		return
+	}
+
+	sessionID := undoSessionID(c)
+
+	if sessionID == nil {
+		return
+	}
+
+	data := helpers.JSONData(c)
+
+	if data == nil {
+		return
+	}
+
+	params, err := undoShareForm.Validate(data)
+
+	if err != nil {
+		api.HandleError(c, 400, err)
+		return
+	}
+
+	session, err := guard.AddShare(sessionID, holder, params["share"].([]byte))
+
+	if err != nil {
+		api.HandleError(c, 400, err)
+		return
+	}
+
+	c.JSON(200, map[string]interface{}{"data": session})
+}
@@ -63,6 +63,18 @@ func Initialize(group *gin.RouterGroup,
 	transformEndpoints.POST("/protect/pcap", pcap.Protect)
 	transformEndpoints.POST("/unprotect/pcap", pcap.Unprotect)
 
+	// Undo sessions (for collecting shares of the undo secret)
+	undoEndpoints := endpoints.Group("")
+	undoEndpoints.Use(decorators.ValidUser(settings, []string{"kiprotect:api:undo"}, false))
+	undoEndpoints.POST("/undo-sessions", resources.CreateUndoSession)
+	undoEndpoints.GET("/undo-sessions/:sessionID", resources.UndoSessionDetails)
+	undoEndpoints.POST("/undo-sessions/:sessionID/shares", resources.AddUndoSessionShare)
+
+	// Parameter provenance (explains how items with a given _kip were processed)
+	parametersEndpoints := endpoints.Group("")
+	parametersEndpoints.Use(decorators.ValidUser(settings, []string{"kiprotect:api:parameters:read"}, false))
+	parametersEndpoints.GET("/parameter-sets/:kip/explain", resources.ExplainParameterSet)
+
 	// Definitions for readers, writers and actions
 	definitionsEndpoints := endpoints.Group("")
 	definitionsEndpoints.Use(decorators.ValidUser(settings, []string{"kiprotect:api:definitions"}, false))
@@ -74,6 +86,13 @@ func Initialize(group *gin.RouterGroup,
 		"stream", []string{"admin", "superuser", "writer"}, []string{"kiprotect:api:stream:submit"}))
 	submitEndpoints.POST("/submit/:streamID", resources.Submit)
 
+	// Dead letters
+	deadLetterEndpoints := endpoints.Group("")
+	deadLetterEndpoints.Use(decorators.ValidObject(settings,
+		"stream", []string{"admin", "superuser", "writer"}, []string{"kiprotect:api:stream:dead-letters"}))
+	deadLetterEndpoints.GET("/streams/:streamID/dead-letters", resources.DeadLetters)
+	deadLetterEndpoints.POST("/streams/:streamID/dead-letters/replay", resources.ReplayDeadLetters)
+
 	transformConfigEndpoints := endpoints.Group("")
 	transformConfigEndpoints.Use(decorators.ValidObject(settings,
 		"config", []string{"admin", "superuser", "writer"}, []string{"kiprotect:api:config:transform"}))
@@ -110,6 +110,14 @@ var BlueprintStreamForm = forms.Form{
 				forms.IsString{},
 			},
 		},
+		{
+			Name: "schedule",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+				IsSchedule{},
+			},
+		},
 		{
 			Name: "data",
 			Validators: []forms.Validator{
@@ -436,8 +444,12 @@ func initActionConfigs(project Project, config map[string]interface{}) error {
 		if strId, ok := actionMapConfig["id"].(string); ok {
 			var err error
 			if id, err = hex.DecodeString(strId); err != nil {
-				id = nil
+				return fmt.Errorf("invalid ID for action %s: %v", name, err)
 			}
+		} else {
+			// keys derived from root secrets depend on the action ID, so it
+			// needs to be the same every time we load the blueprint
+			id = DeriveActionID(project.ID(), name)
 		}
 
 		Log.Debugf("Creating action: %s", name)
@@ -1075,6 +1087,9 @@ func (b *Blueprint) createWithProject(controller Controller, project Project) er
 	if err := initStreams(project, b.config); err != nil {
 		return fmt.Errorf("error creating streams: %v", err)
 	}
+	if err := CheckStreamChains(project); err != nil {
+		return fmt.Errorf("error chaining streams: %v", err)
+	}
 	if err := initKeys(project, b.config); err != nil {
 		return fmt.Errorf("error creating keys: %v", err)
 	}
@@ -0,0 +1,121 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package helpers
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+type replayOptions struct {
+	IDs           []string
+	Action        string
+	ConfigVersion string
+	All           bool
+	DryRun        bool
+}
+
+// Replays dead letters of the default stream of the given blueprint, which
+// should contain the fixed config(s)
+func replayDeadLetters(controller kodex.Controller, blueprintName, version string, options *replayOptions) error {
+
+	store := controller.DeadLetterStore()
+
+	if store == nil {
+		return fmt.Errorf("no dead letter store configured (see the 'dead-letters' setting)")
+	}
+
+	if len(options.IDs) == 0 && options.Action == "" && options.ConfigVersion == "" && !options.All {
+		return fmt.Errorf("please select dead letters with --id, --action or --config-version (or use --all)")
+	}
+
+	blueprintConfig, err := kodex.LoadBlueprintConfig(controller.Settings(), blueprintName, version)
+
+	if err != nil {
+		return err
+	}
+
+	blueprint := kodex.MakeBlueprint(blueprintConfig)
+
+	project, err := blueprint.Create(controller, true)
+
+	if err != nil {
+		return err
+	}
+
+	streams, err := controller.Streams(map[string]interface{}{"name": "default", "project.id": project.ID()})
+
+	if err != nil {
+		return err
+	}
+
+	if len(streams) != 1 {
+		return fmt.Errorf("expected one stream")
+	}
+
+	stream := streams[0]
+
+	filter := &kodex.DeadLetterFilter{
+		ProjectID:     project.ID(),
+		StreamName:    stream.Name(),
+		Action:        options.Action,
+		ConfigVersion: options.ConfigVersion,
+	}
+
+	for _, idStr := range options.IDs {
+		id, err := hex.DecodeString(idStr)
+		if err != nil {
+			return fmt.Errorf("invalid dead letter ID '%s'", idStr)
+		}
+		filter.IDs = append(filter.IDs, id)
+	}
+
+	deadLetters, err := store.DeadLetters(filter)
+
+	if err != nil {
+		return err
+	}
+
+	if options.DryRun {
+		return printDeadLetters(deadLetters)
+	}
+
+	replayed, err := kodex.ReplayDeadLetters(stream, deadLetters)
+
+	fmt.Printf("Replayed %d of %d dead letters.\n", replayed, len(deadLetters))
+
+	return err
+}
+
+func printDeadLetters(deadLetters []*kodex.DeadLetter) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCREATED AT\tCONFIG\tVERSION\tACTION\tERROR")
+	for _, deadLetter := range deadLetters {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			hex.EncodeToString(deadLetter.ID),
+			formatTime(deadLetter.CreatedAt),
+			deadLetter.ConfigName,
+			deadLetter.ConfigVersion,
+			deadLetter.Action,
+			strings.ReplaceAll(deadLetter.Error, "\n", " "))
+	}
+	return w.Flush()
+}
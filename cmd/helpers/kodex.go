@@ -24,8 +24,10 @@ import (
 	kipHelpers "github.com/kiprotect/kodex/helpers"
 	"github.com/kiprotect/kodex/processing"
 	"github.com/urfave/cli"
-	"io/ioutil"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 type decorator func(f func(c *cli.Context) error) func(c *cli.Context) error
@@ -49,56 +51,6 @@ type ParametersStruct struct {
 	ParameterSets []map[string]interface{} `json:"parameter-sets"`
 }
 
-func importParameters(controller kodex.Controller, path string) error {
-	var parametersStruct ParametersStruct
-	parameterStore := controller.ParameterStore()
-	if bytes, err := ioutil.ReadFile(path); err != nil {
-		return err
-	} else if err := json.Unmarshal(bytes, &parametersStruct); err != nil {
-		return err
-	} else {
-		for _, parametersData := range parametersStruct.Parameters {
-			if parameters, err := kodex.RestoreParameters(parametersData, parameterStore); err != nil {
-				return err
-			} else {
-				if err := parameters.Save(); err != nil {
-					kodex.Log.Error(err)
-					continue
-				}
-			}
-		}
-		for _, parameterSetData := range parametersStruct.ParameterSets {
-			if parameterSet, err := kodex.RestoreParameterSet(parameterSetData, parameterStore); err != nil {
-				return err
-			} else if err := parameterSet.Save(); err != nil {
-				kodex.Log.Error(err)
-				continue
-			}
-		}
-	}
-	return nil
-}
-
-func exportParameters(controller kodex.Controller, path string) error {
-	parameterStore := controller.ParameterStore()
-	allParameterSets, err := parameterStore.AllParameterSets()
-	if err != nil {
-		return err
-	}
-	allParameters, err := parameterStore.AllParameters()
-	if err != nil {
-		return err
-	}
-	if bytes, err := json.Marshal(map[string]interface{}{
-		"parameter-sets": allParameterSets,
-		"parameters":     allParameters,
-	}); err != nil {
-		return err
-	} else {
-		return ioutil.WriteFile(path, bytes, 0644)
-	}
-}
-
 func downloadBlueprints(path, url string) error {
 	if data, err := Download(url); err != nil {
 		return err
@@ -179,21 +131,202 @@ func Kodex(definitions *api.Definitions) {
 			Name: "parameters",
 			Subcommands: []cli.Command{
 				cli.Command{
-					Name: "export",
+					Name:  "export",
+					Usage: "Export parameters, optionally as an encrypted and signed bundle",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "passphrase-file",
+							Usage: "encrypt the bundle with the passphrase in the given file",
+						},
+						cli.StringFlag{
+							Name:  "recipient",
+							Usage: "encrypt the bundle for the given X25519 public key (PEM)",
+						},
+						cli.StringFlag{
+							Name:  "sign-key",
+							Usage: "sign the bundle with the given Ed25519 private key (PEM)",
+						},
+					},
 					Action: func(c *cli.Context) error {
 						if c.NArg() != 1 {
 							return fmt.Errorf("usage: export [filename]")
 						}
-						return exportParameters(controller, c.Args().Get(0))
+						keys, err := bundleKeys(c)
+						if err != nil {
+							return err
+						}
+						return exportParameters(controller, c.Args().Get(0), keys)
 					},
 				},
 				cli.Command{
-					Name: "import",
+					Name:  "import",
+					Usage: "Import parameters from a plain export or a bundle",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "passphrase-file",
+							Usage: "decrypt the bundle with the passphrase in the given file",
+						},
+						cli.StringFlag{
+							Name:  "key",
+							Usage: "decrypt the bundle with the given X25519 private key (PEM)",
+						},
+						cli.StringFlag{
+							Name:  "verify-key",
+							Usage: "verify the bundle with the given Ed25519 public key (PEM)",
+						},
+						cli.StringFlag{
+							Name:  "project",
+							Usage: "only import parameters for actions of the given blueprint",
+						},
+						cli.StringFlag{
+							Name:  "version",
+							Value: "",
+							Usage: "optional: the version of the blueprint to load",
+						},
+						cli.StringSliceFlag{
+							Name:  "action",
+							Usage: "only import parameters for the given action (name or ID)",
+						},
+						cli.BoolFlag{
+							Name:  "skip-conflicts",
+							Usage: "import the remaining parameters if there are conflicts",
+						},
+						cli.BoolFlag{
+							Name:  "allow-unsigned",
+							Usage: "import a plain export that is not a signed bundle",
+						},
+					},
 					Action: func(c *cli.Context) error {
 						if c.NArg() != 1 {
 							return fmt.Errorf("usage: import [filename]")
 						}
-						return importParameters(controller, c.Args().Get(0))
+						keys, err := bundleKeys(c)
+						if err != nil {
+							return err
+						}
+						return importParameters(controller, c.Args().Get(0), &importOptions{
+							Keys:          keys,
+							Actions:       c.StringSlice("action"),
+							Project:       c.String("project"),
+							Version:       c.String("version"),
+							SkipConflicts: c.Bool("skip-conflicts"),
+							AllowUnsigned: c.Bool("allow-unsigned"),
+						})
+					},
+				},
+				cli.Command{
+					Name:  "list",
+					Usage: "List all parameter sets",
+					Action: func(c *cli.Context) error {
+						return listParameters(controller)
+					},
+				},
+				cli.Command{
+					Name:  "inspect",
+					Usage: "Show the details of a parameter set",
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 1 {
+							return fmt.Errorf("usage: inspect [kip]")
+						}
+						return inspectParameterSet(controller, c.Args().Get(0))
+					},
+				},
+				cli.Command{
+					Name:  "explain",
+					Usage: "Explain how items with the given _kip value were processed",
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  "json",
+							Usage: "print the explanation as JSON",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 1 {
+							return fmt.Errorf("usage: explain [kip]")
+						}
+						return explainParameterSet(controller, c.Args().Get(0), c.Bool("json"))
+					},
+				},
+				cli.Command{
+					Name:  "verify",
+					Usage: "Check the parameter store for inconsistencies",
+					Action: func(c *cli.Context) error {
+						return verifyParameters(controller)
+					},
+				},
+				cli.Command{
+					Name:  "compact",
+					Usage: "Remove duplicate entries from the parameter store",
+					Action: func(c *cli.Context) error {
+						return compactParameters(controller)
+					},
+				},
+				cli.Command{
+					Name:  "gc",
+					Usage: "Remove parameters that are not used by the given blueprints",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "version",
+							Value: "",
+							Usage: "optional: the version of the blueprints to load",
+						},
+						cli.BoolFlag{
+							Name:  "apply",
+							Usage: "actually remove the unused parameters",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if c.NArg() == 0 {
+							return fmt.Errorf("usage: gc [blueprint]...")
+						}
+						return gcParameters(controller, c.Args(), c.String("version"), c.Bool("apply"))
+					},
+				},
+			},
+		},
+		cli.Command{
+			Name:  "shares",
+			Usage: "Manage shares of the undo secret",
+			Subcommands: []cli.Command{
+				cli.Command{
+					Name:  "generate",
+					Usage: "Generate a new undo secret and split it into shares",
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:  "shares",
+							Value: 3,
+							Usage: "the number of shares to generate",
+						},
+						cli.IntFlag{
+							Name:  "threshold",
+							Value: 2,
+							Usage: "the number of shares required to unlock undo operations",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 1 {
+							return fmt.Errorf("usage: generate [directory]")
+						}
+						return generateShares(c.Args().Get(0), c.Int("shares"), c.Int("threshold"))
+					},
+				},
+				cli.Command{
+					Name:  "verify",
+					Usage: "Check if the given shares unlock undo operations",
+					Action: func(c *cli.Context) error {
+						if c.NArg() == 0 {
+							return fmt.Errorf("usage: verify [share file]...")
+						}
+						if guard, err := kodex.GetUndoGuard(controller); err != nil {
+							return err
+						} else if guard == nil {
+							return fmt.Errorf("no undo guard configured")
+						}
+						if err := unlockUndo(controller, c.Args()); err != nil {
+							return err
+						}
+						fmt.Println("The shares are valid.")
+						return nil
 					},
 				},
 			},
@@ -259,6 +392,110 @@ func Kodex(definitions *api.Definitions) {
 
 			},
 		},
+		cli.Command{
+			Name:  "replay",
+			Usage: "Process dead letters again with the (fixed) configs of a blueprint",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "version",
+					Value: "",
+					Usage: "optional: the version of the blueprint to load",
+				},
+				cli.StringSliceFlag{
+					Name:  "id",
+					Usage: "replay the dead letter with the given ID",
+				},
+				cli.StringFlag{
+					Name:  "action",
+					Usage: "replay dead letters of the given action",
+				},
+				cli.StringFlag{
+					Name:  "config-version",
+					Usage: "replay dead letters of the given config version",
+				},
+				cli.BoolFlag{
+					Name:  "all",
+					Usage: "replay all dead letters of the stream",
+				},
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "only list the selected dead letters",
+				},
+			},
+			Action: func(c *cli.Context) error {
+
+				blueprintName := ""
+
+				if c.NArg() > 0 {
+					blueprintName = c.Args().Get(0)
+				}
+
+				return replayDeadLetters(controller, blueprintName, c.String("version"), &replayOptions{
+					IDs:           c.StringSlice("id"),
+					Action:        c.String("action"),
+					ConfigVersion: c.String("config-version"),
+					All:           c.Bool("all"),
+					DryRun:        c.Bool("dry-run"),
+				})
+			},
+		},
+		cli.Command{
+			Name:  "worker",
+			Usage: "Process the streams of one or more blueprints until stopped",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "version",
+					Value: "",
+					Usage: "optional: the version of the blueprints to load",
+				},
+				cli.StringFlag{
+					Name:  "id",
+					Usage: "optional: the (hex) ID of the worker, used for leases (random by default)",
+				},
+				cli.IntFlag{
+					Name:  "capacity",
+					Value: processing.DefaultWorkerConfig.Capacity,
+					Usage: "the maximum number of streams, sources and destinations (each) to process",
+				},
+				cli.DurationFlag{
+					Name:  "interval",
+					Value: processing.DefaultWorkerConfig.Interval,
+					Usage: "how often to look for new work",
+				},
+				cli.DurationFlag{
+					Name:  "shutdown-timeout",
+					Value: processing.DefaultWorkerConfig.ShutdownTimeout,
+					Usage: "how long to drain in-flight payloads when stopping (0 for no limit)",
+				},
+				cli.StringFlag{
+					Name:  "metrics",
+					Usage: "optional: the address (e.g. ':9100') on which to serve Prometheus metrics",
+				},
+			},
+			Action: func(c *cli.Context) error {
+
+				config := processing.DefaultWorkerConfig
+				config.Capacity = c.Int("capacity")
+				config.Interval = c.Duration("interval")
+				config.ShutdownTimeout = c.Duration("shutdown-timeout")
+
+				stopMetrics, err := serveMetrics(c.String("metrics"))
+
+				if err != nil {
+					return err
+				}
+
+				defer stopMetrics()
+
+				blueprintNames := []string(c.Args())
+
+				if len(blueprintNames) == 0 {
+					blueprintNames = []string{""}
+				}
+
+				return runWorker(controller, blueprintNames, c.String("version"), c.String("id"), config)
+			},
+		},
 		cli.Command{
 			Name: "run",
 			Flags: []cli.Flag{
@@ -267,15 +504,51 @@ func Kodex(definitions *api.Definitions) {
 					Value: "",
 					Usage: "optional: the version of the blueprint to load",
 				},
+				cli.StringSliceFlag{
+					Name:  "share",
+					Usage: "a file with a share of the undo secret (required for undo operations if an undo guard is configured)",
+				},
+				cli.DurationFlag{
+					Name:  "shutdown-timeout",
+					Value: time.Second * 30,
+					Usage: "how long to drain in-flight payloads on SIGINT/SIGTERM (0 for no limit)",
+				},
+				cli.StringFlag{
+					Name:  "metrics",
+					Usage: "optional: the address (e.g. ':9100') on which to serve Prometheus metrics",
+				},
+				cli.BoolFlag{
+					Name:  "daemon",
+					Usage: "keep running and read the sources of scheduled streams on their schedule",
+				},
 			},
 			Action: func(c *cli.Context) error {
 
+				if err := unlockUndo(controller, c.StringSlice("share")); err != nil {
+					return err
+				}
+
 				blueprintName := ""
 
 				if c.NArg() > 0 {
 					blueprintName = c.Args().Get(0)
 				}
 
+				stopMetrics, err := serveMetrics(c.String("metrics"))
+
+				if err != nil {
+					return err
+				}
+
+				defer stopMetrics()
+
+				if c.Bool("daemon") {
+					// we process the blueprint with a worker until we are stopped
+					config := processing.DefaultWorkerConfig
+					config.ShutdownTimeout = c.Duration("shutdown-timeout")
+					return runWorker(controller, []string{blueprintName}, c.String("version"), "", config)
+				}
+
 				blueprintConfig, err := kodex.LoadBlueprintConfig(controller.Settings(), blueprintName, c.String("version"))
 
 				if err != nil {
@@ -302,7 +575,11 @@ func Kodex(definitions *api.Definitions) {
 
 				stream := streams[0]
 
-				return processing.ProcessStream(stream, 0)
+				signals := make(chan os.Signal, 1)
+				signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+				defer signal.Stop(signals)
+
+				return processing.ProcessStream(stream, signals, c.Duration("shutdown-timeout"))
 			},
 		},
 	}
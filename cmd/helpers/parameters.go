@@ -0,0 +1,608 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package helpers
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/parameters"
+	"github.com/urfave/cli"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func maintainableParameterStore(controller kodex.Controller) (kodex.MaintainableParameterStore, error) {
+	if parameterStore, ok := controller.ParameterStore().(kodex.MaintainableParameterStore); !ok {
+		return nil, fmt.Errorf("the parameter store does not support maintenance operations")
+	} else {
+		return parameterStore, nil
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func listParameters(controller kodex.Controller) error {
+	parameterSets, err := controller.ParameterStore().AllParameterSets()
+	if err != nil {
+		return err
+	}
+
+	// we show the newest parameter sets first
+	sort.SliceStable(parameterSets, func(i, j int) bool {
+		return parameterSets[i].CreatedAt().After(parameterSets[j].CreatedAt())
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KIP\tCREATED AT\tACTIONS")
+	for _, parameterSet := range parameterSets {
+		actionTypes := make([]string, len(parameterSet.Parameters()))
+		for i, parameters := range parameterSet.Parameters() {
+			actionTypes[i] = parameters.Action().Type()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n",
+			hex.EncodeToString(parameterSet.Hash()),
+			formatTime(parameterSet.CreatedAt()),
+			strings.Join(actionTypes, ", "))
+	}
+	return w.Flush()
+}
+
+// Shows the details of a parameter set, without revealing the parameters
+// themselves (as they usually contain secret keys).
+func inspectParameterSet(controller kodex.Controller, kip string) error {
+	hash, err := hex.DecodeString(kip)
+	if err != nil {
+		return fmt.Errorf("invalid _kip value: %v", err)
+	}
+
+	parameterSet, err := controller.ParameterStore().ParameterSet(hash)
+	if err != nil {
+		return err
+	}
+
+	if parameterSet == nil {
+		return fmt.Errorf("parameter set '%s' not found", kip)
+	}
+
+	fmt.Printf("KIP:        %s\n", hex.EncodeToString(parameterSet.Hash()))
+	fmt.Printf("Created at: %s\n\n", formatTime(parameterSet.CreatedAt()))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PARAMETERS\tACTION TYPE\tACTION NAME\tACTION ID\tCONFIG HASH\tGROUP HASH\tCREATED AT")
+	for _, parameters := range parameterSet.Parameters() {
+		action := parameters.Action()
+		configHash, err := action.ConfigHash()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			hex.EncodeToString(parameters.ID()),
+			action.Type(),
+			action.Name(),
+			hex.EncodeToString(action.ID()),
+			hex.EncodeToString(configHash),
+			hex.EncodeToString(parameters.ParameterGroup().Hash()),
+			formatTime(parameters.CreatedAt()))
+	}
+	return w.Flush()
+}
+
+func explainParameterSet(controller kodex.Controller, kip string, asJSON bool) error {
+	hash, err := hex.DecodeString(kip)
+	if err != nil {
+		return fmt.Errorf("invalid _kip value: %v", err)
+	}
+
+	explanation, err := kodex.ExplainParameterSet(controller.ParameterStore(), hash)
+	if err == kodex.NotFound {
+		return fmt.Errorf("parameter set '%s' not found", kip)
+	} else if err != nil {
+		return err
+	}
+
+	if asJSON {
+		data, err := json.MarshalIndent(explanation, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	formatTimePtr := func(t *time.Time) string {
+		if t == nil {
+			return formatTime(time.Time{})
+		}
+		return formatTime(*t)
+	}
+
+	fmt.Printf("KIP:        %s\n", explanation.Hash)
+	fmt.Printf("Created at: %s\n", formatTimePtr(explanation.CreatedAt))
+
+	for i, parameters := range explanation.Parameters {
+		config, err := json.Marshal(parameters.Action.Config)
+		if err != nil {
+			return err
+		}
+		group, err := json.Marshal(parameters.ParameterGroup["data"])
+		if err != nil {
+			return err
+		}
+		params, err := json.Marshal(parameters.Parameters)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\nAction %d: %s (%s)\n", i+1, parameters.Action.Name, parameters.Action.Type)
+		fmt.Printf("  Action ID:       %s\n", parameters.Action.ID)
+		fmt.Printf("  Config:          %s\n", config)
+		fmt.Printf("  Config hash:     %s\n", parameters.Action.ConfigHash)
+		fmt.Printf("  Parameters ID:   %s\n", parameters.ID)
+		fmt.Printf("  Parameters:      %s\n", params)
+		fmt.Printf("  Parameter group: %s (%s)\n", parameters.ParameterGroup["hash"], group)
+		fmt.Printf("  Created at:      %s\n", formatTimePtr(parameters.CreatedAt))
+	}
+
+	return nil
+}
+
+func verifyParameters(controller kodex.Controller) error {
+	parameterStore, err := maintainableParameterStore(controller)
+	if err != nil {
+		return err
+	}
+
+	problems, err := parameterStore.Verify()
+	if err != nil {
+		return err
+	}
+
+	for _, problem := range problems {
+		if problem.ID != nil {
+			fmt.Printf("%s %s: %s\n", problem.Type, hex.EncodeToString(problem.ID), problem.Message)
+		} else {
+			fmt.Printf("%s: %s\n", problem.Type, problem.Message)
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("found %d problem(s) in the parameter store", len(problems))
+	}
+
+	fmt.Println("No problems found.")
+
+	return nil
+}
+
+func compactParameters(controller kodex.Controller) error {
+	parameterStore, err := maintainableParameterStore(controller)
+	if err != nil {
+		return err
+	}
+
+	if removed, err := parameterStore.Compact(); err != nil {
+		return err
+	} else {
+		fmt.Printf("Removed %d duplicate entries.\n", removed)
+	}
+
+	return nil
+}
+
+// Removes parameters that are not referenced by any config of the given
+// blueprints. Disabled configs do not count as references.
+func gcParameters(controller kodex.Controller, blueprintNames []string, version string, apply bool) error {
+	parameterStore, err := maintainableParameterStore(controller)
+	if err != nil {
+		return err
+	}
+
+	for _, blueprintName := range blueprintNames {
+		blueprintConfig, err := kodex.LoadBlueprintConfig(controller.Settings(), blueprintName, version)
+
+		if err != nil {
+			return err
+		}
+
+		blueprint := kodex.MakeBlueprint(blueprintConfig)
+
+		if _, err := blueprint.Create(controller, true); err != nil {
+			return err
+		}
+	}
+
+	streams, err := controller.Streams(map[string]interface{}{})
+
+	if err != nil {
+		return err
+	}
+
+	configs := make([]kodex.Config, 0)
+
+	for _, stream := range streams {
+		streamConfigs, err := stream.Configs()
+		if err != nil {
+			return err
+		}
+		for _, config := range streamConfigs {
+			if config.Status() == kodex.DisabledConfig {
+				continue
+			}
+			configs = append(configs, config)
+		}
+	}
+
+	// without any configs we would remove everything, which is most likely
+	// not what the user wants
+	if len(configs) == 0 {
+		return fmt.Errorf("no active configs found, please specify the blueprints that are in use")
+	}
+
+	unusedParameters, unusedParameterSets, err := kodex.UnusedParameters(parameterStore, configs)
+
+	if err != nil {
+		return err
+	}
+
+	for _, parameterSet := range unusedParameterSets {
+		fmt.Printf("parameter-set %s\n", hex.EncodeToString(parameterSet.Hash()))
+	}
+
+	for _, parameters := range unusedParameters {
+		fmt.Printf("parameters %s (%s)\n", hex.EncodeToString(parameters.ID()), parameters.Action().Type())
+	}
+
+	if !apply {
+		fmt.Printf("Found %d unused parameters and %d unused parameter sets, use --apply to remove them.\n", len(unusedParameters), len(unusedParameterSets))
+		return nil
+	}
+
+	if err := parameterStore.Remove(unusedParameters, unusedParameterSets); err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed %d unused parameters and %d unused parameter sets.\n", len(unusedParameters), len(unusedParameterSets))
+
+	return nil
+}
+
+type importOptions struct {
+	// Keys for opening a bundle
+	Keys *parameters.BundleKeys
+	// Only import parameters for actions with the given IDs or names
+	Actions []string
+	// Only import parameters for actions of the given blueprint project
+	Project string
+	// The version of the blueprint
+	Version string
+	// Import non-conflicting parameters even if there are conflicts
+	SkipConflicts bool
+	// Import plain exports, which are neither signed nor encrypted
+	AllowUnsigned bool
+}
+
+// Loads the bundle keys specified via the command line
+func bundleKeys(c *cli.Context) (*parameters.BundleKeys, error) {
+	keys := &parameters.BundleKeys{}
+	found := false
+
+	if filename := c.String("passphrase-file"); filename != "" {
+		if passphrase, err := ioutil.ReadFile(filename); err != nil {
+			return nil, err
+		} else {
+			keys.Passphrase = bytes.TrimRight(passphrase, "\r\n")
+		}
+		found = true
+	}
+
+	if filename := c.String("recipient"); filename != "" {
+		if recipient, err := parameters.LoadRecipientKey(filename); err != nil {
+			return nil, err
+		} else {
+			keys.Recipient = recipient
+		}
+		found = true
+	}
+
+	if filename := c.String("key"); filename != "" {
+		if privateKey, err := parameters.LoadEncryptionKey(filename); err != nil {
+			return nil, err
+		} else {
+			keys.PrivateKey = privateKey
+		}
+		found = true
+	}
+
+	if filename := c.String("sign-key"); filename != "" {
+		if signingKey, err := parameters.LoadSigningKey(filename); err != nil {
+			return nil, err
+		} else {
+			keys.SigningKey = signingKey
+		}
+		found = true
+	}
+
+	if filename := c.String("verify-key"); filename != "" {
+		if verifyKey, err := parameters.LoadVerifyKey(filename); err != nil {
+			return nil, err
+		} else {
+			keys.VerifyKey = verifyKey
+		}
+		found = true
+	}
+
+	if !found {
+		return nil, nil
+	}
+
+	return keys, nil
+}
+
+// Exports all parameters, either as plain JSON or, if keys are given, as an
+// encrypted and signed bundle.
+func exportParameters(controller kodex.Controller, path string, keys *parameters.BundleKeys) error {
+	parameterStore := controller.ParameterStore()
+	allParameterSets, err := parameterStore.AllParameterSets()
+	if err != nil {
+		return err
+	}
+	allParameters, err := parameterStore.AllParameters()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"parameter-sets": allParameterSets,
+		"parameters":     allParameters,
+	})
+	if err != nil {
+		return err
+	}
+	if keys != nil {
+		if bundle, err := parameters.SealBundle(data, keys); err != nil {
+			return err
+		} else if data, err = json.Marshal(bundle); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// Returns a function that checks if parameters for the given action should
+// be imported.
+func actionFilter(controller kodex.Controller, options *importOptions) (func(kodex.Action) bool, error) {
+
+	var projectActions map[string]bool
+
+	if options.Project != "" {
+
+		blueprintConfig, err := kodex.LoadBlueprintConfig(controller.Settings(), options.Project, options.Version)
+
+		if err != nil {
+			return nil, err
+		}
+
+		project, err := kodex.MakeBlueprint(blueprintConfig).Create(controller, true)
+
+		if err != nil {
+			return nil, err
+		}
+
+		actionConfigs, err := controller.ActionConfigs(map[string]interface{}{"project.id": project.ID()})
+
+		if err != nil {
+			return nil, err
+		}
+
+		projectActions = map[string]bool{}
+
+		for _, actionConfig := range actionConfigs {
+			projectActions[string(actionConfig.ID())] = true
+		}
+	}
+
+	return func(action kodex.Action) bool {
+		if projectActions != nil && !projectActions[string(action.ID())] {
+			return false
+		}
+		if len(options.Actions) == 0 {
+			return true
+		}
+		for _, nameOrID := range options.Actions {
+			if nameOrID == action.Name() || nameOrID == hex.EncodeToString(action.ID()) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// Checks if the given parameters conflict with parameters in the store.
+// Returns true if identical parameters already exist.
+func parametersConflict(parameterStore kodex.ParameterStore, params *kodex.Parameters) (bool, error) {
+
+	existingParameters, err := parameterStore.ParametersById(params.ID())
+
+	if err != nil {
+		return false, err
+	}
+
+	if existingParameters == nil {
+		// we check if there are other parameters for the same action and
+		// parameter group, which would take precedence over the imported ones
+		if existingParameters, err = parameterStore.Parameters(params.Action(), params.ParameterGroup()); err != nil {
+			return false, err
+		} else if existingParameters != nil {
+			return false, fmt.Errorf("store contains different parameters (%s) for the same action and parameter group", hex.EncodeToString(existingParameters.ID()))
+		}
+		return false, nil
+	}
+
+	existingData, err := json.Marshal(existingParameters.Parameters())
+
+	if err != nil {
+		return false, err
+	}
+
+	data, err := json.Marshal(params.Parameters())
+
+	if err != nil {
+		return false, err
+	}
+
+	existingConfigHash, err := existingParameters.Action().ConfigHash()
+
+	if err != nil {
+		return false, err
+	}
+
+	configHash, err := params.Action().ConfigHash()
+
+	if err != nil {
+		return false, err
+	}
+
+	if !bytes.Equal(existingData, data) || !bytes.Equal(existingParameters.Sealed(), params.Sealed()) || !bytes.Equal(existingConfigHash, configHash) || !bytes.Equal(existingParameters.ParameterGroup().Hash(), params.ParameterGroup().Hash()) {
+		return false, fmt.Errorf("store contains different parameters with the same ID")
+	}
+
+	return true, nil
+}
+
+func importParameters(controller kodex.Controller, path string, options *importOptions) error {
+
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	if parameters.IsBundle(data) {
+		if options.Keys == nil || options.Keys.VerifyKey == nil {
+			return fmt.Errorf("please specify the public key of the sender to verify the bundle")
+		}
+		var bundle parameters.Bundle
+		if err := json.Unmarshal(data, &bundle); err != nil {
+			return err
+		}
+		if data, err = bundle.Open(options.Keys); err != nil {
+			return err
+		}
+	} else if options.Keys != nil {
+		return fmt.Errorf("'%s' is not a parameter bundle", path)
+	} else if !options.AllowUnsigned {
+		return fmt.Errorf("'%s' is not signed, use --allow-unsigned to import it anyway", path)
+	} else {
+		kodex.Log.Warning("Importing unsigned parameters")
+	}
+
+	var parametersStruct ParametersStruct
+
+	if err := json.Unmarshal(data, &parametersStruct); err != nil {
+		return err
+	}
+
+	filter, err := actionFilter(controller, options)
+
+	if err != nil {
+		return err
+	}
+
+	parameterStore := controller.ParameterStore()
+
+	// parameters that will be available after the import
+	available := map[string]bool{}
+	newParameters := make([]*kodex.Parameters, 0)
+	conflicts := 0
+	skipped := 0
+
+	for _, parametersData := range parametersStruct.Parameters {
+		params, err := kodex.RestoreParameters(parametersData, parameterStore)
+		if err != nil {
+			return err
+		}
+		if !filter(params.Action()) {
+			skipped++
+			continue
+		}
+		if exists, err := parametersConflict(parameterStore, params); err != nil {
+			fmt.Printf("conflict: parameters %s (%s): %v\n", hex.EncodeToString(params.ID()), params.Action().Name(), err)
+			conflicts++
+			continue
+		} else if !exists {
+			newParameters = append(newParameters, params)
+		}
+		available[string(params.ID())] = true
+	}
+
+	newParameterSets := make([]map[string]interface{}, 0)
+
+outer:
+	for _, parameterSetData := range parametersStruct.ParameterSets {
+		config, err := kodex.ParameterSetForm.Validate(parameterSetData)
+		if err != nil {
+			return err
+		}
+		if existingParameterSet, err := parameterStore.ParameterSet(config["hash"].([]byte)); err != nil {
+			return err
+		} else if existingParameterSet != nil {
+			continue
+		}
+		// we only import sets for which all parameters are available
+		for _, id := range config["parameters"].([]interface{}) {
+			if !available[string(id.([]byte))] {
+				skipped++
+				continue outer
+			}
+		}
+		newParameterSets = append(newParameterSets, parameterSetData)
+	}
+
+	if conflicts > 0 && !options.SkipConflicts {
+		return fmt.Errorf("found %d conflicting parameters, nothing was imported (use --skip-conflicts to import the remaining parameters)", conflicts)
+	}
+
+	for _, params := range newParameters {
+		if err := params.Save(); err != nil {
+			kodex.Log.Error(err)
+			continue
+		}
+	}
+
+	for _, parameterSetData := range newParameterSets {
+		if parameterSet, err := kodex.RestoreParameterSet(parameterSetData, parameterStore); err != nil {
+			return err
+		} else if err := parameterSet.Save(); err != nil {
+			kodex.Log.Error(err)
+			continue
+		}
+	}
+
+	fmt.Printf("Imported %d parameters and %d parameter sets (%d filtered, %d conflicts).\n", len(newParameters), len(newParameterSets), skipped, conflicts)
+
+	return nil
+}
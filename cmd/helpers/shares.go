@@ -0,0 +1,105 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package helpers
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Generates a new undo key pair and splits the private key into shares,
+// which are written to the given directory (one file per share holder).
+func generateShares(path string, shares, threshold int) error {
+
+	publicKey, privateKey, err := kodex.GenerateUndoKey()
+
+	if err != nil {
+		return err
+	}
+
+	splitShares, err := kodex.SplitSecret(privateKey, shares, threshold)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return err
+	}
+
+	for i, share := range splitShares {
+		filename := filepath.Join(path, fmt.Sprintf("share-%d.txt", i+1))
+		if err := ioutil.WriteFile(filename, []byte(hex.EncodeToString(share)+"\n"), 0600); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote share %d to '%s'\n", i+1, filename)
+	}
+
+	fmt.Printf("\nPlease distribute the shares to their holders and add the following to your settings:\n\n")
+	fmt.Printf("undo:\n  threshold: %d\n  public-key: %s\n", threshold, hex.EncodeToString(publicKey))
+
+	return nil
+}
+
+// Reads shares from the given files
+func readShares(filenames []string) ([][]byte, error) {
+	shares := make([][]byte, len(filenames))
+	for i, filename := range filenames {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		if shares[i], err = hex.DecodeString(strings.TrimSpace(string(data))); err != nil {
+			return nil, fmt.Errorf("invalid share in '%s': %v", filename, err)
+		}
+	}
+	return shares, nil
+}
+
+// Unlocks undo operations for this process, if an undo guard is configured
+func unlockUndo(controller kodex.Controller, filenames []string) error {
+
+	guard, err := kodex.GetUndoGuard(controller)
+
+	if err != nil {
+		return err
+	}
+
+	if guard == nil {
+		if len(filenames) > 0 {
+			return fmt.Errorf("shares given but no undo guard configured")
+		}
+		return nil
+	}
+
+	if len(filenames) == 0 {
+		return nil
+	}
+
+	shares, err := readShares(filenames)
+
+	if err != nil {
+		return err
+	}
+
+	return guard.Unlock(shares)
+}
@@ -0,0 +1,68 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package helpers
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/processing"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Loads the given blueprints and processes their streams, sources and
+// destinations until the worker receives SIGINT or SIGTERM
+func runWorker(controller kodex.Controller, blueprintNames []string, version, workerID string, config processing.WorkerConfig) error {
+
+	id := kodex.RandomID()
+
+	if workerID != "" {
+		var err error
+		if id, err = hex.DecodeString(workerID); err != nil {
+			return fmt.Errorf("invalid worker ID: %w", err)
+		}
+	}
+
+	for _, blueprintName := range blueprintNames {
+
+		blueprintConfig, err := kodex.LoadBlueprintConfig(controller.Settings(), blueprintName, version)
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := kodex.MakeBlueprint(blueprintConfig).Create(controller, true); err != nil {
+			return err
+		}
+	}
+
+	worker := processing.MakeWorker(controller, id, config)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	go func() {
+		sig := <-signals
+		kodex.Log.Infof("Received %v, stopping worker...", sig)
+		worker.Stop(true)
+	}()
+
+	return worker.Run()
+}
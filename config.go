@@ -230,6 +230,31 @@ func (b *BaseChannelWriter) sendErrorWarning(
 	return nil
 }
 
+// Returns the destinations that receive the processed items of a config,
+// i.e. the active ones (destinations for errors, warnings and messages as
+// well as on-demand destinations only receive items written to them
+// explicitly via the channel writer)
+func ItemDestinations(config Config) ([]DestinationMap, error) {
+
+	destinations, err := config.Destinations()
+
+	if err != nil {
+		return nil, err
+	}
+
+	itemDestinations := make([]DestinationMap, 0)
+
+	for _, destinationMaps := range destinations {
+		for _, destinationMap := range destinationMaps {
+			if destinationMap.Status() == ActiveDestination {
+				itemDestinations = append(itemDestinations, destinationMap)
+			}
+		}
+	}
+
+	return itemDestinations, nil
+}
+
 type BaseConfig struct {
 	Self    Config
 	Stream_ Stream
@@ -347,6 +372,8 @@ func (b *BaseConfig) Processor(internal bool) (*Processor, error) {
 	}
 
 	actions := make([]Action, len(actionConfigs))
+	errorHandling := make([]*ErrorHandling, len(actionConfigs))
+	predicates := make([]Predicate, len(actionConfigs))
 
 	for i, actionConfig := range actionConfigs {
 		if action, err := actionConfig.Action(); err != nil {
@@ -354,6 +381,12 @@ func (b *BaseConfig) Processor(internal bool) (*Processor, error) {
 		} else {
 			actions[i] = action
 		}
+		if errorHandling[i], err = ActionConfigErrorHandling(actionConfig); err != nil {
+			return nil, fmt.Errorf("invalid error handling for action '%s': %v", actionConfig.Name(), err)
+		}
+		if predicates[i], err = ActionConfigPredicate(actionConfig); err != nil {
+			return nil, fmt.Errorf("invalid predicate for action '%s': %v", actionConfig.Name(), err)
+		}
 	}
 
 	parameterSet, err := MakeParameterSet(actions, b.Stream().Project().Controller().ParameterStore())
@@ -375,6 +408,15 @@ func (b *BaseConfig) Processor(internal bool) (*Processor, error) {
 		return nil, err
 	}
 
+	if enabled, err := DeadLettersEnabled(b.Self); err != nil {
+		return nil, err
+	} else if enabled {
+		processor.SetDeadLetterStore(b.Self.Stream().Project().Controller().DeadLetterStore())
+	}
+
+	processor.SetErrorHandling(errorHandling)
+	processor.SetPredicates(predicates)
+
 	if key, err := settings.Get("key"); err == nil {
 		keyString, ok := key.(string)
 		if !ok {
@@ -390,6 +432,31 @@ func (b *BaseConfig) Processor(internal bool) (*Processor, error) {
 		processor.SetSalt([]byte(saltStr))
 	}
 
+	// a root secret (if given) takes precedence over a global key
+	if name, err := RootSecretName(b.Self, settings); err != nil {
+		return nil, err
+	} else if name != "" {
+		rootSecret, err := RootSecret(settings, name)
+		if err != nil {
+			return nil, err
+		}
+		projectKey, err := DeriveProjectKey(rootSecret, b.Self.Stream().Project().ID())
+		if err != nil {
+			return nil, err
+		}
+		processor.SetProjectKey(projectKey)
+	}
+
+	// anyone holding a key or root secret could undo actions without the
+	// shares of the undo guard
+	if processor.keyed() {
+		if guard, err := GetUndoGuard(b.Self.Stream().Project().Controller()); err != nil {
+			return nil, err
+		} else if guard != nil {
+			return nil, fmt.Errorf("a key or root secret can't be used together with an undo guard")
+		}
+	}
+
 	return processor, nil
 
 }
@@ -25,6 +25,10 @@ type ControllerDefinitions map[string]ControllerMaker
 
 var NotFound = fmt.Errorf("object not found")
 
+// Returned when renewing a lease that another processor holds (or that was
+// released)
+var LeaseLost = fmt.Errorf("lease lost")
+
 type Controller interface {
 	SetVar(key string, value interface{}) error
 	GetVar(key string) (interface{}, bool)
@@ -77,8 +81,9 @@ type Controller interface {
 	Acquire(Processable, []byte) (bool, error)
 	// Release a processable entity
 	Release(Processable, []byte) (bool, error)
-	// Send a pingback with stats for a processable entity
-	Ping(Processable, ProcessingStats) error
+	// Send a pingback with stats for a processable entity, which renews the
+	// lease of the given processor (or returns LeaseLost)
+	Ping(Processable, []byte, ProcessingStats) error
 
 	// Datasets
 	Dataset(id []byte) (Dataset, error)
@@ -95,6 +100,9 @@ type Controller interface {
 	// Parameter store
 	ParameterStore() ParameterStore
 
+	// Dead letter store (nil if none is configured)
+	DeadLetterStore() DeadLetterStore
+
 	// Run all hooks of the given name
 	RunHooks(name string, data interface{}) (interface{}, error)
 }
@@ -102,10 +110,11 @@ type Controller interface {
 /* Base Functionality */
 
 type BaseController struct {
-	definitions    *Definitions
-	parameterStore ParameterStore
-	vars           map[string]interface{}
-	settings       Settings
+	definitions     *Definitions
+	parameterStore  ParameterStore
+	deadLetterStore DeadLetterStore
+	vars            map[string]interface{}
+	settings        Settings
 }
 
 func MakeBaseController(settings Settings, definitions *Definitions) (BaseController, error) {
@@ -115,11 +124,18 @@ func MakeBaseController(settings Settings, definitions *Definitions) (BaseContro
 		return BaseController{}, err
 	}
 
+	deadLetterStore, err := MakeDeadLetterStore(settings, definitions)
+
+	if err != nil {
+		return BaseController{}, err
+	}
+
 	return BaseController{
-		parameterStore: parameterStore,
-		definitions:    definitions,
-		settings:       settings,
-		vars:           map[string]interface{}{},
+		parameterStore:  parameterStore,
+		deadLetterStore: deadLetterStore,
+		definitions:     definitions,
+		settings:        settings,
+		vars:            map[string]interface{}{},
 	}, nil
 }
 
@@ -141,6 +157,10 @@ func (b *BaseController) ParameterStore() ParameterStore {
 	return b.parameterStore
 }
 
+func (b *BaseController) DeadLetterStore() DeadLetterStore {
+	return b.deadLetterStore
+}
+
 func (b *BaseController) SetVar(key string, value interface{}) error {
 	b.vars[key] = value
 	return nil
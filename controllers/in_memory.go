@@ -20,7 +20,9 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/kiprotect/kodex"
+	"sort"
 	"sync"
+	"time"
 )
 
 type ProcessorStats struct {
@@ -31,6 +33,9 @@ type ProcessorStats struct {
 }
 
 type Stats struct {
+	// the processor holding the lease
+	ProcessorID    []byte
+	LeaseExpiresAt time.Time
 	ProcessorStats []ProcessorStats
 }
 
@@ -438,23 +443,55 @@ func (c *InMemoryController) Destination(destinationID []byte) (kodex.Destinatio
 	return nil, kodex.NotFound
 }
 
+// Returns true if another processor holds a valid lease on the entity
+func (c *InMemoryController) leased(processable kodex.Processable) bool {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	table, err := c.getTable(processable)
+
+	if err != nil {
+		return false
+	}
+
+	stats, ok := table[string(processable.ID())]
+
+	return ok && time.Now().Before(stats.LeaseExpiresAt)
+}
+
+// Entities that nobody is working on are more urgent than leased ones
+func (c *InMemoryController) moreUrgent(a, b kodex.Processable) bool {
+	return !c.leased(a) && c.leased(b)
+}
+
 func (c *InMemoryController) StreamsByUrgency(n int) ([]kodex.Stream, error) {
 
 	streams := make([]kodex.Stream, 0)
 	for _, stream := range c.streams {
 		streams = append(streams, stream)
-		if len(streams) >= n {
-			break
-		}
 	}
+
+	sort.Slice(streams, func(i, j int) bool { return bytes.Compare(streams[i].ID(), streams[j].ID()) < 0 })
+	sort.SliceStable(streams, func(i, j int) bool { return c.moreUrgent(streams[i], streams[j]) })
+
+	if len(streams) > n {
+		streams = streams[:n]
+	}
+
 	return streams, nil
 }
 
 func (c *InMemoryController) SourcesByUrgency(n int) ([]kodex.SourceMap, error) {
 
+	streams, err := c.StreamsByUrgency(len(c.streams))
+
+	if err != nil {
+		return nil, err
+	}
+
 	sources := make([]kodex.SourceMap, 0)
-OUTER:
-	for _, stream := range c.streams {
+	for _, stream := range streams {
 		streamSources, err := stream.Sources()
 		if err != nil {
 			return nil, err
@@ -462,18 +499,28 @@ OUTER:
 
 		for _, source := range streamSources {
 			sources = append(sources, source)
-			if len(sources) >= n {
-				break OUTER
-			}
 		}
 	}
+
+	sort.SliceStable(sources, func(i, j int) bool { return c.moreUrgent(sources[i], sources[j]) })
+
+	if len(sources) > n {
+		sources = sources[:n]
+	}
+
 	return sources, nil
 }
 
 func (c *InMemoryController) DestinationsByUrgency(n int) ([]kodex.DestinationMap, error) {
+
+	streams, err := c.StreamsByUrgency(len(c.streams))
+
+	if err != nil {
+		return nil, err
+	}
+
 	destinations := make([]kodex.DestinationMap, 0)
-OUTER:
-	for _, stream := range c.streams {
+	for _, stream := range streams {
 		streamConfigs, err := stream.Configs()
 		if err != nil {
 			return nil, err
@@ -486,14 +533,21 @@ OUTER:
 			}
 			for _, destinationMaps := range configDestinations {
 				for _, destinationMap := range destinationMaps {
-					destinations = append(destinations, destinationMap)
-					if len(destinations) >= n {
-						break OUTER
+					// stream destinations are written to by the stream executor
+					if _, ok := kodex.ChainedStreamName(destinationMap.Destination()); !ok {
+						destinations = append(destinations, destinationMap)
 					}
 				}
 			}
 		}
 	}
+
+	sort.SliceStable(destinations, func(i, j int) bool { return c.moreUrgent(destinations[i], destinations[j]) })
+
+	if len(destinations) > n {
+		destinations = destinations[:n]
+	}
+
 	return destinations, nil
 }
 
@@ -501,9 +555,9 @@ func (c *InMemoryController) getTable(processable kodex.Processable) (map[string
 	switch processable.Type() {
 	case "stream":
 		return c.streamStats, nil
-	case "source":
+	case "source", "source_map":
 		return c.sourceStats, nil
-	case "destination":
+	case "destination", "destination_map":
 		return c.destinationStats, nil
 	default:
 		return nil, fmt.Errorf("invalid type: %s", processable.Type())
@@ -523,11 +577,16 @@ func (c *InMemoryController) Acquire(processable kodex.Processable, processorID
 	}
 
 	pId := string(processable.ID())
-	if _, ok := table[pId]; ok {
-		return false, nil
+	if stats, ok := table[pId]; ok && time.Now().Before(stats.LeaseExpiresAt) {
+		// the lease is still valid, only its holder may renew it
+		if !bytes.Equal(stats.ProcessorID, processorID) {
+			return false, nil
+		}
 	}
 
 	table[pId] = Stats{
+		ProcessorID:    processorID,
+		LeaseExpiresAt: time.Now().Add(kodex.LeaseDuration),
 		ProcessorStats: []ProcessorStats{
 			ProcessorStats{
 				ProcessorID:    processorID,
@@ -554,15 +613,46 @@ func (c *InMemoryController) Release(processable kodex.Processable, processorID
 	}
 
 	pId := string(processable.ID())
-	if _, ok := table[pId]; ok {
+	if stats, ok := table[pId]; ok && bytes.Equal(stats.ProcessorID, processorID) {
 		delete(table, pId)
 		return true, nil
 	}
 	return false, nil
 }
 
-// Send a pingback with stats for a processable entity
-func (c *InMemoryController) Ping(processable kodex.Processable, stats kodex.ProcessingStats) error {
+// Send a pingback with stats for a processable entity, which renews the lease
+// if the given processor still holds it
+func (c *InMemoryController) Ping(processable kodex.Processable, processorID []byte, stats kodex.ProcessingStats) error {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	table, err := c.getTable(processable)
+
+	if err != nil {
+		return err
+	}
+
+	pId := string(processable.ID())
+	tableStats, ok := table[pId]
+
+	// the lease was released or another processor acquired it
+	if !ok || !bytes.Equal(tableStats.ProcessorID, processorID) {
+		return kodex.LeaseLost
+	}
+
+	tableStats.LeaseExpiresAt = time.Now().Add(kodex.LeaseDuration)
+
+	for i, processorStats := range tableStats.ProcessorStats {
+		if bytes.Equal(processorStats.ProcessorID, tableStats.ProcessorID) {
+			processorStats.IdleFraction = stats.IdleFraction
+			processorStats.ItemsProcessed += stats.ItemsProcessed
+			tableStats.ProcessorStats[i] = processorStats
+		}
+	}
+
+	table[pId] = tableStats
+
 	return nil
 }
 
@@ -34,6 +34,8 @@ type InMemoryActionConfig struct {
 	deletedAt   *time.Time
 	data        interface{}
 	configData  map[string]interface{}
+	onError     map[string]interface{}
+	when        map[string]interface{}
 }
 
 func MakeInMemoryActionConfig(id []byte, project kodex.Project) *InMemoryActionConfig {
@@ -77,6 +79,24 @@ func (c *InMemoryActionConfig) SetConfigData(configData map[string]interface{})
 	return nil
 }
 
+func (c *InMemoryActionConfig) OnError() map[string]interface{} {
+	return c.onError
+}
+
+func (c *InMemoryActionConfig) SetOnError(onError map[string]interface{}) error {
+	c.onError = onError
+	return nil
+}
+
+func (c *InMemoryActionConfig) When() map[string]interface{} {
+	return c.when
+}
+
+func (c *InMemoryActionConfig) SetWhen(when map[string]interface{}) error {
+	c.when = when
+	return nil
+}
+
 func (c *InMemoryActionConfig) Data() interface{} {
 	return c.data
 }
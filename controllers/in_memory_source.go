@@ -32,6 +32,7 @@ type InMemorySource struct {
 	updatedAt   time.Time
 	deletedAt   *time.Time
 	data        interface{}
+	state       map[string]interface{}
 	reader      kodex.Reader
 	configData  map[string]interface{}
 	id          []byte
@@ -128,6 +129,15 @@ func (i *InMemorySource) SetData(data interface{}) error {
 	return nil
 }
 
+func (i *InMemorySource) State() map[string]interface{} {
+	return i.state
+}
+
+func (i *InMemorySource) SetState(state map[string]interface{}) error {
+	i.state = state
+	return nil
+}
+
 func (i *InMemorySource) SetConfigData(configData map[string]interface{}) error {
 	i.configData = configData
 	return nil
@@ -30,6 +30,7 @@ type InMemoryStream struct {
 	status      kodex.StreamStatus
 	data        interface{}
 	description string
+	schedule    string
 	prio        float64
 	prioT       time.Time
 	createdAt   time.Time
@@ -171,6 +172,15 @@ func (i *InMemoryStream) SetDescription(description string) error {
 	return nil
 }
 
+func (i *InMemoryStream) Schedule() string {
+	return i.schedule
+}
+
+func (i *InMemoryStream) SetSchedule(schedule string) error {
+	i.schedule = schedule
+	return nil
+}
+
 func (i *InMemoryStream) Save() error {
 	controller, ok := i.Project().Controller().(*InMemoryController)
 	if !ok {
@@ -0,0 +1,370 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kodex
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/go-helpers/maps"
+	"time"
+)
+
+/*
+A dead letter is an item that could not be processed. It is stored together
+with the error, the action that failed and the version of the config, so it
+can be replayed once the config has been fixed. Dead letters are only stored
+if a dead letter store is configured in the settings:
+
+	dead-letters:
+	  type: file
+	  directory: ~/.kiprotect/dead-letters
+
+As dead letters contain the original, unprocessed items (which usually hold
+the personal data that the config should protect), configs or streams also
+need to opt in via their data:
+
+	data:
+	  dead-letters: true
+
+Dead letters are kept until they are replayed or deleted.
+*/
+type DeadLetter struct {
+	ID            []byte
+	ProjectID     []byte
+	StreamName    string
+	ConfigName    string
+	ConfigVersion string
+	Action        string
+	Error         string
+	Item          map[string]interface{}
+	CreatedAt     time.Time
+}
+
+type deadLetterJSON struct {
+	ID            string                 `json:"id"`
+	ProjectID     string                 `json:"project_id"`
+	StreamName    string                 `json:"stream"`
+	ConfigName    string                 `json:"config"`
+	ConfigVersion string                 `json:"config_version"`
+	Action        string                 `json:"action"`
+	Error         string                 `json:"error"`
+	Item          map[string]interface{} `json:"item"`
+	CreatedAt     time.Time              `json:"created_at"`
+}
+
+func (d *DeadLetter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&deadLetterJSON{
+		ID:            hex.EncodeToString(d.ID),
+		ProjectID:     hex.EncodeToString(d.ProjectID),
+		StreamName:    d.StreamName,
+		ConfigName:    d.ConfigName,
+		ConfigVersion: d.ConfigVersion,
+		Action:        d.Action,
+		Error:         d.Error,
+		Item:          d.Item,
+		CreatedAt:     d.CreatedAt,
+	})
+}
+
+func (d *DeadLetter) UnmarshalJSON(data []byte) error {
+
+	var dj deadLetterJSON
+
+	if err := json.Unmarshal(data, &dj); err != nil {
+		return err
+	}
+
+	id, err := hex.DecodeString(dj.ID)
+
+	if err != nil {
+		return err
+	}
+
+	projectID, err := hex.DecodeString(dj.ProjectID)
+
+	if err != nil {
+		return err
+	}
+
+	*d = DeadLetter{
+		ID:            id,
+		ProjectID:     projectID,
+		StreamName:    dj.StreamName,
+		ConfigName:    dj.ConfigName,
+		ConfigVersion: dj.ConfigVersion,
+		Action:        dj.Action,
+		Error:         dj.Error,
+		Item:          dj.Item,
+		CreatedAt:     dj.CreatedAt,
+	}
+
+	return nil
+}
+
+// Selects dead letters, empty fields match all dead letters
+type DeadLetterFilter struct {
+	ProjectID     []byte
+	StreamName    string
+	IDs           [][]byte
+	Action        string
+	ConfigVersion string
+}
+
+func (f *DeadLetterFilter) Matches(deadLetter *DeadLetter) bool {
+
+	if f.ProjectID != nil && !bytes.Equal(f.ProjectID, deadLetter.ProjectID) {
+		return false
+	}
+
+	if f.StreamName != "" && f.StreamName != deadLetter.StreamName {
+		return false
+	}
+
+	if f.Action != "" && f.Action != deadLetter.Action {
+		return false
+	}
+
+	if f.ConfigVersion != "" && f.ConfigVersion != deadLetter.ConfigVersion {
+		return false
+	}
+
+	if len(f.IDs) > 0 {
+		for _, id := range f.IDs {
+			if bytes.Equal(id, deadLetter.ID) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+type DeadLetterStore interface {
+	Add(*DeadLetter) error
+	// Returns the matching dead letters, oldest first
+	DeadLetters(filter *DeadLetterFilter) ([]*DeadLetter, error)
+	Delete(id []byte) error
+}
+
+type DeadLetterStoreMaker func(map[string]interface{}) (DeadLetterStore, error)
+
+type DeadLetterStoreDefinition struct {
+	Name        string               `json:"name"`
+	Description string               `json:"description"`
+	Maker       DeadLetterStoreMaker `json:"-"`
+	Form        forms.Form           `json:"form"`
+}
+
+type DeadLetterStoreDefinitions map[string]DeadLetterStoreDefinition
+
+// Returns the dead letter store configured in the settings (or nil if there
+// is none)
+func MakeDeadLetterStore(settings Settings, definitions *Definitions) (DeadLetterStore, error) {
+
+	config, err := settings.Get("dead-letters")
+
+	if err != nil {
+		return nil, nil
+	}
+
+	configMap, ok := maps.ToStringMap(config)
+
+	if !ok {
+		return nil, fmt.Errorf("not a valid config for the dead letter store")
+	}
+
+	storeType, ok := configMap["type"].(string)
+
+	if !ok {
+		return nil, fmt.Errorf("type is missing")
+	}
+
+	definition, ok := definitions.DeadLetterStoreDefinitions[storeType]
+
+	if !ok {
+		return nil, fmt.Errorf("not a valid dead letter store type: %s", storeType)
+	}
+
+	return definition.Maker(configMap)
+}
+
+// Returns whether failed items of the given config should be stored as dead
+// letters (set via the 'dead-letters' key in the data of the config or of its
+// stream, false by default)
+func DeadLettersEnabled(config Config) (bool, error) {
+
+	for _, data := range []interface{}{config.Data(), config.Stream().Data()} {
+		if dataMap, ok := maps.ToStringMap(data); ok {
+			if value, ok := dataMap["dead-letters"]; ok {
+				if enabled, ok := value.(bool); !ok {
+					return false, fmt.Errorf("dead-letters should be a boolean")
+				} else {
+					return enabled, nil
+				}
+			}
+		}
+	}
+
+	return false, nil
+}
+
+func MakeDeadLetter(config Config, action string, item *Item, itemError error) *DeadLetter {
+
+	stream := config.Stream()
+
+	return &DeadLetter{
+		ID:            RandomID(),
+		ProjectID:     stream.Project().ID(),
+		StreamName:    stream.Name(),
+		ConfigName:    config.Name(),
+		ConfigVersion: config.Version(),
+		Action:        action,
+		Error:         itemError.Error(),
+		Item:          item.All(),
+		CreatedAt:     time.Now().UTC(),
+	}
+}
+
+/*
+Replays dead letters of a stream: the items are processed again with the
+current version of the config they failed in and written to the active
+destinations of that config. Items that fail again are stored as new dead
+letters, the replayed dead letters are deleted. Returns the number of
+replayed dead letters.
+*/
+func ReplayDeadLetters(stream Stream, deadLetters []*DeadLetter) (int, error) {
+
+	store := stream.Project().Controller().DeadLetterStore()
+
+	if store == nil {
+		return 0, fmt.Errorf("no dead letter store configured")
+	}
+
+	configs, err := stream.Configs()
+
+	if err != nil {
+		return 0, err
+	}
+
+	byConfig := make(map[string][]*DeadLetter)
+	names := make([]string, 0)
+
+	for _, deadLetter := range deadLetters {
+		if deadLetter.StreamName != stream.Name() || !bytes.Equal(deadLetter.ProjectID, stream.Project().ID()) {
+			return 0, fmt.Errorf("dead letter %x does not belong to stream '%s'", deadLetter.ID, stream.Name())
+		}
+		if _, ok := byConfig[deadLetter.ConfigName]; !ok {
+			names = append(names, deadLetter.ConfigName)
+		}
+		byConfig[deadLetter.ConfigName] = append(byConfig[deadLetter.ConfigName], deadLetter)
+	}
+
+	replayed := 0
+
+	for _, name := range names {
+
+		var config Config
+
+		for _, candidate := range configs {
+			if candidate.Name() == name {
+				config = candidate
+				break
+			}
+		}
+
+		if config == nil {
+			return replayed, fmt.Errorf("config '%s' does not exist", name)
+		}
+
+		if err := replayConfig(config, byConfig[name]); err != nil {
+			return replayed, err
+		}
+
+		for _, deadLetter := range byConfig[name] {
+			if err := store.Delete(deadLetter.ID); err != nil {
+				return replayed, err
+			}
+			replayed++
+		}
+	}
+
+	return replayed, nil
+}
+
+func replayConfig(config Config, deadLetters []*DeadLetter) error {
+
+	processor, err := config.Processor(false)
+
+	if err != nil {
+		return err
+	}
+
+	if err := processor.Setup(); err != nil {
+		return err
+	}
+
+	defer processor.Teardown()
+
+	items := make([]*Item, len(deadLetters))
+
+	for i, deadLetter := range deadLetters {
+		items[i] = MakeItem(deadLetter.Item)
+	}
+
+	newItems, err := processor.Process(items, nil)
+
+	if err != nil {
+		return err
+	}
+
+	destinationMaps, err := ItemDestinations(config)
+
+	if err != nil {
+		return err
+	}
+
+	// replayed items only go to the destinations that receive the processed
+	// items, not to those for errors, warnings, messages or named channels
+	for _, destinationMap := range destinationMaps {
+
+		writer, err := destinationMap.Destination().Writer()
+
+		if err != nil {
+			return err
+		}
+
+		if err := writer.Setup(config); err != nil {
+			return err
+		}
+
+		writeErr := writer.Write(MakeBasicPayload(newItems, map[string]interface{}{}, false))
+
+		if err := writer.Teardown(); err != nil && writeErr == nil {
+			writeErr = err
+		}
+
+		if writeErr != nil {
+			return writeErr
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,207 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kodex_test
+
+import (
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/definitions"
+	pt "github.com/kiprotect/kodex/helpers/testing"
+	pf "github.com/kiprotect/kodex/helpers/testing/fixtures"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Records the items written to the destinations, by the 'sink' config value
+type sinks struct {
+	mutex sync.Mutex
+	items map[string][]*kodex.Item
+}
+
+type sinkWriter struct {
+	sinks *sinks
+	name  string
+}
+
+func (w *sinkWriter) Write(payload kodex.Payload) error {
+	w.sinks.mutex.Lock()
+	defer w.sinks.mutex.Unlock()
+	w.sinks.items[w.name] = append(w.sinks.items[w.name], payload.Items()...)
+	return nil
+}
+
+func (w *sinkWriter) Setup(kodex.Config) error { return nil }
+func (w *sinkWriter) Teardown() error          { return nil }
+
+func (s *sinks) Items(name string) []*kodex.Item {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.items[name]
+}
+
+// Sets the given settings before the controller is created
+type settingsFixture struct {
+	values map[string]interface{}
+}
+
+func (f settingsFixture) Setup(fixtures map[string]interface{}) (interface{}, error) {
+	settings := fixtures["settings"].(kodex.Settings)
+	for key, value := range f.values {
+		settings.Set(key, value)
+	}
+	return nil, nil
+}
+
+func (f settingsFixture) Teardown(fixture interface{}) error {
+	return nil
+}
+
+func TestReplayDeadLetters(t *testing.T) {
+
+	recorded := &sinks{items: map[string][]*kodex.Item{}}
+
+	defs := kodex.MergeDefinitions(definitions.DefaultDefinitions, kodex.Definitions{
+		WriterDefinitions: kodex.WriterDefinitions{
+			"sink": kodex.WriterDefinition{
+				Maker: func(config map[string]interface{}) (kodex.Writer, error) {
+					return &sinkWriter{sinks: recorded, name: config["sink"].(string)}, nil
+				},
+				Form: forms.Form{
+					Fields: []forms.Field{
+						{Name: "sink", Validators: []forms.Validator{forms.IsString{}}},
+					},
+				},
+			},
+		},
+	})
+
+	destinations := []interface{}{}
+	configDestinations := []interface{}{}
+
+	for name, status := range map[string]string{"out": "active", "errors": "error", "warnings": "warning", "other": "on-demand"} {
+		destinations = append(destinations, map[string]interface{}{
+			"name":   name,
+			"type":   "sink",
+			"config": map[string]interface{}{"sink": name},
+		})
+		configDestinations = append(configDestinations, map[string]interface{}{"name": name, "status": status})
+	}
+
+	var fixtureConfig = []pt.FC{
+		pt.FC{pf.Definitions{Definitions: defs}, "definitions"},
+		pt.FC{pf.Settings{}, "settings"},
+		pt.FC{settingsFixture{map[string]interface{}{
+			"dead-letters": map[string]interface{}{"type": "inMemory"},
+		}}, "deadLetters"},
+		pt.FC{pf.Controller{}, "controller"},
+		pt.FC{pf.Blueprint{Config: map[string]interface{}{
+			"destinations": destinations,
+			"streams": []interface{}{
+				map[string]interface{}{
+					"name": "default",
+					"configs": []interface{}{
+						map[string]interface{}{
+							"name":         "default",
+							"destinations": configDestinations,
+						},
+					},
+				},
+			},
+		}}, "blueprint"},
+	}
+
+	fixtures, err := pt.SetupFixtures(fixtureConfig)
+	defer pt.TeardownFixtures(fixtureConfig, fixtures)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	controller := fixtures["controller"].(kodex.Controller)
+	streams, err := controller.Streams(map[string]interface{}{"name": "default"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream := streams[0]
+	store := controller.DeadLetterStore()
+
+	deadLetter := &kodex.DeadLetter{
+		ID:         []byte("dead letter"),
+		ProjectID:  stream.Project().ID(),
+		StreamName: stream.Name(),
+		ConfigName: "default",
+		Action:     "validate",
+		Error:      "invalid item",
+		Item:       map[string]interface{}{"name": "max"},
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	if err := store.Add(deadLetter); err != nil {
+		t.Fatal(err)
+	}
+
+	if replayed, err := kodex.ReplayDeadLetters(stream, []*kodex.DeadLetter{deadLetter}); err != nil {
+		t.Fatal(err)
+	} else if replayed != 1 {
+		t.Fatalf("expected one replayed dead letter, got %d", replayed)
+	}
+
+	if items := recorded.Items("out"); len(items) != 1 {
+		t.Fatalf("expected one replayed item, got %d", len(items))
+	}
+
+	// destinations for errors, warnings and named channels receive nothing
+	for _, name := range []string{"errors", "warnings", "other"} {
+		if items := recorded.Items(name); len(items) != 0 {
+			t.Errorf("expected no items in '%s', got %d", name, len(items))
+		}
+	}
+
+	if list, err := store.DeadLetters(&kodex.DeadLetterFilter{}); err != nil {
+		t.Fatal(err)
+	} else if len(list) != 0 {
+		t.Fatalf("expected the replayed dead letter to be deleted")
+	}
+
+	configs, err := stream.Configs()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// dead letters contain unprocessed items, so configs have to opt in
+	for _, test := range []struct {
+		streamData interface{}
+		configData interface{}
+		enabled    bool
+	}{
+		{nil, nil, false},
+		{map[string]interface{}{"dead-letters": true}, nil, true},
+		{map[string]interface{}{"dead-letters": true}, map[string]interface{}{"dead-letters": false}, false},
+	} {
+		stream.SetData(test.streamData)
+		configs[0].SetData(test.configData)
+		if enabled, err := kodex.DeadLettersEnabled(configs[0]); err != nil {
+			t.Fatal(err)
+		} else if enabled != test.enabled {
+			t.Errorf("expected dead letters to be enabled: %v", test.enabled)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package deadletters
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/kodex"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var FileDeadLetterStoreForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the file dead letter store form",
+	Fields: []forms.Field{
+		{
+			Name: "directory",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{MinLength: 1},
+			},
+		},
+	},
+}
+
+/*
+The file dead letter store writes every dead letter to its own JSON file,
+so dead letters can be added by several processes and deleted individually.
+*/
+type FileDeadLetterStore struct {
+	Directory string
+}
+
+func MakeFileDeadLetterStore(config map[string]interface{}) (kodex.DeadLetterStore, error) {
+
+	params, err := FileDeadLetterStoreForm.Validate(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	directory, err := kodex.NormalizePath(params["directory"].(string))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, err
+	}
+
+	return &FileDeadLetterStore{
+		Directory: directory,
+	}, nil
+}
+
+func (f *FileDeadLetterStore) path(id []byte) string {
+	return filepath.Join(f.Directory, hex.EncodeToString(id)+".json")
+}
+
+func (f *FileDeadLetterStore) Add(deadLetter *kodex.DeadLetter) error {
+
+	data, err := json.Marshal(deadLetter)
+
+	if err != nil {
+		return err
+	}
+
+	// we write to a temporary file first so readers never see partial files
+	tmpFile, err := os.CreateTemp(f.Directory, ".dead-letter-*.tmp")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), f.path(deadLetter.ID))
+}
+
+func (f *FileDeadLetterStore) DeadLetters(filter *kodex.DeadLetterFilter) ([]*kodex.DeadLetter, error) {
+
+	entries, err := os.ReadDir(f.Directory)
+
+	if err != nil {
+		return nil, err
+	}
+
+	deadLetters := make([]*kodex.DeadLetter, 0)
+
+	for _, entry := range entries {
+
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(f.Directory, entry.Name()))
+
+		if err != nil {
+			if os.IsNotExist(err) {
+				// the dead letter was deleted in the meantime
+				continue
+			}
+			return nil, err
+		}
+
+		deadLetter := &kodex.DeadLetter{}
+
+		if err := json.Unmarshal(data, deadLetter); err != nil {
+			kodex.Log.Errorf("Cannot read dead letter '%s': %v", entry.Name(), err)
+			continue
+		}
+
+		if filter.Matches(deadLetter) {
+			deadLetters = append(deadLetters, deadLetter)
+		}
+	}
+
+	sortDeadLetters(deadLetters)
+
+	return deadLetters, nil
+}
+
+func (f *FileDeadLetterStore) Delete(id []byte) error {
+	if err := os.Remove(f.path(id)); err != nil {
+		if os.IsNotExist(err) {
+			return kodex.NotFound
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package deadletters
+
+import (
+	"bytes"
+	"github.com/kiprotect/kodex"
+	"sort"
+	"sync"
+)
+
+type InMemoryDeadLetterStore struct {
+	deadLetters []*kodex.DeadLetter
+	mutex       sync.Mutex
+}
+
+func MakeInMemoryDeadLetterStore(config map[string]interface{}) (kodex.DeadLetterStore, error) {
+	return &InMemoryDeadLetterStore{
+		deadLetters: make([]*kodex.DeadLetter, 0),
+	}, nil
+}
+
+func (i *InMemoryDeadLetterStore) Add(deadLetter *kodex.DeadLetter) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.deadLetters = append(i.deadLetters, deadLetter)
+	return nil
+}
+
+func (i *InMemoryDeadLetterStore) DeadLetters(filter *kodex.DeadLetterFilter) ([]*kodex.DeadLetter, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	deadLetters := make([]*kodex.DeadLetter, 0)
+	for _, deadLetter := range i.deadLetters {
+		if filter.Matches(deadLetter) {
+			deadLetters = append(deadLetters, deadLetter)
+		}
+	}
+	sortDeadLetters(deadLetters)
+	return deadLetters, nil
+}
+
+func (i *InMemoryDeadLetterStore) Delete(id []byte) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	for j, deadLetter := range i.deadLetters {
+		if bytes.Equal(deadLetter.ID, id) {
+			i.deadLetters = append(i.deadLetters[:j], i.deadLetters[j+1:]...)
+			return nil
+		}
+	}
+	return kodex.NotFound
+}
+
+// Sorts dead letters by time (oldest first)
+func sortDeadLetters(deadLetters []*kodex.DeadLetter) {
+	sort.SliceStable(deadLetters, func(i, j int) bool {
+		return deadLetters[i].CreatedAt.Before(deadLetters[j].CreatedAt)
+	})
+}
@@ -0,0 +1,90 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package deadletters_test
+
+import (
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/deadletters"
+	"testing"
+	"time"
+)
+
+func TestStores(t *testing.T) {
+
+	for name, definition := range deadletters.DeadLetterStores {
+
+		t.Run(name, func(t *testing.T) {
+
+			store, err := definition.Maker(map[string]interface{}{
+				"directory": t.TempDir(),
+			})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			projectID := []byte("project")
+			created := time.Now().UTC()
+
+			deadLetters := []*kodex.DeadLetter{
+				{ID: []byte{2}, ProjectID: projectID, StreamName: "default", Action: "validate", Item: map[string]interface{}{"name": "max"}, CreatedAt: created.Add(time.Second)},
+				{ID: []byte{1}, ProjectID: projectID, StreamName: "default", Action: "pseudonymize", Item: map[string]interface{}{"name": "anna"}, CreatedAt: created},
+				{ID: []byte{3}, ProjectID: projectID, StreamName: "other", Action: "validate", CreatedAt: created},
+			}
+
+			for _, deadLetter := range deadLetters {
+				if err := store.Add(deadLetter); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			list, err := store.DeadLetters(&kodex.DeadLetterFilter{ProjectID: projectID, StreamName: "default"})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(list) != 2 {
+				t.Fatalf("expected two dead letters, got %d", len(list))
+			}
+
+			if list[0].Action != "pseudonymize" || list[0].Item["name"] != "anna" {
+				t.Fatalf("expected the oldest dead letter first")
+			}
+
+			if list, err := store.DeadLetters(&kodex.DeadLetterFilter{Action: "validate", IDs: [][]byte{{3}}}); err != nil {
+				t.Fatal(err)
+			} else if len(list) != 1 || list[0].StreamName != "other" {
+				t.Fatalf("expected a single matching dead letter")
+			}
+
+			if err := store.Delete([]byte{1}); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := store.Delete([]byte{1}); err != kodex.NotFound {
+				t.Fatalf("expected a not found error, got %v", err)
+			}
+
+			if list, err := store.DeadLetters(&kodex.DeadLetterFilter{}); err != nil {
+				t.Fatal(err)
+			} else if len(list) != 2 {
+				t.Fatalf("expected two remaining dead letters, got %d", len(list))
+			}
+		})
+	}
+}
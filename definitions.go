@@ -23,6 +23,7 @@ import (
 type Definitions struct {
 	CommandsDefinitions
 	ParameterStoreDefinitions
+	DeadLetterStoreDefinitions
 	PluginDefinitions
 	ActionDefinitions
 	WriterDefinitions
@@ -33,13 +34,14 @@ type Definitions struct {
 
 func (d Definitions) Marshal() map[string]interface{} {
 	return map[string]interface{}{
-		"commands":   d.CommandsDefinitions,
-		"parameters": d.ParameterStoreDefinitions,
-		"plugins":    d.PluginDefinitions,
-		"actions":    d.ActionDefinitions,
-		"writers":    d.WriterDefinitions,
-		"readers":    d.ReaderDefinitions,
-		"hooks":      d.HookDefinitions,
+		"commands":     d.CommandsDefinitions,
+		"parameters":   d.ParameterStoreDefinitions,
+		"dead-letters": d.DeadLetterStoreDefinitions,
+		"plugins":      d.PluginDefinitions,
+		"actions":      d.ActionDefinitions,
+		"writers":      d.WriterDefinitions,
+		"readers":      d.ReaderDefinitions,
+		"hooks":        d.HookDefinitions,
 	}
 }
 
@@ -51,14 +53,15 @@ func (d Definitions) MarshalJSON() ([]byte, error) {
 
 func MergeDefinitions(a, b Definitions) Definitions {
 	c := Definitions{
-		CommandsDefinitions:       CommandsDefinitions{},
-		ParameterStoreDefinitions: ParameterStoreDefinitions{},
-		PluginDefinitions:         PluginDefinitions{},
-		ActionDefinitions:         ActionDefinitions{},
-		WriterDefinitions:         WriterDefinitions{},
-		ReaderDefinitions:         ReaderDefinitions{},
-		ControllerDefinitions:     ControllerDefinitions{},
-		HookDefinitions:           make(HookDefinitions, 0),
+		CommandsDefinitions:        CommandsDefinitions{},
+		ParameterStoreDefinitions:  ParameterStoreDefinitions{},
+		DeadLetterStoreDefinitions: DeadLetterStoreDefinitions{},
+		PluginDefinitions:          PluginDefinitions{},
+		ActionDefinitions:          ActionDefinitions{},
+		WriterDefinitions:          WriterDefinitions{},
+		ReaderDefinitions:          ReaderDefinitions{},
+		ControllerDefinitions:      ControllerDefinitions{},
+		HookDefinitions:            make(HookDefinitions, 0),
 	}
 	for _, obj := range []Definitions{a, b} {
 	addCommand:
@@ -88,6 +91,9 @@ func MergeDefinitions(a, b Definitions) Definitions {
 		for k, v := range obj.ParameterStoreDefinitions {
 			c.ParameterStoreDefinitions[k] = v
 		}
+		for k, v := range obj.DeadLetterStoreDefinitions {
+			c.DeadLetterStoreDefinitions[k] = v
+		}
 		for k, v := range obj.HookDefinitions {
 			c.HookDefinitions[k] = append(c.HookDefinitions[k], v...)
 		}
@@ -21,6 +21,7 @@ import (
 	"github.com/kiprotect/kodex/actions"
 	"github.com/kiprotect/kodex/cmd"
 	"github.com/kiprotect/kodex/controllers"
+	"github.com/kiprotect/kodex/deadletters"
 	"github.com/kiprotect/kodex/parameters"
 	"github.com/kiprotect/kodex/plugins"
 	"github.com/kiprotect/kodex/readers"
@@ -28,11 +29,12 @@ import (
 )
 
 var DefaultDefinitions = kodex.Definitions{
-	ParameterStoreDefinitions: parameters.ParameterStores,
-	CommandsDefinitions:       cmd.Commands,
-	PluginDefinitions:         plugins.Plugins,
-	ActionDefinitions:         actions.Actions,
-	WriterDefinitions:         writers.Writers,
-	ReaderDefinitions:         readers.Readers,
-	ControllerDefinitions:     controllers.Controllers,
+	ParameterStoreDefinitions:  parameters.ParameterStores,
+	DeadLetterStoreDefinitions: deadletters.DeadLetterStores,
+	CommandsDefinitions:        cmd.Commands,
+	PluginDefinitions:          plugins.Plugins,
+	ActionDefinitions:          actions.Actions,
+	WriterDefinitions:          writers.Writers,
+	ReaderDefinitions:          readers.Readers,
+	ControllerDefinitions:      controllers.Controllers,
 }
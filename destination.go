@@ -182,7 +182,7 @@ var DestinationForm = forms.Form{
 		},
 		{
 			Name:       "data",
-			Validators: []forms.Validator{forms.IsOptional{}, forms.IsStringMap{}},
+			Validators: []forms.Validator{forms.IsOptional{}, forms.IsStringMap{}, IsProcessingData{}},
 		},
 		{
 			Name:       "type",
@@ -59,7 +59,20 @@ func (b *BaseDestinationMap) createWriter() (Writer, error) {
 	return MakeInternalWriter(channel), nil
 }
 
+// Returns the writer to which streams write the items for the destination.
+// Stream destinations write to the internal channel of the chained stream
+// directly, so that the destination needs no writer of its own.
 func (b *BaseDestinationMap) InternalWriter() (Writer, error) {
+	if b.Self.Destination().DestinationType() == StreamDestinationType {
+		writer, err := MakeStreamWriter(b.Self.Destination().ConfigData())
+		if err != nil {
+			return nil, err
+		}
+		if err := writer.Setup(b.Self.Config()); err != nil {
+			return nil, err
+		}
+		return writer, nil
+	}
 	channel := MakeInternalChannel()
 	if err := channel.Setup(b.Self.Destination().Project().Controller(), b.Self); err != nil {
 		return nil, err
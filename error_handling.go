@@ -0,0 +1,198 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kodex
+
+import (
+	"fmt"
+	"github.com/kiprotect/go-helpers/errors"
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/go-helpers/maps"
+	"time"
+)
+
+// Determines what happens to an item if a given action fails to process it
+// (an action config's 'on-error' setting). Without a policy, the error
+// policy of the processor applies.
+type ErrorHandling struct {
+	Policy     ActionErrorPolicy
+	Field      string
+	Value      interface{}
+	Channel    string
+	Retries    int
+	RetryDelay time.Duration
+	MaxDelay   time.Duration
+}
+
+type ActionErrorPolicy string
+
+const (
+	// keeps the item as it was before the action
+	SkipAction ActionErrorPolicy = "skip"
+	// drops the item
+	DropItem ActionErrorPolicy = "drop"
+	// sets the field of the action to null
+	NullField ActionErrorPolicy = "null"
+	// sets the field of the action to a default value
+	DefaultField ActionErrorPolicy = "default"
+	// writes the item (as it was before the action) to a named channel
+	RouteItem ActionErrorPolicy = "route"
+	// aborts the processing
+	AbortProcessing ActionErrorPolicy = "abort"
+)
+
+var ErrorHandlingForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the error handling form",
+	Fields: []forms.Field{
+		{
+			Name: "policy",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsIn{Choices: []interface{}{"", "skip", "drop", "null", "default", "route", "abort"}},
+			},
+		},
+		{
+			// the field that is replaced by the 'null' and 'default' policies
+			// (the 'key' of the action config by default)
+			Name: "field",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// the value used by the 'default' policy
+			Name: "value",
+			Validators: []forms.Validator{
+				forms.CanBeAnything{},
+			},
+		},
+		{
+			// the channel to which the 'route' policy writes the item
+			Name: "channel",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// how often to retry the action before giving up
+			Name: "retries",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(0)},
+				forms.IsInteger{HasMin: true, Min: 0, HasMax: true, Max: 10},
+			},
+		},
+		{
+			// the delay before the first retry (in milliseconds), which
+			// doubles with every further retry
+			Name: "retry-delay",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(100)},
+				forms.IsInteger{HasMin: true, Min: 0, HasMax: true, Max: 60000},
+			},
+		},
+		{
+			// the maximum total time to wait between retries (in
+			// milliseconds), after which the action gives up
+			Name: "max-delay",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(10000)},
+				forms.IsInteger{HasMin: true, Min: 0, HasMax: true, Max: 600000},
+			},
+		},
+	},
+}
+
+// Validates an 'on-error' setting, which is either the name of a policy or
+// a map with the fields of the ErrorHandlingForm
+type IsErrorHandling struct{}
+
+func (i IsErrorHandling) Validate(value interface{}, values map[string]interface{}) (interface{}, error) {
+	if policy, ok := value.(string); ok {
+		value = map[string]interface{}{"policy": policy}
+	}
+	config, ok := maps.ToStringMap(value)
+	if !ok {
+		return nil, fmt.Errorf("expected a policy or a map")
+	}
+	params, err := ErrorHandlingForm.Validate(config)
+	if err != nil {
+		return nil, err
+	}
+	switch ActionErrorPolicy(params["policy"].(string)) {
+	case RouteItem:
+		if params["channel"] == "" {
+			return nil, fmt.Errorf("the 'route' policy requires a channel")
+		}
+	case DefaultField:
+		if _, ok := params["value"]; !ok || params["value"] == nil {
+			return nil, fmt.Errorf("the 'default' policy requires a value")
+		}
+	}
+	return params, nil
+}
+
+// Makes the error handling of an action from its (validated) 'on-error'
+// setting and its config
+func MakeErrorHandling(params map[string]interface{}, actionConfig map[string]interface{}) (*ErrorHandling, error) {
+
+	handling := &ErrorHandling{
+		Policy:     ActionErrorPolicy(params["policy"].(string)),
+		Field:      params["field"].(string),
+		Value:      params["value"],
+		Channel:    params["channel"].(string),
+		Retries:    int(params["retries"].(int64)),
+		RetryDelay: time.Duration(params["retry-delay"].(int64)) * time.Millisecond,
+		MaxDelay:   time.Duration(params["max-delay"].(int64)) * time.Millisecond,
+	}
+
+	if handling.Policy == NullField || handling.Policy == DefaultField {
+		if handling.Field == "" {
+			// most actions that work on a single field call it 'key'
+			if key, ok := actionConfig["key"].(string); ok {
+				handling.Field = key
+			} else {
+				return nil, fmt.Errorf("the '%s' policy requires a field", handling.Policy)
+			}
+		}
+	}
+
+	return handling, nil
+}
+
+func checkErrorHandling(onError map[string]interface{}, actionConfig map[string]interface{}) error {
+	if onError == nil {
+		return nil
+	}
+	_, err := MakeErrorHandling(onError, actionConfig)
+	return err
+}
+
+// Returns the error handling of an action config (nil if there is none)
+func ActionConfigErrorHandling(actionConfig ActionConfig) (*ErrorHandling, error) {
+	onError := actionConfig.OnError()
+	if onError == nil {
+		return nil, nil
+	}
+	return MakeErrorHandling(onError, actionConfig.ConfigData())
+}
+
+// Returns true if an action's error handling aborted the processing
+func aborted(err error) bool {
+	chainableErr, ok := err.(errors.ChainableError)
+	return ok && chainableErr.Code() == "ABORT-ACTION"
+}
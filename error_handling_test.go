@@ -0,0 +1,304 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kodex_test
+
+import (
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/actions"
+	"github.com/kiprotect/kodex/parameters"
+	"testing"
+	"time"
+)
+
+// an action that fails a given number of times before it succeeds
+type flakyAction struct {
+	kodex.BaseAction
+	failures int
+	calls    int
+}
+
+func (a *flakyAction) Params() interface{} {
+	return nil
+}
+
+func (a *flakyAction) HasParams() bool {
+	return false
+}
+
+func (a *flakyAction) SetParams(params interface{}) error {
+	return nil
+}
+
+func (a *flakyAction) GenerateParams(key, salt []byte) error {
+	return nil
+}
+
+func (a *flakyAction) Do(item *kodex.Item, channelWriter kodex.ChannelWriter) (*kodex.Item, error) {
+	a.calls++
+	// we modify the item before failing, which should not leak through
+	item.Set("name", "modified")
+	if a.calls <= a.failures {
+		return nil, fmt.Errorf("external resource unavailable")
+	}
+	item.Set("done", true)
+	return item, nil
+}
+
+func makeErrorHandlingProcessor(t *testing.T, failures int, onError interface{}) (*kodex.Processor, *flakyAction, *kodex.InMemoryChannelWriter) {
+
+	definitions := &kodex.Definitions{ActionDefinitions: actions.Actions}
+
+	store, err := parameters.MakeInMemoryParameterStore(map[string]interface{}{}, definitions)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	action := &flakyAction{
+		BaseAction: kodex.MakeBaseAction(kodex.ActionSpecification{
+			Name:   "flaky",
+			ID:     []byte("flaky"),
+			Config: map[string]interface{}{"key": "name"},
+		}, "flaky"),
+		failures: failures,
+	}
+
+	parameterSet, err := kodex.MakeParameterSet([]kodex.Action{action}, store)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	channelWriter := kodex.MakeInMemoryChannelWriter()
+
+	processor, err := kodex.MakeProcessor(parameterSet, channelWriter, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if onError != nil {
+		params, err := kodex.IsErrorHandling{}.Validate(onError, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handling, err := kodex.MakeErrorHandling(params.(map[string]interface{}), action.Config())
+		if err != nil {
+			t.Fatal(err)
+		}
+		processor.SetErrorHandling([]*kodex.ErrorHandling{handling})
+	}
+
+	return processor, action, channelWriter
+}
+
+func processOne(t *testing.T, processor *kodex.Processor) ([]*kodex.Item, error) {
+	return processor.Process([]*kodex.Item{kodex.MakeItem(map[string]interface{}{"name": "max"})}, nil)
+}
+
+func TestErrorHandlingPolicies(t *testing.T) {
+
+	for _, policy := range []string{"skip", "null", "default"} {
+
+		onError := map[string]interface{}{"policy": policy, "value": "unknown"}
+
+		processor, _, channelWriter := makeErrorHandlingProcessor(t, 1, onError)
+
+		items, err := processOne(t, processor)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(items) != 1 {
+			t.Fatalf("%s: expected one item, got %d", policy, len(items))
+		}
+
+		name, _ := items[0].Get("name")
+
+		switch policy {
+		case "skip":
+			if name != "max" {
+				t.Fatalf("skip: expected the unmodified item, got %v", name)
+			}
+		case "null":
+			if name != nil {
+				t.Fatalf("null: expected a null value, got %v", name)
+			}
+		case "default":
+			if name != "unknown" {
+				t.Fatalf("default: expected the default value, got %v", name)
+			}
+		}
+
+		if len(channelWriter.Warnings) != 1 {
+			t.Fatalf("%s: expected a warning", policy)
+		}
+
+		if len(channelWriter.Errors) != 0 {
+			t.Fatalf("%s: expected no errors", policy)
+		}
+	}
+
+	// the 'drop' policy removes the item
+	processor, _, _ := makeErrorHandlingProcessor(t, 1, "drop")
+
+	if items, err := processOne(t, processor); err != nil {
+		t.Fatal(err)
+	} else if len(items) != 0 {
+		t.Fatalf("expected the item to be dropped")
+	}
+
+	// the 'route' policy writes the item to a channel
+	processor, _, channelWriter := makeErrorHandlingProcessor(t, 1, map[string]interface{}{"policy": "route", "channel": "failed"})
+
+	if items, err := processOne(t, processor); err != nil {
+		t.Fatal(err)
+	} else if len(items) != 0 {
+		t.Fatalf("expected the item to be routed")
+	}
+
+	if routed := channelWriter.Items["failed"]; len(routed) != 1 {
+		t.Fatalf("expected one routed item")
+	} else if name, _ := routed[0].Get("name"); name != "max" {
+		t.Fatalf("expected the unmodified item to be routed, got %v", name)
+	}
+
+	// the 'abort' policy overrides the error policy of the processor
+	processor, _, channelWriter = makeErrorHandlingProcessor(t, 1, "abort")
+
+	if _, err := processOne(t, processor); err == nil {
+		t.Fatalf("expected the processing to abort")
+	}
+
+	if len(channelWriter.Errors) != 0 {
+		t.Fatalf("expected no reported errors")
+	}
+
+	// without an error handling, the error policy of the processor applies
+	processor, _, channelWriter = makeErrorHandlingProcessor(t, 1, nil)
+
+	if items, err := processOne(t, processor); err != nil {
+		t.Fatal(err)
+	} else if len(items) != 0 {
+		t.Fatalf("expected the item to be dropped")
+	}
+
+	if len(channelWriter.Errors) != 1 {
+		t.Fatalf("expected a reported error")
+	}
+
+}
+
+func TestErrorHandlingRetries(t *testing.T) {
+
+	onError := map[string]interface{}{"retries": 2, "retry-delay": 1}
+
+	processor, action, _ := makeErrorHandlingProcessor(t, 2, onError)
+
+	items, err := processOne(t, processor)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 1 || action.calls != 3 {
+		t.Fatalf("expected the action to succeed on the third attempt")
+	}
+
+	if done, _ := items[0].Get("done"); done != true {
+		t.Fatalf("expected a processed item")
+	}
+
+	// if the retries are exhausted, the policy applies
+	onError["policy"] = "skip"
+
+	processor, action, _ = makeErrorHandlingProcessor(t, 3, onError)
+
+	if items, err := processOne(t, processor); err != nil {
+		t.Fatal(err)
+	} else if len(items) != 1 || action.calls != 3 {
+		t.Fatalf("expected the action to be skipped after three attempts")
+	} else if name, _ := items[0].Get("name"); name != "max" {
+		t.Fatalf("expected the unmodified item, got %v", name)
+	}
+
+}
+
+func TestErrorHandlingRetryLimits(t *testing.T) {
+
+	// the retries stop once the total delay exceeds the maximum
+	onError := map[string]interface{}{"policy": "skip", "retries": 10, "retry-delay": 10, "max-delay": 25}
+
+	processor, action, _ := makeErrorHandlingProcessor(t, 10, onError)
+
+	if _, err := processOne(t, processor); err != nil {
+		t.Fatal(err)
+	} else if action.calls != 3 {
+		// we wait 10ms and then 15ms (instead of 20ms)
+		t.Fatalf("expected three attempts, got %d", action.calls)
+	}
+
+	// interrupting the processor ends the retries right away
+	onError = map[string]interface{}{"policy": "skip", "retries": 1, "retry-delay": 60000}
+
+	processor, action, _ = makeErrorHandlingProcessor(t, 1, onError)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		processor.Interrupt()
+	}()
+
+	start := time.Now()
+
+	if _, err := processOne(t, processor); err != kodex.RetryInterrupted {
+		t.Fatalf("expected an interrupted retry, got %v", err)
+	} else if time.Since(start) > time.Second || action.calls != 1 {
+		t.Fatalf("expected the retry to be interrupted")
+	}
+
+}
+
+func TestErrorHandlingValidation(t *testing.T) {
+
+	invalid := []interface{}{
+		"explode",
+		map[string]interface{}{"policy": "route"},
+		map[string]interface{}{"policy": "default"},
+		map[string]interface{}{"retries": 100},
+		42,
+	}
+
+	for _, onError := range invalid {
+		if _, err := (kodex.IsErrorHandling{}).Validate(onError, nil); err == nil {
+			t.Fatalf("expected '%v' to be invalid", onError)
+		}
+	}
+
+	params, err := kodex.IsErrorHandling{}.Validate("null", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without a 'key' in the action config, the field is required
+	if _, err := kodex.MakeErrorHandling(params.(map[string]interface{}), map[string]interface{}{}); err == nil {
+		t.Fatalf("expected an error")
+	}
+
+}
@@ -75,6 +75,19 @@ func (s *InternalChannel) Teardown() error {
 	return err
 }
 
+// Internal readers that know how many payloads are waiting to be read
+type QueueingReader interface {
+	QueueLength() int
+}
+
+// Returns the number of payloads waiting in the channel (0 if unknown)
+func (s *InternalChannel) QueueLength() int {
+	if queueingReader, ok := s.InternalReader.(QueueingReader); ok {
+		return queueingReader.QueueLength()
+	}
+	return 0
+}
+
 func (a *InternalChannel) Purge() error {
 	if a.InternalReader == nil {
 		return nil
@@ -140,6 +153,12 @@ func (i *BasicInternalReader) Read() (Payload, error) {
 	return payload, nil
 }
 
+func (i *BasicInternalReader) QueueLength() int {
+	i.Store.Lock()
+	defer i.Store.Unlock()
+	return len(i.Store.Items[i.Model.Type()][hex.EncodeToString(i.Model.ID())])
+}
+
 func (i *BasicInternalReader) Setup(Stream) error {
 	return fmt.Errorf("setup with stream not supported")
 }
@@ -376,9 +395,17 @@ func (s *InternalChannel) Read() (Payload, error) {
 	return s.InternalReader.Read()
 }
 
-// We write items to the internal Internal writer.
+// We write items to the internal Internal writer. Only the basic channel
+// passes the payload itself on, other channels take over the responsibility
+// for the items with a successful write, so we acknowledge the payload.
 func (s *InternalChannel) Write(payload Payload) error {
-	return s.InternalWriter.Write(payload)
+	if err := s.InternalWriter.Write(payload); err != nil {
+		return err
+	}
+	if _, ok := s.InternalWriter.(*BasicInternalWriter); !ok {
+		return payload.Acknowledge()
+	}
+	return nil
 }
 
 func MakeInternalChannel() *InternalChannel {
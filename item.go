@@ -64,6 +64,15 @@ func (f *Item) Set(key string, value interface{}) {
 	f.d[key] = value
 }
 
+// Returns a shallow copy of the item
+func (f *Item) Copy() *Item {
+	d := make(map[string]interface{}, len(f.d))
+	for key, value := range f.d {
+		d[key] = value
+	}
+	return MakeItem(d)
+}
+
 func (f *Item) Serialize(format string) ([]byte, error) {
 	switch format {
 	case "json":
@@ -0,0 +1,251 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kodex
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/go-helpers/maps"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+/*
+Key derivation hierarchy
+
+Instead of generating random parameters and persisting them in the parameter
+store, actions can derive their parameters from a root secret. To make sure
+that different projects and actions never share key material, the root secret
+is not used directly but passes through several HKDF-SHA256 steps, each with
+an explicit context label:
+
+	project key = HKDF(root secret,  salt = project ID,  info = "kodex:v1:project")
+	action key  = HKDF(project key,  salt = action ID,   info = "kodex:v1:action")
+	key         = HKDF(action key,   salt = config hash, info = "kodex:v1:action-key")
+	salt        = HKDF(action key,   salt = config hash, info = "kodex:v1:action-salt")
+
+The resulting key and salt are passed to the GenerateParams method of the
+action. Runs with the same root secret are therefore reproducible, while
+changing the project, the action or the action configuration yields
+independent parameters.
+
+Root secrets are defined by name in the settings and never need to appear in
+blueprints:
+
+	root-secrets:
+	  main:
+	    env: KODEX_ROOT_SECRET # or 'file: /path/to/secret' or 'value: ...'
+	root-secret: main # the default root secret (optional)
+
+A config (or stream) can reference a root secret via its data:
+
+	data:
+	  root-secret: main
+
+As the action key depends on the action ID, actions need a stable ID for runs
+to be reproducible. Actions in blueprints can specify their ID (as a hex
+string) via 'id', otherwise it is derived from the project ID and the action
+name (see DeriveActionID), so renaming an action changes its parameters.
+*/
+
+const (
+	KeyDerivationProjectLabel    = "kodex:v1:project"
+	KeyDerivationActionLabel     = "kodex:v1:action"
+	KeyDerivationActionKeyLabel  = "kodex:v1:action-key"
+	KeyDerivationActionSaltLabel = "kodex:v1:action-salt"
+	KeyDerivationActionIDLabel   = "kodex:v1:action-id"
+	// length of all derived keys and salts (in bytes)
+	KeyDerivationLength = 32
+)
+
+var RootSecretForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "value",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "env",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "file",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsString{},
+			},
+		},
+	},
+}
+
+func hkdfExpand(secret, salt []byte, label string) ([]byte, error) {
+	key := make([]byte, KeyDerivationLength)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(label)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// DeriveProjectKey derives the key of a given project from a root secret.
+func DeriveProjectKey(rootSecret, projectID []byte) ([]byte, error) {
+	if len(rootSecret) == 0 {
+		return nil, fmt.Errorf("root secret is empty")
+	}
+	if len(projectID) == 0 {
+		return nil, fmt.Errorf("project ID is empty")
+	}
+	return hkdfExpand(rootSecret, projectID, KeyDerivationProjectLabel)
+}
+
+// DeriveActionKey derives the key and salt for a given action from a
+// project key, binding them to the action ID and configuration.
+func DeriveActionKey(projectKey []byte, action Action) ([]byte, []byte, error) {
+
+	if len(action.ID()) == 0 {
+		return nil, nil, fmt.Errorf("action ID is empty")
+	}
+
+	configHash, err := action.ConfigHash()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	actionKey, err := hkdfExpand(projectKey, action.ID(), KeyDerivationActionLabel)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := hkdfExpand(actionKey, configHash, KeyDerivationActionKeyLabel)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	salt, err := hkdfExpand(actionKey, configHash, KeyDerivationActionSaltLabel)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, salt, nil
+}
+
+// DeriveActionID derives a stable ID for an action without an explicit ID
+// from the project ID and the action name (this is not a secret).
+func DeriveActionID(projectID []byte, name string) []byte {
+	hash := sha256.New()
+	for _, value := range [][]byte{[]byte(KeyDerivationActionIDLabel), projectID, []byte(name)} {
+		// we prefix every value with its length to keep them apart
+		hash.Write([]byte(fmt.Sprintf("%d:", len(value))))
+		hash.Write(value)
+	}
+	return hash.Sum(nil)[:RANDOM_ID_LENGTH]
+}
+
+// RootSecret returns the root secret with the given name from the settings.
+func RootSecret(settings Settings, name string) ([]byte, error) {
+
+	secretsValue, err := settings.Get("root-secrets")
+
+	if err != nil {
+		return nil, fmt.Errorf("no root secrets defined")
+	}
+
+	secretsMap, ok := maps.ToStringMap(secretsValue)
+
+	if !ok {
+		return nil, fmt.Errorf("root secrets should be a map")
+	}
+
+	secretValue, ok := secretsMap[name]
+
+	if !ok {
+		return nil, fmt.Errorf("root secret '%s' is not defined", name)
+	}
+
+	secretMap, ok := maps.ToStringMap(secretValue)
+
+	if !ok {
+		return nil, fmt.Errorf("root secret '%s' should be a map", name)
+	}
+
+	params, err := RootSecretForm.Validate(secretMap)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var secret []byte
+
+	if value, ok := params["value"].(string); ok {
+		secret = []byte(value)
+	} else if env, ok := params["env"].(string); ok {
+		secret = []byte(os.Getenv(env))
+	} else if file, ok := params["file"].(string); ok {
+		if secret, err = ioutil.ReadFile(file); err != nil {
+			return nil, err
+		}
+		secret = bytes.TrimSpace(secret)
+	} else {
+		return nil, fmt.Errorf("root secret '%s' needs a value, env or file", name)
+	}
+
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("root secret '%s' is empty", name)
+	}
+
+	return secret, nil
+}
+
+// RootSecretName returns the name of the root secret that should be used for
+// the given config, or an empty string if none is defined.
+func RootSecretName(config Config, settings Settings) (string, error) {
+
+	for _, data := range []interface{}{config.Data(), config.Stream().Data()} {
+		if dataMap, ok := maps.ToStringMap(data); ok {
+			if name, ok := dataMap["root-secret"]; ok {
+				if nameStr, ok := name.(string); !ok {
+					return "", fmt.Errorf("root secret name should be a string")
+				} else {
+					return nameStr, nil
+				}
+			}
+		}
+	}
+
+	if name, err := settings.Get("root-secret"); err == nil {
+		if nameStr, ok := name.(string); !ok {
+			return "", fmt.Errorf("root secret name should be a string")
+		} else {
+			return nameStr, nil
+		}
+	}
+
+	return "", nil
+}
@@ -0,0 +1,197 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kodex_test
+
+import (
+	"bytes"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/actions"
+	pt "github.com/kiprotect/kodex/helpers/testing"
+	pf "github.com/kiprotect/kodex/helpers/testing/fixtures"
+	"testing"
+)
+
+func makePseudonymizeAction(t *testing.T, id []byte, key string) kodex.Action {
+	definitions := &kodex.Definitions{ActionDefinitions: actions.Actions}
+	action, err := kodex.MakeAction("test", "", "pseudonymize", id, map[string]interface{}{
+		"key":    key,
+		"method": "merengue",
+		"config": map[string]interface{}{},
+	}, definitions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return action
+}
+
+func TestKeyDerivation(t *testing.T) {
+
+	projectKey, err := kodex.DeriveProjectKey([]byte("root secret"), []byte("project"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if otherKey, err := kodex.DeriveProjectKey([]byte("root secret"), []byte("project")); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(projectKey, otherKey) {
+		t.Fatalf("project keys should be reproducible")
+	}
+
+	if otherKey, err := kodex.DeriveProjectKey([]byte("root secret"), []byte("other project")); err != nil {
+		t.Fatal(err)
+	} else if bytes.Equal(projectKey, otherKey) {
+		t.Fatalf("project keys should differ between projects")
+	}
+
+	if _, err := kodex.DeriveProjectKey(nil, []byte("project")); err == nil {
+		t.Fatalf("an empty root secret should be rejected")
+	}
+
+	actionA := makePseudonymizeAction(t, []byte("action-a"), "foo")
+	actionB := makePseudonymizeAction(t, []byte("action-b"), "foo")
+	// same ID but a different configuration
+	actionC := makePseudonymizeAction(t, []byte("action-a"), "bar")
+
+	keyA, saltA, err := kodex.DeriveActionKey(projectKey, actionA)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(keyA, saltA) {
+		t.Fatalf("key and salt should differ")
+	}
+
+	if key, salt, err := kodex.DeriveActionKey(projectKey, actionA); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(key, keyA) || !bytes.Equal(salt, saltA) {
+		t.Fatalf("action keys should be reproducible")
+	}
+
+	for _, action := range []kodex.Action{actionB, actionC} {
+		if key, salt, err := kodex.DeriveActionKey(projectKey, action); err != nil {
+			t.Fatal(err)
+		} else if bytes.Equal(key, keyA) || bytes.Equal(salt, saltA) {
+			t.Fatalf("action keys should be isolated")
+		}
+	}
+
+}
+
+func TestRootSecret(t *testing.T) {
+
+	var fixtureConfig = []pt.FC{
+		pt.FC{&pf.Settings{}, "settings"},
+	}
+
+	fixtures, err := pt.SetupFixtures(fixtureConfig)
+	defer pt.TeardownFixtures(fixtureConfig, fixtures)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings := fixtures["settings"].(kodex.Settings)
+
+	if _, err := kodex.RootSecret(settings, "main"); err == nil {
+		t.Fatalf("expected an error without root secrets")
+	}
+
+	t.Setenv("KODEX_TEST_ROOT_SECRET", "from env")
+
+	settings.Set("root-secrets", map[string]interface{}{
+		"main":  map[string]interface{}{"value": "from value"},
+		"env":   map[string]interface{}{"env": "KODEX_TEST_ROOT_SECRET"},
+		"empty": map[string]interface{}{"env": "KODEX_TEST_MISSING_ROOT_SECRET"},
+	})
+
+	for name, expected := range map[string]string{"main": "from value", "env": "from env"} {
+		if secret, err := kodex.RootSecret(settings, name); err != nil {
+			t.Fatal(err)
+		} else if string(secret) != expected {
+			t.Fatalf("unexpected root secret for '%s': %s", name, secret)
+		}
+	}
+
+	for _, name := range []string{"empty", "missing"} {
+		if _, err := kodex.RootSecret(settings, name); err == nil {
+			t.Fatalf("expected an error for root secret '%s'", name)
+		}
+	}
+
+}
+
+func TestBlueprintActionIDs(t *testing.T) {
+
+	blueprint := map[string]interface{}{
+		"actions": []interface{}{
+			map[string]interface{}{
+				"name": "pseudonymize",
+				"type": "pseudonymize",
+				"config": map[string]interface{}{
+					"key":    "foo",
+					"method": "merengue",
+				},
+			},
+		},
+	}
+
+	actionID := func() []byte {
+
+		var fixtureConfig = []pt.FC{
+			pt.FC{&pf.Settings{}, "settings"},
+			pt.FC{&pf.Controller{}, "controller"},
+			pt.FC{&pf.Blueprint{Config: blueprint}, "blueprint"},
+		}
+
+		fixtures, err := pt.SetupFixtures(fixtureConfig)
+		defer pt.TeardownFixtures(fixtureConfig, fixtures)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		controller := fixtures["controller"].(kodex.Controller)
+
+		actionConfigs, err := controller.ActionConfigs(map[string]interface{}{"name": "pseudonymize"})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(actionConfigs) != 1 {
+			t.Fatalf("expected one action config, got %d", len(actionConfigs))
+		}
+
+		return actionConfigs[0].ID()
+	}
+
+	// actions without an ID (and thus their derived keys) should be the same
+	// every time the blueprint is loaded
+	if first, second := actionID(), actionID(); !bytes.Equal(first, second) {
+		t.Fatalf("expected a stable action ID")
+	}
+
+	if bytes.Equal(kodex.DeriveActionID([]byte("project"), "a"), kodex.DeriveActionID([]byte("project"), "b")) {
+		t.Fatalf("action IDs should differ between actions")
+	}
+
+	if bytes.Equal(kodex.DeriveActionID([]byte("project"), "a"), kodex.DeriveActionID([]byte("other project"), "a")) {
+		t.Fatalf("action IDs should differ between projects")
+	}
+}
@@ -0,0 +1,154 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kodex
+
+import (
+	"sync"
+)
+
+/*
+A lineage links the payloads derived from a payload (e.g. the payloads that a
+stream writes to its destinations) to that payload. The payload is acknowledged
+once all derived payloads were acknowledged, and rejected (so that it can be
+delivered again) if any of them was rejected. If derived payloads were only
+requeued (e.g. because a shutdown aborted their processing), the payload is
+requeued as well, which does not count as a failed delivery.
+
+The creator of a lineage holds it until it has derived all payloads and then
+resolves it via Acknowledge or Reject. Lineages can be nested, since derived
+payloads can be the origin of other lineages.
+*/
+type Lineage struct {
+	mutex      sync.Mutex
+	payload    Payload
+	pending    int
+	resolution resolution
+}
+
+// The resolutions of a payload, a rejection overrides a requeueing, which
+// overrides an acknowledgement
+type resolution int
+
+const (
+	acknowledgedPayload resolution = iota
+	requeuedPayload
+	rejectedPayload
+)
+
+func MakeLineage(payload Payload) *Lineage {
+	return &Lineage{
+		payload: payload,
+		pending: 1,
+	}
+}
+
+func (l *Lineage) Payload() Payload {
+	return l.payload
+}
+
+// Derives a new payload, which needs to be acknowledged or rejected
+func (l *Lineage) Derive(items []*Item, headers map[string]interface{}, endOfStream bool) *DerivedPayload {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.pending++
+	return &DerivedPayload{
+		BasicPayload: MakeBasicPayload(items, headers, endOfStream),
+		lineage:      l,
+	}
+}
+
+// Releases the creator's hold on the lineage
+func (l *Lineage) Acknowledge() error {
+	return l.resolve(acknowledgedPayload)
+}
+
+// Releases the creator's hold on the lineage and rejects the payload
+func (l *Lineage) Reject() error {
+	return l.resolve(rejectedPayload)
+}
+
+// Releases the creator's hold on the lineage and requeues the payload
+func (l *Lineage) Requeue() error {
+	return l.resolve(requeuedPayload)
+}
+
+func (l *Lineage) resolve(resolution resolution) error {
+
+	l.mutex.Lock()
+
+	if l.pending == 0 {
+		l.mutex.Unlock()
+		return nil
+	}
+
+	l.pending--
+	if resolution > l.resolution {
+		l.resolution = resolution
+	}
+
+	if l.pending > 0 {
+		l.mutex.Unlock()
+		return nil
+	}
+
+	resolution = l.resolution
+	l.mutex.Unlock()
+
+	switch resolution {
+	case rejectedPayload:
+		return l.payload.Reject()
+	case requeuedPayload:
+		return RequeuePayload(l.payload)
+	}
+
+	return l.payload.Acknowledge()
+}
+
+type DerivedPayload struct {
+	*BasicPayload
+	mutex    sync.Mutex
+	lineage  *Lineage
+	resolved bool
+}
+
+func (d *DerivedPayload) Lineage() *Lineage {
+	return d.lineage
+}
+
+func (d *DerivedPayload) Acknowledge() error {
+	return d.resolve(acknowledgedPayload)
+}
+
+func (d *DerivedPayload) Reject() error {
+	return d.resolve(rejectedPayload)
+}
+
+func (d *DerivedPayload) Requeue() error {
+	return d.resolve(requeuedPayload)
+}
+
+// only the first acknowledgement, rejection or requeueing counts
+func (d *DerivedPayload) resolve(resolution resolution) error {
+	d.mutex.Lock()
+	if d.resolved {
+		d.mutex.Unlock()
+		return nil
+	}
+	d.resolved = true
+	d.mutex.Unlock()
+	return d.lineage.resolve(resolution)
+}
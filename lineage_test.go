@@ -0,0 +1,136 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kodex_test
+
+import (
+	"github.com/kiprotect/kodex"
+	"testing"
+)
+
+type resolvedPayload struct {
+	*kodex.BasicPayload
+	acknowledged int
+	rejected     int
+}
+
+func (p *resolvedPayload) Acknowledge() error {
+	p.acknowledged++
+	return nil
+}
+
+func (p *resolvedPayload) Reject() error {
+	p.rejected++
+	return nil
+}
+
+func TestLineage(t *testing.T) {
+
+	for _, reject := range []bool{false, true} {
+
+		payload := &resolvedPayload{BasicPayload: kodex.MakeBasicPayload(nil, nil, false)}
+
+		lineage := kodex.MakeLineage(payload)
+
+		first := lineage.Derive(nil, nil, false)
+		second := lineage.Derive(nil, nil, false)
+
+		// nested lineages resolve their origin when they are resolved
+		nested := kodex.MakeLineage(second)
+		third := nested.Derive(nil, nil, false)
+
+		if err := nested.Acknowledge(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := lineage.Acknowledge(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := first.Acknowledge(); err != nil {
+			t.Fatal(err)
+		}
+
+		// only the first resolution of a derived payload counts
+		if err := first.Reject(); err != nil {
+			t.Fatal(err)
+		}
+
+		if payload.acknowledged != 0 || payload.rejected != 0 {
+			t.Fatalf("the payload should not be resolved yet")
+		}
+
+		if reject {
+			third.Reject()
+		} else {
+			third.Acknowledge()
+		}
+
+		if reject && (payload.acknowledged != 0 || payload.rejected != 1) {
+			t.Fatalf("expected the payload to be rejected")
+		} else if !reject && (payload.acknowledged != 1 || payload.rejected != 0) {
+			t.Fatalf("expected the payload to be acknowledged")
+		}
+	}
+}
+
+type requeueablePayload struct {
+	resolvedPayload
+	requeued int
+}
+
+func (p *requeueablePayload) Requeue() error {
+	p.requeued++
+	return nil
+}
+
+func TestLineageRequeue(t *testing.T) {
+
+	for _, reject := range []bool{false, true} {
+
+		payload := &requeueablePayload{resolvedPayload: resolvedPayload{BasicPayload: kodex.MakeBasicPayload(nil, nil, false)}}
+
+		lineage := kodex.MakeLineage(payload)
+
+		first := lineage.Derive(nil, nil, false)
+		second := lineage.Derive(nil, nil, false)
+
+		if err := lineage.Acknowledge(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := first.Requeue(); err != nil {
+			t.Fatal(err)
+		}
+
+		// a rejection overrides the requeueing
+		if reject {
+			second.Reject()
+		} else {
+			second.Acknowledge()
+		}
+
+		if reject && (payload.rejected != 1 || payload.requeued != 0) {
+			t.Fatalf("expected the payload to be rejected")
+		} else if !reject && (payload.rejected != 0 || payload.requeued != 1) {
+			t.Fatalf("expected the payload to be requeued")
+		}
+
+		if payload.acknowledged != 0 {
+			t.Fatalf("the payload should not be acknowledged")
+		}
+	}
+}
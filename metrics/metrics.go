@@ -0,0 +1,133 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+/*
+Package metrics provides Prometheus metrics for the processing of streams,
+actions and destinations. All metrics are registered with the Registry of
+this package, which is exposed via the API (at /metrics) and optionally by
+'kodex run' and 'kodex worker' (see Serve).
+*/
+package metrics
+
+import (
+	"github.com/kiprotect/go-helpers/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+var Registry = prometheus.NewRegistry()
+
+var (
+	SourceItems = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kodex",
+		Name:      "source_items_total",
+		Help:      "Number of items read from sources.",
+	}, []string{"source"})
+
+	StreamItems = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kodex",
+		Name:      "stream_items_total",
+		Help:      "Number of items received by streams.",
+	}, []string{"stream"})
+
+	ConfigItems = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kodex",
+		Name:      "config_items_total",
+		Help:      "Number of items processed by configs.",
+	}, []string{"stream", "config"})
+
+	ActionItems = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kodex",
+		Name:      "action_items_total",
+		Help:      "Number of items successfully processed by actions.",
+	}, []string{"stream", "config", "action"})
+
+	Errors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kodex",
+		Name:      "errors_total",
+		Help:      "Number of processing errors by error code.",
+	}, []string{"stream", "config", "action", "code"})
+
+	ProcessingDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "kodex",
+		Name:      "processing_duration_seconds",
+		Help:      "Time it takes configs to process a batch of items.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+	}, []string{"stream", "config"})
+
+	DestinationItems = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kodex",
+		Name:      "destination_items_total",
+		Help:      "Number of items written to destinations.",
+	}, []string{"destination"})
+
+	DestinationErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kodex",
+		Name:      "destination_errors_total",
+		Help:      "Number of failed writes to destinations.",
+	}, []string{"destination"})
+
+	DestinationWriteDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "kodex",
+		Name:      "destination_write_duration_seconds",
+		Help:      "Time it takes destinations to write a batch of items.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+	}, []string{"destination"})
+
+	ParameterLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kodex",
+		Name:      "parameter_lookups_total",
+		Help:      "Number of parameter lookups by result: 'cached' (already in the parameter set), 'loaded' (from the parameter store) or 'missing' (new parameters are generated).",
+	}, []string{"stream", "config", "action", "result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		SourceItems,
+		StreamItems,
+		ConfigItems,
+		ActionItems,
+		Errors,
+		ProcessingDuration,
+		DestinationItems,
+		DestinationErrors,
+		DestinationWriteDuration,
+		ParameterLookups,
+		queues,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Returns the code of the innermost structured error (or "UNKNOWN")
+func ErrorCode(err error) string {
+	code := "UNKNOWN"
+	for err != nil {
+		chainableErr, ok := err.(errors.ChainableError)
+		if !ok {
+			break
+		}
+		code = chainableErr.Code()
+		err = chainableErr.Parent()
+	}
+	return code
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,84 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package metrics
+
+import (
+	"fmt"
+	"github.com/kiprotect/go-helpers/errors"
+	"testing"
+)
+
+func queueLengths(t *testing.T) map[string]float64 {
+	families, err := Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lengths := make(map[string]float64)
+	for _, family := range families {
+		if family.GetName() != "kodex_queue_length" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := metric.GetLabel()
+			lengths[labels[0].GetValue()+"/"+labels[1].GetValue()] = metric.GetGauge().GetValue()
+		}
+	}
+	return lengths
+}
+
+func TestQueues(t *testing.T) {
+
+	unregisterA := RegisterQueue("stream", "test", func() int { return 2 })
+	unregisterB := RegisterQueue("stream", "test", func() int { return 3 })
+	unregisterC := RegisterQueue("destination", "test", func() int { return 1 })
+
+	lengths := queueLengths(t)
+
+	// queues of the same kind and name are summed up
+	if lengths["stream/test"] != 5 || lengths["destination/test"] != 1 {
+		t.Fatalf("unexpected queue lengths: %v", lengths)
+	}
+
+	unregisterA()
+	unregisterC()
+
+	lengths = queueLengths(t)
+
+	if lengths["stream/test"] != 3 || len(lengths) != 1 {
+		t.Fatalf("unexpected queue lengths: %v", lengths)
+	}
+
+	unregisterB()
+
+	if lengths = queueLengths(t); len(lengths) != 0 {
+		t.Fatalf("expected no queues, got %v", lengths)
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+
+	inner := errors.MakeExternalError("cannot parse", "PARSE", nil, fmt.Errorf("invalid"))
+	outer := errors.MakeExternalError("error processing action", "PROCESS-ACTION", nil, inner)
+
+	if code := ErrorCode(outer); code != "PARSE" {
+		t.Fatalf("expected the innermost code, got %s", code)
+	}
+
+	if code := ErrorCode(fmt.Errorf("invalid")); code != "UNKNOWN" {
+		t.Fatalf("expected an unknown code, got %s", code)
+	}
+}
@@ -0,0 +1,83 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+)
+
+type queueKey struct {
+	kind string
+	name string
+}
+
+// Reports the lengths of the registered queues when metrics are collected.
+// Queues with the same kind and name (e.g. of different executors for the
+// same stream) are summed up.
+type queueCollector struct {
+	mutex  sync.Mutex
+	desc   *prometheus.Desc
+	next   int
+	queues map[int]queue
+}
+
+type queue struct {
+	key    queueKey
+	length func() int
+}
+
+var queues = &queueCollector{
+	desc: prometheus.NewDesc(
+		"kodex_queue_length",
+		"Number of payloads waiting in the queues of executors.",
+		[]string{"kind", "name"},
+		nil,
+	),
+	queues: map[int]queue{},
+}
+
+// Registers a queue of the given kind (e.g. 'stream') and name, returns a
+// function that unregisters it again
+func RegisterQueue(kind, name string, length func() int) func() {
+	queues.mutex.Lock()
+	defer queues.mutex.Unlock()
+	id := queues.next
+	queues.next++
+	queues.queues[id] = queue{key: queueKey{kind, name}, length: length}
+	return func() {
+		queues.mutex.Lock()
+		defer queues.mutex.Unlock()
+		delete(queues.queues, id)
+	}
+}
+
+func (q *queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- q.desc
+}
+
+func (q *queueCollector) Collect(ch chan<- prometheus.Metric) {
+	q.mutex.Lock()
+	lengths := make(map[queueKey]int)
+	for _, queue := range q.queues {
+		lengths[queue.key] += queue.length()
+	}
+	q.mutex.Unlock()
+	for key, length := range lengths {
+		ch <- prometheus.MustNewConstMetric(q.desc, prometheus.GaugeValue, float64(length), key.kind, key.name)
+	}
+}
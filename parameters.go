@@ -24,6 +24,7 @@ import (
 	"github.com/kiprotect/go-helpers/forms"
 	"github.com/kiprotect/go-helpers/maps"
 	"sort"
+	"time"
 )
 
 type ParameterStoreDefinition struct {
@@ -50,6 +51,29 @@ type ParameterStore interface {
 	AllParameterSets() ([]*ParameterSet, error)
 }
 
+// Describes an inconsistency in a parameter store, e.g. a parameter set
+// that references parameters that do not exist.
+type ParameterStoreProblem struct {
+	// The ID of the affected parameters or the hash of the parameter set
+	ID []byte `json:"id"`
+	// Either "parameters" or "parameter-set"
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// A parameter store that supports maintenance operations. Maintenance
+// operations should only be performed while no other process is using the
+// store.
+type MaintainableParameterStore interface {
+	ParameterStore
+	// Checks the store for inconsistencies
+	Verify() ([]*ParameterStoreProblem, error)
+	// Compacts the store and returns the number of removed entries
+	Compact() (int, error)
+	// Removes the given parameters and parameter sets from the store
+	Remove([]*Parameters, []*ParameterSet) error
+}
+
 func MakeParameterStore(settings Settings, definitions *Definitions) (ParameterStore, error) {
 	config, err := settings.Get("parameter-store")
 
@@ -84,6 +108,7 @@ type ParameterSet struct {
 	parameterStore ParameterStore
 	parameters     []*Parameters
 	hash           []byte
+	createdAt      time.Time
 }
 
 func MakeParameterSet(actions []Action, parameterStore ParameterStore) (*ParameterSet, error) {
@@ -110,10 +135,16 @@ func (a ParameterSet) MarshalJSON() ([]byte, error) {
 		parametersList[i] = hex.EncodeToString(parameters.ID())
 	}
 
-	return json.Marshal(map[string]interface{}{
+	data := map[string]interface{}{
 		"parameters": parametersList,
 		"hash":       hex.EncodeToString(a.hash),
-	})
+	}
+
+	if !a.createdAt.IsZero() {
+		data["created_at"] = a.createdAt
+	}
+
+	return json.Marshal(data)
 }
 
 func (p *ParameterSet) SetParameterStore(parameterStore ParameterStore) {
@@ -166,6 +197,9 @@ func (p *ParameterSet) ParametersFor(action Action, parameterGroup *ParameterGro
 	// if we have found some valid parameters in the store we replace them in
 	// the parameter set
 	if validParameters != nil {
+		// we keep using our own action, as stateful actions (e.g. aggregates)
+		// were set up already
+		validParameters.action = action
 		p.parameters[i] = validParameters
 		return validParameters, true, p.UpdateHash()
 	}
@@ -177,13 +211,35 @@ func (p *ParameterSet) Hash() []byte {
 	return p.hash
 }
 
+// Returns the time at which the parameter set was first saved (zero for
+// unsaved sets and sets that were stored by older versions of Kodex)
+func (p *ParameterSet) CreatedAt() time.Time {
+	return p.createdAt
+}
+
 func (p *ParameterSet) UpdateParameters(action Action, params interface{}, parameterGroup *ParameterGroup) error {
+	return p.updateParameters(MakeParameters(action, p.ParameterStore(), params, parameterGroup))
+}
+
+// Like UpdateParameters, but the parameters are only stored sealed with the
+// public key of the undo guard (see UndoGuard)
+func (p *ParameterSet) UpdateSealedParameters(action Action, params interface{}, parameterGroup *ParameterGroup, guard *UndoGuard) error {
+	newParameters := MakeParameters(action, p.ParameterStore(), nil, parameterGroup)
+	if sealed, err := guard.sealParameters(newParameters.ID(), params); err != nil {
+		return err
+	} else {
+		newParameters.sealed = sealed
+	}
+	return p.updateParameters(newParameters)
+}
+
+func (p *ParameterSet) updateParameters(newParameters *Parameters) error {
 
 	found := false
 	var i int
 	var parameters *Parameters
 	for i, parameters = range p.parameters {
-		if bytes.Equal(parameters.Action().ID(), action.ID()) {
+		if bytes.Equal(parameters.Action().ID(), newParameters.Action().ID()) {
 			found = true
 			break
 		}
@@ -193,8 +249,7 @@ func (p *ParameterSet) UpdateParameters(action Action, params interface{}, param
 		return NotFound
 	}
 
-	// we try to generate new parameters for the given parameter group
-	newParameters := MakeParameters(action, p.ParameterStore(), params, parameterGroup)
+	// we try to save the new parameters for the given parameter group
 	if err := newParameters.Save(); err != nil {
 		return err
 	}
@@ -205,6 +260,16 @@ func (p *ParameterSet) UpdateParameters(action Action, params interface{}, param
 	return p.UpdateHash()
 }
 
+// Returns the parameters of the set for the given action
+func (p *ParameterSet) ActionParameters(action Action) *Parameters {
+	for _, parameters := range p.parameters {
+		if bytes.Equal(parameters.Action().ID(), action.ID()) {
+			return parameters
+		}
+	}
+	return nil
+}
+
 // The hash uniquely identifies a given parameters set based on the IDs of
 // the constitutent paremeters.
 func (p *ParameterSet) UpdateHash() error {
@@ -221,6 +286,10 @@ func (p *ParameterSet) UpdateHash() error {
 	if hash, err := StructuredHash(ids); err != nil {
 		return err
 	} else {
+		if !bytes.Equal(hash, p.hash) {
+			// this is a new parameter set that hasn't been saved yet
+			p.createdAt = time.Time{}
+		}
 		p.hash = hash
 	}
 	return nil
@@ -233,7 +302,7 @@ func (p *ParameterSet) Parameters() []*Parameters {
 
 func (p *ParameterSet) Empty() bool {
 	for _, parameters := range p.parameters {
-		if parameters.Parameters() != nil {
+		if parameters.Parameters() != nil || parameters.Sealed() != nil {
 			return false
 		}
 	}
@@ -248,6 +317,9 @@ func (p *ParameterSet) Save() error {
 	if p.parameterStore == nil {
 		return fmt.Errorf("no parameter store given")
 	}
+	if p.createdAt.IsZero() {
+		p.createdAt = time.Now().UTC()
+	}
 	_, err := p.parameterStore.SaveParameterSet(p)
 	return err
 }
@@ -272,6 +344,9 @@ type Parameters struct {
 	action         Action
 	id             []byte
 	parameterGroup *ParameterGroup
+	createdAt      time.Time
+	// the parameters sealed by an undo guard (see UndoGuard)
+	sealed []byte
 }
 
 var ParameterForm = forms.Form{
@@ -364,6 +439,20 @@ var ParameterForm = forms.Form{
 				forms.IsOptional{Default: nil},
 			},
 		},
+		{
+			Name: "sealed",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsBytes{Encoding: "hex"},
+			},
+		},
+		{
+			Name: "created_at",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsTime{Format: "rfc3339"},
+			},
+		},
 	},
 }
 
@@ -392,6 +481,13 @@ var ParameterSetForm = forms.Form{
 				},
 			},
 		},
+		{
+			Name: "created_at",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsTime{Format: "rfc3339"},
+			},
+		},
 	},
 }
 
@@ -412,10 +508,12 @@ func RestoreParameterSet(data map[string]interface{}, parameterStore ParameterSt
 		}
 		parametersList[i] = parameters
 	}
+	createdAt, _ := config["created_at"].(time.Time)
 	return &ParameterSet{
 		parameterStore: parameterStore,
 		parameters:     parametersList,
 		hash:           config["hash"].([]byte),
+		createdAt:      createdAt,
 	}, nil
 }
 
@@ -431,12 +529,16 @@ func RestoreParameters(data map[string]interface{}, parameterStore ParameterStor
 		return nil, err
 	}
 	parameterGroupConfig := config["parameter_group"].(map[string]interface{})
+	createdAt, _ := config["created_at"].(time.Time)
+	sealed, _ := config["sealed"].([]byte)
 
 	return &Parameters{
 		action:         action,
 		id:             config["id"].([]byte),
 		parameters:     config["parameters"],
+		sealed:         sealed,
 		parameterStore: parameterStore,
+		createdAt:      createdAt,
 		parameterGroup: &ParameterGroup{
 			data: parameterGroupConfig["data"].(map[string]interface{}),
 			hash: parameterGroupConfig["hash"].([]byte),
@@ -445,7 +547,7 @@ func RestoreParameters(data map[string]interface{}, parameterStore ParameterStor
 }
 
 func (a Parameters) MarshalJSON() ([]byte, error) {
-	return json.Marshal(map[string]interface{}{
+	data := map[string]interface{}{
 		"parameters": a.parameters,
 		"id":         hex.EncodeToString(a.id),
 		"action":     a.action,
@@ -453,7 +555,14 @@ func (a Parameters) MarshalJSON() ([]byte, error) {
 			"hash": hex.EncodeToString(a.parameterGroup.Hash()),
 			"data": a.parameterGroup.Data(),
 		},
-	})
+	}
+	if !a.createdAt.IsZero() {
+		data["created_at"] = a.createdAt
+	}
+	if a.sealed != nil {
+		data["sealed"] = hex.EncodeToString(a.sealed)
+	}
+	return json.Marshal(data)
 }
 
 func MakeParameters(action Action, parameterStore ParameterStore, parameters interface{}, parameterGroup *ParameterGroup) *Parameters {
@@ -463,6 +572,7 @@ func MakeParameters(action Action, parameterStore ParameterStore, parameters int
 		parameterStore: parameterStore,
 		parameters:     parameters,
 		parameterGroup: parameterGroup,
+		createdAt:      time.Now().UTC(),
 	}
 }
 
@@ -487,11 +597,23 @@ func (p *Parameters) ParameterGroup() *ParameterGroup {
 	return p.parameterGroup
 }
 
+// Returns the time at which the parameters were generated (zero for
+// parameters that were stored by older versions of Kodex)
+func (p *Parameters) CreatedAt() time.Time {
+	return p.createdAt
+}
+
 // Returns the parameters
 func (p *Parameters) Parameters() interface{} {
 	return p.parameters
 }
 
+// Returns the sealed parameters (nil if the parameters are not sealed), which
+// can be opened with the undo guard (see UndoGuard.OpenParameters)
+func (p *Parameters) Sealed() []byte {
+	return p.sealed
+}
+
 // Returns whether the parameters are valid for a given parameter group
 func (p *Parameters) Valid(action Action, parameterGroup *ParameterGroup) (bool, error) {
 	configHashA, err := action.ConfigHash()
@@ -515,3 +637,112 @@ func (p *Parameters) Action() Action {
 func (p *Parameters) ID() []byte {
 	return p.id
 }
+
+// Returns the parameters and parameter sets in the store that are not
+// referenced by any of the given configs. Parameters are referenced if
+// their action ID and config hash match an action of one of the configs.
+// Parameter sets are unused if they contain at least one unused parameter.
+func UnusedParameters(parameterStore ParameterStore, configs []Config) ([]*Parameters, []*ParameterSet, error) {
+
+	// we collect the action ID & config hash combinations that are in use
+	used := map[string]bool{}
+
+	for _, config := range configs {
+		actionConfigs, err := config.ActionConfigs()
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, actionConfig := range actionConfigs {
+			action, err := actionConfig.Action()
+			if err != nil {
+				return nil, nil, err
+			}
+			configHash, err := action.ConfigHash()
+			if err != nil {
+				return nil, nil, err
+			}
+			used[hex.EncodeToString(action.ID())+":"+hex.EncodeToString(configHash)] = true
+		}
+	}
+
+	allParameters, err := parameterStore.AllParameters()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	unusedParameters := make([]*Parameters, 0)
+	unusedIDs := map[string]bool{}
+
+	for _, parameters := range allParameters {
+		configHash, err := parameters.Action().ConfigHash()
+		if err != nil {
+			return nil, nil, err
+		}
+		if used[hex.EncodeToString(parameters.Action().ID())+":"+hex.EncodeToString(configHash)] {
+			continue
+		}
+		unusedParameters = append(unusedParameters, parameters)
+		unusedIDs[string(parameters.ID())] = true
+	}
+
+	allParameterSets, err := parameterStore.AllParameterSets()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	unusedParameterSets := make([]*ParameterSet, 0)
+
+	for _, parameterSet := range allParameterSets {
+		for _, parameters := range parameterSet.Parameters() {
+			if unusedIDs[string(parameters.ID())] {
+				unusedParameterSets = append(unusedParameterSets, parameterSet)
+				break
+			}
+		}
+	}
+
+	return unusedParameters, unusedParameterSets, nil
+}
+
+// Checks that every parameter set in the store references existing
+// parameters and that its hash matches the IDs of these parameters.
+func VerifyParameterStore(parameterStore ParameterStore) ([]*ParameterStoreProblem, error) {
+
+	parameterSets, err := parameterStore.AllParameterSets()
+
+	if err != nil {
+		return nil, err
+	}
+
+	problems := make([]*ParameterStoreProblem, 0)
+
+	for _, parameterSet := range parameterSets {
+		ids := make([]string, 0, len(parameterSet.Parameters()))
+		for _, parameters := range parameterSet.Parameters() {
+			ids = append(ids, string(parameters.ID()))
+			if existingParameters, err := parameterStore.ParametersById(parameters.ID()); err != nil {
+				return nil, err
+			} else if existingParameters == nil {
+				problems = append(problems, &ParameterStoreProblem{
+					ID:      parameterSet.Hash(),
+					Type:    "parameter-set",
+					Message: fmt.Sprintf("references missing parameters '%s'", hex.EncodeToString(parameters.ID())),
+				})
+			}
+		}
+		sort.Strings(ids)
+		if hash, err := StructuredHash(ids); err != nil {
+			return nil, err
+		} else if !bytes.Equal(hash, parameterSet.Hash()) {
+			problems = append(problems, &ParameterStoreProblem{
+				ID:      parameterSet.Hash(),
+				Type:    "parameter-set",
+				Message: "hash does not match the referenced parameters",
+			})
+		}
+	}
+
+	return problems, nil
+}
@@ -0,0 +1,371 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package parameters
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+	"io"
+	"os"
+	"time"
+)
+
+/*
+A bundle is used to move parameters between environments. It contains the
+serialized parameters, encrypted either with a key derived from a passphrase
+or with a key agreed with the public key of the recipient, and it is signed
+by the sender.
+
+Keys are stored as PEM files and can be generated with OpenSSL, e.g.
+
+	openssl genpkey -algorithm ed25519 -out signing.pem
+	openssl pkey -in signing.pem -pubout -out signing.pub.pem
+	openssl genpkey -algorithm x25519 -out encryption.pem
+	openssl pkey -in encryption.pem -pubout -out encryption.pub.pem
+*/
+type Bundle struct {
+	Version    int               `json:"version"`
+	CreatedAt  time.Time         `json:"created_at"`
+	Encryption *BundleEncryption `json:"encryption"`
+	Nonce      []byte            `json:"nonce"`
+	Ciphertext []byte            `json:"ciphertext,omitempty"`
+	Signer     []byte            `json:"signer"`
+	Signature  []byte            `json:"signature,omitempty"`
+}
+
+type BundleEncryption struct {
+	// Either "passphrase" or "x25519"
+	Type string `json:"type"`
+	// scrypt parameters (for passphrase encryption)
+	Salt []byte `json:"salt,omitempty"`
+	N    int    `json:"n,omitempty"`
+	R    int    `json:"r,omitempty"`
+	P    int    `json:"p,omitempty"`
+	// key agreement parameters (for public key encryption)
+	EphemeralKey []byte `json:"ephemeral_key,omitempty"`
+	Recipient    []byte `json:"recipient,omitempty"`
+}
+
+type BundleKeys struct {
+	// Passphrase used for encryption/decryption
+	Passphrase []byte
+	// Public key of the recipient (for encryption)
+	Recipient *ecdh.PublicKey
+	// Private key of the recipient (for decryption)
+	PrivateKey *ecdh.PrivateKey
+	// Key used for signing the bundle
+	SigningKey ed25519.PrivateKey
+	// Key used for verifying the bundle signature
+	VerifyKey ed25519.PublicKey
+}
+
+const BundleVersion = 1
+
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+	// upper bound for the scrypt cost we accept when opening a bundle
+	maxScryptN = 1 << 20
+)
+
+var bundleInfo = []byte("kodex parameter bundle v1")
+
+// Checks if the given data is a bundle (as opposed to a plain JSON export)
+func IsBundle(data []byte) bool {
+	var bundle map[string]interface{}
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return false
+	}
+	_, hasCiphertext := bundle["ciphertext"]
+	_, hasSignature := bundle["signature"]
+	return hasCiphertext && hasSignature
+}
+
+// Encrypts and signs the given data
+func SealBundle(data []byte, keys *BundleKeys) (*Bundle, error) {
+
+	if keys.SigningKey == nil {
+		return nil, fmt.Errorf("a signing key is required")
+	}
+
+	bundle := &Bundle{
+		Version:   BundleVersion,
+		CreatedAt: time.Now().UTC(),
+		Signer:    keys.SigningKey.Public().(ed25519.PublicKey),
+	}
+
+	var key []byte
+	var err error
+
+	if keys.Recipient != nil {
+		var ephemeralKey *ecdh.PrivateKey
+		if ephemeralKey, err = ecdh.X25519().GenerateKey(rand.Reader); err != nil {
+			return nil, err
+		}
+		bundle.Encryption = &BundleEncryption{
+			Type:         "x25519",
+			EphemeralKey: ephemeralKey.PublicKey().Bytes(),
+			Recipient:    keys.Recipient.Bytes(),
+		}
+		if key, err = agreeKey(ephemeralKey, keys.Recipient, bundle.Encryption); err != nil {
+			return nil, err
+		}
+	} else if keys.Passphrase != nil {
+		bundle.Encryption = &BundleEncryption{
+			Type: "passphrase",
+			N:    scryptN,
+			R:    scryptR,
+			P:    scryptP,
+		}
+		if bundle.Encryption.Salt, err = kodex.RandomBytes(16); err != nil {
+			return nil, err
+		}
+		if key, err = deriveKey(keys.Passphrase, bundle.Encryption); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, fmt.Errorf("a passphrase or recipient key is required")
+	}
+
+	aead, err := chacha20poly1305.NewX(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if bundle.Nonce, err = kodex.RandomBytes(aead.NonceSize()); err != nil {
+		return nil, err
+	}
+
+	// we bind the header to the ciphertext
+	header, err := json.Marshal(bundle)
+
+	if err != nil {
+		return nil, err
+	}
+
+	bundle.Ciphertext = aead.Seal(nil, bundle.Nonce, data, header)
+
+	signedData, err := json.Marshal(bundle)
+
+	if err != nil {
+		return nil, err
+	}
+
+	bundle.Signature = ed25519.Sign(keys.SigningKey, signedData)
+
+	return bundle, nil
+}
+
+// Verifies the signature of the bundle and decrypts its data
+func (b *Bundle) Open(keys *BundleKeys) ([]byte, error) {
+
+	if b.Version != BundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version: %d", b.Version)
+	}
+
+	if keys.VerifyKey == nil {
+		return nil, fmt.Errorf("a verification key is required")
+	}
+
+	if !ed25519.PublicKey(b.Signer).Equal(keys.VerifyKey) {
+		return nil, fmt.Errorf("bundle was not signed by the given key")
+	}
+
+	signature := b.Signature
+	ciphertext := b.Ciphertext
+
+	b.Signature = nil
+	signedData, err := json.Marshal(b)
+	b.Signature = signature
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !ed25519.Verify(keys.VerifyKey, signedData, signature) {
+		return nil, fmt.Errorf("invalid bundle signature")
+	}
+
+	if b.Encryption == nil {
+		return nil, fmt.Errorf("encryption parameters missing")
+	}
+
+	var key []byte
+
+	switch b.Encryption.Type {
+	case "x25519":
+		if keys.PrivateKey == nil {
+			return nil, fmt.Errorf("bundle is encrypted for a recipient key, please provide the private key")
+		}
+		ephemeralKey, err := ecdh.X25519().NewPublicKey(b.Encryption.EphemeralKey)
+		if err != nil {
+			return nil, err
+		}
+		if key, err = agreeKey(keys.PrivateKey, ephemeralKey, b.Encryption); err != nil {
+			return nil, err
+		}
+	case "passphrase":
+		if keys.Passphrase == nil {
+			return nil, fmt.Errorf("bundle is encrypted with a passphrase, please provide it")
+		}
+		if b.Encryption.N > maxScryptN {
+			return nil, fmt.Errorf("scrypt cost too high")
+		}
+		if key, err = deriveKey(keys.Passphrase, b.Encryption); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown encryption type: %s", b.Encryption.Type)
+	}
+
+	aead, err := chacha20poly1305.NewX(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce")
+	}
+
+	// the header does not include the ciphertext and signature
+	b.Ciphertext, b.Signature = nil, nil
+	header, err := json.Marshal(b)
+	b.Ciphertext, b.Signature = ciphertext, signature
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := aead.Open(nil, b.Nonce, ciphertext, header)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt bundle (wrong key or passphrase?)")
+	}
+
+	return data, nil
+}
+
+func deriveKey(passphrase []byte, encryption *BundleEncryption) ([]byte, error) {
+	return scrypt.Key(passphrase, encryption.Salt, encryption.N, encryption.R, encryption.P, chacha20poly1305.KeySize)
+}
+
+func agreeKey(privateKey *ecdh.PrivateKey, publicKey *ecdh.PublicKey, encryption *BundleEncryption) ([]byte, error) {
+	secret, err := privateKey.ECDH(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	// we bind the key to both public keys
+	salt := append(append([]byte{}, encryption.EphemeralKey...), encryption.Recipient...)
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, bundleInfo), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func readPEM(filename string) (*pem.Block, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in '%s'", filename)
+	}
+	return block, nil
+}
+
+func readPrivateKey(filename string) (interface{}, error) {
+	block, err := readPEM(filename)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParsePKCS8PrivateKey(block.Bytes)
+}
+
+func readPublicKey(filename string) (interface{}, error) {
+	block, err := readPEM(filename)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// Loads an Ed25519 private key from a PKCS #8 PEM file
+func LoadSigningKey(filename string) (ed25519.PrivateKey, error) {
+	key, err := readPrivateKey(filename)
+	if err != nil {
+		return nil, err
+	}
+	if signingKey, ok := key.(ed25519.PrivateKey); !ok {
+		return nil, fmt.Errorf("'%s' does not contain an Ed25519 private key", filename)
+	} else {
+		return signingKey, nil
+	}
+}
+
+// Loads an Ed25519 public key from a PKIX PEM file
+func LoadVerifyKey(filename string) (ed25519.PublicKey, error) {
+	key, err := readPublicKey(filename)
+	if err != nil {
+		return nil, err
+	}
+	if verifyKey, ok := key.(ed25519.PublicKey); !ok {
+		return nil, fmt.Errorf("'%s' does not contain an Ed25519 public key", filename)
+	} else {
+		return verifyKey, nil
+	}
+}
+
+// Loads an X25519 private key from a PKCS #8 PEM file
+func LoadEncryptionKey(filename string) (*ecdh.PrivateKey, error) {
+	key, err := readPrivateKey(filename)
+	if err != nil {
+		return nil, err
+	}
+	if privateKey, ok := key.(*ecdh.PrivateKey); !ok || privateKey.Curve() != ecdh.X25519() {
+		return nil, fmt.Errorf("'%s' does not contain an X25519 private key", filename)
+	} else {
+		return privateKey, nil
+	}
+}
+
+// Loads an X25519 public key from a PKIX PEM file
+func LoadRecipientKey(filename string) (*ecdh.PublicKey, error) {
+	key, err := readPublicKey(filename)
+	if err != nil {
+		return nil, err
+	}
+	if publicKey, ok := key.(*ecdh.PublicKey); !ok || publicKey.Curve() != ecdh.X25519() {
+		return nil, fmt.Errorf("'%s' does not contain an X25519 public key", filename)
+	} else {
+		return publicKey, nil
+	}
+}
@@ -0,0 +1,189 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package parameters_test
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/kiprotect/kodex/parameters"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writePEM(t *testing.T, filename, blockType string, data []byte) {
+	if err := os.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBundle(t *testing.T) {
+
+	dir := t.TempDir()
+
+	verifyKey, signingKey, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// we write the keys to PEM files and load them again
+	if data, err := x509.MarshalPKCS8PrivateKey(signingKey); err != nil {
+		t.Fatal(err)
+	} else {
+		writePEM(t, filepath.Join(dir, "signing.pem"), "PRIVATE KEY", data)
+	}
+
+	if data, err := x509.MarshalPKIXPublicKey(verifyKey); err != nil {
+		t.Fatal(err)
+	} else {
+		writePEM(t, filepath.Join(dir, "signing.pub.pem"), "PUBLIC KEY", data)
+	}
+
+	if data, err := x509.MarshalPKCS8PrivateKey(privateKey); err != nil {
+		t.Fatal(err)
+	} else {
+		writePEM(t, filepath.Join(dir, "encryption.pem"), "PRIVATE KEY", data)
+	}
+
+	if data, err := x509.MarshalPKIXPublicKey(privateKey.PublicKey()); err != nil {
+		t.Fatal(err)
+	} else {
+		writePEM(t, filepath.Join(dir, "encryption.pub.pem"), "PUBLIC KEY", data)
+	}
+
+	loadedSigningKey, err := parameters.LoadSigningKey(filepath.Join(dir, "signing.pem"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loadedVerifyKey, err := parameters.LoadVerifyKey(filepath.Join(dir, "signing.pub.pem"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loadedPrivateKey, err := parameters.LoadEncryptionKey(filepath.Join(dir, "encryption.pem"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loadedRecipient, err := parameters.LoadRecipientKey(filepath.Join(dir, "encryption.pub.pem"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := parameters.LoadRecipientKey(filepath.Join(dir, "signing.pub.pem")); err == nil {
+		t.Fatalf("expected an error when loading a signing key as recipient key")
+	}
+
+	data := []byte(`{"parameters": [], "parameter-sets": []}`)
+
+	for _, keys := range []*parameters.BundleKeys{
+		{Passphrase: []byte("secret"), SigningKey: loadedSigningKey},
+		{Recipient: loadedRecipient, SigningKey: loadedSigningKey},
+	} {
+		bundle, err := parameters.SealBundle(data, keys)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bundleData, err := json.Marshal(bundle)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !parameters.IsBundle(bundleData) {
+			t.Fatalf("expected a bundle")
+		}
+
+		if bytes.Contains(bundleData, []byte("parameter-sets")) {
+			t.Fatalf("bundle is not encrypted")
+		}
+
+		var restoredBundle parameters.Bundle
+
+		if err := json.Unmarshal(bundleData, &restoredBundle); err != nil {
+			t.Fatal(err)
+		}
+
+		openKeys := &parameters.BundleKeys{
+			Passphrase: []byte("secret"),
+			PrivateKey: loadedPrivateKey,
+			VerifyKey:  loadedVerifyKey,
+		}
+
+		if openedData, err := restoredBundle.Open(openKeys); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(openedData, data) {
+			t.Fatalf("data does not match")
+		}
+
+		// a bundle signed by someone else should be rejected
+		otherVerifyKey, _, _ := ed25519.GenerateKey(rand.Reader)
+
+		if _, err := restoredBundle.Open(&parameters.BundleKeys{
+			Passphrase: []byte("secret"),
+			PrivateKey: loadedPrivateKey,
+			VerifyKey:  otherVerifyKey,
+		}); err == nil {
+			t.Fatalf("expected an error for an unknown signer")
+		}
+
+		// a modified bundle should be rejected
+		restoredBundle.Ciphertext[0] ^= 1
+
+		if _, err := restoredBundle.Open(openKeys); err == nil {
+			t.Fatalf("expected an error for a modified bundle")
+		}
+
+		restoredBundle.Ciphertext[0] ^= 1
+
+		// we re-sign the bundle with a different encryption header, which
+		// should still fail as the header is bound to the ciphertext
+		if keys.Passphrase != nil {
+			restoredBundle.Encryption.N = 1 << 14
+			restoredBundle.Signature = nil
+			signedData, _ := json.Marshal(restoredBundle)
+			restoredBundle.Signature = ed25519.Sign(loadedSigningKey, signedData)
+			if _, err := restoredBundle.Open(openKeys); err == nil {
+				t.Fatalf("expected an error for a modified header")
+			}
+		}
+	}
+
+	if _, err := parameters.SealBundle(data, &parameters.BundleKeys{Passphrase: []byte("secret")}); err == nil {
+		t.Fatalf("expected an error for an unsigned bundle")
+	}
+
+}
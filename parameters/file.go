@@ -288,8 +288,10 @@ func (e *DataEntry) Reassemble(chunks []*DataChunk) error {
 }
 
 type ByPosition struct {
-	Entries   []*DataEntry
-	Positions map[string]int
+	Entries []*DataEntry
+	// The position of the first chunk of each entry (the same entry ID can
+	// occur multiple times, so we store them by index)
+	Positions []int
 }
 
 func (b ByPosition) Len() int {
@@ -298,12 +300,11 @@ func (b ByPosition) Len() int {
 
 func (b ByPosition) Swap(i, j int) {
 	b.Entries[i], b.Entries[j] = b.Entries[j], b.Entries[i]
+	b.Positions[i], b.Positions[j] = b.Positions[j], b.Positions[i]
 }
 
 func (b ByPosition) Less(i, j int) bool {
-	posI := b.Positions[string(b.Entries[i].ID)]
-	posJ := b.Positions[string(b.Entries[j].ID)]
-	return posI < posJ
+	return b.Positions[i] < b.Positions[j]
 }
 
 // Reassembles data entries from a list of data chunks. Returns any remaining
@@ -314,7 +315,7 @@ func reassemble(chunks []*DataChunk) ([]*DataEntry, []*DataChunk, error) {
 		be interleaved within each other.
 	*/
 	chunkPositions := make(map[string]int)
-	entryPositions := make(map[string]int)
+	entryPositions := make([]int, 0, 10)
 	dataEntries := make([]*DataEntry, 0, 10)
 	remainingChunks := make([]*DataChunk, 0, 10)
 	chunksByID := make(map[string][]*DataChunk)
@@ -337,12 +338,12 @@ func reassemble(chunks []*DataChunk) ([]*DataEntry, []*DataChunk, error) {
 		if err := dataEntry.Reassemble(idChunks); err != nil {
 			return nil, nil, err
 		}
-		entryPositions[string(dataEntry.ID)] = chunkPositions[id]
 		if dataEntry.ID == nil {
 			remainingChunks = append(remainingChunks, idChunks...)
 			continue
 		}
 		dataEntries = append(dataEntries, dataEntry)
+		entryPositions = append(entryPositions, chunkPositions[id])
 	}
 	sortedByPosition := &ByPosition{
 		Entries:   dataEntries,
@@ -358,6 +359,11 @@ type DataStore interface {
 	Write(*DataEntry) error
 	// Read data from the store
 	Read() ([]*DataEntry, error)
+	// Read all data from the beginning of the store, also returning chunks
+	// that do not belong to a complete entry
+	ReadAll() ([]*DataEntry, []*DataChunk, error)
+	// Replace the contents of the store with the given entries
+	Rewrite([]*DataEntry) error
 	Init() error
 }
 
@@ -383,7 +389,10 @@ func MakeFileDataStore(filename, format string) *FileDataStore {
 func (f *FileDataStore) Init() error {
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
+	return f.init()
+}
 
+func (f *FileDataStore) init() error {
 	// we try to create the directory if it doesn't exist
 	dir := filepath.Dir(f.filename)
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
@@ -408,15 +417,19 @@ func (f *FileDataStore) Init() error {
 }
 
 func (f *FileDataStore) readChunks() ([]*DataChunk, error) {
+	return readChunks(f.rfile)
+}
+
+func readChunks(file *os.File) ([]*DataChunk, error) {
 	chunks := make([]*DataChunk, 0, 10)
 	for {
 		chunk := &DataChunk{}
-		position, err := f.rfile.Seek(0, 1)
+		position, err := file.Seek(0, 1)
 		if err != nil {
 			return nil, err
 		}
-		if err := chunk.Read(f.rfile); err != nil {
-			if _, seekErr := f.rfile.Seek(position, 0); seekErr != nil {
+		if err := chunk.Read(file); err != nil {
+			if _, seekErr := file.Seek(position, 0); seekErr != nil {
 				kodex.Log.Errorf("Warning, two errors occured.")
 				kodex.Log.Error(seekErr)
 			}
@@ -450,6 +463,86 @@ func (f *FileDataStore) Read() ([]*DataEntry, error) {
 	return dataEntries, nil
 }
 
+func (f *FileDataStore) ReadAll() ([]*DataEntry, []*DataChunk, error) {
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	// we use a separate file handle so that we do not interfere with the
+	// position of the incremental reader
+	rfile, err := os.Open(f.filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rfile.Close()
+
+	chunks, err := readChunks(rfile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return reassemble(chunks)
+}
+
+// Rewrites the store with the given entries. The new data is written to a
+// temporary file first, which then replaces the original file. A backup of
+// the original file is kept with a ".bak" suffix.
+func (f *FileDataStore) Rewrite(entries []*DataEntry) error {
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	tmpFilename := f.filename + ".tmp"
+
+	tmpFile, err := os.OpenFile(tmpFilename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0700)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		chunks, err := entry.Split()
+		if err != nil {
+			tmpFile.Close()
+			return err
+		}
+		for _, chunk := range chunks {
+			if err := chunk.Write(tmpFile); err != nil {
+				tmpFile.Close()
+				return err
+			}
+		}
+	}
+
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	if err := f.wfile.Close(); err != nil {
+		return err
+	}
+
+	if err := f.rfile.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(f.filename, f.filename+".bak"); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpFilename, f.filename); err != nil {
+		return err
+	}
+
+	// we start reading from the beginning of the new file
+	f.chunks = make([]*DataChunk, 0, 10)
+
+	return f.init()
+}
+
 func (f *FileDataStore) Write(entry *DataEntry) error {
 	if chunks, err := entry.Split(); err != nil {
 		return err
@@ -579,7 +672,13 @@ func (p *FileParameterStore) update() error {
 						kodex.Log.Debug("Skipping parameter set")
 						continue
 					}
-					if err := parameterSet.Save(); err != nil {
+					// we save the set directly to the in-memory store, as
+					// ParameterSet.Save would set a creation time for sets
+					// written by older versions
+					if parameterSet.Empty() {
+						continue
+					}
+					if _, err := p.inMemoryStore.SaveParameterSet(parameterSet); err != nil {
 						return err
 					}
 					parameterSet.SetParameterStore(p)
@@ -785,3 +884,228 @@ func (p *FileParameterStore) SaveParameterSet(parameterSet *kodex.ParameterSet)
 	// if not we write it to disk
 	return true, p.writeParameterSet(parameterSet)
 }
+
+// Checks the raw entries of the data store for inconsistencies. In contrast
+// to the other methods, this does not stop at the first invalid entry.
+func (p *FileParameterStore) Verify() ([]*kodex.ParameterStoreProblem, error) {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	entries, remainingChunks, err := p.dataStore.ReadAll()
+
+	if err != nil {
+		return nil, err
+	}
+
+	problems := make([]*kodex.ParameterStoreProblem, 0)
+
+	if len(remainingChunks) > 0 {
+		problems = append(problems, &kodex.ParameterStoreProblem{
+			Type:    "chunks",
+			Message: fmt.Sprintf("found %d chunks that do not belong to a complete entry", len(remainingChunks)),
+		})
+	}
+
+	// we restore the entries into a separate in-memory store
+	inMemoryStore, err := MakeInMemoryParameterStore(map[string]interface{}{}, p.Definitions())
+
+	if err != nil {
+		return nil, err
+	}
+
+	store := inMemoryStore.(*InMemoryParameterStore)
+
+	parameterSetEntries := make([]*DataEntry, 0)
+
+	for _, entry := range entries {
+		var data map[string]interface{}
+		if err := json.Unmarshal(entry.Data, &data); err != nil {
+			problems = append(problems, &kodex.ParameterStoreProblem{
+				ID:      entry.ID,
+				Type:    entryType(entry),
+				Message: fmt.Sprintf("cannot parse entry: %v", err),
+			})
+			continue
+		}
+		switch entry.Type {
+		case ParametersType:
+			parameters, err := store.RestoreParameters(data)
+			if err != nil {
+				problems = append(problems, &kodex.ParameterStoreProblem{
+					ID:      entry.ID,
+					Type:    "parameters",
+					Message: fmt.Sprintf("cannot restore parameters: %v", err),
+				})
+				continue
+			}
+			if !bytes.Equal(parameters.ID(), entry.ID) {
+				problems = append(problems, &kodex.ParameterStoreProblem{
+					ID:      entry.ID,
+					Type:    "parameters",
+					Message: "entry ID does not match parameters ID",
+				})
+			}
+			// duplicates are not a problem, they are removed by compaction
+			if existingParameters, err := store.ParametersById(parameters.ID()); err != nil {
+				return nil, err
+			} else if existingParameters != nil {
+				continue
+			}
+			if existingParameters, err := store.Parameters(parameters.Action(), parameters.ParameterGroup()); err != nil {
+				return nil, err
+			} else if existingParameters != nil {
+				continue
+			}
+			if _, err := store.SaveParameters(parameters); err != nil {
+				return nil, err
+			}
+		case ParameterSetType:
+			// we check parameter sets after all parameters have been restored
+			parameterSetEntries = append(parameterSetEntries, entry)
+		default:
+			problems = append(problems, &kodex.ParameterStoreProblem{
+				ID:      entry.ID,
+				Type:    entryType(entry),
+				Message: fmt.Sprintf("unknown entry type: %d", entry.Type),
+			})
+		}
+	}
+
+	for _, entry := range parameterSetEntries {
+		var data map[string]interface{}
+		// we already know this works
+		json.Unmarshal(entry.Data, &data)
+		config, err := kodex.ParameterSetForm.Validate(data)
+		if err != nil {
+			problems = append(problems, &kodex.ParameterStoreProblem{
+				ID:      entry.ID,
+				Type:    "parameter-set",
+				Message: fmt.Sprintf("invalid parameter set: %v", err),
+			})
+			continue
+		}
+		ids := make([]string, 0)
+		for _, id := range config["parameters"].([]interface{}) {
+			ids = append(ids, string(id.([]byte)))
+			if parameters, err := store.ParametersById(id.([]byte)); err != nil {
+				return nil, err
+			} else if parameters == nil {
+				problems = append(problems, &kodex.ParameterStoreProblem{
+					ID:      entry.ID,
+					Type:    "parameter-set",
+					Message: fmt.Sprintf("references missing parameters '%s'", hex.EncodeToString(id.([]byte))),
+				})
+			}
+		}
+		sort.Strings(ids)
+		if hash, err := kodex.StructuredHash(ids); err != nil {
+			return nil, err
+		} else if !bytes.Equal(hash, entry.ID) {
+			problems = append(problems, &kodex.ParameterStoreProblem{
+				ID:      entry.ID,
+				Type:    "parameter-set",
+				Message: "hash does not match the referenced parameters",
+			})
+		}
+	}
+
+	return problems, nil
+}
+
+// Rewrites the data store without duplicate entries and incomplete chunks.
+// No other process may write to the store while it gets compacted.
+func (p *FileParameterStore) Compact() (int, error) {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	entries, remainingChunks, err := p.dataStore.ReadAll()
+
+	if err != nil {
+		return 0, err
+	}
+
+	seen := map[string]bool{}
+	compactedEntries := make([]*DataEntry, 0, len(entries))
+
+	for _, entry := range entries {
+		key := fmt.Sprintf("%d:%s", entry.Type, hex.EncodeToString(entry.ID))
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		compactedEntries = append(compactedEntries, entry)
+	}
+
+	removed := len(entries) - len(compactedEntries)
+
+	if removed == 0 && len(remainingChunks) == 0 {
+		// nothing to do
+		return 0, nil
+	}
+
+	if err := p.dataStore.Rewrite(compactedEntries); err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
+
+// Removes the given parameters and parameter sets by rewriting the data store.
+// No other process may write to the store while it gets rewritten.
+func (p *FileParameterStore) Remove(parametersList []*kodex.Parameters, parameterSets []*kodex.ParameterSet) error {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	entries, _, err := p.dataStore.ReadAll()
+
+	if err != nil {
+		return err
+	}
+
+	removed := map[string]bool{}
+
+	for _, parameters := range parametersList {
+		removed[fmt.Sprintf("%d:%s", ParametersType, hex.EncodeToString(parameters.ID()))] = true
+	}
+
+	for _, parameterSet := range parameterSets {
+		removed[fmt.Sprintf("%d:%s", ParameterSetType, hex.EncodeToString(parameterSet.Hash()))] = true
+	}
+
+	remainingEntries := make([]*DataEntry, 0, len(entries))
+
+	for _, entry := range entries {
+		if removed[fmt.Sprintf("%d:%s", entry.Type, hex.EncodeToString(entry.ID))] {
+			continue
+		}
+		remainingEntries = append(remainingEntries, entry)
+	}
+
+	if err := p.dataStore.Rewrite(remainingEntries); err != nil {
+		return err
+	}
+
+	// we reset the cache, it will be repopulated on the next update
+	inMemoryStore, err := MakeInMemoryParameterStore(p.inMemoryStore.config, p.Definitions())
+
+	if err != nil {
+		return err
+	}
+
+	p.inMemoryStore = inMemoryStore.(*InMemoryParameterStore)
+
+	return nil
+}
+
+func entryType(entry *DataEntry) string {
+	switch entry.Type {
+	case ParametersType:
+		return "parameters"
+	case ParameterSetType:
+		return "parameter-set"
+	}
+	return "unknown"
+}
@@ -0,0 +1,162 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package parameters_test
+
+import (
+	"encoding/json"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/actions"
+	"github.com/kiprotect/kodex/parameters"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func makeFileStore(t *testing.T, filename string, definitions *kodex.Definitions) kodex.MaintainableParameterStore {
+	store, err := parameters.MakeFileParameterStore(map[string]interface{}{
+		"filename": filename,
+	}, definitions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store.(kodex.MaintainableParameterStore)
+}
+
+func TestFileParameterStoreMaintenance(t *testing.T) {
+
+	filename := filepath.Join(t.TempDir(), "parameters.kip")
+	definitions := &kodex.Definitions{ActionDefinitions: actions.Actions}
+
+	store := makeFileStore(t, filename, definitions)
+
+	action, err := kodex.MakeAction("test", "", "pseudonymize", kodex.RandomID(), map[string]interface{}{
+		"key":    "foo",
+		"method": "merengue",
+		"config": map[string]interface{}{},
+	}, definitions)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parameterSet, err := kodex.MakeParameterSet([]kodex.Action{action}, store)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parameterGroup, err := action.ParameterGroup(kodex.MakeItem(map[string]interface{}{}))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := parameterSet.UpdateParameters(action, map[string]interface{}{"key": "bar"}, parameterGroup); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := parameterSet.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// we write the same entries again, as a concurrent process might do
+	dataStore := parameters.MakeFileDataStore(filename, "json")
+
+	if err := dataStore.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	parametersData, _ := json.Marshal(parameterSet.Parameters()[0])
+	parameterSetData, _ := json.Marshal(parameterSet)
+
+	for _, entry := range []*parameters.DataEntry{
+		{Type: parameters.ParametersType, ID: parameterSet.Parameters()[0].ID(), Data: parametersData},
+		{Type: parameters.ParameterSetType, ID: parameterSet.Hash(), Data: parameterSetData},
+	} {
+		if err := dataStore.Write(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if problems, err := store.Verify(); err != nil {
+		t.Fatal(err)
+	} else if len(problems) != 0 {
+		t.Fatalf("expected no problems, got %d", len(problems))
+	}
+
+	if removed, err := store.Compact(); err != nil {
+		t.Fatal(err)
+	} else if removed != 2 {
+		t.Fatalf("expected 2 removed entries, got %d", removed)
+	}
+
+	if _, err := os.Stat(filename + ".bak"); err != nil {
+		t.Fatalf("expected a backup file: %v", err)
+	}
+
+	// we reopen the compacted store
+	store = makeFileStore(t, filename, definitions)
+
+	if parameterSets, err := store.AllParameterSets(); err != nil {
+		t.Fatal(err)
+	} else if len(parameterSets) != 1 {
+		t.Fatalf("expected one parameter set, got %d", len(parameterSets))
+	} else if parameterSets[0].CreatedAt().IsZero() {
+		t.Fatalf("expected a creation time")
+	}
+
+	if err := store.Remove(parameterSet.Parameters(), []*kodex.ParameterSet{parameterSet}); err != nil {
+		t.Fatal(err)
+	}
+
+	store = makeFileStore(t, filename, definitions)
+
+	if allParameters, err := store.AllParameters(); err != nil {
+		t.Fatal(err)
+	} else if len(allParameters) != 0 {
+		t.Fatalf("expected no parameters, got %d", len(allParameters))
+	}
+
+	// the store file was replaced, so we need to reopen it
+	dataStore = parameters.MakeFileDataStore(filename, "json")
+
+	if err := dataStore.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	// we add a parameter set that references missing parameters
+	brokenData, _ := json.Marshal(map[string]interface{}{
+		"hash":       "abcd",
+		"parameters": []string{"0123"},
+	})
+
+	if err := dataStore.Write(&parameters.DataEntry{
+		Type: parameters.ParameterSetType,
+		ID:   []byte{0xab, 0xcd},
+		Data: brokenData,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if problems, err := store.Verify(); err != nil {
+		t.Fatal(err)
+	} else if len(problems) != 2 {
+		// missing parameters & hash mismatch
+		t.Fatalf("expected two problems, got %d", len(problems))
+	}
+
+}
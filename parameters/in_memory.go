@@ -141,12 +141,12 @@ func (p *InMemoryParameterStore) DeleteParameters(parameters *kodex.Parameters)
 	configHashStr := hex.EncodeToString(configHash)
 	for actionConfigHash, configHashParameters := range actionParameters {
 		if actionConfigHash == configHashStr {
-			newActionParameters := make([]*kodex.Parameters, 0, len(actionParameters)-1)
+			newActionParameters := make([]*kodex.Parameters, 0, len(configHashParameters))
 			for _, existingParameters := range configHashParameters {
 				if bytes.Equal(existingParameters.ParameterGroup().Hash(), parameters.ParameterGroup().Hash()) {
 					continue
 				}
-				newActionParameters = append(newActionParameters, parameters)
+				newActionParameters = append(newActionParameters, existingParameters)
 			}
 			p.parameters[id][configHashStr] = newActionParameters
 			break
@@ -240,3 +240,27 @@ func (p *InMemoryParameterStore) SaveParameterSet(parameterSet *kodex.ParameterS
 	p.parameterSets[hashStr] = parameterSet
 	return true, nil
 }
+
+func (p *InMemoryParameterStore) Verify() ([]*kodex.ParameterStoreProblem, error) {
+	return kodex.VerifyParameterStore(p)
+}
+
+// The in-memory store does not contain any redundant data
+func (p *InMemoryParameterStore) Compact() (int, error) {
+	return 0, nil
+}
+
+func (p *InMemoryParameterStore) Remove(parametersList []*kodex.Parameters, parameterSets []*kodex.ParameterSet) error {
+	// we remove the parameter sets first as they reference the parameters
+	for _, parameterSet := range parameterSets {
+		if err := p.DeleteParameterSet(parameterSet); err != nil {
+			return err
+		}
+	}
+	for _, parameters := range parametersList {
+		if err := p.DeleteParameters(parameters); err != nil {
+			return err
+		}
+	}
+	return nil
+}
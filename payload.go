@@ -24,6 +24,21 @@ type Payload interface {
 	Reject() error
 }
 
+// Payloads that can be returned to their source without counting as a failed
+// delivery (e.g. when a shutdown aborts their processing)
+type RequeueablePayload interface {
+	Requeue() error
+}
+
+// Returns the payload to its source without counting it as a failed delivery,
+// payloads that do not support this are rejected instead
+func RequeuePayload(payload Payload) error {
+	if requeueablePayload, ok := payload.(RequeueablePayload); ok {
+		return requeueablePayload.Requeue()
+	}
+	return payload.Reject()
+}
+
 type BasicPayload struct {
 	items       []*Item
 	headers     map[string]interface{}
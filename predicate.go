@@ -0,0 +1,335 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kodex
+
+import (
+	"fmt"
+	"github.com/kiprotect/go-helpers/maps"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// A predicate decides whether an item matches a condition (e.g. an action
+// config's 'when' setting). Predicates are described by maps like
+//
+//	{field: tenant, equals: acme}
+//	{field: email, exists: true}
+//	{field: email, matches: "@example\.com$"}
+//	{field: age, gte: 18}
+//	{and: [...]}, {or: [...]}, {not: {...}}
+//
+// where a list of predicates is shorthand for 'and'. A missing field only
+// matches 'exists: false' (and, via negation, 'not-equals').
+type Predicate interface {
+	Matches(item *Item) bool
+}
+
+type AndPredicate []Predicate
+
+func (a AndPredicate) Matches(item *Item) bool {
+	for _, predicate := range a {
+		if !predicate.Matches(item) {
+			return false
+		}
+	}
+	return true
+}
+
+type OrPredicate []Predicate
+
+func (o OrPredicate) Matches(item *Item) bool {
+	for _, predicate := range o {
+		if predicate.Matches(item) {
+			return true
+		}
+	}
+	return false
+}
+
+type NotPredicate struct {
+	Predicate Predicate
+}
+
+func (n NotPredicate) Matches(item *Item) bool {
+	return !n.Predicate.Matches(item)
+}
+
+type FieldOperator string
+
+const (
+	ExistsOperator    FieldOperator = "exists"
+	EqualsOperator    FieldOperator = "equals"
+	NotEqualsOperator FieldOperator = "not-equals"
+	InOperator        FieldOperator = "in"
+	MatchesOperator   FieldOperator = "matches"
+	GtOperator        FieldOperator = "gt"
+	GteOperator       FieldOperator = "gte"
+	LtOperator        FieldOperator = "lt"
+	LteOperator       FieldOperator = "lte"
+)
+
+var fieldOperators = []FieldOperator{ExistsOperator, EqualsOperator, NotEqualsOperator, InOperator, MatchesOperator, GtOperator, GteOperator, LtOperator, LteOperator}
+
+// Compares the value of a field with a given value. Fields can refer to
+// nested values with a dotted path (e.g. 'address.city').
+type FieldPredicate struct {
+	Field    string
+	Operator FieldOperator
+	Value    interface{}
+	regexp   *regexp.Regexp
+}
+
+func (f *FieldPredicate) Matches(item *Item) bool {
+
+	value, ok := fieldValue(item, f.Field)
+
+	switch f.Operator {
+	case ExistsOperator:
+		return ok == f.Value.(bool)
+	case NotEqualsOperator:
+		return !ok || !equalValues(value, f.Value)
+	}
+
+	if !ok {
+		return false
+	}
+
+	switch f.Operator {
+	case EqualsOperator:
+		return equalValues(value, f.Value)
+	case InOperator:
+		for _, v := range f.Value.([]interface{}) {
+			if equalValues(value, v) {
+				return true
+			}
+		}
+		return false
+	case MatchesOperator:
+		str, ok := value.(string)
+		return ok && f.regexp.MatchString(str)
+	}
+
+	c, ok := compareValues(value, f.Value)
+
+	if !ok {
+		return false
+	}
+
+	switch f.Operator {
+	case GtOperator:
+		return c > 0
+	case GteOperator:
+		return c >= 0
+	case LtOperator:
+		return c < 0
+	case LteOperator:
+		return c <= 0
+	}
+
+	return false
+}
+
+func fieldValue(item *Item, field string) (interface{}, bool) {
+	if value, ok := item.Get(field); ok {
+		return value, true
+	}
+	path := strings.Split(field, ".")
+	value, ok := item.Get(path[0])
+	for _, key := range path[1:] {
+		if !ok {
+			break
+		}
+		var m map[string]interface{}
+		if m, ok = maps.ToStringMap(value); ok {
+			value, ok = m[key]
+		}
+	}
+	return value, ok
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// numbers are compared by value, as their type depends on the format they
+// were decoded from
+func equalValues(a, b interface{}) bool {
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		return ok && fa == fb
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compares two numbers or two strings
+func compareValues(a, b interface{}) (int, bool) {
+	if fa, ok := toFloat(a); ok {
+		if fb, ok := toFloat(b); ok {
+			switch {
+			case fa < fb:
+				return -1, true
+			case fa > fb:
+				return 1, true
+			}
+			return 0, true
+		}
+		return 0, false
+	}
+	if sa, ok := a.(string); ok {
+		if sb, ok := b.(string); ok {
+			return strings.Compare(sa, sb), true
+		}
+	}
+	return 0, false
+}
+
+func makePredicates(config interface{}) ([]Predicate, error) {
+	list, ok := config.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a list of predicates")
+	}
+	predicates := make([]Predicate, len(list))
+	for i, predicateConfig := range list {
+		predicate, err := MakePredicate(predicateConfig)
+		if err != nil {
+			return nil, err
+		}
+		predicates[i] = predicate
+	}
+	return predicates, nil
+}
+
+// Makes a predicate from its description (see Predicate)
+func MakePredicate(config interface{}) (Predicate, error) {
+
+	if _, ok := config.([]interface{}); ok {
+		config = map[string]interface{}{"and": config}
+	}
+
+	m, ok := maps.ToStringMap(config)
+
+	if !ok {
+		return nil, fmt.Errorf("expected a map or a list")
+	}
+
+	if len(m) == 1 {
+		if and, ok := m["and"]; ok {
+			predicates, err := makePredicates(and)
+			return AndPredicate(predicates), err
+		}
+		if or, ok := m["or"]; ok {
+			predicates, err := makePredicates(or)
+			return OrPredicate(predicates), err
+		}
+		if not, ok := m["not"]; ok {
+			predicate, err := MakePredicate(not)
+			return NotPredicate{Predicate: predicate}, err
+		}
+	}
+
+	field, ok := m["field"].(string)
+
+	if !ok || field == "" {
+		return nil, fmt.Errorf("expected 'and', 'or', 'not' or a field")
+	}
+
+	if len(m) != 2 {
+		return nil, fmt.Errorf("expected exactly one operator for field '%s'", field)
+	}
+
+	predicate := &FieldPredicate{Field: field}
+
+	for _, operator := range fieldOperators {
+		if value, ok := m[string(operator)]; ok {
+			predicate.Operator = operator
+			predicate.Value = value
+		}
+	}
+
+	switch predicate.Operator {
+	case "":
+		return nil, fmt.Errorf("unknown operator for field '%s'", field)
+	case ExistsOperator:
+		if _, ok := predicate.Value.(bool); !ok {
+			return nil, fmt.Errorf("'exists' expects a boolean")
+		}
+	case InOperator:
+		if _, ok := predicate.Value.([]interface{}); !ok {
+			return nil, fmt.Errorf("'in' expects a list")
+		}
+	case MatchesOperator:
+		expression, ok := predicate.Value.(string)
+		if !ok {
+			return nil, fmt.Errorf("'matches' expects a regular expression")
+		}
+		var err error
+		if predicate.regexp, err = regexp.Compile(expression); err != nil {
+			return nil, fmt.Errorf("invalid regular expression: %v", err)
+		}
+	case GtOperator, GteOperator, LtOperator, LteOperator:
+		if _, ok := toFloat(predicate.Value); !ok {
+			if _, ok := predicate.Value.(string); !ok {
+				return nil, fmt.Errorf("'%s' expects a number or a string", predicate.Operator)
+			}
+		}
+	}
+
+	return predicate, nil
+}
+
+// Validates a predicate description, which is returned as a map (lists are
+// converted to 'and' predicates)
+type IsPredicate struct{}
+
+func (i IsPredicate) Validate(value interface{}, values map[string]interface{}) (interface{}, error) {
+	if _, err := MakePredicate(value); err != nil {
+		return nil, err
+	}
+	if list, ok := value.([]interface{}); ok {
+		return map[string]interface{}{"and": list}, nil
+	}
+	m, _ := maps.ToStringMap(value)
+	return m, nil
+}
+
+// Returns the 'when' predicate of an action config (nil if there is none)
+func ActionConfigPredicate(actionConfig ActionConfig) (Predicate, error) {
+	when := actionConfig.When()
+	if when == nil {
+		return nil, nil
+	}
+	return MakePredicate(when)
+}
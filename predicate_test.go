@@ -0,0 +1,394 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kodex_test
+
+import (
+	"encoding/hex"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/actions"
+	"github.com/kiprotect/kodex/parameters"
+	"testing"
+)
+
+type predicateTest struct {
+	predicate interface{}
+	matches   bool
+}
+
+func TestPredicates(t *testing.T) {
+
+	item := kodex.MakeItem(map[string]interface{}{
+		"tenant":  "acme",
+		"email":   "max@example.com",
+		"age":     42.0,
+		"count":   3,
+		"address": map[string]interface{}{"city": "Berlin"},
+	})
+
+	tests := []predicateTest{
+		{map[string]interface{}{"field": "tenant", "equals": "acme"}, true},
+		{map[string]interface{}{"field": "tenant", "equals": "other"}, false},
+		{map[string]interface{}{"field": "tenant", "not-equals": "other"}, true},
+		{map[string]interface{}{"field": "missing", "not-equals": "other"}, true},
+		{map[string]interface{}{"field": "missing", "equals": nil}, false},
+		{map[string]interface{}{"field": "tenant", "in": []interface{}{"foo", "acme"}}, true},
+		{map[string]interface{}{"field": "email", "exists": true}, true},
+		{map[string]interface{}{"field": "phone", "exists": true}, false},
+		{map[string]interface{}{"field": "phone", "exists": false}, true},
+		{map[string]interface{}{"field": "email", "matches": `@example\.com$`}, true},
+		{map[string]interface{}{"field": "age", "matches": `42`}, false},
+		// numbers are compared by value, regardless of their type
+		{map[string]interface{}{"field": "age", "equals": 42}, true},
+		{map[string]interface{}{"field": "count", "equals": 3.0}, true},
+		{map[string]interface{}{"field": "age", "gte": 18}, true},
+		{map[string]interface{}{"field": "age", "lt": 18}, false},
+		{map[string]interface{}{"field": "tenant", "gt": "aaa"}, true},
+		{map[string]interface{}{"field": "tenant", "gt": 1}, false},
+		{map[string]interface{}{"field": "address.city", "equals": "Berlin"}, true},
+		{map[string]interface{}{"field": "address.zip", "exists": true}, false},
+		{map[string]interface{}{"not": map[string]interface{}{"field": "tenant", "equals": "acme"}}, false},
+		{map[string]interface{}{"or": []interface{}{
+			map[string]interface{}{"field": "tenant", "equals": "other"},
+			map[string]interface{}{"field": "age", "gt": 40},
+		}}, true},
+		// a list is shorthand for 'and'
+		{[]interface{}{
+			map[string]interface{}{"field": "tenant", "equals": "acme"},
+			map[string]interface{}{"field": "age", "gt": 50},
+		}, false},
+	}
+
+	for i, test := range tests {
+		predicate, err := kodex.MakePredicate(test.predicate)
+		if err != nil {
+			t.Fatalf("test %d: %v", i, err)
+		}
+		if predicate.Matches(item) != test.matches {
+			t.Fatalf("test %d: expected %v for %v", i, test.matches, test.predicate)
+		}
+	}
+
+	invalid := []interface{}{
+		"tenant",
+		map[string]interface{}{"field": "tenant"},
+		map[string]interface{}{"field": "tenant", "equals": "a", "in": []interface{}{"a"}},
+		map[string]interface{}{"field": "tenant", "contains": "a"},
+		map[string]interface{}{"field": "tenant", "matches": "("},
+		map[string]interface{}{"field": "tenant", "exists": "yes"},
+		map[string]interface{}{"field": "tenant", "gt": true},
+		map[string]interface{}{"and": "foo"},
+		map[string]interface{}{"not": []interface{}{42}},
+	}
+
+	for _, predicate := range invalid {
+		if _, err := (kodex.IsPredicate{}).Validate(predicate, nil); err == nil {
+			t.Fatalf("expected '%v' to be invalid", predicate)
+		}
+	}
+
+}
+
+func TestConditionalActions(t *testing.T) {
+
+	definitions := &kodex.Definitions{ActionDefinitions: actions.Actions}
+
+	store, err := parameters.MakeInMemoryParameterStore(map[string]interface{}{}, definitions)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	route, err := kodex.MakeAction("route", "", "route", []byte("route"), map[string]interface{}{
+		"routes": []interface{}{
+			map[string]interface{}{
+				"when":    map[string]interface{}{"field": "tenant", "equals": "acme"},
+				"channel": "acme",
+			},
+		},
+	}, definitions)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drop, err := kodex.MakeAction("drop", "", "drop", []byte("drop"), map[string]interface{}{}, definitions)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	when, err := kodex.MakePredicate(map[string]interface{}{"field": "type", "equals": "debug"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parameterSet, err := kodex.MakeParameterSet([]kodex.Action{route, drop}, store)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	channelWriter := kodex.MakeInMemoryChannelWriter()
+
+	processor, err := kodex.MakeProcessor(parameterSet, channelWriter, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only debug items are dropped
+	processor.SetPredicates([]kodex.Predicate{nil, when})
+
+	items, err := processor.Process([]*kodex.Item{
+		kodex.MakeItem(map[string]interface{}{"tenant": "acme", "type": "info"}),
+		kodex.MakeItem(map[string]interface{}{"tenant": "other", "type": "info"}),
+		kodex.MakeItem(map[string]interface{}{"tenant": "other", "type": "debug"}),
+	}, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 1 {
+		t.Fatalf("expected one item, got %d", len(items))
+	} else if tenant, _ := items[0].Get("tenant"); tenant != "other" {
+		t.Fatalf("expected the item of the other tenant")
+	}
+
+	if routed := channelWriter.Items["acme"]; len(routed) != 1 {
+		t.Fatalf("expected one routed item")
+	}
+
+	if _, err := kodex.MakeAction("route", "", "route", []byte("route"), map[string]interface{}{
+		"routes": []interface{}{
+			map[string]interface{}{"when": map[string]interface{}{"field": "tenant"}, "channel": "acme"},
+		},
+	}, definitions); err == nil {
+		t.Fatalf("expected an invalid route to fail")
+	}
+
+}
+
+func TestRoutedItemsUndo(t *testing.T) {
+
+	definitions := &kodex.Definitions{ActionDefinitions: actions.Actions}
+
+	store, err := parameters.MakeInMemoryParameterStore(map[string]interface{}{}, definitions)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pseudonymize, err := kodex.MakeAction("pseudonymize", "", "pseudonymize", []byte("pseudonymize"), map[string]interface{}{
+		"key":    "name",
+		"method": "merengue",
+		"config": map[string]interface{}{},
+	}, definitions)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	route, err := kodex.MakeAction("route", "", "route", []byte("route"), map[string]interface{}{
+		"routes": []interface{}{
+			map[string]interface{}{
+				"when":    map[string]interface{}{"field": "tenant", "equals": "acme"},
+				"channel": "acme",
+			},
+		},
+	}, definitions)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parameterSet, err := kodex.MakeParameterSet([]kodex.Action{pseudonymize, route}, store)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	channelWriter := kodex.MakeInMemoryChannelWriter()
+
+	processor, err := kodex.MakeProcessor(parameterSet, channelWriter, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := processor.Process([]*kodex.Item{
+		kodex.MakeItem(map[string]interface{}{"tenant": "acme", "name": "max"}),
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	routed := channelWriter.Items["acme"]
+
+	if len(routed) != 1 {
+		t.Fatalf("expected one routed item")
+	}
+
+	if name, _ := routed[0].Get("name"); name == "max" {
+		t.Fatalf("expected the name to be pseudonymized")
+	}
+
+	kip, ok := routed[0].Get("_kip")
+
+	if !ok {
+		t.Fatalf("expected the routed item to have a '_kip' value")
+	}
+
+	kipBytes, err := hex.DecodeString(kip.(string))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	undoParameterSet, err := store.ParameterSet(kipBytes)
+
+	if err != nil {
+		t.Fatal(err)
+	} else if undoParameterSet == nil {
+		t.Fatalf("parameter set not found")
+	}
+
+	undoProcessor, err := kodex.MakeProcessor(undoParameterSet, kodex.MakeInMemoryChannelWriter(), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := undoProcessor.Undo(routed, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 1 {
+		t.Fatalf("expected one item, got %d", len(items))
+	}
+
+	if name, _ := items[0].Get("name"); name != "max" {
+		t.Fatalf("expected the original name, got %v", name)
+	}
+
+	if _, ok := items[0].Get("_kip"); ok {
+		t.Fatalf("the '_kip' value should be removed")
+	}
+
+}
+
+func TestConditionalActionsUndo(t *testing.T) {
+
+	definitions := &kodex.Definitions{ActionDefinitions: actions.Actions}
+
+	store, err := parameters.MakeInMemoryParameterStore(map[string]interface{}{}, definitions)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pseudonymize, err := kodex.MakeAction("pseudonymize", "", "pseudonymize", []byte("pseudonymize"), map[string]interface{}{
+		"key":    "name",
+		"method": "merengue",
+		"config": map[string]interface{}{},
+	}, definitions)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the predicate tests the field that the action changes
+	when, err := kodex.MakePredicate(map[string]interface{}{"field": "name", "matches": "^max$"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parameterSet, err := kodex.MakeParameterSet([]kodex.Action{pseudonymize}, store)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	processor, err := kodex.MakeProcessor(parameterSet, kodex.MakeInMemoryChannelWriter(), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	processor.SetPredicates([]kodex.Predicate{when})
+
+	items, err := processor.Process([]*kodex.Item{
+		kodex.MakeItem(map[string]interface{}{"name": "max"}),
+		kodex.MakeItem(map[string]interface{}{"name": "moritz"}),
+	}, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name, _ := items[0].Get("name"); name == "max" {
+		t.Fatalf("expected the name to be pseudonymized")
+	}
+
+	if name, _ := items[1].Get("name"); name != "moritz" {
+		t.Fatalf("expected the name to be unchanged, got %v", name)
+	}
+
+	if skip, _ := items[1].Get("_kip_skip"); skip != "0" {
+		t.Fatalf("expected the skipped action to be recorded, got %v", skip)
+	}
+
+	kip, _ := items[0].Get("_kip")
+	kipBytes, err := hex.DecodeString(kip.(string))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	undoParameterSet, err := store.ParameterSet(kipBytes)
+
+	if err != nil || undoParameterSet == nil {
+		t.Fatalf("parameter set not found")
+	}
+
+	undoProcessor, err := kodex.MakeProcessor(undoParameterSet, kodex.MakeInMemoryChannelWriter(), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	undoProcessor.SetPredicates([]kodex.Predicate{when})
+
+	undoneItems, err := undoProcessor.Undo(items, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, expected := range []string{"max", "moritz"} {
+		if name, _ := undoneItems[i].Get("name"); name != expected {
+			t.Fatalf("expected name '%s', got %v", expected, name)
+		}
+		if _, ok := undoneItems[i].Get("_kip_skip"); ok {
+			t.Fatalf("the '_kip_skip' value should be removed")
+		}
+	}
+
+}
@@ -20,6 +20,10 @@ import (
 	"time"
 )
 
+// Processors hold a lease on the entities they acquired, which expires if it
+// is not renewed by a ping within this duration
+const LeaseDuration = time.Second * 30
+
 type ProcessingStats struct {
 	From           time.Time
 	To             time.Time
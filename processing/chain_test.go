@@ -0,0 +1,163 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package processing
+
+import (
+	"github.com/kiprotect/kodex"
+	"testing"
+)
+
+func chainedBlueprint(chained string) map[string]interface{} {
+	return map[string]interface{}{
+		"sources": []interface{}{
+			map[string]interface{}{"name": "in", "type": "test", "config": map[string]interface{}{}},
+		},
+		"destinations": []interface{}{
+			map[string]interface{}{"name": "out", "type": "test", "config": map[string]interface{}{}},
+			map[string]interface{}{"name": "next", "type": "stream", "config": map[string]interface{}{
+				"stream":      "second",
+				"max-pending": 1,
+				"timeout":     50,
+			}},
+			map[string]interface{}{"name": "chained", "type": "stream", "config": map[string]interface{}{
+				"stream": chained,
+			}},
+		},
+		"streams": []interface{}{
+			map[string]interface{}{
+				"name": "default",
+				"sources": []interface{}{
+					map[string]interface{}{"source": "in"},
+				},
+				"configs": []interface{}{
+					map[string]interface{}{
+						"name": "default",
+						"destinations": []interface{}{
+							map[string]interface{}{"name": "next", "status": "active"},
+						},
+					},
+				},
+			},
+			map[string]interface{}{
+				"name": "second",
+				"configs": []interface{}{
+					map[string]interface{}{
+						"name": "default",
+						"destinations": []interface{}{
+							map[string]interface{}{"name": "chained", "status": "active"},
+						},
+					},
+				},
+			},
+			map[string]interface{}{
+				"name": "third",
+				"configs": []interface{}{
+					map[string]interface{}{
+						"name": "default",
+						"destinations": []interface{}{
+							map[string]interface{}{"name": "out", "status": "active"},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestStreamChaining(t *testing.T) {
+
+	reader := &payloadsReader{}
+
+	for i := 0; i < 10; i++ {
+		reader.payloads = append(reader.payloads, &trackedPayload{
+			BasicPayload: kodex.MakeBasicPayload([]*kodex.Item{kodex.MakeItem(map[string]interface{}{"i": i})}, map[string]interface{}{}, i == 9),
+		})
+	}
+
+	writer := &countingWriter{}
+	stream, teardown := setupTestBlueprint(t, reader, writer, chainedBlueprint("third"))
+	defer teardown()
+
+	processStream(t, stream, nil, 0)
+
+	if writer.Items() != 10 {
+		t.Fatalf("expected 10 written items, got %d", writer.Items())
+	}
+
+	// payloads are only acknowledged once the last stream has written them
+	for i, payload := range reader.payloads {
+		if acknowledged, rejected := payload.Resolutions(); acknowledged != 1 || rejected != 0 {
+			t.Errorf("expected payload %d to be acknowledged (acknowledged: %d, rejected: %d)", i, acknowledged, rejected)
+		}
+	}
+
+	levels, err := streamLevels(stream)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(levels) != 3 || levels[2][0].Name() != "third" {
+		t.Fatalf("expected three levels of streams")
+	}
+
+	// streams that form a cycle are rejected
+	controller := stream.Project().Controller()
+
+	if _, err := kodex.MakeBlueprint(chainedBlueprint("default")).Create(controller, true); err == nil {
+		t.Fatalf("expected an error for a cycle")
+	}
+}
+
+func TestStreamChainingBackpressure(t *testing.T) {
+
+	stream, teardown := setupTestBlueprint(t, &payloadsReader{}, &countingWriter{}, chainedBlueprint("third"))
+	defer teardown()
+
+	configs, err := stream.Configs()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	destinations, err := configs[0].Destinations()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writer, err := destinations["next"][0].InternalWriter()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer writer.Teardown()
+
+	payload := func() kodex.Payload {
+		return kodex.MakeBasicPayload([]*kodex.Item{kodex.MakeItem(map[string]interface{}{})}, map[string]interface{}{}, false)
+	}
+
+	if err := writer.Write(payload()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the chained stream is not processed, so the next write times out
+	if err := writer.Write(payload()); err == nil {
+		t.Fatalf("expected the write to block and fail")
+	}
+}
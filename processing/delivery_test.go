@@ -0,0 +1,165 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package processing
+
+import (
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"sync"
+	"testing"
+)
+
+type trackedPayload struct {
+	*kodex.BasicPayload
+	mutex        sync.Mutex
+	acknowledged int
+	rejected     int
+}
+
+func (p *trackedPayload) Acknowledge() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.acknowledged++
+	return nil
+}
+
+func (p *trackedPayload) Reject() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.rejected++
+	return nil
+}
+
+func (p *trackedPayload) Resolutions() (int, int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.acknowledged, p.rejected
+}
+
+// Returns the given payloads, the last one ends the stream
+type payloadsReader struct {
+	mutex    sync.Mutex
+	payloads []*trackedPayload
+	next     int
+}
+
+func (r *payloadsReader) Read() (kodex.Payload, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.next >= len(r.payloads) {
+		return nil, nil
+	}
+	r.next++
+	return r.payloads[r.next-1], nil
+}
+
+func (r *payloadsReader) Setup(kodex.Stream) error { return nil }
+func (r *payloadsReader) Purge() error             { return nil }
+func (r *payloadsReader) Teardown() error          { return nil }
+
+// Fails to write payloads that contain an item with a 'fail' field
+type failingWriter struct {
+	countingWriter
+}
+
+func (w *failingWriter) Write(payload kodex.Payload) error {
+	for _, item := range payload.Items() {
+		if _, ok := item.Get("fail"); ok {
+			return fmt.Errorf("cannot write item")
+		}
+	}
+	return w.countingWriter.Write(payload)
+}
+
+func TestAtLeastOnceDelivery(t *testing.T) {
+
+	reader := &payloadsReader{}
+
+	for i := 0; i < 10; i++ {
+		item := map[string]interface{}{"i": i}
+		if i%3 == 0 {
+			item["fail"] = true
+		}
+		reader.payloads = append(reader.payloads, &trackedPayload{
+			BasicPayload: kodex.MakeBasicPayload([]*kodex.Item{kodex.MakeItem(item)}, map[string]interface{}{}, i == 9),
+		})
+	}
+
+	writer := &failingWriter{}
+	stream, teardown := setupTestStream(t, reader, writer)
+	defer teardown()
+
+	processStream(t, stream, nil, 0)
+
+	for i, payload := range reader.payloads {
+
+		acknowledged, rejected := payload.Resolutions()
+
+		if i%3 == 0 {
+			// the destination could not write the payload, so it should be rejected
+			if acknowledged != 0 || rejected != 1 {
+				t.Errorf("expected payload %d to be rejected (acknowledged: %d, rejected: %d)", i, acknowledged, rejected)
+			}
+		} else if acknowledged != 1 || rejected != 0 {
+			t.Errorf("expected payload %d to be acknowledged (acknowledged: %d, rejected: %d)", i, acknowledged, rejected)
+		}
+	}
+
+	if writer.Items() != 6 {
+		t.Fatalf("expected 6 written items, got %d", writer.Items())
+	}
+}
+
+func TestLogChannelDelivery(t *testing.T) {
+
+	reader := &payloadsReader{}
+
+	for i := 0; i < 10; i++ {
+		item := map[string]interface{}{"i": i}
+		if i%3 == 0 {
+			item["fail"] = true
+		}
+		reader.payloads = append(reader.payloads, &trackedPayload{
+			BasicPayload: kodex.MakeBasicPayload([]*kodex.Item{kodex.MakeItem(item)}, map[string]interface{}{}, i == 9),
+		})
+	}
+
+	writer := &failingWriter{}
+	stream, teardown := setupTestStream(t, reader, writer)
+	defer teardown()
+
+	stream.Project().Controller().Settings().Set("internal-channel", map[string]interface{}{
+		"type": "log",
+		"config": map[string]interface{}{
+			"directory": t.TempDir(),
+			"sync":      false,
+		},
+	})
+
+	processStream(t, stream, nil, 0)
+
+	for i, payload := range reader.payloads {
+		// the log takes over the responsibility for all payloads
+		if acknowledged, rejected := payload.Resolutions(); acknowledged != 1 || rejected != 0 {
+			t.Errorf("expected payload %d to be acknowledged (acknowledged: %d, rejected: %d)", i, acknowledged, rejected)
+		}
+	}
+
+	if writer.Items() != 6 {
+		t.Fatalf("expected 6 written items, got %d", writer.Items())
+	}
+}
@@ -21,12 +21,45 @@ import (
 )
 
 type Supervisor interface {
+	// Called once the executor stopped (and released its lock)
 	ExecutorStopped(Executor, kodex.Processable)
 }
 
+// Aborted executors make their workers reject their remaining payloads
+type abortable interface {
+	aborted() bool
+}
+
+func isAborted(executor Executor) bool {
+	if abortableExecutor, ok := executor.(abortable); ok {
+		return abortableExecutor.aborted()
+	}
+	return false
+}
+
+// Requeues a payload without processing it, so that it can be redelivered
+// (this does not count as a failed delivery)
+func requeuePayload(payload kodex.Payload) {
+	if err := kodex.RequeuePayload(payload); err != nil {
+		kodex.Log.Error(err)
+	}
+}
+
+// Replaces a payload by one without the end of stream flag, which keeps
+// the acknowledgement of the original payload pending
+func withoutEndOfStream(payload kodex.Payload) kodex.Payload {
+	lineage := kodex.MakeLineage(payload)
+	defer lineage.Acknowledge()
+	return lineage.Derive(payload.Items(), payload.Headers(), false)
+}
+
 type Executor interface {
 	Start(Supervisor, kodex.Processable) error
 	Stop(graceful bool) error
 	Stopped() bool
+	// Returns true if the executor stopped at the end of its stream
+	Completed() bool
+	// Returns the processing statistics since the last call
+	Stats() kodex.ProcessingStats
 	ID() []byte
 }
@@ -17,11 +17,86 @@
 package processing
 
 import (
+	"encoding/hex"
+	"fmt"
 	"github.com/kiprotect/kodex"
+	"os"
+	"sync"
 	"time"
 )
 
-func ProcessStream(stream kodex.Stream, timeout time.Duration) error {
+func allStopped(executors []Executor) bool {
+	for _, executor := range executors {
+		if !executor.Stopped() {
+			return false
+		}
+	}
+	return true
+}
+
+// Stops the executors stage by stage (e.g. sources before streams) and waits
+// until they are stopped. If the deadline passes or a value is received on
+// the abort channel, we abort the remaining executors, which then reject their
+// payloads (so that e.g. AMQP or Kafka sources redeliver them).
+func stopExecutors(stages [][]Executor, graceful bool, deadline time.Time, abort <-chan os.Signal) {
+
+	var timeout <-chan time.Time
+
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for _, stage := range stages {
+
+		done := make(chan bool)
+
+		go func(stage []Executor, graceful bool) {
+			var wg sync.WaitGroup
+			for _, executor := range stage {
+				wg.Add(1)
+				go func(executor Executor) {
+					defer wg.Done()
+					if err := executor.Stop(graceful); err != nil {
+						kodex.Log.Error(err)
+					}
+				}(executor)
+			}
+			wg.Wait()
+			close(done)
+		}(stage, graceful)
+
+		for stopped := false; !stopped; {
+			select {
+			case <-done:
+				stopped = true
+			case <-timeout:
+				kodex.Log.Warningf("Cannot drain all payloads in time, rejecting the remaining ones...")
+				graceful = false
+				abortExecutors(stage)
+			case <-abort:
+				kodex.Log.Warningf("Rejecting the remaining payloads...")
+				graceful = false
+				abortExecutors(stage)
+			}
+		}
+	}
+}
+
+func abortExecutors(executors []Executor) {
+	for _, executor := range executors {
+		go executor.Stop(false)
+	}
+}
+
+// Processes the stream until its sources are exhausted or until a signal is
+// received on the shutdown channel. We then stop the sources and drain the
+// in-flight payloads for at most the given timeout (zero means no limit), a
+// second signal rejects the remaining payloads right away.
+func ProcessStream(stream kodex.Stream, shutdown <-chan os.Signal, timeout time.Duration) error {
+
+	id := kodex.RandomID()
 
 	// we get all the sources for the stream
 	sourceMaps, _ := stream.Sources()
@@ -29,92 +104,168 @@ func ProcessStream(stream kodex.Stream, timeout time.Duration) error {
 	// we create readers for all the sources
 	sourceReaders := make([]Executor, 0)
 	for _, sourceMap := range sourceMaps {
-		sourceReader := MakeLocalSourceReader(1, []byte("test"))
+		sourceReader := MakeLocalSourceReader(1, id)
 		if err := sourceReader.Start(nil, sourceMap); err != nil {
 			return err
 		}
 		sourceReaders = append(sourceReaders, sourceReader)
 	}
 
-	// we process the stream using a local stream executor
-	streamExecutor := MakeLocalStreamExecutor(4, []byte("test"))
+	// we process the stream and the streams chained to it (level by level)
+	// using local stream executors
+	levels, err := streamLevels(stream)
 
-	if err := streamExecutor.Start(nil, stream); err != nil {
+	if err != nil {
 		return err
 	}
 
-	// we process all destinations using local destination writers
-	destinationWriters := make([]Executor, 0)
+	streamStages := make([][]Executor, len(levels))
 	destinationMaps := make([]kodex.DestinationMap, 0)
-	configs, err := stream.Configs()
 
-	if err != nil {
-		return err
-	}
+	for i, levelStreams := range levels {
+		for _, levelStream := range levelStreams {
 
-	for _, config := range configs {
-		configDestinations, err := config.Destinations()
-		if err != nil {
-			return err
-		}
+			streamExecutor := MakeLocalStreamExecutor(4, id)
+
+			if err := streamExecutor.Start(nil, levelStream); err != nil {
+				return err
+			}
+
+			streamStages[i] = append(streamStages[i], streamExecutor)
 
-		for _, configDestinationMaps := range configDestinations {
-			destinationMaps = append(destinationMaps, configDestinationMaps...)
+			configs, err := levelStream.Configs()
+
+			if err != nil {
+				return err
+			}
+
+			for _, config := range configs {
+				configDestinations, err := config.Destinations()
+				if err != nil {
+					return err
+				}
+
+				for _, configDestinationMaps := range configDestinations {
+					for _, destinationMap := range configDestinationMaps {
+						// stream destinations are written to by the stream executor
+						if _, ok := kodex.ChainedStreamName(destinationMap.Destination()); !ok {
+							destinationMaps = append(destinationMaps, destinationMap)
+						}
+					}
+				}
+			}
 		}
 	}
 
+	// we process all destinations using local destination writers
+	destinationWriters := make([]Executor, 0)
+
 	for _, destinationMap := range destinationMaps {
-		destinationWriter := MakeLocalDestinationWriter(4, []byte("test"))
+		destinationWriter := MakeLocalDestinationWriter(4, id)
 		if err := destinationWriter.Start(nil, destinationMap); err != nil {
 			return err
 		}
 		destinationWriters = append(destinationWriters, destinationWriter)
 	}
 
-	// we wait for all source readers to finish their work
-	for {
-		allStopped := true
-		for _, sourceReader := range sourceReaders {
-			if !sourceReader.Stopped() {
-				allStopped = false
-				break
-			}
-		}
-		if allStopped {
-			break
+	stages := append([][]Executor{sourceReaders}, streamStages...)
+	stages = append(stages, destinationWriters)
+	names := []string{"Readers"}
+
+	for i := range streamStages {
+		if i == 0 {
+			names = append(names, "Stream executors")
+		} else {
+			names = append(names, fmt.Sprintf("Chained stream executors (level %d)", i))
 		}
-		time.Sleep(time.Millisecond)
 	}
 
-	kodex.Log.Infof("Readers stopped...")
+	names = append(names, "Destination writers")
+
+	stopping := false
 
-	// we wait for the stream executor to finish its work
-	for {
-		if streamExecutor.Stopped() {
-			break
+	// we wait for each stage to finish its work
+	for i, stage := range stages {
+		if level := i - 1; level > 0 && level < len(levels) && !stopping {
+			// all streams writing to the chained streams have stopped
+			for _, levelStream := range levels[level] {
+				if err := endStream(levelStream); err != nil {
+					return err
+				}
+			}
+		}
+		for !allStopped(stage) {
+			select {
+			case sig := <-shutdown:
+				kodex.Log.Infof("Received %v, stopping...", sig)
+				stopping = true
+				var deadline time.Time
+				if timeout > 0 {
+					deadline = time.Now().Add(timeout)
+				}
+				stopExecutors(stages, true, deadline, shutdown)
+			case <-time.After(time.Millisecond):
+			}
 		}
-		time.Sleep(time.Millisecond)
+		kodex.Log.Infof("%s stopped...", names[i])
 	}
 
-	kodex.Log.Infof("Stream executors stopped...")
+	return nil
+
+}
+
+// Groups a stream and the streams chained to it into levels, so that streams
+// only receive items from streams on lower levels (each stream is placed on
+// the level after its last writing stream).
+func streamLevels(stream kodex.Stream) ([][]kodex.Stream, error) {
 
-	// we wait for all destination writers to finish their work
-	for {
-		allStopped := true
-		for _, destinationWriter := range destinationWriters {
-			if !destinationWriter.Stopped() {
-				allStopped = false
-				break
-			}
+	levelByID := map[string]int{}
+	streamsByID := map[string]kodex.Stream{}
+
+	queue := []kodex.Stream{stream}
+	levelByID[hex.EncodeToString(stream.ID())] = 0
+	streamsByID[hex.EncodeToString(stream.ID())] = stream
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		level := levelByID[hex.EncodeToString(current.ID())]
+		chained, err := kodex.ChainedStreams(current)
+		if err != nil {
+			return nil, err
 		}
-		if allStopped {
-			break
+		for _, chainedStream := range chained {
+			id := hex.EncodeToString(chainedStream.ID())
+			if chainedLevel, ok := levelByID[id]; ok && chainedLevel > level {
+				continue
+			}
+			if level+1 > len(streamsByID) {
+				return nil, fmt.Errorf("streams form a cycle")
+			}
+			levelByID[id] = level + 1
+			streamsByID[id] = chainedStream
+			queue = append(queue, chainedStream)
 		}
-		time.Sleep(time.Millisecond)
 	}
 
-	kodex.Log.Infof("Destination writers stopped...")
+	levels := make([][]kodex.Stream, 0)
 
-	return nil
+	for id, level := range levelByID {
+		for len(levels) <= level {
+			levels = append(levels, nil)
+		}
+		levels[level] = append(levels[level], streamsByID[id])
+	}
 
+	return levels, nil
+}
+
+// Ends a chained stream by writing an "end of stream" payload to its channel
+func endStream(stream kodex.Stream) error {
+	channel := kodex.MakeInternalChannel()
+	if err := channel.Setup(stream.Project().Controller(), stream); err != nil {
+		return err
+	}
+	defer channel.Teardown()
+	return channel.Write(kodex.MakeBasicPayload([]*kodex.Item{}, map[string]interface{}{}, true))
 }
@@ -27,6 +27,8 @@ type LocalDestinationWorker struct {
 	started        bool
 	ItemsProcessed int
 	writer         kodex.Writer
+	metrics        *destinationMetrics
+	ordering       *sequencer
 	channels       []*kodex.InternalChannel
 	executor       Executor
 	mutex          sync.Mutex
@@ -36,6 +38,8 @@ type LocalDestinationWorker struct {
 
 func MakeLocalDestinationWorker(pool chan chan kodex.Payload,
 	writer kodex.Writer,
+	writeMetrics *destinationMetrics,
+	ordering *sequencer,
 	executor Executor) (*LocalDestinationWorker, error) {
 	return &LocalDestinationWorker{
 		pool:           pool,
@@ -44,6 +48,8 @@ func MakeLocalDestinationWorker(pool chan chan kodex.Payload,
 		executor:       executor,
 		started:        false,
 		writer:         writer,
+		metrics:        writeMetrics,
+		ordering:       ordering,
 	}, nil
 }
 
@@ -60,8 +66,15 @@ func (w *LocalDestinationWorker) Start() {
 		for {
 			select {
 			case payload := <-w.payloadChannel:
-				w.ItemsProcessed += len(payload.Items())
-				w.ProcessPayload(payload)
+				if isAborted(w.executor) {
+					w.ordering.run(payload, func() error {
+						requeuePayload(payload)
+						return nil
+					})
+				} else {
+					w.ItemsProcessed += len(payload.Items())
+					w.ProcessPayload(payload)
+				}
 				w.pool <- w.payloadChannel
 			case <-w.stop:
 				stop = true
@@ -135,10 +148,18 @@ func (w *LocalDestinationWorker) ProcessPayload(payload kodex.Payload) error {
 		return err
 	}
 
-	if err := w.writer.Write(payload); err != nil {
-		return handleError(err)
-	}
+	// in the ordered mode, we write the payloads in the order they were read
+	return w.ordering.run(payload, func() error {
+
+		start := time.Now()
+		err := w.writer.Write(payload)
+		w.metrics.written(len(payload.Items()), start, err)
+
+		if err != nil {
+			return handleError(err)
+		}
 
-	return payload.Acknowledge()
+		return payload.Acknowledge()
+	})
 
 }
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"github.com/kiprotect/kodex"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -28,13 +29,17 @@ type LocalDestinationWriter struct {
 	workers               []*LocalDestinationWorker
 	id                    []byte
 	pool                  chan chan kodex.Payload
+	ordering              *sequencer
 	destinationMap        kodex.DestinationMap
 	writer                kodex.Writer
-	endOfStream           bool
+	endOfStream           atomic.Bool
+	aborting              atomic.Bool
 	channel               *kodex.InternalChannel
 	stopChannel           chan bool
 	mutex                 sync.Mutex
 	supervisor            Supervisor
+	stats                 statsCollector
+	unregisterQueue       func()
 	stopped               bool
 	stopping              bool
 	payloadChannel        chan kodex.Payload
@@ -51,6 +56,20 @@ func MakeLocalDestinationWriter(maxDestinationWorkers int,
 	}
 }
 
+func (d *LocalDestinationWriter) Stats() kodex.ProcessingStats {
+	return d.stats.Stats()
+}
+
+func (d *LocalDestinationWriter) aborted() bool {
+	return d.aborting.Load()
+}
+
+func (d *LocalDestinationWriter) Completed() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.stopped && d.endOfStream.Load()
+}
+
 func (d *LocalDestinationWriter) ID() []byte {
 	return d.id
 }
@@ -70,7 +89,8 @@ func (d *LocalDestinationWriter) Start(supervisor Supervisor, processable kodex.
 		return fmt.Errorf("busy")
 	}
 
-	d.endOfStream = false
+	d.endOfStream.Store(false)
+	d.aborting.Store(false)
 	d.destinationMap = destinationMap
 	d.supervisor = supervisor
 
@@ -94,6 +114,10 @@ func (d *LocalDestinationWriter) Start(supervisor Supervisor, processable kodex.
 }
 
 func (d *LocalDestinationWriter) Stop(graceful bool) error {
+	if !graceful {
+		// workers reject their remaining payloads instead of processing them
+		d.aborting.Store(true)
+	}
 	return d.stop(graceful)
 }
 
@@ -105,17 +129,46 @@ func (d *LocalDestinationWriter) run() error {
 		return fmt.Errorf("no destination map defined")
 	}
 
-	d.pool = make(chan chan kodex.Payload, d.maxDestinationWorkers)
+	settings, err := kodex.MakeProcessingSettings(d.destinationMap.Destination().Data())
+
+	if err != nil {
+		return err
+	}
+
+	if settings.Mode == kodex.PartitionedProcessing {
+		return fmt.Errorf("destinations do not support the partitioned mode")
+	}
+
+	workers := d.maxDestinationWorkers
+
+	if settings.Workers > 0 {
+		workers = settings.Workers
+	}
+
+	d.ordering = nil
+
+	if settings.Mode == kodex.OrderedProcessing {
+		d.ordering = makeSequencer()
+	}
 
-	for i := 0; i < d.maxDestinationWorkers; i++ {
-		worker, err := MakeLocalDestinationWorker(d.pool, d.writer, d)
+	d.pool = make(chan chan kodex.Payload, workers)
+
+	name := d.destinationMap.Destination().Name()
+	writeMetrics := makeDestinationMetrics(name)
+	workerChannels := make([]chan kodex.Payload, 0, workers)
+
+	for i := 0; i < workers; i++ {
+		worker, err := MakeLocalDestinationWorker(d.pool, d.writer, writeMetrics, d.ordering, d)
 		if err != nil {
 			return err
 		}
 		worker.Start()
 		d.workers = append(d.workers, worker)
+		workerChannels = append(workerChannels, worker.payloadChannel)
 	}
 
+	d.unregisterQueue = registerQueue("destination", name, d.channel, workerChannels)
+
 	d.stopped = false
 
 	go d.write()
@@ -131,24 +184,28 @@ func (d *LocalDestinationWriter) Stopped() bool {
 
 func (d *LocalDestinationWriter) stop(graceful bool) error {
 
+	d.mutex.Lock()
+
 	if d.stopping || d.stopped {
+		d.mutex.Unlock()
 		return nil
 	}
 
-	d.mutex.Lock()
-
 	destinationMap := d.destinationMap
 	supervisor := d.supervisor
 
 	d.stopping = true
 
 	defer func() {
-		d.mutex.Unlock()
 		d.destinationMap = nil
 		d.writer = nil
 		d.stopped = true
 		d.stopping = false
 		d.supervisor = nil
+		d.mutex.Unlock()
+		if supervisor != nil {
+			supervisor.ExecutorStopped(d, destinationMap)
+		}
 	}()
 
 	// first we stop the destination writer to stop reading more payloads..
@@ -161,10 +218,10 @@ func (d *LocalDestinationWriter) stop(graceful bool) error {
 	for i, worker := range d.workers {
 		// we submit the "end of stream" payload to the last active worker
 		// to ensure it will be processed as the last payload
-		if d.endOfStream && i == len(d.workers)-1 {
+		if d.endOfStream.Load() && i == len(d.workers)-1 {
 			endOfStreamPayload := kodex.MakeBasicPayload([]*kodex.Item{}, map[string]interface{}{}, true)
 			workerChannel := <-d.pool
-			workerChannel <- endOfStreamPayload
+			workerChannel <- d.ordering.sequence(endOfStreamPayload)
 		}
 		worker.Stop()
 		itemsProcessed += worker.ItemsProcessed
@@ -172,6 +229,11 @@ func (d *LocalDestinationWriter) stop(graceful bool) error {
 
 	kodex.Log.Debugf("%d items processed by destination workers", itemsProcessed)
 
+	if d.unregisterQueue != nil {
+		d.unregisterQueue()
+		d.unregisterQueue = nil
+	}
+
 	// then we tear down the destination writer
 	if err := d.writer.Teardown(); err != nil {
 		kodex.Log.Error(err)
@@ -181,10 +243,6 @@ func (d *LocalDestinationWriter) stop(graceful bool) error {
 		kodex.Log.Error(err)
 	}
 
-	if supervisor != nil {
-		supervisor.ExecutorStopped(d, destinationMap)
-	}
-
 	return nil
 
 }
@@ -192,10 +250,11 @@ func (d *LocalDestinationWriter) stop(graceful bool) error {
 func (d *LocalDestinationWriter) write() {
 
 	stopping := false
+	stopRequested := false
 
 	stop := func() {
-		if !stopping {
-			stopping = true
+		if !stopRequested && !stopping {
+			stopRequested = true
 			go d.stop(true)
 		}
 	}
@@ -214,15 +273,24 @@ func (d *LocalDestinationWriter) write() {
 			break
 		}
 
+		if stopping && d.aborted() {
+			// we leave the remaining payloads in the channel
+			d.stopChannel <- true
+			return
+		}
+
 		// to do: check if the destination was updated and if yes break out of
 		// the loop (to reload configuration)
 
 		if payload, err = d.channel.Read(); err != nil {
+			d.stats.error()
 			kodex.Log.Error(err)
 			stop()
 			continue
 		}
 
+		d.stats.poll(payloadItems(payload))
+
 		// we didn't receive any new items...
 		if payload == nil {
 			if stopping {
@@ -238,15 +306,13 @@ func (d *LocalDestinationWriter) write() {
 		if payload.EndOfStream() {
 			// we replace the "end of stream payload" and instead send a replacement
 			// payload during the stop process to ensure that it will be processed last
-			replacedPayload := kodex.MakeBasicPayload(payload.Items(), payload.Headers(), false)
+			replacedPayload := withoutEndOfStream(payload)
 			workerChannel := <-d.pool
-			workerChannel <- replacedPayload
-			d.mutex.Lock()
-			d.endOfStream = true
-			d.mutex.Unlock()
+			workerChannel <- d.ordering.sequence(replacedPayload)
+			d.endOfStream.Store(true)
 		} else {
 			workerChannel := <-d.pool
-			workerChannel <- payload
+			workerChannel <- d.ordering.sequence(payload)
 		}
 
 		if payload.EndOfStream() {
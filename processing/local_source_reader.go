@@ -19,7 +19,10 @@ package processing
 import (
 	"fmt"
 	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/metrics"
+	"github.com/prometheus/client_golang/prometheus"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -32,9 +35,14 @@ type LocalSourceReader struct {
 	reader           kodex.Reader
 	configs          []kodex.Config
 	stopChannel      chan bool
-	endOfStream      bool
+	endOfStream      atomic.Bool
+	aborting         atomic.Bool
 	mutex            sync.Mutex
 	supervisor       Supervisor
+	stats            statsCollector
+	items            prometheus.Counter
+	unregisterQueue  func()
+	batch            bool
 	stopped          bool
 	stopping         bool
 	payloadChannel   chan kodex.Payload
@@ -43,7 +51,7 @@ type LocalSourceReader struct {
 func MakeLocalSourceReader(maxSourceWorkers int,
 	id []byte) *LocalSourceReader {
 	return &LocalSourceReader{
-		stopChannel:      make(chan bool, 1),
+		stopChannel:      make(chan bool),
 		stopped:          true,
 		id:               id,
 		payloadChannel:   make(chan kodex.Payload, maxSourceWorkers*8),
@@ -51,6 +59,20 @@ func MakeLocalSourceReader(maxSourceWorkers int,
 	}
 }
 
+func (d *LocalSourceReader) Stats() kodex.ProcessingStats {
+	return d.stats.Stats()
+}
+
+func (d *LocalSourceReader) aborted() bool {
+	return d.aborting.Load()
+}
+
+func (d *LocalSourceReader) Completed() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.stopped && d.endOfStream.Load()
+}
+
 func (d *LocalSourceReader) ID() []byte {
 	return d.id
 }
@@ -70,7 +92,8 @@ func (d *LocalSourceReader) Start(supervisor Supervisor, processable kodex.Proce
 		return fmt.Errorf("busy")
 	}
 
-	d.endOfStream = false
+	d.endOfStream.Store(false)
+	d.aborting.Store(false)
 	d.sourceMap = sourceMap
 	d.supervisor = supervisor
 
@@ -79,7 +102,14 @@ func (d *LocalSourceReader) Start(supervisor Supervisor, processable kodex.Proce
 		return err
 	}
 
-	if err := d.reader.Setup(nil); err != nil {
+	// stateful readers (e.g. SQL) keep their state in the source
+	if statefulReader, ok := d.reader.(kodex.StatefulReader); ok {
+		err = statefulReader.SetupWithSource(d.sourceMap.Source())
+	} else {
+		err = d.reader.Setup(nil)
+	}
+
+	if err != nil {
 		return err
 	}
 
@@ -87,6 +117,13 @@ func (d *LocalSourceReader) Start(supervisor Supervisor, processable kodex.Proce
 }
 
 func (d *LocalSourceReader) Stop(graceful bool) error {
+	if graceful && !d.Stopped() {
+		// we end the stream so that stream executors finalize their work
+		d.endOfStream.Store(true)
+	} else if !graceful {
+		// workers reject their remaining payloads instead of processing them
+		d.aborting.Store(true)
+	}
 	return d.stop(graceful)
 }
 
@@ -107,6 +144,8 @@ func (d *LocalSourceReader) run() error {
 
 	d.pool = make(chan chan kodex.Payload, d.maxSourceWorkers)
 
+	workerChannels := make([]chan kodex.Payload, 0, d.maxSourceWorkers)
+
 	for i := 0; i < d.maxSourceWorkers; i++ {
 		worker, err := MakeLocalSourceWorker(d.pool, streams, d)
 		if err != nil {
@@ -114,8 +153,13 @@ func (d *LocalSourceReader) run() error {
 		}
 		worker.Start()
 		d.workers = append(d.workers, worker)
+		workerChannels = append(workerChannels, worker.payloadChannel)
 	}
 
+	name := d.sourceMap.Source().Name()
+	d.items = metrics.SourceItems.WithLabelValues(name)
+	d.unregisterQueue = registerQueue("source", name, nil, workerChannels)
+
 	d.stopped = false
 
 	go d.read()
@@ -135,12 +179,13 @@ func (d *LocalSourceReader) SourceMap() kodex.SourceMap {
 
 func (d *LocalSourceReader) stop(graceful bool) error {
 
+	d.mutex.Lock()
+
 	if d.stopping || d.stopped {
+		d.mutex.Unlock()
 		return nil
 	}
 
-	d.mutex.Lock()
-
 	sourceMap := d.sourceMap
 	supervisor := d.supervisor
 	defer func() {
@@ -166,8 +211,10 @@ func (d *LocalSourceReader) stop(graceful bool) error {
 	// then we stop the workers...
 	for i, worker := range d.workers {
 		// we submit the "end of stream" payload to the last active worker
-		// to ensure it will be processed as the last payload
-		if d.endOfStream && i == len(d.workers)-1 {
+		// to ensure it will be processed as the last payload (in batch mode,
+		// i.e. for scheduled streams, the end of the source only ends the
+		// current run and not the streams)
+		if d.endOfStream.Load() && !d.batch && i == len(d.workers)-1 {
 			endOfStreamPayload := kodex.MakeBasicPayload([]*kodex.Item{}, map[string]interface{}{}, true)
 			workerChannel := <-d.pool
 			workerChannel <- endOfStreamPayload
@@ -178,6 +225,11 @@ func (d *LocalSourceReader) stop(graceful bool) error {
 
 	kodex.Log.Debugf("%d items processed by reader workers", itemsProcessed)
 
+	if d.unregisterQueue != nil {
+		d.unregisterQueue()
+		d.unregisterQueue = nil
+	}
+
 	// then we tear down the source reader
 	if err := d.reader.Teardown(); err != nil {
 		kodex.Log.Error(err)
@@ -215,27 +267,33 @@ func (d *LocalSourceReader) read() {
 		// the loop (to reload configuration)
 
 		if payload, err = d.reader.Read(); err != nil {
+			d.stats.error()
 			stop()
 			continue
 		}
 
+		d.stats.poll(payloadItems(payload))
+		d.items.Add(float64(payloadItems(payload)))
+
 		// we didn't receive any new items...
 		if payload == nil || len(payload.Items()) == 0 {
 			if stopping {
 
 				return
 			}
+			// readers that wait for data (e.g. HTTP or AMQP) return no payload on timeout
+			if payload == nil {
+				continue
+			}
 		}
 
 		if payload.EndOfStream() {
 			// we replace the "end of stream payload" and instead send a replacement
 			// payload during the stop process to ensure that it will be processed last
-			replacedPayload := kodex.MakeBasicPayload(payload.Items(), payload.Headers(), false)
+			replacedPayload := withoutEndOfStream(payload)
 			workerChannel := <-d.pool
 			workerChannel <- replacedPayload
-			d.mutex.Lock()
-			d.endOfStream = true
-			d.mutex.Unlock()
+			d.endOfStream.Store(true)
 		} else {
 			workerChannel := <-d.pool
 			workerChannel <- payload
@@ -81,8 +81,12 @@ func (w *LocalSourceWorker) Start() {
 		for {
 			select {
 			case payload := <-w.payloadChannel:
-				w.ItemsProcessed += len(payload.Items())
-				w.ProcessPayload(payload)
+				if isAborted(w.executor) {
+					requeuePayload(payload)
+				} else {
+					w.ItemsProcessed += len(payload.Items())
+					w.ProcessPayload(payload)
+				}
 				w.pool <- w.payloadChannel
 			case <-w.stop:
 				stop = true
@@ -146,19 +150,26 @@ func (w *LocalSourceWorker) Stop() {
 
 func (w *LocalSourceWorker) ProcessPayload(payload kodex.Payload) error {
 
-	// we send the items from the payload to the designated internal queues
+	// we send the items from the payload to the designated internal queues,
+	// the payload is acknowledged once all streams have written them
+	lineage := kodex.MakeLineage(payload)
 
 	handleError := func(err error) error {
 		kodex.Log.Error(err)
+		if err := lineage.Reject(); err != nil {
+			kodex.Log.Error(err)
+		}
 		return err
 	}
 
 	for _, channel := range w.channels {
-		if err := channel.Write(payload); err != nil {
+		derivedPayload := lineage.Derive(payload.Items(), payload.Headers(), payload.EndOfStream())
+		if err := channel.Write(derivedPayload); err != nil {
+			derivedPayload.Reject()
 			return handleError(err)
 		}
 	}
 
-	return nil
+	return lineage.Acknowledge()
 
 }
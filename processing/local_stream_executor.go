@@ -20,22 +20,39 @@ import (
 	"encoding/hex"
 	"fmt"
 	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/metrics"
+	"github.com/prometheus/client_golang/prometheus"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// How often stream executors advance stateful actions (e.g. to close
+// aggregation windows) if they receive no payloads
+const DefaultAdvanceInterval = time.Second * 10
+
 type LocalStreamExecutor struct {
 	maxStreamWorkers int
+	advanceInterval  time.Duration
 	workers          []*LocalStreamWorker
 	id               []byte
-	pool             chan chan kodex.Payload
+	pools            []chan chan kodex.Payload
+	settings         *kodex.ProcessingSettings
+	ordering         *sequencer
 	stream           kodex.Stream
 	channel          *kodex.InternalChannel
 	contexts         []*ConfigContext
+	contextsMutex    sync.Mutex
 	stopChannel      chan bool
 	mutex            sync.Mutex
 	supervisor       Supervisor
-	endOfStream      bool
+	stats            statsCollector
+	items            prometheus.Counter
+	unregisterQueue  func()
+	endOfStream      atomic.Bool
+	finalizing       atomic.Bool
+	aborting         atomic.Bool
+	scheduled        bool
 	stopped          bool
 	stopping         bool
 	payloadChannel   chan kodex.Payload
@@ -49,9 +66,24 @@ func MakeLocalStreamExecutor(maxStreamWorkers int,
 		id:               id,
 		payloadChannel:   make(chan kodex.Payload, maxStreamWorkers*8),
 		maxStreamWorkers: maxStreamWorkers,
+		advanceInterval:  DefaultAdvanceInterval,
 	}
 }
 
+func (d *LocalStreamExecutor) Stats() kodex.ProcessingStats {
+	return d.stats.Stats()
+}
+
+func (d *LocalStreamExecutor) aborted() bool {
+	return d.aborting.Load()
+}
+
+func (d *LocalStreamExecutor) Completed() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.stopped && d.endOfStream.Load()
+}
+
 func (d *LocalStreamExecutor) ID() []byte {
 	return d.id
 }
@@ -74,7 +106,9 @@ func (d *LocalStreamExecutor) Start(supervisor Supervisor, processable kodex.Pro
 	}
 
 	d.stream = stream
-	d.endOfStream = false
+	d.endOfStream.Store(false)
+	d.finalizing.Store(false)
+	d.aborting.Store(false)
 	d.supervisor = supervisor
 	d.channel = kodex.MakeInternalChannel()
 
@@ -86,9 +120,41 @@ func (d *LocalStreamExecutor) Start(supervisor Supervisor, processable kodex.Pro
 }
 
 func (d *LocalStreamExecutor) Stop(graceful bool) error {
+	if graceful && !d.Stopped() {
+		if d.scheduled {
+			// the sources of scheduled streams do not end the stream, so we
+			// end it here to finalize the actions (e.g. aggregates)
+			d.endOfStream.Store(true)
+		} else {
+			// continuous streams will be restarted, so we only finalize the
+			// actions without ending the stream for the destinations
+			d.finalizing.Store(true)
+		}
+	} else if !graceful {
+		// workers reject their remaining payloads instead of processing them
+		d.aborting.Store(true)
+		// and processors stop retrying failed actions
+		d.interrupt()
+	}
 	return d.stop(graceful)
 }
 
+// Sets the contexts, which aborting stops read without holding the main mutex
+func (d *LocalStreamExecutor) setContexts(contexts []*ConfigContext) {
+	d.contextsMutex.Lock()
+	defer d.contextsMutex.Unlock()
+	d.contexts = contexts
+}
+
+// Interrupts the retries of the processors (see kodex.Processor.Interrupt)
+func (d *LocalStreamExecutor) interrupt() {
+	d.contextsMutex.Lock()
+	defer d.contextsMutex.Unlock()
+	for _, context := range d.contexts {
+		context.Processor.Interrupt()
+	}
+}
+
 func (d *LocalStreamExecutor) run() error {
 
 	d.workers = make([]*LocalStreamWorker, 0)
@@ -112,23 +178,58 @@ func (d *LocalStreamExecutor) run() error {
 		}
 	}
 
-	d.pool = make(chan chan kodex.Payload, d.maxStreamWorkers)
+	if d.settings, err = kodex.MakeProcessingSettings(d.stream.Data()); err != nil {
+		return err
+	}
 
-	d.contexts, err = makeContexts(activeConfigs)
+	workers := d.maxStreamWorkers
 
-	if err != nil {
-		return err
+	if d.settings.Workers > 0 {
+		workers = d.settings.Workers
+	}
+
+	d.ordering = nil
+
+	if d.settings.Mode == kodex.OrderedProcessing {
+		d.ordering = makeSequencer()
 	}
 
-	for i := 0; i < d.maxStreamWorkers; i++ {
-		worker, err := MakeLocalStreamWorker(d.pool, d.contexts, false, d)
+	partitioned := d.settings.Mode == kodex.PartitionedProcessing
+
+	// in the partitioned mode, every worker has its own pool and contexts
+	// (so that stateful actions only see the items of their partition)
+	pool := make(chan chan kodex.Payload, workers)
+	d.pools = make([]chan chan kodex.Payload, workers)
+	d.setContexts(nil)
+
+	var contexts []*ConfigContext
+
+	workerChannels := make([]chan kodex.Payload, 0, workers)
+
+	for i := 0; i < workers; i++ {
+		if partitioned || i == 0 {
+			if contexts, err = makeContexts(activeConfigs); err != nil {
+				return err
+			}
+			d.setContexts(append(d.contexts, contexts...))
+		}
+		if partitioned {
+			pool = make(chan chan kodex.Payload, 1)
+		}
+		d.pools[i] = pool
+		worker, err := MakeLocalStreamWorker(pool, contexts, false, d.ordering, d)
 		if err != nil {
 			return err
 		}
 		worker.Start()
 		d.workers = append(d.workers, worker)
+		workerChannels = append(workerChannels, worker.payloadChannel)
 	}
 
+	d.scheduled = d.stream.Schedule() != ""
+	d.items = metrics.StreamItems.WithLabelValues(d.stream.Name())
+	d.unregisterQueue = registerQueue("stream", d.stream.Name(), d.channel, workerChannels)
+
 	d.stopped = false
 
 	go d.read()
@@ -175,29 +276,30 @@ func makeContexts(configs []kodex.Config) ([]*ConfigContext, error) {
 
 func (d *LocalStreamExecutor) stop(graceful bool) error {
 
+	d.mutex.Lock()
+
 	if d.stopping || d.stopped {
+		d.mutex.Unlock()
 		return nil
 	}
 
-	d.mutex.Lock()
-
 	stream := d.stream
 	supervisor := d.supervisor
 
 	defer func() {
 
 		d.stream = nil
-		d.contexts = nil
+		d.setContexts(nil)
 		d.channel = nil
 		d.stopped = true
 		d.stopping = false
 		d.supervisor = nil
 
+		d.mutex.Unlock()
+
 		if supervisor != nil {
 			supervisor.ExecutorStopped(d, stream)
 		}
-
-		d.mutex.Unlock()
 	}()
 
 	d.stopping = true
@@ -211,10 +313,15 @@ func (d *LocalStreamExecutor) stop(graceful bool) error {
 	for i, worker := range d.workers {
 		// we submit the "end of stream" payload to the last active worker
 		// to ensure it will be processed as the last payload
-		if d.endOfStream && i == len(d.workers)-1 {
-			endOfStreamPayload := kodex.MakeBasicPayload([]*kodex.Item{}, map[string]interface{}{}, true)
-			workerChannel := <-d.pool
-			workerChannel <- endOfStreamPayload
+		// (in the partitioned mode, every worker finalizes its own actions)
+		// (when finalizing, no worker announces the end of the stream)
+		if (d.endOfStream.Load() || d.finalizing.Load()) && (i == len(d.workers)-1 || d.settings.Mode == kodex.PartitionedProcessing) {
+			var endOfStreamPayload kodex.Payload = kodex.MakeBasicPayload([]*kodex.Item{}, map[string]interface{}{}, true)
+			if i < len(d.workers)-1 || !d.endOfStream.Load() {
+				endOfStreamPayload = &finalizePayload{endOfStreamPayload}
+			}
+			workerChannel := <-d.pools[i]
+			workerChannel <- d.ordering.sequence(endOfStreamPayload)
 		}
 		worker.Stop()
 		itemsProcessed += worker.ItemsProcessed
@@ -222,6 +329,11 @@ func (d *LocalStreamExecutor) stop(graceful bool) error {
 
 	kodex.Log.Debugf("%d items processed by stream workers", itemsProcessed)
 
+	if d.unregisterQueue != nil {
+		d.unregisterQueue()
+		d.unregisterQueue = nil
+	}
+
 	// then we tear down the stream channel
 	if err := d.channel.Teardown(); err != nil {
 		kodex.Log.Error(err)
@@ -252,23 +364,42 @@ func (d *LocalStreamExecutor) stop(graceful bool) error {
 
 }
 
+// Hands a payload to the next free worker (in the partitioned mode, to the
+// workers responsible for its items)
+func (d *LocalStreamExecutor) dispatch(payload kodex.Payload) {
+	if d.settings.Mode == kodex.PartitionedProcessing {
+		for i, partitionedPayload := range partitionPayload(payload, d.settings.PartitionKey, len(d.pools)) {
+			if partitionedPayload != nil {
+				workerChannel := <-d.pools[i]
+				workerChannel <- partitionedPayload
+			}
+		}
+		return
+	}
+	workerChannel := <-d.pools[0]
+	workerChannel <- d.ordering.sequence(payload)
+}
+
 func (d *LocalStreamExecutor) read() {
 
 	stopping := false
+	stopRequested := false
 
 	stop := func() {
-		if !stopping {
-			stopping = true
+		if !stopRequested && !stopping {
+			stopRequested = true
 			go d.stop(true)
 		}
 	}
 
 	// we generate an empty payload that we send to one of the processors, which triggers
 	// e.g. the 'Advance()' method for stateful actions...
-	payload := kodex.MakeBasicPayload([]*kodex.Item{}, map[string]interface{}{}, false)
+	advance := func() {
+		d.dispatch(kodex.MakeBasicPayload([]*kodex.Item{}, map[string]interface{}{}, false))
+	}
 
-	workerChannel := <-d.pool
-	workerChannel <- payload
+	advance()
+	advancedAt := time.Now()
 
 	itemsProcessed := 0
 
@@ -283,12 +414,22 @@ func (d *LocalStreamExecutor) read() {
 			break
 		}
 
+		if stopping && d.aborted() {
+			// we leave the remaining payloads in the channel
+			d.stopChannel <- true
+			return
+		}
+
 		if payload, err = d.channel.Read(); err != nil {
+			d.stats.error()
 			kodex.Log.Error(err)
 			stop()
 			continue
 		}
 
+		d.stats.poll(payloadItems(payload))
+		d.items.Add(float64(payloadItems(payload)))
+
 		// we didn't receive any new items...
 		if payload == nil {
 			if stopping {
@@ -296,23 +437,26 @@ func (d *LocalStreamExecutor) read() {
 				kodex.Log.Debugf("%d items processed in stream", itemsProcessed)
 				return
 			}
+			// we advance the actions regularly even without new items, so that
+			// e.g. time-based aggregation windows are closed
+			if time.Since(advancedAt) >= d.advanceInterval {
+				advance()
+				advancedAt = time.Now()
+			}
 			continue
 		}
 
+		// processing a payload advances the actions as well
+		advancedAt = time.Now()
 		itemsProcessed += len(payload.Items())
 
 		if payload.EndOfStream() {
 			// we replace the "end of stream payload" and instead send a replacement
 			// payload during the stop process to ensure that it will be processed last
-			replacedPayload := kodex.MakeBasicPayload(payload.Items(), payload.Headers(), false)
-			workerChannel := <-d.pool
-			workerChannel <- replacedPayload
-			d.mutex.Lock()
-			d.endOfStream = true
-			d.mutex.Unlock()
+			d.dispatch(withoutEndOfStream(payload))
+			d.endOfStream.Store(true)
 		} else {
-			workerChannel := <-d.pool
-			workerChannel <- payload
+			d.dispatch(payload)
 		}
 
 		if payload.EndOfStream() {
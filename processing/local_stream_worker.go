@@ -35,6 +35,7 @@ type LocalStreamWorker struct {
 	ItemsProcessed    int
 	mutex             sync.Mutex
 	contexts          []*ConfigContext
+	ordering          *sequencer
 	executor          Executor
 	payloadChannel    chan kodex.Payload
 	stop              chan bool
@@ -43,6 +44,7 @@ type LocalStreamWorker struct {
 func MakeLocalStreamWorker(pool chan chan kodex.Payload,
 	contexts []*ConfigContext,
 	acknowledgeFailed bool,
+	ordering *sequencer,
 	executor Executor) (*LocalStreamWorker, error) {
 	// todo: proper error handling
 
@@ -52,6 +54,7 @@ func MakeLocalStreamWorker(pool chan chan kodex.Payload,
 		payloadChannel:    make(chan kodex.Payload),
 		stop:              make(chan bool),
 		contexts:          contexts,
+		ordering:          ordering,
 		started:           false,
 		executor:          executor,
 	}, nil
@@ -67,8 +70,15 @@ func (w *LocalStreamWorker) Start() {
 		for {
 			select {
 			case payload := <-w.payloadChannel:
-				w.ItemsProcessed += len(payload.Items())
-				w.ProcessPayload(payload)
+				if isAborted(w.executor) {
+					w.ordering.run(payload, func() error {
+						requeuePayload(payload)
+						return nil
+					})
+				} else {
+					w.ItemsProcessed += len(payload.Items())
+					w.ProcessPayload(payload)
+				}
 				w.pool <- w.payloadChannel
 				break
 			case <-w.stop:
@@ -126,41 +136,73 @@ func (w *LocalStreamWorker) Stop() {
 
 func (w *LocalStreamWorker) ProcessPayload(payload kodex.Payload) error {
 
+	// the payload is acknowledged once all destinations have written the
+	// items derived from it
+	lineage := kodex.MakeLineage(payload)
+
 	handleError := func(err error) error {
 		kodex.Log.Error(err)
-		if w.acknowledgeFailed {
+		if err == kodex.RetryInterrupted {
+			// the executor is stopping, the payload will be delivered again
+			kodex.Log.Warning("Requeueing interrupted payload...")
+			lineage.Requeue()
+		} else if w.acknowledgeFailed {
 			kodex.Log.Warning("Acknowledging failed payload...")
-			payload.Acknowledge()
+			lineage.Acknowledge()
 		} else {
 			kodex.Log.Warning("Rejecting failed payload...")
-			payload.Reject()
+			lineage.Reject()
 		}
 		return err
 	}
 
-	var items, newItems []*kodex.Item
+	var newItems []*kodex.Item
 	var err error
 
-	items = payload.Items()
+	items := payload.Items()
+	results := make([][]*kodex.Item, len(w.contexts))
 
 	kodex.Log.Debugf("Received %d items for payload...", len(items))
 
-	for _, context := range w.contexts {
+	// we process the items with all configs first, so that only the writing
+	// of the results needs to wait for other workers in the ordered mode
+	for i, context := range w.contexts {
 
 		if newItems, err = context.Processor.Process(items, nil); err != nil {
-			return handleError(err)
+			break
 		}
 
 		if payload.EndOfStream() {
-			if finalizedItems, err := context.Processor.Finalize(); err != nil {
-				return handleError(err)
+			if finalizedItems, finalizeErr := context.Processor.Finalize(); finalizeErr != nil {
+				err = finalizeErr
+				break
 			} else {
 				newItems = append(newItems, finalizedItems...)
 			}
 		}
 
-		for _, destinationMaps := range context.Destinations {
+		results[i] = newItems
+	}
+
+	return w.ordering.run(payload, func() error {
+		if err != nil {
+			return handleError(err)
+		}
+		return w.write(payload, lineage, results, handleError)
+	})
 
+}
+
+// Writes the results of the configs to their destinations
+func (w *LocalStreamWorker) write(payload kodex.Payload, lineage *kodex.Lineage, results [][]*kodex.Item, handleError func(error) error) error {
+
+	endOfStream := announcesEndOfStream(payload)
+
+	for i, context := range w.contexts {
+
+		newItems := results[i]
+
+		for _, destinationMaps := range context.Destinations {
 			for _, destinationMap := range destinationMaps {
 
 				// we do not perform any writer setup as we already did this before
@@ -175,8 +217,10 @@ func (w *LocalStreamWorker) ProcessPayload(payload kodex.Payload) error {
 				if destinationMap.Status() != kodex.ActiveDestination {
 
 					// we always announce the end of the stream to the destination writer...
-					if payload.EndOfStream() {
-						if err := writer.Write(kodex.MakeBasicPayload([]*kodex.Item{}, payload.Headers(), payload.EndOfStream())); err != nil {
+					if endOfStream {
+						endOfStreamPayload := lineage.Derive([]*kodex.Item{}, payload.Headers(), endOfStream)
+						if err := writer.Write(endOfStreamPayload); err != nil {
+							endOfStreamPayload.Reject()
 							kodex.Log.Error("error writing end of stream message...")
 							return handleError(err)
 						}
@@ -185,7 +229,9 @@ func (w *LocalStreamWorker) ProcessPayload(payload kodex.Payload) error {
 					continue
 				}
 
-				if err := writer.Write(kodex.MakeBasicPayload(newItems, payload.Headers(), payload.EndOfStream())); err != nil {
+				derivedPayload := lineage.Derive(newItems, payload.Headers(), endOfStream)
+				if err := writer.Write(derivedPayload); err != nil {
+					derivedPayload.Reject()
 					kodex.Log.Error("error writing items...")
 					return handleError(err)
 				}
@@ -196,8 +242,6 @@ func (w *LocalStreamWorker) ProcessPayload(payload kodex.Payload) error {
 
 	}
 
-	payload.Acknowledge()
-
-	return nil
+	return lineage.Acknowledge()
 
 }
@@ -0,0 +1,63 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package processing
+
+import (
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// Registers the queue of an executor, which consists of the payloads waiting
+// in its internal channel (if any) and in the channels of its workers.
+// Returns a function that unregisters the queue again.
+func registerQueue(kind, name string, channel *kodex.InternalChannel, workerChannels []chan kodex.Payload) func() {
+	return metrics.RegisterQueue(kind, name, func() int {
+		length := 0
+		if channel != nil {
+			length += channel.QueueLength()
+		}
+		for _, workerChannel := range workerChannels {
+			length += len(workerChannel)
+		}
+		return length
+	})
+}
+
+type destinationMetrics struct {
+	items    prometheus.Counter
+	errors   prometheus.Counter
+	duration prometheus.Observer
+}
+
+func makeDestinationMetrics(name string) *destinationMetrics {
+	return &destinationMetrics{
+		items:    metrics.DestinationItems.WithLabelValues(name),
+		errors:   metrics.DestinationErrors.WithLabelValues(name),
+		duration: metrics.DestinationWriteDuration.WithLabelValues(name),
+	}
+}
+
+func (d *destinationMetrics) written(items int, start time.Time, err error) {
+	d.duration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		d.errors.Inc()
+	} else {
+		d.items.Add(float64(items))
+	}
+}
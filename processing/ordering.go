@@ -0,0 +1,144 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package processing
+
+import (
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"hash/fnv"
+	"sync"
+)
+
+// A payload with a sequence number (in the ordered mode)
+type sequencedPayload struct {
+	kodex.Payload
+	sequence uint64
+}
+
+// Numbers payloads in the order in which they are handed to the workers and
+// lets the workers write their results in that order (ordered mode), while
+// they process the payloads in parallel
+type sequencer struct {
+	mutex sync.Mutex
+	cond  *sync.Cond
+	next  uint64
+	turn  uint64
+}
+
+func makeSequencer() *sequencer {
+	s := &sequencer{}
+	s.cond = sync.NewCond(&s.mutex)
+	return s
+}
+
+// Numbers a payload (payloads are returned as they are without a sequencer)
+func (s *sequencer) sequence(payload kodex.Payload) kodex.Payload {
+	if s == nil {
+		return payload
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.next++
+	return &sequencedPayload{Payload: payload, sequence: s.next - 1}
+}
+
+// Waits until all payloads with lower numbers are done, then calls the
+// function. Every numbered payload has to pass through here exactly once,
+// otherwise the payloads after it would wait forever.
+func (s *sequencer) run(payload kodex.Payload, f func() error) error {
+
+	sequenced, ok := payload.(*sequencedPayload)
+
+	if s == nil || !ok {
+		return f()
+	}
+
+	s.mutex.Lock()
+	for s.turn != sequenced.sequence {
+		s.cond.Wait()
+	}
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		s.turn++
+		s.mutex.Unlock()
+		s.cond.Broadcast()
+	}()
+
+	return f()
+}
+
+// Makes all but the last worker finalize their actions at the end of the
+// stream in the partitioned mode, without announcing the end of the stream to
+// the destinations (which only the last worker does)
+type finalizePayload struct {
+	kodex.Payload
+}
+
+// Returns whether the end of the stream should be passed on to destinations
+func announcesEndOfStream(payload kodex.Payload) bool {
+	if _, ok := payload.(*finalizePayload); ok {
+		return false
+	}
+	return payload.EndOfStream()
+}
+
+// Returns the worker that processes an item (in the partitioned mode)
+func partition(item *kodex.Item, key string, partitions int) int {
+	value, _ := item.Get(key)
+	hash := fnv.New32a()
+	// items without the key all go to the same worker
+	if value != nil {
+		hash.Write([]byte(fmt.Sprint(value)))
+	}
+	return int(hash.Sum32() % uint32(partitions))
+}
+
+// Splits a payload into one derived payload per worker, so that items with
+// the same partition key always go to the same worker. Payloads without
+// items (which e.g. advance stateful actions) go to all workers.
+func partitionPayload(payload kodex.Payload, key string, partitions int) []kodex.Payload {
+
+	lineage := kodex.MakeLineage(payload)
+	defer lineage.Acknowledge()
+
+	partitioned := make([]kodex.Payload, partitions)
+	items := payload.Items()
+
+	if len(items) == 0 {
+		for i := range partitioned {
+			partitioned[i] = lineage.Derive(items, payload.Headers(), payload.EndOfStream())
+		}
+		return partitioned
+	}
+
+	partitionItems := make([][]*kodex.Item, partitions)
+
+	for _, item := range items {
+		i := partition(item, key, partitions)
+		partitionItems[i] = append(partitionItems[i], item)
+	}
+
+	for i, items := range partitionItems {
+		if len(items) > 0 {
+			partitioned[i] = lineage.Derive(items, payload.Headers(), payload.EndOfStream())
+		}
+	}
+
+	return partitioned
+}
@@ -0,0 +1,195 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package processing
+
+import (
+	"github.com/kiprotect/kodex"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Records the items in the order in which they are written
+type recordingWriter struct {
+	mutex   sync.Mutex
+	written []int64
+}
+
+func (w *recordingWriter) Write(payload kodex.Payload) error {
+	for _, item := range payload.Items() {
+		value, _ := item.Get("i")
+		i, _ := value.(int64)
+		// later items are written faster, so that they overtake earlier ones
+		// unless the order is enforced
+		time.Sleep(time.Duration(4-i%4) * 5 * time.Millisecond)
+		w.mutex.Lock()
+		w.written = append(w.written, i)
+		w.mutex.Unlock()
+	}
+	return nil
+}
+
+func (w *recordingWriter) Written() []int64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.written
+}
+
+func (w *recordingWriter) Setup(kodex.Config) error { return nil }
+func (w *recordingWriter) Teardown() error          { return nil }
+
+func processingBlueprint(streamProcessing, destinationProcessing map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"sources": []interface{}{
+			map[string]interface{}{"name": "in", "type": "test", "config": map[string]interface{}{}},
+		},
+		"destinations": []interface{}{
+			map[string]interface{}{
+				"name":   "out",
+				"type":   "test",
+				"config": map[string]interface{}{},
+				"data":   map[string]interface{}{"processing": destinationProcessing},
+			},
+		},
+		"streams": []interface{}{
+			map[string]interface{}{
+				"name": "default",
+				"data": map[string]interface{}{"processing": streamProcessing},
+				"sources": []interface{}{
+					map[string]interface{}{"source": "in"},
+				},
+				"configs": []interface{}{
+					map[string]interface{}{
+						"name": "default",
+						"destinations": []interface{}{
+							map[string]interface{}{"name": "out", "status": "active"},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func makeReader(n int) *payloadsReader {
+	reader := &payloadsReader{}
+	for i := 0; i < n; i++ {
+		reader.payloads = append(reader.payloads, &trackedPayload{
+			BasicPayload: kodex.MakeBasicPayload([]*kodex.Item{kodex.MakeItem(map[string]interface{}{"i": int64(i), "key": int64(i % 3)})}, map[string]interface{}{}, i == n-1),
+		})
+	}
+	return reader
+}
+
+func TestOrderedProcessing(t *testing.T) {
+
+	processing := map[string]interface{}{"workers": 4, "mode": "ordered"}
+	writer := &recordingWriter{}
+	stream, teardown := setupTestBlueprint(t, makeReader(40), writer, processingBlueprint(processing, processing))
+	defer teardown()
+
+	processStream(t, stream, nil, 0)
+
+	written := writer.Written()
+
+	if len(written) != 40 {
+		t.Fatalf("expected 40 written items, got %d", len(written))
+	}
+
+	for i, value := range written {
+		if value != int64(i) {
+			t.Fatalf("expected item %d at position %d, got %v", i, i, written)
+		}
+	}
+}
+
+func TestPartitionedProcessing(t *testing.T) {
+
+	writer := &recordingWriter{}
+	reader := makeReader(40)
+	stream, teardown := setupTestBlueprint(t, reader, writer, processingBlueprint(
+		map[string]interface{}{"workers": 3, "mode": "partitioned", "partition-key": "key"},
+		map[string]interface{}{"workers": 2},
+	))
+	defer teardown()
+
+	processStream(t, stream, nil, 0)
+
+	if len(writer.Written()) != 40 {
+		t.Fatalf("expected 40 written items, got %d", len(writer.Written()))
+	}
+
+	for i, payload := range reader.payloads {
+		if acknowledged, rejected := payload.Resolutions(); acknowledged != 1 || rejected != 0 {
+			t.Errorf("expected payload %d to be acknowledged (acknowledged: %d, rejected: %d)", i, acknowledged, rejected)
+		}
+	}
+
+	items := []*kodex.Item{}
+
+	for i := 0; i < 30; i++ {
+		items = append(items, kodex.MakeItem(map[string]interface{}{"key": i % 3}))
+	}
+
+	partitions := map[interface{}]int{}
+
+	for i, payload := range partitionPayload(kodex.MakeBasicPayload(items, map[string]interface{}{}, false), "key", 4) {
+		if payload == nil {
+			continue
+		}
+		for _, item := range payload.Items() {
+			key, _ := item.Get("key")
+			if j, ok := partitions[key]; ok && j != i {
+				t.Fatalf("items with key %v were assigned to different partitions", key)
+			}
+			partitions[key] = i
+		}
+	}
+
+	if len(partitions) != 3 {
+		t.Fatalf("expected three keys, got %d", len(partitions))
+	}
+}
+
+func TestProcessingSettings(t *testing.T) {
+
+	for _, data := range []map[string]interface{}{
+		{"processing": map[string]interface{}{"mode": "partitioned"}},
+		{"processing": map[string]interface{}{"workers": -1}},
+		{"processing": map[string]interface{}{"mode": "random"}},
+	} {
+		if _, err := kodex.MakeProcessingSettings(data); err == nil {
+			t.Errorf("expected an error for %v", data)
+		}
+	}
+
+	if settings, err := kodex.MakeProcessingSettings(nil); err != nil {
+		t.Fatal(err)
+	} else if settings.Workers != 0 || settings.Mode != kodex.UnorderedProcessing {
+		t.Fatalf("expected the default settings")
+	}
+
+	stream, teardown := setupTestStream(t, &payloadsReader{}, &countingWriter{})
+	defer teardown()
+
+	// destinations do not support the partitioned mode
+	blueprint := processingBlueprint(nil, map[string]interface{}{"mode": "partitioned", "partition-key": "key"})
+
+	if _, err := kodex.MakeBlueprint(blueprint).Create(stream.Project().Controller(), true); err == nil {
+		t.Fatalf("expected an error for a partitioned destination")
+	}
+}
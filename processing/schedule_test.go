@@ -0,0 +1,173 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package processing
+
+import (
+	"github.com/kiprotect/kodex"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Returns one item and then the end of the stream on every run
+type batchReader struct {
+	mutex sync.Mutex
+	runs  int
+	read  bool
+}
+
+func (r *batchReader) Read() (kodex.Payload, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.read {
+		return nil, nil
+	}
+	r.read = true
+	return kodex.MakeBasicPayload([]*kodex.Item{kodex.MakeItem(map[string]interface{}{"run": r.runs})}, map[string]interface{}{}, true), nil
+}
+
+func (r *batchReader) Runs() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.runs
+}
+
+func (r *batchReader) Setup(kodex.Stream) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.runs++
+	r.read = false
+	return nil
+}
+
+func (r *batchReader) Purge() error    { return nil }
+func (r *batchReader) Teardown() error { return nil }
+
+// A stateful action that emits an item whenever it is advanced
+type tickAction struct {
+	kodex.BaseAction
+}
+
+func (a *tickAction) Params() interface{}                   { return nil }
+func (a *tickAction) HasParams() bool                       { return false }
+func (a *tickAction) SetParams(params interface{}) error    { return nil }
+func (a *tickAction) GenerateParams(key, salt []byte) error { return nil }
+func (a *tickAction) Reset() error                          { return nil }
+
+func (a *tickAction) Do(item *kodex.Item, channelWriter kodex.ChannelWriter) (*kodex.Item, error) {
+	return item, nil
+}
+
+func (a *tickAction) Advance(channelWriter kodex.ChannelWriter) ([]*kodex.Item, error) {
+	return []*kodex.Item{kodex.MakeItem(map[string]interface{}{"tick": true})}, nil
+}
+
+func (a *tickAction) Finalize(channelWriter kodex.ChannelWriter) ([]*kodex.Item, error) {
+	return nil, nil
+}
+
+func scheduledBlueprint(schedule string) map[string]interface{} {
+	blueprint := processingBlueprint(nil, nil)
+	stream := blueprint["streams"].([]interface{})[0].(map[string]interface{})
+	stream["schedule"] = schedule
+	return blueprint
+}
+
+// Starts a worker for the project of the given stream
+func startWorker(stream kodex.Stream, config WorkerConfig) *Worker {
+
+	config.Interval = 10 * time.Millisecond
+
+	worker := MakeWorker(stream.Project().Controller(), []byte("worker"), config)
+
+	go worker.Run()
+
+	return worker
+}
+
+func TestScheduledSources(t *testing.T) {
+
+	reader := &batchReader{}
+	writer := &countingWriter{}
+	stream, teardown := setupTestBlueprint(t, reader, writer, scheduledBlueprint("50ms"))
+	defer teardown()
+
+	worker := startWorker(stream, DefaultWorkerConfig)
+
+	// the source is read on every run, while the stream keeps running
+	waitFor(t, func() bool { return reader.Runs() >= 3 && writer.Items() >= 3 })
+
+	if n := countTasks(worker, func(task *workerTask) bool { return task.completed }); n != 0 {
+		t.Fatalf("expected no completed tasks, got %d", n)
+	}
+
+	if n := countTasks(worker, func(task *workerTask) bool { return task.running && worker.kind(task.processable) == "stream" }); n != 1 {
+		t.Fatalf("expected the stream to be running")
+	}
+
+	if err := worker.Stop(true); err != nil {
+		t.Fatal(err)
+	}
+
+	if writer.Items() != reader.Runs() {
+		t.Fatalf("expected one item per run (%d runs), got %d", reader.Runs(), writer.Items())
+	}
+
+	// invalid schedules are rejected
+	if _, err := kodex.MakeBlueprint(scheduledBlueprint("every day")).Create(stream.Project().Controller(), true); err == nil {
+		t.Fatalf("expected an error for an invalid schedule")
+	}
+}
+
+func TestAdvanceInterval(t *testing.T) {
+
+	writer := &countingWriter{}
+	defs := kodex.MergeDefinitions(testDefinitions(&payloadsReader{}, writer), kodex.Definitions{
+		ActionDefinitions: kodex.ActionDefinitions{
+			"tick": kodex.ActionDefinition{
+				Name: "Tick",
+				Maker: func(spec kodex.ActionSpecification) (kodex.Action, error) {
+					return &tickAction{BaseAction: kodex.MakeBaseAction(spec, "tick")}, nil
+				},
+			},
+		},
+	})
+
+	blueprint := scheduledBlueprint("@daily")
+	blueprint["actions"] = []interface{}{
+		map[string]interface{}{"name": "tick", "type": "tick", "config": map[string]interface{}{}},
+	}
+	stream := blueprint["streams"].([]interface{})[0].(map[string]interface{})
+	stream["configs"].([]interface{})[0].(map[string]interface{})["actions"] = []interface{}{
+		map[string]interface{}{"name": "tick"},
+	}
+
+	defaultStream, teardown := setupTestDefinitions(t, defs, blueprint)
+	defer teardown()
+
+	config := DefaultWorkerConfig
+	config.AdvanceInterval = 20 * time.Millisecond
+
+	worker := startWorker(defaultStream, config)
+
+	// the actions are advanced even though the source is not read
+	waitFor(t, func() bool { return writer.Items() >= 3 })
+
+	if err := worker.Stop(true); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,389 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package processing
+
+import (
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/definitions"
+	pt "github.com/kiprotect/kodex/helpers/testing"
+	pf "github.com/kiprotect/kodex/helpers/testing/fixtures"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Produces one item per read and never ends
+type endlessReader struct {
+	mutex sync.Mutex
+	read  int
+}
+
+func (r *endlessReader) Read() (kodex.Payload, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.read++
+	return kodex.MakeBasicPayload([]*kodex.Item{kodex.MakeItem(map[string]interface{}{"i": r.read})}, map[string]interface{}{}, false), nil
+}
+
+func (r *endlessReader) Items() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.read
+}
+
+func (r *endlessReader) Setup(kodex.Stream) error { return nil }
+func (r *endlessReader) Purge() error             { return nil }
+func (r *endlessReader) Teardown() error          { return nil }
+
+type countingWriter struct {
+	mutex   sync.Mutex
+	delay   time.Duration
+	written int
+}
+
+func (w *countingWriter) Write(payload kodex.Payload) error {
+	time.Sleep(w.delay)
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.written += len(payload.Items())
+	return nil
+}
+
+func (w *countingWriter) Items() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.written
+}
+
+func (w *countingWriter) Setup(kodex.Config) error { return nil }
+func (w *countingWriter) Teardown() error          { return nil }
+
+// Sets up a stream that reads from the given reader and writes to the given
+// writer, the returned function tears the fixtures down again
+func setupTestStream(t *testing.T, reader kodex.Reader, writer kodex.Writer) (kodex.Stream, func()) {
+	return setupTestBlueprint(t, reader, writer, map[string]interface{}{
+		"sources": []interface{}{
+			map[string]interface{}{"name": "in", "type": "test", "config": map[string]interface{}{}},
+		},
+		"destinations": []interface{}{
+			map[string]interface{}{"name": "out", "type": "test", "config": map[string]interface{}{}},
+		},
+		"streams": []interface{}{
+			map[string]interface{}{
+				"name": "default",
+				"sources": []interface{}{
+					map[string]interface{}{"source": "in"},
+				},
+				"configs": []interface{}{
+					map[string]interface{}{
+						"name": "default",
+						"destinations": []interface{}{
+							map[string]interface{}{"name": "out", "status": "active"},
+						},
+					},
+				},
+			},
+		},
+	})
+}
+
+// Sets up the given blueprint, in which the 'test' source type reads from the
+// given reader and the 'test' destination type writes to the given writer,
+// and returns the 'default' stream
+func setupTestBlueprint(t *testing.T, reader kodex.Reader, writer kodex.Writer, blueprint map[string]interface{}) (kodex.Stream, func()) {
+	return setupTestDefinitions(t, testDefinitions(reader, writer), blueprint)
+}
+
+// Returns the default definitions with a 'test' source and destination type
+func testDefinitions(reader kodex.Reader, writer kodex.Writer) kodex.Definitions {
+	return kodex.MergeDefinitions(definitions.DefaultDefinitions, kodex.Definitions{
+		ReaderDefinitions: kodex.ReaderDefinitions{
+			"test": kodex.ReaderDefinition{
+				Maker: func(map[string]interface{}) (kodex.Reader, error) { return reader, nil },
+				Form:  forms.Form{},
+			},
+		},
+		WriterDefinitions: kodex.WriterDefinitions{
+			"test": kodex.WriterDefinition{
+				Maker: func(map[string]interface{}) (kodex.Writer, error) { return writer, nil },
+				Form:  forms.Form{},
+			},
+		},
+	})
+}
+
+// Sets up the given blueprint with the given definitions and returns the
+// 'default' stream
+func setupTestDefinitions(t *testing.T, defs kodex.Definitions, blueprint map[string]interface{}) (kodex.Stream, func()) {
+
+	var fixtureConfig = []pt.FC{
+		pt.FC{pf.Definitions{Definitions: defs}, "definitions"},
+		pt.FC{pf.Settings{}, "settings"},
+		pt.FC{pf.Controller{}, "controller"},
+		pt.FC{pf.Blueprint{Config: blueprint}, "blueprint"},
+	}
+
+	fixtures, err := pt.SetupFixtures(fixtureConfig)
+	teardown := func() { pt.TeardownFixtures(fixtureConfig, fixtures) }
+
+	if err != nil {
+		teardown()
+		t.Fatal(err)
+	}
+
+	controller := fixtures["controller"].(kodex.Controller)
+	streams, err := controller.Streams(map[string]interface{}{"name": "default"})
+
+	if err != nil {
+		teardown()
+		t.Fatal(err)
+	}
+
+	return streams[0], teardown
+}
+
+func processStream(t *testing.T, stream kodex.Stream, shutdown chan os.Signal, timeout time.Duration) {
+
+	done := make(chan error)
+
+	go func() {
+		done <- ProcessStream(stream, shutdown, timeout)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the stream did not stop")
+	}
+}
+
+func processEndlessStream(t *testing.T, writer *countingWriter, timeout time.Duration) *endlessReader {
+
+	reader := &endlessReader{}
+	stream, teardown := setupTestStream(t, reader, writer)
+	defer teardown()
+
+	shutdown := make(chan os.Signal, 1)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		shutdown <- syscall.SIGTERM
+	}()
+
+	processStream(t, stream, shutdown, timeout)
+
+	return reader
+}
+
+func TestGracefulShutdown(t *testing.T) {
+
+	writer := &countingWriter{}
+	reader := processEndlessStream(t, writer, 0)
+
+	if reader.Items() == 0 {
+		t.Fatalf("expected items to be read")
+	}
+
+	// all items that were read should have been drained to the destination
+	if writer.Items() != reader.Items() {
+		t.Fatalf("expected %d items to be written, got %d", reader.Items(), writer.Items())
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+
+	// the writer is too slow to drain all items before the deadline
+	writer := &countingWriter{delay: 10 * time.Millisecond}
+	reader := processEndlessStream(t, writer, 50*time.Millisecond)
+
+	if writer.Items() >= reader.Items() {
+		t.Fatalf("expected the remaining items to be rejected")
+	}
+}
+
+// Sets up a stream with an action that counts the items per value of 'i' and
+// writes the counts to the given aggregates writer when it is finalized
+func setupAggregateStream(t *testing.T, reader kodex.Reader, writer, aggregates kodex.Writer) (kodex.Stream, func()) {
+
+	defs := testDefinitions(reader, writer)
+	defs.WriterDefinitions["aggregates"] = kodex.WriterDefinition{
+		Maker: func(map[string]interface{}) (kodex.Writer, error) { return aggregates, nil },
+		Form:  forms.Form{},
+	}
+
+	return setupTestDefinitions(t, defs, map[string]interface{}{
+		"sources": []interface{}{
+			map[string]interface{}{"name": "in", "type": "test", "config": map[string]interface{}{}},
+		},
+		"destinations": []interface{}{
+			map[string]interface{}{"name": "out", "type": "test", "config": map[string]interface{}{}},
+			map[string]interface{}{"name": "aggregates", "type": "aggregates", "config": map[string]interface{}{}},
+		},
+		"actions": []interface{}{
+			map[string]interface{}{
+				"name": "count",
+				"type": "anonymize",
+				"config": map[string]interface{}{
+					"method":   "aggregate",
+					"function": "count",
+					"config": map[string]interface{}{
+						"epsilon": 10000,
+					},
+					"group-by": []interface{}{
+						map[string]interface{}{
+							"function":        "value",
+							"always-included": true,
+							"config": map[string]interface{}{
+								"field": "i",
+							},
+						},
+					},
+					"channels":       []interface{}{"counts"},
+					"finalize-after": -1,
+				},
+			},
+		},
+		"streams": []interface{}{
+			map[string]interface{}{
+				"name": "default",
+				"sources": []interface{}{
+					map[string]interface{}{"source": "in"},
+				},
+				"configs": []interface{}{
+					map[string]interface{}{
+						"name": "default",
+						"actions": []interface{}{
+							map[string]interface{}{"name": "count"},
+						},
+						"destinations": []interface{}{
+							map[string]interface{}{"name": "out", "status": "active"},
+							map[string]interface{}{"name": "counts", "destination": "aggregates", "status": "on-demand"},
+						},
+					},
+				},
+			},
+		},
+	})
+}
+
+func TestGracefulShutdownFinalizesAggregates(t *testing.T) {
+
+	reader := &endlessReader{}
+	writer := &countingWriter{}
+	aggregates := &countingWriter{}
+
+	stream, teardown := setupAggregateStream(t, reader, writer, aggregates)
+	defer teardown()
+
+	shutdown := make(chan os.Signal, 1)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		shutdown <- syscall.SIGTERM
+	}()
+
+	processStream(t, stream, shutdown, 0)
+
+	if writer.Items() != reader.Items() {
+		t.Fatalf("expected %d items to be written, got %d", reader.Items(), writer.Items())
+	}
+
+	// every item forms its own group
+	if aggregates.Items() != reader.Items() {
+		t.Fatalf("expected %d aggregates to be written, got %d", reader.Items(), aggregates.Items())
+	}
+}
+
+func TestStoppingStreamExecutorFinalizesAggregates(t *testing.T) {
+
+	writer := &countingWriter{}
+	aggregates := &countingWriter{}
+
+	stream, teardown := setupAggregateStream(t, &endlessReader{}, writer, aggregates)
+	defer teardown()
+
+	channel := kodex.MakeInternalChannel()
+
+	if err := channel.Setup(stream.Project().Controller(), stream); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		item := kodex.MakeItem(map[string]interface{}{"i": i})
+		if err := channel.Write(kodex.MakeBasicPayload([]*kodex.Item{item}, map[string]interface{}{}, false)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	id := kodex.RandomID()
+
+	// the stream is continuous, so only stopping the executor (e.g. when the
+	// worker shuts down) can finalize the actions
+	executor := MakeLocalStreamExecutor(4, id)
+
+	if err := executor.Start(nil, stream); err != nil {
+		t.Fatal(err)
+	}
+
+	configs, err := stream.Configs()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	destinationWriters := make([]Executor, 0)
+
+	for _, config := range configs {
+		configDestinations, err := config.Destinations()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, destinationMaps := range configDestinations {
+			for _, destinationMap := range destinationMaps {
+				destinationWriter := MakeLocalDestinationWriter(1, id)
+				if err := destinationWriter.Start(nil, destinationMap); err != nil {
+					t.Fatal(err)
+				}
+				destinationWriters = append(destinationWriters, destinationWriter)
+			}
+		}
+	}
+
+	for i := 0; writer.Items() < 10; i++ {
+		if i > 5000 {
+			t.Fatalf("the items were not processed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	stopExecutors([][]Executor{[]Executor{executor}, destinationWriters}, true, time.Time{}, nil)
+
+	if aggregates.Items() != 10 {
+		t.Fatalf("expected 10 aggregates to be written, got %d", aggregates.Items())
+	}
+
+	// the stream did not end, so it should be restarted
+	if executor.Completed() {
+		t.Fatalf("expected the stream not to be completed")
+	}
+}
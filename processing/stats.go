@@ -0,0 +1,86 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package processing
+
+import (
+	"github.com/kiprotect/kodex"
+	"sync"
+	"time"
+)
+
+// Collects the processing statistics of an executor between two pings
+type statsCollector struct {
+	mutex     sync.Mutex
+	from      time.Time
+	polls     int64
+	idlePolls int64
+	items     int64
+	errors    int64
+}
+
+// Records a poll of the executor's input, which is idle if no items arrived
+func (s *statsCollector) poll(items int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.polls++
+	if items == 0 {
+		s.idlePolls++
+	}
+	s.items += int64(items)
+}
+
+func (s *statsCollector) error() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.errors++
+}
+
+func payloadItems(payload kodex.Payload) int {
+	if payload == nil {
+		return 0
+	}
+	return len(payload.Items())
+}
+
+// Returns the statistics since the last call and resets them
+func (s *statsCollector) Stats() kodex.ProcessingStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+
+	stats := kodex.ProcessingStats{
+		From:           s.from,
+		To:             now,
+		IdleFraction:   1,
+		ItemsProcessed: s.items,
+		Errors:         s.errors,
+	}
+
+	if s.from.IsZero() {
+		stats.From = now
+	}
+
+	if s.polls > 0 {
+		stats.IdleFraction = float64(s.idlePolls) / float64(s.polls)
+	}
+
+	s.from = now
+	s.polls, s.idlePolls, s.items, s.errors = 0, 0, 0, 0
+
+	return stats
+}
@@ -0,0 +1,466 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package processing
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"sync"
+	"time"
+)
+
+type WorkerConfig struct {
+	// the maximum number of streams, sources and destinations (each) that
+	// the worker processes at the same time
+	Capacity           int
+	SourceWorkers      int
+	StreamWorkers      int
+	DestinationWorkers int
+	// how often the worker looks for new work
+	Interval time.Duration
+	// how often the worker reports stats (and renews its leases)
+	PingInterval time.Duration
+	// executors that stopped unexpectedly are restarted with an exponential
+	// backoff between these durations
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// how long we drain in-flight payloads when stopping (zero means no limit)
+	ShutdownTimeout time.Duration
+	// how often streams advance their stateful actions without new items
+	AdvanceInterval time.Duration
+}
+
+var DefaultWorkerConfig = WorkerConfig{
+	Capacity:           100,
+	SourceWorkers:      1,
+	StreamWorkers:      4,
+	DestinationWorkers: 4,
+	Interval:           time.Second,
+	PingInterval:       kodex.LeaseDuration / 3,
+	MinBackoff:         time.Second,
+	MaxBackoff:         time.Minute,
+	ShutdownTimeout:    time.Second * 30,
+	AdvanceInterval:    DefaultAdvanceInterval,
+}
+
+type workerTask struct {
+	processable kodex.Processable
+	executor    Executor
+	running     bool
+	completed   bool
+	failures    int
+	startedAt   time.Time
+	pingedAt    time.Time
+	retryAt     time.Time
+}
+
+/*
+The worker is a long-running supervisor that pulls streams, sources and
+destinations from the controller by urgency, acquires them and runs them
+in local executors. Executors that stop unexpectedly are restarted with a
+backoff, executors that reach the end of their stream are not.
+*/
+type Worker struct {
+	controller  kodex.Controller
+	id          []byte
+	config      WorkerConfig
+	tasks       map[string]*workerTask
+	mutex       sync.Mutex
+	stopChannel chan bool
+	doneChannel chan bool
+	started     bool
+	stopping    bool
+	graceful    bool
+}
+
+func MakeWorker(controller kodex.Controller, id []byte, config WorkerConfig) *Worker {
+	return &Worker{
+		controller:  controller,
+		id:          id,
+		config:      config,
+		tasks:       make(map[string]*workerTask),
+		stopChannel: make(chan bool),
+		doneChannel: make(chan bool),
+	}
+}
+
+func (w *Worker) ID() []byte {
+	return w.id
+}
+
+func taskKey(processable kodex.Processable) string {
+	return fmt.Sprintf("%s:%s", processable.Type(), hex.EncodeToString(processable.ID()))
+}
+
+// Schedules work until the worker is stopped
+func (w *Worker) Run() error {
+
+	w.mutex.Lock()
+
+	if w.started {
+		w.mutex.Unlock()
+		return fmt.Errorf("already running")
+	}
+
+	w.started = true
+	w.mutex.Unlock()
+
+	defer close(w.doneChannel)
+
+	kodex.Log.Infof("Worker %s started", hex.EncodeToString(w.id))
+
+	ticker := time.NewTicker(w.config.Interval)
+	defer ticker.Stop()
+
+	for {
+
+		w.schedule()
+		w.ping()
+
+		select {
+		case <-w.stopChannel:
+			w.stopExecutors()
+			kodex.Log.Infof("Worker %s stopped", hex.EncodeToString(w.id))
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stops the worker and all its executors and waits until they are stopped
+func (w *Worker) Stop(graceful bool) error {
+
+	w.mutex.Lock()
+
+	if !w.started || w.stopping {
+		w.mutex.Unlock()
+		return nil
+	}
+
+	w.stopping = true
+	w.graceful = graceful
+	w.mutex.Unlock()
+
+	close(w.stopChannel)
+	<-w.doneChannel
+
+	return nil
+}
+
+func (w *Worker) ExecutorStopped(executor Executor, processable kodex.Processable) {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	task, ok := w.tasks[taskKey(processable)]
+
+	if !ok || task.executor != executor {
+		return
+	}
+
+	task.running = false
+
+	if _, err := w.controller.Release(processable, w.id); err != nil {
+		kodex.Log.Error(err)
+	}
+
+	if w.stopping {
+		return
+	}
+
+	if executor.Completed() {
+		if schedule, err := sourceSchedule(processable); err != nil {
+			kodex.Log.Error(err)
+		} else if schedule != nil {
+			// the sources of scheduled streams run again on their schedule
+			task.failures = 0
+			if scheduleNextRun(task, schedule) {
+				kodex.Log.Infof("Completed %s, next run at %s", taskKey(processable), task.retryAt.Format(time.RFC3339))
+			}
+			return
+		}
+		task.completed = true
+		kodex.Log.Infof("Completed %s", taskKey(processable))
+		return
+	}
+
+	// executors that ran for a while start over with the minimum backoff
+	if time.Since(task.startedAt) > w.config.MaxBackoff {
+		task.failures = 0
+	}
+
+	w.failed(task, fmt.Errorf("executor stopped unexpectedly"))
+}
+
+// Marks a task as failed and schedules its restart (needs to hold the lock)
+func (w *Worker) failed(task *workerTask, err error) {
+
+	task.failures++
+
+	backoff := w.config.MinBackoff
+
+	for i := 1; i < task.failures && backoff < w.config.MaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > w.config.MaxBackoff {
+		backoff = w.config.MaxBackoff
+	}
+
+	task.retryAt = time.Now().Add(backoff)
+
+	kodex.Log.Warningf("Cannot process %s (%v), restarting in %v...", taskKey(task.processable), err, backoff)
+}
+
+func (w *Worker) processablesByUrgency(kind string) ([]kodex.Processable, error) {
+
+	processables := make([]kodex.Processable, 0)
+
+	switch kind {
+	case "source":
+		sourceMaps, err := w.controller.SourcesByUrgency(w.config.Capacity)
+		if err != nil {
+			return nil, err
+		}
+		for _, sourceMap := range sourceMaps {
+			processables = append(processables, sourceMap)
+		}
+	case "stream":
+		streams, err := w.controller.StreamsByUrgency(w.config.Capacity)
+		if err != nil {
+			return nil, err
+		}
+		for _, stream := range streams {
+			processables = append(processables, stream)
+		}
+	case "destination":
+		destinationMaps, err := w.controller.DestinationsByUrgency(w.config.Capacity)
+		if err != nil {
+			return nil, err
+		}
+		for _, destinationMap := range destinationMaps {
+			processables = append(processables, destinationMap)
+		}
+	}
+
+	return processables, nil
+}
+
+// Returns the schedule of the stream of a source map (or nil if the
+// processable is not a source map or its stream has no schedule)
+func sourceSchedule(processable kodex.Processable) (kodex.Recurrence, error) {
+	sourceMap, ok := processable.(kodex.SourceMap)
+	if !ok {
+		return nil, nil
+	}
+	return kodex.StreamSchedule(sourceMap.Stream())
+}
+
+// Sets the time of the next run of a scheduled source. Returns false (and
+// completes the task) if the schedule does not fire anymore.
+func scheduleNextRun(task *workerTask, schedule kodex.Recurrence) bool {
+	task.retryAt = schedule.Next(time.Now())
+	if task.retryAt.IsZero() {
+		kodex.Log.Warningf("The schedule of %s does not fire anymore", taskKey(task.processable))
+		task.completed = true
+		return false
+	}
+	return true
+}
+
+func (w *Worker) makeExecutor(kind string, scheduled bool) Executor {
+	switch kind {
+	case "source":
+		executor := MakeLocalSourceReader(w.config.SourceWorkers, w.id)
+		executor.batch = scheduled
+		return executor
+	case "stream":
+		executor := MakeLocalStreamExecutor(w.config.StreamWorkers, w.id)
+		if w.config.AdvanceInterval > 0 {
+			executor.advanceInterval = w.config.AdvanceInterval
+		}
+		return executor
+	default:
+		return MakeLocalDestinationWriter(w.config.DestinationWorkers, w.id)
+	}
+}
+
+func (w *Worker) schedule() {
+
+	// we start consumers before producers so no payloads pile up
+	for _, kind := range []string{"destination", "stream", "source"} {
+
+		processables, err := w.processablesByUrgency(kind)
+
+		if err != nil {
+			kodex.Log.Error(err)
+			continue
+		}
+
+		running := 0
+
+		w.mutex.Lock()
+		for _, task := range w.tasks {
+			if task.running && w.kind(task.processable) == kind {
+				running++
+			}
+		}
+		w.mutex.Unlock()
+
+		for _, processable := range processables {
+			if running >= w.config.Capacity {
+				break
+			}
+			if w.start(kind, processable) {
+				running++
+			}
+		}
+	}
+}
+
+func (w *Worker) kind(processable kodex.Processable) string {
+	switch processable.(type) {
+	case kodex.SourceMap:
+		return "source"
+	case kodex.Stream:
+		return "stream"
+	default:
+		return "destination"
+	}
+}
+
+// Acquires the processable and starts an executor for it
+func (w *Worker) start(kind string, processable kodex.Processable) bool {
+
+	key := taskKey(processable)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.stopping {
+		return false
+	}
+
+	task, ok := w.tasks[key]
+
+	if ok && (task.running || task.completed || time.Now().Before(task.retryAt)) {
+		return false
+	}
+
+	schedule, err := sourceSchedule(processable)
+
+	if err != nil {
+		kodex.Log.Errorf("Invalid schedule for %s: %v", key, err)
+		return false
+	}
+
+	if !ok && schedule != nil {
+		// the sources of scheduled streams wait for their first run
+		task = &workerTask{processable: processable}
+		w.tasks[key] = task
+		if scheduleNextRun(task, schedule) {
+			kodex.Log.Infof("Scheduled %s, first run at %s", key, task.retryAt.Format(time.RFC3339))
+		}
+		return false
+	}
+
+	if acquired, err := w.controller.Acquire(processable, w.id); err != nil {
+		kodex.Log.Error(err)
+		return false
+	} else if !acquired {
+		// another worker is processing this already
+		if schedule != nil {
+			// (so we skip this run of the scheduled source)
+			scheduleNextRun(task, schedule)
+		}
+		return false
+	}
+
+	if !ok {
+		task = &workerTask{processable: processable}
+		w.tasks[key] = task
+	}
+
+	task.executor = w.makeExecutor(kind, schedule != nil)
+	task.startedAt = time.Now()
+	task.pingedAt = task.startedAt
+	task.running = true
+
+	kodex.Log.Debugf("Starting %s", key)
+
+	if err := task.executor.Start(w, processable); err != nil {
+		task.running = false
+		if _, err := w.controller.Release(processable, w.id); err != nil {
+			kodex.Log.Error(err)
+		}
+		w.failed(task, err)
+		return false
+	}
+
+	return true
+}
+
+// Reports the stats of all running executors to the controller, which renews
+// their leases. We stop executors whose lease cannot be renewed, as another
+// worker might acquire them otherwise while they are still running.
+func (w *Worker) ping() {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for key, task := range w.tasks {
+		if !task.running || time.Since(task.pingedAt) < w.config.PingInterval {
+			continue
+		}
+		task.pingedAt = time.Now()
+		if err := w.controller.Ping(task.processable, w.id, task.executor.Stats()); err != nil {
+			kodex.Log.Errorf("Cannot renew the lease of %s (%v), stopping it...", key, err)
+			// stopping calls ExecutorStopped, which needs the lock
+			go func(executor Executor) {
+				if err := executor.Stop(false); err != nil {
+					kodex.Log.Error(err)
+				}
+			}(task.executor)
+		}
+	}
+}
+
+func (w *Worker) stopExecutors() {
+
+	w.mutex.Lock()
+	graceful := w.graceful
+	executors := make(map[string][]Executor)
+	for _, task := range w.tasks {
+		if task.running {
+			kind := w.kind(task.processable)
+			executors[kind] = append(executors[kind], task.executor)
+		}
+	}
+	w.mutex.Unlock()
+
+	// we stop producers before consumers so they can drain their input
+	stages := [][]Executor{executors["source"], executors["stream"], executors["destination"]}
+
+	var deadline time.Time
+
+	if w.config.ShutdownTimeout > 0 {
+		deadline = time.Now().Add(w.config.ShutdownTimeout)
+	}
+
+	stopExecutors(stages, graceful, deadline, nil)
+}
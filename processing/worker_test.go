@@ -0,0 +1,275 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package processing
+
+import (
+	"bufio"
+	"github.com/kiprotect/kodex"
+	pt "github.com/kiprotect/kodex/helpers/testing"
+	pf "github.com/kiprotect/kodex/helpers/testing/fixtures"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func workerBlueprint(inputPath, outputPath string) map[string]interface{} {
+	return map[string]interface{}{
+		"sources": []interface{}{
+			map[string]interface{}{
+				"name": "in",
+				"type": "file",
+				"config": map[string]interface{}{
+					"path":   inputPath,
+					"format": "json",
+				},
+			},
+		},
+		"destinations": []interface{}{
+			map[string]interface{}{
+				"name": "out",
+				"type": "file",
+				"config": map[string]interface{}{
+					"path":      outputPath,
+					"base-name": "items",
+					"format":    "json",
+				},
+			},
+		},
+		"streams": []interface{}{
+			map[string]interface{}{
+				"name": "default",
+				"sources": []interface{}{
+					map[string]interface{}{"source": "in"},
+				},
+				"configs": []interface{}{
+					map[string]interface{}{
+						"name": "default",
+						"destinations": []interface{}{
+							map[string]interface{}{"name": "out", "status": "active"},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	for i := 0; i < 500; i++ {
+		if condition() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timeout")
+}
+
+func countTasks(worker *Worker, condition func(*workerTask) bool) int {
+	worker.mutex.Lock()
+	defer worker.mutex.Unlock()
+	n := 0
+	for _, task := range worker.tasks {
+		if condition(task) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestWorker(t *testing.T) {
+
+	dir := t.TempDir()
+	inputPath := filepath.Join(dir, "in.json")
+
+	if err := os.WriteFile(inputPath, []byte("{\"foo\": \"bar\"}\n{\"foo\": \"baz\"}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var fixtureConfig = []pt.FC{
+		pt.FC{pf.Settings{}, "settings"},
+		pt.FC{pf.Controller{}, "controller"},
+		pt.FC{pf.Blueprint{Config: workerBlueprint(inputPath, dir)}, "blueprint"},
+	}
+
+	fixtures, err := pt.SetupFixtures(fixtureConfig)
+	defer pt.TeardownFixtures(fixtureConfig, fixtures)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	controller := fixtures["controller"].(kodex.Controller)
+
+	config := DefaultWorkerConfig
+	config.Interval = 10 * time.Millisecond
+
+	worker := MakeWorker(controller, []byte("worker"), config)
+
+	go worker.Run()
+
+	// the source, the stream and the destination should run to completion
+	waitFor(t, func() bool {
+		return countTasks(worker, func(task *workerTask) bool { return task.completed }) == 3
+	})
+
+	streams, err := controller.StreamsByUrgency(1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the worker released the stream, so another worker can acquire it
+	if acquired, err := controller.Acquire(streams[0], []byte("other")); err != nil {
+		t.Fatal(err)
+	} else if !acquired {
+		t.Fatalf("expected the stream to be released")
+	}
+
+	if acquired, err := controller.Acquire(streams[0], []byte("worker")); err != nil {
+		t.Fatal(err)
+	} else if acquired {
+		t.Fatalf("the stream should be leased to the other worker")
+	}
+
+	if err := worker.Stop(true); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(filepath.Join(dir, "items.json"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		lines++
+	}
+
+	if lines != 2 {
+		t.Fatalf("expected two items, got %d", lines)
+	}
+}
+
+func TestWorkerBackoff(t *testing.T) {
+
+	dir := t.TempDir()
+
+	var fixtureConfig = []pt.FC{
+		pt.FC{pf.Settings{}, "settings"},
+		pt.FC{pf.Controller{}, "controller"},
+		pt.FC{pf.Blueprint{Config: workerBlueprint(filepath.Join(dir, "missing.json"), dir)}, "blueprint"},
+	}
+
+	fixtures, err := pt.SetupFixtures(fixtureConfig)
+	defer pt.TeardownFixtures(fixtureConfig, fixtures)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	controller := fixtures["controller"].(kodex.Controller)
+
+	config := DefaultWorkerConfig
+	config.Interval = 10 * time.Millisecond
+	config.MinBackoff = 20 * time.Millisecond
+	config.MaxBackoff = 40 * time.Millisecond
+
+	worker := MakeWorker(controller, []byte("worker"), config)
+
+	go worker.Run()
+
+	// the source cannot be read, so the worker keeps restarting it
+	waitFor(t, func() bool {
+		return countTasks(worker, func(task *workerTask) bool { return task.failures >= 3 }) == 1
+	})
+
+	worker.mutex.Lock()
+	for _, task := range worker.tasks {
+		if task.failures > 0 && task.retryAt.Sub(time.Now()) > config.MaxBackoff {
+			t.Errorf("the backoff should not exceed the maximum")
+		}
+	}
+	worker.mutex.Unlock()
+
+	if err := worker.Stop(true); err != nil {
+		t.Fatal(err)
+	}
+
+	// stopping the worker stops the stream and destination executors as well
+	if n := countTasks(worker, func(task *workerTask) bool { return task.running }); n != 0 {
+		t.Fatalf("expected all executors to be stopped, %d are running", n)
+	}
+}
+
+func TestWorkerLostLease(t *testing.T) {
+
+	stream, teardown := setupTestStream(t, &endlessReader{}, &countingWriter{})
+	defer teardown()
+
+	controller := stream.Project().Controller()
+
+	config := DefaultWorkerConfig
+	config.Interval = 10 * time.Millisecond
+	config.PingInterval = 10 * time.Millisecond
+	config.MinBackoff = time.Minute
+
+	worker := MakeWorker(controller, []byte("worker"), config)
+
+	go worker.Run()
+
+	defer worker.Stop(false)
+
+	isStream := func(task *workerTask) bool { return task.processable.Type() == "stream" }
+
+	waitFor(t, func() bool {
+		return countTasks(worker, func(task *workerTask) bool { return isStream(task) && task.running }) == 1
+	})
+
+	// only the holder of a lease can renew it
+	if err := controller.Ping(stream, []byte("other"), kodex.ProcessingStats{}); err != kodex.LeaseLost {
+		t.Fatalf("expected the lease to be lost, got %v", err)
+	}
+
+	// we simulate that the lease expired and another worker acquired it
+	if _, err := controller.Release(stream, []byte("worker")); err != nil {
+		t.Fatal(err)
+	}
+
+	if acquired, err := controller.Acquire(stream, []byte("other")); err != nil {
+		t.Fatal(err)
+	} else if !acquired {
+		t.Fatalf("expected the stream to be acquired")
+	}
+
+	// the worker cannot renew the lease, so it should stop the executor
+	waitFor(t, func() bool {
+		return countTasks(worker, func(task *workerTask) bool { return isStream(task) && task.running }) == 0
+	})
+
+	// the lease still belongs to the other worker
+	if acquired, err := controller.Acquire(stream, []byte("worker")); err != nil {
+		t.Fatal(err)
+	} else if acquired {
+		t.Fatalf("the stream should be leased to the other worker")
+	}
+}
@@ -0,0 +1,127 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kodex
+
+import (
+	"fmt"
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/go-helpers/maps"
+)
+
+type ProcessingMode string
+
+const (
+	// payloads are processed and written in any order (the default)
+	UnorderedProcessing ProcessingMode = "unordered"
+	// payloads are processed in parallel but written in the order in which
+	// they were read
+	OrderedProcessing ProcessingMode = "ordered"
+	// items with the same partition key are always processed by the same
+	// worker (e.g. for stateful actions), each worker has its own actions
+	PartitionedProcessing ProcessingMode = "partitioned"
+)
+
+// How streams and destinations process payloads, set via the 'processing'
+// key of their data, e.g.
+//
+//	data:
+//	  processing:
+//	    workers: 8
+//	    mode: partitioned
+//	    partition-key: tenant
+type ProcessingSettings struct {
+	// the number of workers (0 for the default of the executor)
+	Workers      int
+	Mode         ProcessingMode
+	PartitionKey string
+}
+
+var ProcessingSettingsForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the processing settings form",
+	Fields: []forms.Field{
+		{
+			Name: "workers",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(0)},
+				forms.IsInteger{HasMin: true, Min: 0, HasMax: true, Max: 256},
+			},
+		},
+		{
+			Name: "mode",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: string(UnorderedProcessing)},
+				forms.IsIn{Choices: []interface{}{
+					string(UnorderedProcessing),
+					string(OrderedProcessing),
+					string(PartitionedProcessing)}},
+			},
+		},
+		{
+			Name: "partition-key",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+	},
+}
+
+// Returns the processing settings from the data of a stream or destination
+// (the defaults if there are none)
+func MakeProcessingSettings(data interface{}) (*ProcessingSettings, error) {
+
+	config := map[string]interface{}{}
+
+	if dataMap, ok := maps.ToStringMap(data); ok && dataMap["processing"] != nil {
+		if config, ok = maps.ToStringMap(dataMap["processing"]); !ok {
+			return nil, fmt.Errorf("processing settings should be a map")
+		}
+	}
+
+	params, err := ProcessingSettingsForm.Validate(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	settings := &ProcessingSettings{
+		Workers:      int(params["workers"].(int64)),
+		Mode:         ProcessingMode(params["mode"].(string)),
+		PartitionKey: params["partition-key"].(string),
+	}
+
+	if settings.Mode == PartitionedProcessing && settings.PartitionKey == "" {
+		return nil, fmt.Errorf("the partitioned mode requires a partition key")
+	}
+
+	return settings, nil
+}
+
+// Validates the processing settings in the data of a stream or destination
+// (destinations do not support the partitioned mode)
+type IsProcessingData struct {
+	Partitioned bool
+}
+
+func (i IsProcessingData) Validate(value interface{}, values map[string]interface{}) (interface{}, error) {
+	if settings, err := MakeProcessingSettings(value); err != nil {
+		return nil, err
+	} else if settings.Mode == PartitionedProcessing && !i.Partitioned {
+		return nil, fmt.Errorf("the partitioned mode is not supported here")
+	}
+	return value, nil
+}
@@ -19,17 +19,34 @@ package kodex
 import (
 	"encoding/hex"
 	"github.com/kiprotect/go-helpers/errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 type Processor struct {
 	errorPolicy   ErrorPolicy
+	errorHandling []*ErrorHandling
+	predicates    []Predicate
 	parameterSet  *ParameterSet
 	channelWriter ChannelWriter
+	deadLetters   DeadLetterStore
 	config        Config
 	key, salt     []byte
+	projectKey    []byte
+	guard         *UndoGuard
+	guardLoaded   bool
 	id            string
+	metrics       *processorMetrics
+	interrupted   chan struct{}
+	interruptOnce sync.Once
 }
 
+// Returned when the retries of an action are interrupted because the
+// processor is stopping
+var RetryInterrupted = errors.MakeExternalError("retry interrupted", "RETRY-INTERRUPTED", nil, nil)
+
 func (p *Processor) ParameterSet() *ParameterSet {
 	return p.parameterSet
 }
@@ -38,12 +55,32 @@ func (p *Processor) updateParams(item *Item, undo bool) error {
 
 	updated := false
 
-	for _, action := range p.parameterSet.Actions() {
+	guard, err := p.undoGuard()
+
+	if err != nil {
+		return err
+	}
+
+	// with an undo guard, parameters are sealed (see UndoGuard)
+	sealed := guard != nil && !p.keyed()
+
+	for j, action := range p.parameterSet.Actions() {
 		// we get the parameter group for the specific item
 		parameterGroup, err := action.ParameterGroup(item)
 		if err != nil {
 			return err
 		}
+		if sealed {
+			if !undo {
+				if parameterGroup, err = guard.ParameterGroup(parameterGroup); err != nil {
+					return err
+				}
+			} else if parameters := p.parameterSet.ActionParameters(action); parameters != nil && parameters.Sealed() != nil {
+				// sealed parameters belong to the process that generated
+				// them, so we use the group of the parameters in the set
+				parameterGroup = parameters.ParameterGroup()
+			}
+		}
 		i := 0
 		for {
 			i += 1
@@ -52,7 +89,7 @@ func (p *Processor) updateParams(item *Item, undo bool) error {
 			var err error
 			// if a key is specified, we generate all parameters from it and
 			// do not persist anything to the parameter store
-			if p.key == nil {
+			if !p.keyed() {
 				spec, loaded, err = p.parameterSet.ParametersFor(action, parameterGroup)
 				if err != nil {
 					// this might be a race condition with another processor
@@ -61,19 +98,29 @@ func (p *Processor) updateParams(item *Item, undo bool) error {
 					}
 					continue
 				}
+				p.metrics.action(j).lookup(spec, loaded)
 			}
 			if spec == nil {
-				if undo && p.key == nil {
+				if undo && !p.keyed() {
 					return errors.MakeExternalError("error getting parameters", "GET-ACTION-PARAMS", nil, nil)
 				}
 				// there are no action parameters, we generate some and try
 				// to save them
-				if err = action.GenerateParams(p.key, p.salt); err != nil {
+				key, salt, err := p.actionKey(action)
+				if err != nil {
+					return errors.MakeExternalError("error deriving action key", "GEN-PARAMS", nil, err)
+				}
+				if err = action.GenerateParams(key, salt); err != nil {
 					return errors.MakeExternalError("error generating params", "GEN-PARAMS", nil, err)
 				}
-				if p.key == nil && action.HasParams() {
+				if !p.keyed() && action.HasParams() {
 					// we update the action parameters
-					if err := p.parameterSet.UpdateParameters(action, action.Params(), parameterGroup); err != nil {
+					if sealed {
+						err = p.parameterSet.UpdateSealedParameters(action, action.Params(), parameterGroup, guard)
+					} else {
+						err = p.parameterSet.UpdateParameters(action, action.Params(), parameterGroup)
+					}
+					if err != nil {
 						// this might be a race condition with another processor
 						if i > 2 {
 							return errors.MakeExternalError("error setting params", "GEN-PARAMS", nil, err)
@@ -88,15 +135,24 @@ func (p *Processor) updateParams(item *Item, undo bool) error {
 				if loaded {
 					updated = true
 				}
+				params := spec.Parameters()
+				if spec.Sealed() != nil {
+					if guard == nil {
+						return errors.MakeExternalError("sealed parameters require an undo guard", "SET-PARAMS", nil, nil)
+					}
+					if params, err = guard.OpenParameters(spec); err != nil {
+						return errors.MakeExternalError("cannot open sealed parameters", "SET-PARAMS", nil, err)
+					}
+				}
 				// the action might have changed
-				if err = spec.Action().SetParams(spec.Parameters()); err != nil {
+				if err = spec.Action().SetParams(params); err != nil {
 					return errors.MakeExternalError("error setting params", "SET-PARAMS", nil, err)
 				}
 			}
 			break
 		}
 	}
-	if updated && !undo && p.key == nil && !p.parameterSet.Empty() {
+	if updated && !undo && !p.keyed() && !p.parameterSet.Empty() {
 		// we try to save the new parameter set as well
 		return p.parameterSet.Save()
 	}
@@ -111,10 +167,20 @@ func MakeProcessor(parameterSet *ParameterSet, channelWriter ChannelWriter, conf
 		errorPolicy:   ReportErrors,
 		config:        config,
 		id:            hex.EncodeToString(RandomID()),
+		metrics:       makeProcessorMetrics(parameterSet, config),
+		interrupted:   make(chan struct{}),
 	}
 	return &processor, nil
 }
 
+// Interrupt makes pending and future retries of actions fail right away
+// with RetryInterrupted (e.g. when the executor of the processor stops).
+func (p *Processor) Interrupt() {
+	p.interruptOnce.Do(func() {
+		close(p.interrupted)
+	})
+}
+
 func (p *Processor) SetWriter(channelWriter ChannelWriter) {
 	p.channelWriter = channelWriter
 }
@@ -123,6 +189,12 @@ func (p *Processor) Writer() ChannelWriter {
 	return p.channelWriter
 }
 
+// SetDeadLetterStore makes the processor store items that can't be
+// processed as dead letters (see DeadLetter).
+func (p *Processor) SetDeadLetterStore(store DeadLetterStore) {
+	p.deadLetters = store
+}
+
 func (p *Processor) SetSalt(salt []byte) {
 	p.salt = salt
 }
@@ -131,6 +203,58 @@ func (p *Processor) SetKey(key []byte) {
 	p.key = key
 }
 
+// SetProjectKey makes the processor derive the parameters of each action
+// from the given project key (see DeriveActionKey).
+func (p *Processor) SetProjectKey(projectKey []byte) {
+	p.projectKey = projectKey
+}
+
+// SetUndoGuard makes the processor seal the parameters that it generates
+// (see UndoGuard). By default, the undo guard of the controller is used.
+func (p *Processor) SetUndoGuard(guard *UndoGuard) {
+	p.guard, p.guardLoaded = guard, true
+}
+
+// keyed returns true if parameters are generated from a key instead of
+// being loaded from and persisted to the parameter store
+func (p *Processor) keyed() bool {
+	return p.key != nil || p.projectKey != nil
+}
+
+func (p *Processor) actionKey(action Action) ([]byte, []byte, error) {
+	if p.projectKey != nil {
+		return DeriveActionKey(p.projectKey, action)
+	}
+	return p.key, p.salt, nil
+}
+
+// SetErrorHandling sets the error handling of the processor's actions (in
+// the order of the actions, nil if an action has none).
+func (p *Processor) SetErrorHandling(errorHandling []*ErrorHandling) {
+	p.errorHandling = errorHandling
+}
+
+func (p *Processor) actionErrorHandling(i int) *ErrorHandling {
+	if i < len(p.errorHandling) {
+		return p.errorHandling[i]
+	}
+	return nil
+}
+
+// SetPredicates sets the predicates that decide which items the processor's
+// actions process (in the order of the actions, nil if an action processes
+// all items).
+func (p *Processor) SetPredicates(predicates []Predicate) {
+	p.predicates = predicates
+}
+
+func (p *Processor) actionPredicate(i int) Predicate {
+	if i < len(p.predicates) {
+		return p.predicates[i]
+	}
+	return nil
+}
+
 func (p *Processor) SetErrorPolicy(policy ErrorPolicy) {
 	p.errorPolicy = policy
 }
@@ -181,6 +305,7 @@ func (p *Processor) Reset() error {
 	for _, action := range p.parameterSet.Actions() {
 		if statefulAction, ok := action.(StatefulAction); ok {
 			if err := statefulAction.Reset(); err != nil {
+				p.metrics.error(action.Name(), err)
 				switch p.errorPolicy {
 				case ReportErrors:
 					actionError := errors.MakeExternalError("error resetting action", "RESET-ACTION", nil, err)
@@ -204,6 +329,7 @@ func (p *Processor) Finalize() ([]*Item, error) {
 	for _, action := range p.parameterSet.Actions() {
 		if statefulAction, ok := action.(StatefulAction); ok {
 			if newItems, err := statefulAction.Finalize(p.channelWriter); err != nil {
+				p.metrics.error(action.Name(), err)
 				switch p.errorPolicy {
 				case ReportErrors:
 					actionError := errors.MakeExternalError("error finalization action", "FINALIZE-ACTION", nil, err)
@@ -225,54 +351,247 @@ func (p *Processor) Finalize() ([]*Item, error) {
 	return finalizedItems, nil
 }
 
-func (p *Processor) processItem(item *Item, paramsMap map[string]interface{}, undo bool) (*Item, error) {
+// Processes a single item and returns the new item or the error and the name
+// of the action that failed (if any)
+func (p *Processor) processItem(item *Item, paramsMap map[string]interface{}, undo bool) (*Item, string, error) {
 	var err error
 	if err = p.updateParams(item, undo); err != nil {
-		return nil, errors.MakeExternalError("error setting action params", "SET-ACTION-PARAMS", nil, err)
+		return nil, "", errors.MakeExternalError("error setting action params", "SET-ACTION-PARAMS", nil, err)
+	}
+	var kip string
+	if !undo && !p.keyed() && !p.parameterSet.Empty() {
+		kip = hex.EncodeToString(p.parameterSet.Hash())
+		if paramsMap != nil {
+			if _, ok := paramsMap[kip]; !ok {
+				paramsMap[kip] = p.parameterSet
+			}
+		}
+	}
+	actions := p.parameterSet.Actions()
+	// items that actions write to other channels (e.g. routed items) need
+	// the same '_kip' value as the items we return, so they can be undone
+	writer := &kipChannelWriter{ChannelWriter: p.channelWriter, kip: kip, undo: undo, actions: len(actions)}
+	skipped := map[int]bool{}
+	if undo {
+		// we only undo the actions that were applied to the item
+		if skipped, err = skippedActions(item); err != nil {
+			return nil, "", err
+		}
 	}
 	newItem := item
-	for _, action := range p.parameterSet.Actions() {
-		err = nil
+	for i, action := range actions {
+		writer.current = i
 		if undo {
-			if undoableAction, ok := action.(UndoableAction); ok {
-				// not all actions that have an Undo function are always
-				// undoable (e.g. some pseudonymization methods are one-way)
-				if undoableAction.Undoable(newItem) {
-					newItem, err = undoableAction.Undo(newItem, p.channelWriter)
-				}
-			}
-		} else {
-			if configurableAction, ok := action.(ConfigurableAction); p.config != nil && ok {
-				newItem, err = configurableAction.DoWithConfig(newItem, p.channelWriter, p.config)
-			} else if doableAction, ok := action.(DoableAction); ok {
-				newItem, err = doableAction.Do(newItem, p.channelWriter)
+			if skipped[i] {
+				continue
 			}
+		} else if predicate := p.actionPredicate(i); predicate != nil && !predicate.Matches(newItem) {
+			writer.skipped = append(writer.skipped, i)
+			continue
 		}
-		if err != nil {
+		handling := p.actionErrorHandling(i)
+		previousItem := newItem
+		if newItem, err = p.doAction(action, handling, writer, newItem, undo); err != nil {
+			if err == RetryInterrupted {
+				// the item was not processed, so we don't apply the policy
+				return nil, action.Name(), err
+			}
 			itemError := errors.MakeExternalError("error processing action", "PROCESS-ACTION", action.Name(), err)
-			return nil, itemError
+			if handling == nil || handling.Policy == "" {
+				return nil, action.Name(), itemError
+			}
+			p.metrics.error(action.Name(), itemError)
+			if newItem, err = p.handleActionError(handling, action, writer, previousItem, itemError); err != nil {
+				return nil, action.Name(), err
+			}
+			// the action was not applied to the item
+			writer.skipped = append(writer.skipped, i)
+		} else {
+			p.metrics.action(i).processed()
 		}
 		if newItem == nil {
 			break
 		}
 	}
-	if !undo && p.key == nil && !p.parameterSet.Empty() {
-		hashStr := hex.EncodeToString(p.parameterSet.Hash())
-		if paramsMap != nil {
-			if _, ok := paramsMap[hashStr]; !ok {
-				paramsMap[hashStr] = p.parameterSet
-			}
+	if newItem != nil {
+		writer.stamp(newItem, writer.skipped)
+	}
+	return newItem, "", nil
+}
+
+// Returns the indexes of the actions that were not applied to an item, as
+// recorded in its '_kip_skip' value
+func skippedActions(item *Item) (map[int]bool, error) {
+	skipped := map[int]bool{}
+	value, ok := item.Get("_kip_skip")
+	if !ok {
+		return skipped, nil
+	}
+	strValue, ok := value.(string)
+	if !ok {
+		return nil, errors.MakeExternalError("invalid '_kip_skip' value", "UNDO-ACTIONS", nil, nil)
+	}
+	for _, index := range strings.Split(strValue, ",") {
+		if i, err := strconv.Atoi(index); err != nil {
+			return nil, errors.MakeExternalError("invalid '_kip_skip' value", "UNDO-ACTIONS", nil, err)
+		} else {
+			skipped[i] = true
 		}
-		if newItem != nil {
-			newItem.Set("_kip", hashStr)
+	}
+	return skipped, nil
+}
+
+// Sets the '_kip' value of the items that actions write to other channels
+// (or removes it when undoing), as processItem does for the items it
+// returns. As the written items did not pass through the current and the
+// following actions, these are recorded as skipped.
+type kipChannelWriter struct {
+	ChannelWriter
+	kip     string
+	undo    bool
+	actions int
+	current int
+	skipped []int
+}
+
+// Sets the '_kip' value of an item and records the actions that were not
+// applied to it (if any) in its '_kip_skip' value
+func (k *kipChannelWriter) stamp(item *Item, skipped []int) {
+	if k.undo {
+		item.Delete("_kip")
+		item.Delete("_kip_skip")
+		return
+	}
+	if k.kip != "" {
+		item.Set("_kip", k.kip)
+	}
+	if len(skipped) > 0 {
+		indexes := make([]string, len(skipped))
+		for i, index := range skipped {
+			indexes[i] = strconv.Itoa(index)
 		}
+		item.Set("_kip_skip", strings.Join(indexes, ","))
 	}
+}
+
+func (k *kipChannelWriter) Write(channel string, items []*Item) error {
+	skipped := append([]int{}, k.skipped...)
+	for i := k.current; i < k.actions; i++ {
+		skipped = append(skipped, i)
+	}
+	for _, item := range items {
+		k.stamp(item, skipped)
+	}
+	return k.ChannelWriter.Write(channel, items)
+}
+
+func (p *Processor) applyAction(action Action, writer ChannelWriter, item *Item, undo bool) (*Item, error) {
 	if undo {
-		newItem.Delete("_kip")
+		if undoableAction, ok := action.(UndoableAction); ok {
+			// not all actions that have an Undo function are always
+			// undoable (e.g. some pseudonymization methods are one-way)
+			if undoableAction.Undoable(item) {
+				return undoableAction.Undo(item, writer)
+			}
+		}
+	} else {
+		if configurableAction, ok := action.(ConfigurableAction); p.config != nil && ok {
+			return configurableAction.DoWithConfig(item, writer, p.config)
+		} else if doableAction, ok := action.(DoableAction); ok {
+			return doableAction.Do(item, writer)
+		}
+	}
+	return item, nil
+}
+
+// Applies an action to an item, retrying it if the error handling of the
+// action allows for it. If an action has an error handling, it works on a
+// copy of the item, as actions might modify items in place.
+func (p *Processor) doAction(action Action, handling *ErrorHandling, writer ChannelWriter, item *Item, undo bool) (*Item, error) {
+
+	if handling == nil {
+		return p.applyAction(action, writer, item, undo)
+	}
+
+	delay := handling.RetryDelay
+	var waited time.Duration
+
+	for attempt := 0; ; attempt++ {
+		newItem, err := p.applyAction(action, writer, item.Copy(), undo)
+		if err == nil || attempt >= handling.Retries {
+			return newItem, err
+		}
+		if delay > 0 && waited >= handling.MaxDelay {
+			// we have waited long enough for this action
+			return newItem, err
+		}
+		if waited+delay > handling.MaxDelay {
+			delay = handling.MaxDelay - waited
+		}
+		Log.Warningf("Action '%s' failed (attempt %d of %d), retrying in %v: %v", action.Name(), attempt+1, handling.Retries+1, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-p.interrupted:
+			return nil, RetryInterrupted
+		}
+		waited += delay
+		delay *= 2
 	}
-	return newItem, nil
 }
+
+// Applies the error handling of an action to the item the action failed on
+// (as it was before the action). Returns the item to continue with (nil if
+// the item should not be processed further).
+func (p *Processor) handleActionError(handling *ErrorHandling, action Action, writer ChannelWriter, item *Item, err error) (*Item, error) {
+	switch handling.Policy {
+	case AbortProcessing:
+		return nil, errors.MakeExternalError("action failed, aborting", "ABORT-ACTION", action.Name(), err)
+	case RouteItem:
+		if writeErr := writer.Write(handling.Channel, []*Item{item}); writeErr != nil {
+			return nil, errors.MakeExternalError("cannot route item", "ROUTE-ITEM", handling.Channel, writeErr)
+		}
+		return nil, nil
+	}
+
+	// the item continues (or is dropped), we only report a warning
+	if warnErr := p.channelWriter.Warning(item, err); warnErr != nil {
+		return nil, warnErr
+	}
+
+	switch handling.Policy {
+	case DropItem:
+		return nil, nil
+	case NullField:
+		item.Set(handling.Field, nil)
+	case DefaultField:
+		item.Set(handling.Field, handling.Value)
+	}
+
+	return item, nil
+}
+
+// Returns the undo guard of the controller (if any)
+func (p *Processor) undoGuard() (*UndoGuard, error) {
+	if p.config == nil || p.guardLoaded {
+		return p.guard, nil
+	}
+	guard, err := GetUndoGuard(p.config.Stream().Project().Controller())
+	if err != nil {
+		return nil, err
+	}
+	p.guard, p.guardLoaded = guard, true
+	return guard, nil
+}
+
 func (p *Processor) Undo(items []*Item, paramsMap map[string]interface{}) ([]*Item, error) {
+	// if an undo guard is configured, it needs to be unlocked
+	if guard, err := p.undoGuard(); err != nil {
+		return nil, err
+	} else if guard != nil {
+		if err := guard.Unlocked(); err != nil {
+			return nil, errors.MakeExternalError("undo is not permitted", "UNDO-LOCKED", nil, err)
+		}
+	}
 	return p.process(items, paramsMap, true)
 }
 
@@ -285,6 +604,7 @@ func (p *Processor) Advance() ([]*Item, error) {
 	for _, action := range p.parameterSet.Actions() {
 		if statefulAction, ok := action.(StatefulAction); ok {
 			if advanceItems, err := statefulAction.Advance(p.channelWriter); err != nil {
+				p.metrics.error(action.Name(), err)
 				switch p.errorPolicy {
 				case ReportErrors:
 					advanceErr := errors.MakeExternalError("error advancing action", "ADVANCE-ACTION", action.Name(), err)
@@ -306,6 +626,7 @@ func (p *Processor) Advance() ([]*Item, error) {
 
 func (p *Processor) process(items []*Item, paramsMap map[string]interface{}, undo bool) ([]*Item, error) {
 	Log.Debugf("Processing %d items with error policy '%s'", len(items), p.errorPolicy)
+	defer p.metrics.processed(len(items), time.Now())
 	newItems := make([]*Item, 0)
 	// we first perform the Advance() method (for stateful actions)
 	if advanceItems, err := p.Advance(); err != nil {
@@ -318,11 +639,31 @@ func (p *Processor) process(items []*Item, paramsMap map[string]interface{}, und
 		newItems = append(newItems, advanceItems...)
 	}
 	for _, item := range items {
-		newItem, err := p.processItem(item, paramsMap, undo)
+		var original *Item
+		if p.deadLetters != nil && !undo {
+			// actions modify items in place, so we keep the original
+			original = item.Copy()
+		}
+		newItem, action, err := p.processItem(item, paramsMap, undo)
+		if err == RetryInterrupted {
+			// the items will be processed again (see Interrupt)
+			return newItems, err
+		}
 		if err != nil {
-			switch p.errorPolicy {
+			p.metrics.error(action, err)
+			policy := p.errorPolicy
+			if aborted(err) {
+				// the error handling of the action overrides the error policy
+				policy = AbortOnError
+			}
+			switch policy {
 			case ReportErrors:
 				itemError := errors.MakeExternalError("error processing item", "PROCESS-ITEM", nil, err)
+				if original != nil && p.config != nil {
+					if err := p.deadLetters.Add(MakeDeadLetter(p.config, action, original, err)); err != nil {
+						Log.Errorf("Cannot store dead letter: %v", err)
+					}
+				}
 				// if we encounter an error during error reporting (i.e.
 				// too many errors received) we abort the processing.
 				Log.Error(itemError)
@@ -0,0 +1,115 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kodex
+
+import (
+	"github.com/kiprotect/kodex/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// The metrics of a processor, which we look up once so that we do not need
+// to do this for every item
+type processorMetrics struct {
+	stream   string
+	config   string
+	items    prometheus.Counter
+	duration prometheus.Observer
+	actions  []*actionMetrics
+}
+
+type actionMetrics struct {
+	name    string
+	items   prometheus.Counter
+	cached  prometheus.Counter
+	loaded  prometheus.Counter
+	missing prometheus.Counter
+}
+
+func makeProcessorMetrics(parameterSet *ParameterSet, config Config) *processorMetrics {
+
+	var streamName, configName string
+
+	// processors without a config (e.g. for the transform API) are reported
+	// without a stream and config name
+	if config != nil {
+		configName = config.Name()
+		if stream := config.Stream(); stream != nil {
+			streamName = stream.Name()
+		}
+	}
+
+	m := &processorMetrics{
+		stream:   streamName,
+		config:   configName,
+		items:    metrics.ConfigItems.WithLabelValues(streamName, configName),
+		duration: metrics.ProcessingDuration.WithLabelValues(streamName, configName),
+		actions:  make([]*actionMetrics, 0),
+	}
+
+	for _, action := range parameterSet.Actions() {
+		lookups := metrics.ParameterLookups.MustCurryWith(prometheus.Labels{
+			"stream": streamName,
+			"config": configName,
+			"action": action.Name(),
+		})
+		m.actions = append(m.actions, &actionMetrics{
+			name:    action.Name(),
+			items:   metrics.ActionItems.WithLabelValues(streamName, configName, action.Name()),
+			cached:  lookups.WithLabelValues("cached"),
+			loaded:  lookups.WithLabelValues("loaded"),
+			missing: lookups.WithLabelValues("missing"),
+		})
+	}
+
+	return m
+}
+
+func (m *processorMetrics) action(i int) *actionMetrics {
+	if i < len(m.actions) {
+		return m.actions[i]
+	}
+	return nil
+}
+
+func (m *processorMetrics) processed(items int, start time.Time) {
+	m.items.Add(float64(items))
+	m.duration.Observe(time.Since(start).Seconds())
+}
+
+func (m *processorMetrics) error(action string, err error) {
+	metrics.Errors.WithLabelValues(m.stream, m.config, action, metrics.ErrorCode(err)).Inc()
+}
+
+func (a *actionMetrics) lookup(parameters *Parameters, loaded bool) {
+	if a == nil {
+		return
+	}
+	if parameters == nil {
+		a.missing.Inc()
+	} else if loaded {
+		a.loaded.Inc()
+	} else {
+		a.cached.Inc()
+	}
+}
+
+func (a *actionMetrics) processed() {
+	if a != nil {
+		a.items.Inc()
+	}
+}
@@ -0,0 +1,282 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kodex
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/go-helpers/maps"
+	"strings"
+	"time"
+)
+
+const Redacted = "[redacted]"
+
+// config keys that might contain secrets and which we therefore redact, in
+// addition to the fields that the form of an action marks as secret
+var secretConfigKeys = []string{"secret", "password", "passphrase", "salt", "token", "private"}
+
+// Explains which actions and parameters were used to process an item (as
+// identified by the '_kip' value of the item). Parameter values are always
+// redacted, as are config values that are marked as secret by the form of
+// the action or that look like secrets.
+type ParameterSetExplanation struct {
+	Hash       string                   `json:"hash"`
+	CreatedAt  *time.Time               `json:"created_at,omitempty"`
+	Parameters []*ParametersExplanation `json:"parameters"`
+}
+
+type ParametersExplanation struct {
+	ID             string                 `json:"id"`
+	Action         ActionExplanation      `json:"action"`
+	ParameterGroup map[string]interface{} `json:"parameter_group"`
+	Parameters     interface{}            `json:"parameters"`
+	CreatedAt      *time.Time             `json:"created_at,omitempty"`
+}
+
+type ActionExplanation struct {
+	ID          string                 `json:"id"`
+	Type        string                 `json:"type"`
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Config      map[string]interface{} `json:"config"`
+	ConfigHash  string                 `json:"config_hash"`
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// Replaces all values in the given structure with a placeholder, keeping
+// only the structure itself (i.e. map keys and list lengths) intact.
+func RedactValues(value interface{}) interface{} {
+	if mapValue, ok := maps.ToStringMap(value); ok {
+		redactedMap := make(map[string]interface{}, len(mapValue))
+		for k, v := range mapValue {
+			redactedMap[k] = RedactValues(v)
+		}
+		return redactedMap
+	}
+	if listValue, ok := value.([]interface{}); ok {
+		redactedList := make([]interface{}, len(listValue))
+		for i, v := range listValue {
+			redactedList[i] = RedactValues(v)
+		}
+		return redactedList
+	}
+	if value == nil {
+		return nil
+	}
+	return Redacted
+}
+
+func isSecretConfigKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, secretKey := range secretConfigKeys {
+		if strings.Contains(key, secretKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redacts all values of the given config whose keys look like they
+// contain secrets (e.g. 'password' or 'secret').
+func RedactConfig(value interface{}) interface{} {
+	if mapValue, ok := maps.ToStringMap(value); ok {
+		redactedMap := make(map[string]interface{}, len(mapValue))
+		for k, v := range mapValue {
+			if isSecretConfigKey(k) {
+				redactedMap[k] = RedactValues(v)
+			} else {
+				redactedMap[k] = RedactConfig(v)
+			}
+		}
+		return redactedMap
+	}
+	if listValue, ok := value.([]interface{}); ok {
+		redactedList := make([]interface{}, len(listValue))
+		for i, v := range listValue {
+			redactedList[i] = RedactConfig(v)
+		}
+		return redactedList
+	}
+	return value
+}
+
+// Marks a form field that contains a secret (e.g. a key), so that its value
+// is redacted when explaining parameters. Does not change the value.
+type IsSecret struct{}
+
+func (f IsSecret) Validate(input interface{}, values map[string]interface{}) (interface{}, error) {
+	return input, nil
+}
+
+// Redacts all values of the given config that are marked as secret by the
+// given form (including nested forms), as well as all values whose keys look
+// like they contain secrets.
+func RedactConfigWithForm(value interface{}, form *forms.Form) interface{} {
+	mapValue, ok := maps.ToStringMap(value)
+
+	if !ok || form == nil {
+		return RedactConfig(value)
+	}
+
+	redactedMap := make(map[string]interface{}, len(mapValue))
+
+	for k, v := range mapValue {
+		var validators []forms.Validator
+		for _, field := range form.Fields {
+			if field.Name == k {
+				validators = field.Validators
+				break
+			}
+		}
+		redactedMap[k] = redactField(k, v, validators, mapValue)
+	}
+
+	return redactedMap
+}
+
+func redactField(key string, value interface{}, validators []forms.Validator, values map[string]interface{}) interface{} {
+	for _, validator := range validators {
+		switch v := validator.(type) {
+		case IsSecret, *IsSecret:
+			return RedactValues(value)
+		case forms.IsStringMap:
+			if v.Form != nil {
+				return RedactConfigWithForm(value, v.Form)
+			}
+		case *forms.IsStringMap:
+			if v.Form != nil {
+				return RedactConfigWithForm(value, v.Form)
+			}
+		case forms.Switch:
+			if caseValidators := switchCase(v, values); caseValidators != nil {
+				return redactField(key, value, caseValidators, values)
+			}
+		case *forms.Switch:
+			if caseValidators := switchCase(*v, values); caseValidators != nil {
+				return redactField(key, value, caseValidators, values)
+			}
+		case forms.IsList:
+			if listValue, ok := value.([]interface{}); ok {
+				redactedList := make([]interface{}, len(listValue))
+				for i, lv := range listValue {
+					redactedList[i] = redactField(key, lv, v.Validators, values)
+				}
+				return redactedList
+			}
+		}
+	}
+	if isSecretConfigKey(key) {
+		return RedactValues(value)
+	}
+	return RedactConfig(value)
+}
+
+func switchCase(validator forms.Switch, values map[string]interface{}) []forms.Validator {
+	strValue, ok := values[validator.Key].(string)
+
+	if !ok {
+		return nil
+	}
+
+	if caseValidators, ok := validator.Cases[strValue]; ok {
+		return caseValidators
+	}
+
+	return validator.Cases["default!"]
+}
+
+func ExplainParameters(parameters *Parameters, definitions *Definitions) (*ParametersExplanation, error) {
+
+	action := parameters.Action()
+	configHash, err := action.ConfigHash()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var form *forms.Form
+
+	if definitions != nil {
+		if definition, ok := definitions.ActionDefinitions[action.Type()]; ok {
+			form = definition.Form
+		}
+	}
+
+	config, _ := RedactConfigWithForm(action.Config(), form).(map[string]interface{})
+
+	explanation := &ParametersExplanation{
+		ID: hex.EncodeToString(parameters.ID()),
+		Action: ActionExplanation{
+			ID:          hex.EncodeToString(action.ID()),
+			Type:        action.Type(),
+			Name:        action.Name(),
+			Description: action.Description(),
+			Config:      config,
+			ConfigHash:  hex.EncodeToString(configHash),
+		},
+		Parameters: RedactValues(parameters.Parameters()),
+		CreatedAt:  timeOrNil(parameters.CreatedAt()),
+	}
+
+	if parameterGroup := parameters.ParameterGroup(); parameterGroup != nil {
+		explanation.ParameterGroup = map[string]interface{}{
+			"hash": hex.EncodeToString(parameterGroup.Hash()),
+			"data": parameterGroup.Data(),
+		}
+	}
+
+	return explanation, nil
+}
+
+// Returns an explanation of the parameter set with the given hash (i.e. the
+// '_kip' value of a processed item).
+func ExplainParameterSet(parameterStore ParameterStore, hash []byte) (*ParameterSetExplanation, error) {
+
+	parameterSet, err := parameterStore.ParameterSet(hash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if parameterSet == nil {
+		return nil, NotFound
+	}
+
+	explanation := &ParameterSetExplanation{
+		Hash:       hex.EncodeToString(parameterSet.Hash()),
+		CreatedAt:  timeOrNil(parameterSet.CreatedAt()),
+		Parameters: make([]*ParametersExplanation, 0, len(parameterSet.Parameters())),
+	}
+
+	for _, parameters := range parameterSet.Parameters() {
+		if parametersExplanation, err := ExplainParameters(parameters, parameterStore.Definitions()); err != nil {
+			return nil, fmt.Errorf("cannot explain parameters %s: %v", hex.EncodeToString(parameters.ID()), err)
+		} else {
+			explanation.Parameters = append(explanation.Parameters, parametersExplanation)
+		}
+	}
+
+	return explanation, nil
+}
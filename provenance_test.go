@@ -0,0 +1,235 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kodex_test
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/actions"
+	"github.com/kiprotect/kodex/parameters"
+	"strings"
+	"testing"
+)
+
+func TestExplainParameterSet(t *testing.T) {
+
+	definitions := &kodex.Definitions{ActionDefinitions: actions.Actions}
+
+	store, err := parameters.MakeInMemoryParameterStore(map[string]interface{}{}, definitions)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	action := makePseudonymizeAction(t, []byte("action"), "name")
+
+	parameterSet, err := kodex.MakeParameterSet([]kodex.Action{action}, store)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := kodex.ExplainParameterSet(store, []byte("unknown")); err != kodex.NotFound {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+
+	parameterGroup, err := action.ParameterGroup(kodex.MakeItem(map[string]interface{}{}))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := action.GenerateParams(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := parameterSet.UpdateParameters(action, action.Params(), parameterGroup); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := parameterSet.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	explanation, err := kodex.ExplainParameterSet(store, parameterSet.Hash())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if explanation.Hash != hex.EncodeToString(parameterSet.Hash()) {
+		t.Fatalf("unexpected hash")
+	}
+
+	if explanation.CreatedAt == nil {
+		t.Fatalf("expected a creation time")
+	}
+
+	if len(explanation.Parameters) != 1 {
+		t.Fatalf("expected one set of parameters")
+	}
+
+	actionExplanation := explanation.Parameters[0].Action
+	configHash, _ := action.ConfigHash()
+
+	if actionExplanation.Type != "pseudonymize" || actionExplanation.Name != "test" || actionExplanation.ConfigHash != hex.EncodeToString(configHash) {
+		t.Fatalf("unexpected action explanation: %v", actionExplanation)
+	}
+
+	if explanation.Parameters[0].ParameterGroup["hash"] != hex.EncodeToString(parameterGroup.Hash()) {
+		t.Fatalf("unexpected parameter group")
+	}
+
+	// no actual parameter values should show up in the explanation
+	data, err := json.Marshal(explanation)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params, err := json.Marshal(action.Params())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paramsMap map[string]interface{}
+
+	if err := json.Unmarshal(params, &paramsMap); err != nil {
+		t.Fatal(err)
+	}
+
+	for k, v := range paramsMap {
+		if str, ok := v.(string); ok && strings.Contains(string(data), str) {
+			t.Fatalf("parameter '%s' is not redacted", k)
+		}
+	}
+
+}
+
+func TestRedactConfig(t *testing.T) {
+
+	config := kodex.RedactConfig(map[string]interface{}{
+		"key": "name",
+		"auth": map[string]interface{}{
+			"Password": "foo",
+			"secrets":  []interface{}{"bar", "baz"},
+		},
+	}).(map[string]interface{})
+
+	if config["key"] != "name" {
+		t.Fatalf("non-secret values should not be redacted")
+	}
+
+	auth := config["auth"].(map[string]interface{})
+
+	if auth["Password"] != kodex.Redacted {
+		t.Fatalf("passwords should be redacted")
+	}
+
+	if secrets := auth["secrets"].([]interface{}); len(secrets) != 2 || secrets[0] != kodex.Redacted {
+		t.Fatalf("secrets should be redacted")
+	}
+
+}
+
+func TestExplainStructuredPseudonymizer(t *testing.T) {
+
+	definitions := &kodex.Definitions{ActionDefinitions: actions.Actions}
+
+	store, err := parameters.MakeInMemoryParameterStore(map[string]interface{}{}, definitions)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	action, err := kodex.MakeAction("test", "", "pseudonymize", []byte("action"), map[string]interface{}{
+		"key":    "ip",
+		"method": "structured",
+		"config": map[string]interface{}{
+			"type": "ip",
+			"key":  "my-structured-key",
+		},
+	}, definitions)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parameterSet, err := kodex.MakeParameterSet([]kodex.Action{action}, store)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parameterGroup, err := action.ParameterGroup(kodex.MakeItem(map[string]interface{}{}))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := action.GenerateParams(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := parameterSet.UpdateParameters(action, action.Params(), parameterGroup); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := parameterSet.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	explanation, err := kodex.ExplainParameterSet(store, parameterSet.Hash())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := explanation.Parameters[0].Action.Config
+
+	// the item key is not a secret
+	if config["key"] != "ip" {
+		t.Fatalf("the item key should not be redacted: %v", config["key"])
+	}
+
+	structuredConfig, ok := config["config"].(map[string]interface{})
+
+	if !ok {
+		t.Fatalf("expected a structured config")
+	}
+
+	if structuredConfig["key"] != kodex.Redacted {
+		t.Fatalf("the pseudonymization key should be redacted: %v", structuredConfig["key"])
+	}
+
+	if structuredConfig["type"] != "ip" {
+		t.Fatalf("the type should not be redacted")
+	}
+
+	data, err := json.Marshal(explanation)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(data), "my-structured-key") {
+		t.Fatalf("the pseudonymization key shows up in the explanation")
+	}
+
+}
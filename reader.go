@@ -49,6 +49,12 @@ type PeekingReader interface {
 	Reader
 }
 
+// A reader that keeps state (e.g. a cursor) in the source it reads from.
+type StatefulReader interface {
+	Reader
+	SetupWithSource(Source) error
+}
+
 // A reader that is able to write objects for a specific model such as a stream.
 type ModelReader interface {
 	Reader
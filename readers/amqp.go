@@ -27,13 +27,23 @@ import (
 	"github.com/kiprotect/kodex/writers"
 	"github.com/streadway/amqp"
 	"io"
+	"sync"
 	"time"
 )
 
+// the header in which we count how often a message was retried
+const AMQPRetriesHeader = "kodex-retries"
+
 type AMQPReader struct {
 	writers.AMQPBase
-	deliveries   <-chan amqp.Delivery
-	ConsumerName string
+	deliveries          <-chan amqp.Delivery
+	ConsumerName        string
+	MaxRetries          int64
+	ConfirmationTimeout float64
+	confirmations       chan amqp.Confirmation
+	// the number of messages we published (for matching confirmations)
+	published uint64
+	mutex     sync.Mutex
 }
 
 func MakeAMQPReader(config map[string]interface{}) (kodex.Reader, error) {
@@ -45,13 +55,16 @@ func MakeAMQPReader(config map[string]interface{}) (kodex.Reader, error) {
 			return nil, err
 		}
 		return &AMQPReader{
-			AMQPBase:     base,
-			ConsumerName: params["consumer"].(string),
+			AMQPBase:            base,
+			ConsumerName:        params["consumer"].(string),
+			MaxRetries:          params["max-retries"].(int64),
+			ConfirmationTimeout: params["confirmation_timeout"].(float64),
 		}, nil
 	}
 }
 
 type AMQPPayload struct {
+	reader       *AMQPReader
 	delivery     amqp.Delivery
 	compressed   bool
 	rejected     bool
@@ -82,6 +95,15 @@ func (f *AMQPPayload) Headers() map[string]interface{} {
 	return f.headers
 }
 
+/*
+Rejects the message so that it will be delivered again. As a requeued message
+does not tell us how often it was rejected before, we instead publish a copy of
+it with an incremented retry count and acknowledge the original once the broker
+confirmed the copy. Once the retry limit is reached, we reject the message
+without requeueing it (so that e.g. the dead letter exchange of the queue
+receives it), which keeps messages that can never be processed from being
+redelivered forever.
+*/
 func (f *AMQPPayload) Reject() error {
 	if f.rejected {
 		return nil
@@ -90,7 +112,103 @@ func (f *AMQPPayload) Reject() error {
 		return fmt.Errorf("payload was already acknowledged")
 	}
 	f.rejected = true
-	return f.delivery.Reject(false)
+
+	retries := amqpRetries(f.delivery.Headers)
+
+	if retries >= f.reader.MaxRetries {
+		kodex.Log.Warningf("Discarding AMQP message after %d retries", retries)
+		return f.delivery.Reject(false)
+	}
+
+	headers := amqp.Table{}
+
+	for k, v := range f.delivery.Headers {
+		headers[k] = v
+	}
+
+	headers[AMQPRetriesHeader] = retries + 1
+
+	if err := f.reader.publish(amqp.Publishing{
+		ContentType:  f.delivery.ContentType,
+		DeliveryMode: f.delivery.DeliveryMode,
+		Headers:      headers,
+		Body:         f.delivery.Body,
+	}); err != nil {
+		// we requeue the message instead
+		kodex.Log.Error(err)
+		return f.delivery.Reject(true)
+	}
+
+	return f.delivery.Ack(false)
+}
+
+// Requeues the message without counting it as a retry (e.g. because a
+// shutdown aborted its processing)
+func (f *AMQPPayload) Requeue() error {
+	if f.rejected {
+		return nil
+	}
+	if f.acknowledged {
+		return fmt.Errorf("payload was already acknowledged")
+	}
+	f.rejected = true
+	return f.delivery.Reject(true)
+}
+
+// Publishes a message to our queue and waits until the broker confirms it
+func (a *AMQPReader) publish(publishing amqp.Publishing) error {
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if err := a.Channel.Publish(
+		"",          // the default exchange routes directly to the queue
+		a.QueueName, // routing key
+		false,       // mandatory
+		false,       // immediate
+		publishing,
+	); err != nil {
+		return err
+	}
+
+	// confirmations are numbered in the order of the publications
+	a.published++
+
+	timeout := time.After(time.Nanosecond * time.Duration(a.ConfirmationTimeout*1e9))
+
+	for {
+		select {
+		case confirmation, ok := <-a.confirmations:
+			if !ok {
+				return fmt.Errorf("channel closed while waiting for confirmation")
+			}
+			// this might be a late confirmation of an earlier publication
+			if confirmation.DeliveryTag < a.published {
+				continue
+			}
+			if !confirmation.Ack {
+				return fmt.Errorf("message was not confirmed")
+			}
+			return nil
+		case <-timeout:
+			return fmt.Errorf("timeout while waiting for confirmation")
+		}
+	}
+}
+
+// Returns how often a message was retried
+func amqpRetries(headers amqp.Table) int64 {
+	switch v := headers[AMQPRetriesHeader].(type) {
+	case int64:
+		return v
+	case int32:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int:
+		return int64(v)
+	}
+	return 0
 }
 
 func (a *AMQPReader) Purge() error {
@@ -132,6 +250,7 @@ func (a *AMQPReader) MakeAMQPPayload(delivery amqp.Delivery) (*AMQPPayload, erro
 	}
 
 	payload := AMQPPayload{
+		reader:      a,
 		delivery:    delivery,
 		compressed:  a.Compress,
 		format:      a.Format,
@@ -165,18 +284,32 @@ func (a *AMQPPayload) getReader() (*bufio.Reader, error) {
 
 func (a *AMQPReader) Peek() (kodex.Payload, error) {
 	payload, err := a.Read()
-	if err != nil {
+	if err != nil || payload == nil {
 		return payload, err
 	}
-	// we reject the payload
-	if err := payload.Reject(); err != nil {
+	// we requeue the message, as peeking does not count as a retry
+	if err := payload.(*AMQPPayload).delivery.Reject(true); err != nil {
 		return payload, err
 	}
 	return payload, err
 }
 
 func (a *AMQPReader) Setup(stream kodex.Stream) error {
-	return a.AMQPBase.SetupWithModel(nil)
+	if err := a.AMQPBase.SetupWithModel(nil); err != nil {
+		return err
+	}
+
+	a.published = 0
+	a.confirmations = make(chan amqp.Confirmation, 1000)
+
+	// we wait for confirmations of the messages we publish again
+	if err := a.Channel.Confirm(false); err != nil {
+		return err
+	}
+
+	a.Channel.NotifyPublish(a.confirmations)
+
+	return nil
 }
 
 func (a *AMQPPayload) readItems() error {
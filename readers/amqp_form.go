@@ -32,5 +32,21 @@ var AMQPReaderForm = forms.Form{
 				forms.IsString{},
 			},
 		},
+		{
+			Name: "max-retries",
+			Validators: []forms.Validator{
+				// how often a rejected message is delivered again
+				forms.IsOptional{Default: int64(5)},
+				forms.IsInteger{HasMin: true, Min: 0},
+			},
+		},
+		{
+			Name: "confirmation_timeout",
+			Validators: []forms.Validator{
+				// how long we wait for the broker to confirm a retried message
+				forms.IsOptional{Default: 1.0},
+				forms.IsFloat{Convert: false},
+			},
+		},
 	}, writers.AMQPBaseForm.Fields...),
 }
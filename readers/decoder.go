@@ -0,0 +1,148 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"io"
+)
+
+// Decodes items from a (possibly gzip-compressed) stream of JSON lines or
+// of CSV data with a header row. Used by the file and S3 readers.
+type ItemDecoder struct {
+	Format    string
+	reader    *bufio.Reader
+	gzReader  *gzip.Reader
+	csvReader *csv.Reader
+	columns   []string
+	eof       bool
+}
+
+func MakeItemDecoder(reader io.Reader, format string, compressed bool) (*ItemDecoder, error) {
+
+	decoder := &ItemDecoder{
+		Format: format,
+	}
+
+	if compressed {
+		gzReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		decoder.gzReader = gzReader
+		reader = gzReader
+	}
+
+	decoder.reader = bufio.NewReader(reader)
+
+	if format == "csv" {
+		decoder.csvReader = csv.NewReader(decoder.reader)
+	}
+
+	return decoder, nil
+}
+
+// Returns up to n items. Once the end of the stream has been reached, the
+// second return value is true.
+func (d *ItemDecoder) Next(n int) ([]*kodex.Item, bool, error) {
+
+	items := make([]*kodex.Item, 0, n)
+
+	for len(items) < n && !d.eof {
+
+		var item *kodex.Item
+		var err error
+
+		switch d.Format {
+		case "csv":
+			item, err = d.nextCSV()
+		default:
+			item, err = d.nextJSON()
+		}
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		if item != nil {
+			items = append(items, item)
+		}
+	}
+
+	return items, d.eof, nil
+}
+
+func (d *ItemDecoder) nextJSON() (*kodex.Item, error) {
+
+	line, err := d.reader.ReadBytes('\n')
+
+	if err == io.EOF {
+		d.eof = true
+	} else if err != nil {
+		return nil, err
+	}
+
+	if len(line) <= 1 {
+		return nil, nil
+	}
+
+	itemMap := make(map[string]interface{})
+
+	if err := json.Unmarshal(line, &itemMap); err != nil {
+		return nil, err
+	}
+
+	return kodex.MakeItem(itemMap), nil
+}
+
+func (d *ItemDecoder) nextCSV() (*kodex.Item, error) {
+
+	record, err := d.csvReader.Read()
+
+	if err == io.EOF {
+		d.eof = true
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("invalid CSV data: %v", err)
+	}
+
+	// the first row contains the column names
+	if d.columns == nil {
+		d.columns = record
+		return nil, nil
+	}
+
+	itemMap := make(map[string]interface{}, len(d.columns))
+
+	for i, column := range d.columns {
+		itemMap[column] = record[i]
+	}
+
+	return kodex.MakeItem(itemMap), nil
+}
+
+func (d *ItemDecoder) Close() error {
+	if d.gzReader != nil {
+		return d.gzReader.Close()
+	}
+	return nil
+}
@@ -17,19 +17,14 @@
 package readers
 
 import (
-	"bufio"
-	"compress/gzip"
-	"encoding/json"
 	"fmt"
 	"github.com/kiprotect/kodex"
-	"io"
 	"os"
 )
 
 type FileReader struct {
-	Reader     *bufio.Reader
+	Decoder    *ItemDecoder
 	File       *os.File
-	GzReader   *gzip.Reader
 	Format     string
 	Compressed bool
 	Headers    map[string]interface{}
@@ -76,33 +71,26 @@ func (s *FileReader) Setup(stream kodex.Stream) error {
 		return fmt.Errorf("reader path is not a file")
 	}
 
-	var reader io.Reader
-
 	if s.File, err = os.Open(s.Path); err != nil {
 		return err
 	}
 
-	if s.Compressed {
-		s.GzReader, err = gzip.NewReader(reader)
-		if err != nil {
-			return err
-		}
-		reader = s.GzReader
-	} else {
-		reader = s.File
+	if s.Decoder, err = MakeItemDecoder(s.File, s.Format, s.Compressed); err != nil {
+		s.File.Close()
+		s.File = nil
+		return err
 	}
 
-	s.Reader = bufio.NewReader(reader)
-
 	return nil
 
 }
 
 func (s *FileReader) Teardown() error {
-	if s.GzReader != nil {
-		if err := s.GzReader.Close(); err != nil {
-			return err
-		}
+	if s.File == nil {
+		return nil
+	}
+	if err := s.Decoder.Close(); err != nil {
+		return err
 	}
 	err := s.File.Close()
 	s.File = nil
@@ -130,38 +118,15 @@ func (s *FileReader) Read() (kodex.Payload, error) {
 		return nil, err
 	}
 
-	items := make([]*kodex.Item, 0)
-
-	endOfFile := false
-
-	for i := 0; i < s.ChunkSize; i++ {
-		item := make(map[string]interface{})
-		line, err := s.Reader.ReadBytes('\n')
-		if err == io.EOF {
-			endOfFile = true
-			if len(line) == 0 {
-				break
-			}
-		} else if err != nil {
-			return nil, err
-		}
-		if len(line) <= 1 {
-			continue
-		}
-		switch s.Format {
-		case "json":
-			err := json.Unmarshal(line, &item)
-			if err != nil {
-				return nil, err
-			}
-			item := kodex.MakeItem(item)
-			items = append(items, item)
-		}
+	items, endOfFile, err := s.Decoder.Next(s.ChunkSize)
+
+	if err != nil {
+		return nil, err
 	}
 
 	kodex.Log.Debugf("Read %d items...", len(items))
 
-	if len(items) == 0 {
+	if len(items) == 0 && !endOfFile {
 		return nil, nil
 	}
 
@@ -34,7 +34,7 @@ var FileReaderForm = forms.Form{
 			Name: "format",
 			Validators: []forms.Validator{
 				forms.IsRequired{},
-				forms.IsIn{Choices: []interface{}{"json"}},
+				forms.IsIn{Choices: []interface{}{"json", "csv"}},
 			},
 		},
 		{
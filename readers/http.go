@@ -0,0 +1,500 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+The HTTP reader starts its own HTTP(S) listener and accepts items via POST
+requests. Request bodies can contain newline-delimited JSON
+(application/x-ndjson), JSON objects or arrays (application/json) or CSV
+with a header row (text/csv) and can be gzip-compressed (Content-Encoding:
+gzip). Clients authenticate either with a shared bearer token or with a TLS
+client certificate (mTLS).
+
+The request only returns a 2xx response once the items of the request have
+been processed by the stream (i.e. when the payload was acknowledged). If
+processing fails (or does not finish in time) the client receives an error
+and should retry the request.
+*/
+type HTTPReader struct {
+	Address      string
+	Path         string
+	Token        string
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	Timeout      time.Duration
+	MaxBodySize  int64
+	Headers      map[string]interface{}
+	payloads     chan *HTTPPayload
+	stopped      chan bool
+	server       *http.Server
+	listener     net.Listener
+	mutex        sync.Mutex
+}
+
+type HTTPPayload struct {
+	items   []*kodex.Item
+	headers map[string]interface{}
+	result  chan error
+	once    sync.Once
+}
+
+func (f *HTTPPayload) EndOfStream() bool {
+	return false
+}
+
+func (f *HTTPPayload) Items() []*kodex.Item {
+	return f.items
+}
+
+func (f *HTTPPayload) Headers() map[string]interface{} {
+	return f.headers
+}
+
+// only the first acknowledgement or rejection counts
+func (f *HTTPPayload) resolve(err error) {
+	f.once.Do(func() {
+		f.result <- err
+	})
+}
+
+func (f *HTTPPayload) Acknowledge() error {
+	f.resolve(nil)
+	return nil
+}
+
+func (f *HTTPPayload) Reject() error {
+	f.resolve(fmt.Errorf("payload was rejected"))
+	return nil
+}
+
+func MakeHTTPReader(config map[string]interface{}) (kodex.Reader, error) {
+	if params, err := HTTPReaderForm.Validate(config); err != nil {
+		return nil, err
+	} else {
+		token := params["token"].(string)
+
+		if tokenEnv := params["token-env"].(string); tokenEnv != "" {
+			if token != "" {
+				return nil, fmt.Errorf("please specify either a token or a token environment variable")
+			}
+			if token = os.Getenv(tokenEnv); token == "" {
+				return nil, fmt.Errorf("environment variable '%s' is empty", tokenEnv)
+			}
+		}
+
+		reader := &HTTPReader{
+			Address:      params["address"].(string),
+			Path:         params["path"].(string),
+			Token:        token,
+			CertFile:     params["cert-file"].(string),
+			KeyFile:      params["key-file"].(string),
+			ClientCAFile: params["client-ca-file"].(string),
+			Timeout:      time.Duration(params["timeout"].(int64)) * time.Second,
+			MaxBodySize:  params["max-body-size"].(int64),
+			Headers:      params["headers"].(map[string]interface{}),
+		}
+
+		if (reader.CertFile == "") != (reader.KeyFile == "") {
+			return nil, fmt.Errorf("please specify both a certificate and a key file")
+		}
+
+		if reader.ClientCAFile != "" && reader.CertFile == "" {
+			return nil, fmt.Errorf("client certificates require TLS (i.e. a certificate and key file)")
+		}
+
+		if reader.Token == "" && reader.ClientCAFile == "" {
+			return nil, fmt.Errorf("the HTTP reader requires a token or client certificates for authentication")
+		}
+
+		return reader, nil
+	}
+}
+
+// Returns the address the reader listens on (useful if the port is chosen
+// automatically, e.g. with 'localhost:0')
+func (h *HTTPReader) Addr() net.Addr {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.listener == nil {
+		return nil
+	}
+	return h.listener.Addr()
+}
+
+// Returns the TLS configuration for a listener. If a client CA file is
+// given, clients need to present a certificate signed by that CA.
+func ServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCAFile != "" {
+		caData, err := ioutil.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("no valid certificates found in '%s'", clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+func (h *HTTPReader) Setup(stream kodex.Stream) error {
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.server != nil {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", h.Address)
+
+	if err != nil {
+		return err
+	}
+
+	if h.CertFile != "" {
+		config, err := ServerTLSConfig(h.CertFile, h.KeyFile, h.ClientCAFile)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		listener = tls.NewListener(listener, config)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(h.Path, h.handle)
+
+	h.listener = listener
+	h.payloads = make(chan *HTTPPayload)
+	h.stopped = make(chan bool)
+	h.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	server := h.server
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			kodex.Log.Error(err)
+		}
+	}()
+
+	kodex.Log.Infof("HTTP reader listening on %s...", listener.Addr())
+
+	return nil
+}
+
+func (h *HTTPReader) Teardown() error {
+
+	h.mutex.Lock()
+
+	server := h.server
+
+	if server == nil {
+		h.mutex.Unlock()
+		return nil
+	}
+
+	// requests that are waiting for a result will fail
+	close(h.stopped)
+
+	h.server = nil
+	h.listener = nil
+
+	h.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.Timeout)
+	defer cancel()
+
+	return server.Shutdown(ctx)
+}
+
+func (h *HTTPReader) Purge() error {
+	return nil
+}
+
+func (h *HTTPReader) Read() (kodex.Payload, error) {
+
+	h.mutex.Lock()
+	payloads := h.payloads
+	h.mutex.Unlock()
+
+	if payloads == nil {
+		return nil, fmt.Errorf("HTTP reader is not set up")
+	}
+
+	select {
+	case payload := <-payloads:
+		return payload, nil
+	case <-time.After(time.Second):
+		return nil, nil
+	}
+}
+
+func (h *HTTPReader) authorized(r *http.Request) bool {
+
+	// with mTLS, the TLS layer already verified the client certificate
+	if h.ClientCAFile != "" && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+
+	if h.Token == "" {
+		return false
+	}
+
+	authorization := r.Header.Get("Authorization")
+
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return false
+	}
+
+	token := strings.TrimPrefix(authorization, "Bearer ")
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.Token)) == 1
+}
+
+func respond(w http.ResponseWriter, code int, data map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		kodex.Log.Error(err)
+	}
+}
+
+func respondError(w http.ResponseWriter, code int, err error) {
+	respond(w, code, map[string]interface{}{"message": err.Error()})
+}
+
+func (h *HTTPReader) handle(w http.ResponseWriter, r *http.Request) {
+
+	h.mutex.Lock()
+	payloads, stopped := h.payloads, h.stopped
+	h.mutex.Unlock()
+
+	if r.Method != http.MethodPost {
+		respondError(w, 405, fmt.Errorf("method not allowed"))
+		return
+	}
+
+	if !h.authorized(r) {
+		respondError(w, 401, fmt.Errorf("not authorized"))
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, h.MaxBodySize))
+
+	if err != nil {
+		respondError(w, 413, fmt.Errorf("cannot read request body: %v", err))
+		return
+	}
+
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		if body, err = gunzip(body, h.MaxBodySize); err != nil {
+			respondError(w, 400, err)
+			return
+		}
+	}
+
+	items, err := ParseHTTPItems(r.Header.Get("Content-Type"), body)
+
+	if err != nil {
+		respondError(w, 400, err)
+		return
+	}
+
+	if len(items) == 0 {
+		respond(w, 200, map[string]interface{}{"items": 0})
+		return
+	}
+
+	payload := &HTTPPayload{
+		items:   items,
+		headers: h.Headers,
+		result:  make(chan error, 1),
+	}
+
+	timeout := time.After(h.Timeout)
+
+	select {
+	case payloads <- payload:
+	case <-timeout:
+		respondError(w, 503, fmt.Errorf("timeout while waiting for the reader"))
+		return
+	case <-stopped:
+		respondError(w, 503, fmt.Errorf("reader is shutting down"))
+		return
+	case <-r.Context().Done():
+		return
+	}
+
+	select {
+	case err := <-payload.result:
+		if err != nil {
+			respondError(w, 500, fmt.Errorf("items could not be processed"))
+			return
+		}
+		respond(w, 200, map[string]interface{}{"items": len(items)})
+	case <-timeout:
+		respondError(w, 504, fmt.Errorf("timeout while waiting for items to be processed"))
+	case <-stopped:
+		respondError(w, 503, fmt.Errorf("reader is shutting down"))
+	case <-r.Context().Done():
+	}
+}
+
+func gunzip(body []byte, maxSize int64) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid gzip data: %v", err)
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(reader, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid gzip data: %v", err)
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("request body too large")
+	}
+	return data, nil
+}
+
+// Parses items from a request body with the given content type.
+func ParseHTTPItems(contentType string, body []byte) ([]*kodex.Item, error) {
+
+	mediaType := "application/json"
+
+	if contentType != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(contentType); err != nil {
+			return nil, fmt.Errorf("invalid content type: %v", err)
+		}
+	}
+
+	switch mediaType {
+	case "application/json", "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return parseJSONItems(body)
+	case "text/csv":
+		return parseCSVItems(body)
+	default:
+		return nil, fmt.Errorf("unsupported content type: %s", mediaType)
+	}
+}
+
+// Parses a sequence of JSON values (e.g. newline-delimited JSON), each of
+// which can be an object or an array of objects.
+func parseJSONItems(body []byte) ([]*kodex.Item, error) {
+
+	items := make([]*kodex.Item, 0)
+	decoder := json.NewDecoder(bytes.NewReader(body))
+
+	for {
+		var value interface{}
+		if err := decoder.Decode(&value); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid JSON data: %v", err)
+		}
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			items = append(items, kodex.MakeItem(v))
+		case []interface{}:
+			for _, element := range v {
+				if itemMap, ok := element.(map[string]interface{}); ok {
+					items = append(items, kodex.MakeItem(itemMap))
+				} else {
+					return nil, fmt.Errorf("expected a list of JSON objects")
+				}
+			}
+		default:
+			return nil, fmt.Errorf("expected a JSON object or list of objects")
+		}
+	}
+
+	return items, nil
+}
+
+// Parses CSV data with a header row, all values are returned as strings.
+func parseCSVItems(body []byte) ([]*kodex.Item, error) {
+
+	reader := csv.NewReader(bytes.NewReader(body))
+
+	header, err := reader.Read()
+
+	if err == io.EOF {
+		return []*kodex.Item{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("invalid CSV data: %v", err)
+	}
+
+	items := make([]*kodex.Item, 0)
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid CSV data: %v", err)
+		}
+		itemMap := make(map[string]interface{}, len(header))
+		for i, column := range header {
+			itemMap[column] = record[i]
+		}
+		items = append(items, kodex.MakeItem(itemMap))
+	}
+
+	return items, nil
+}
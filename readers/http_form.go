@@ -0,0 +1,101 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"github.com/kiprotect/go-helpers/forms"
+)
+
+var HTTPReaderForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the HTTP reader form",
+	Fields: []forms.Field{
+		{
+			Name: "address",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "path",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "/"},
+				forms.IsString{},
+			},
+		},
+		{
+			// shared token that clients need to send as a bearer token
+			Name: "token",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// name of an environment variable that contains the token
+			Name: "token-env",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "cert-file",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "key-file",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// if given, clients need to present a certificate signed by this CA
+			Name: "client-ca-file",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// how long we wait for items to be processed before giving up (in seconds)
+			Name: "timeout",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(30)},
+				forms.IsInteger{HasMin: true, Min: 1, HasMax: true, Max: 3600},
+			},
+		},
+		{
+			Name: "max-body-size",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(10 * 1024 * 1024)},
+				forms.IsInteger{HasMin: true, Min: 1},
+			},
+		},
+		{
+			Name: "headers",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: map[string]interface{}{}},
+				forms.IsStringMap{},
+			},
+		},
+	},
+}
@@ -0,0 +1,178 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/readers"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func post(url, token, contentType, body string) chan int {
+	return postWithEncoding(url, token, contentType, "", []byte(body))
+}
+
+func postWithEncoding(url, token, contentType, encoding string, body []byte) chan int {
+	result := make(chan int, 1)
+	go func() {
+		req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+		if err != nil {
+			result <- 0
+			return
+		}
+		req.Header.Set("Content-Type", contentType)
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			result <- 0
+			return
+		}
+		resp.Body.Close()
+		result <- resp.StatusCode
+	}()
+	return result
+}
+
+func readPayload(t *testing.T, reader kodex.Reader) kodex.Payload {
+	for i := 0; i < 5; i++ {
+		payload, err := reader.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payload != nil {
+			return payload
+		}
+	}
+	t.Fatalf("no payload received")
+	return nil
+}
+
+func TestHTTPReader(t *testing.T) {
+
+	if _, err := readers.MakeHTTPReader(map[string]interface{}{
+		"address": "localhost:0",
+	}); err == nil {
+		t.Fatalf("expected an error without authentication")
+	}
+
+	reader, err := readers.MakeHTTPReader(map[string]interface{}{
+		"address": "localhost:0",
+		"token":   "secret",
+		"timeout": 5,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reader.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	defer reader.Teardown()
+
+	url := fmt.Sprintf("http://%s/", reader.(*readers.HTTPReader).Addr())
+
+	if code := <-post(url, "wrong", "application/json", `{"foo": "bar"}`); code != 401 {
+		t.Fatalf("expected a 401 response, got %d", code)
+	}
+
+	if code := <-post(url, "secret", "application/json", `{"foo": `); code != 400 {
+		t.Fatalf("expected a 400 response, got %d", code)
+	}
+
+	for _, body := range []struct {
+		contentType string
+		data        string
+	}{
+		{"application/x-ndjson", "{\"foo\": \"bar\"}\n{\"foo\": \"baz\"}\n"},
+		{"application/json", `[{"foo": "bar"}, {"foo": "baz"}]`},
+		{"text/csv", "foo\nbar\nbaz\n"},
+	} {
+
+		result := post(url, "secret", body.contentType, body.data)
+		payload := readPayload(t, reader)
+
+		if len(payload.Items()) != 2 {
+			t.Fatalf("expected 2 items for %s, got %d", body.contentType, len(payload.Items()))
+		}
+
+		if value, _ := payload.Items()[1].Get("foo"); value != "baz" {
+			t.Fatalf("unexpected item value for %s", body.contentType)
+		}
+
+		select {
+		case <-result:
+			t.Fatalf("request should not finish before the payload was acknowledged")
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		if err := payload.Acknowledge(); err != nil {
+			t.Fatal(err)
+		}
+
+		if code := <-result; code != 200 {
+			t.Fatalf("expected a 200 response, got %d", code)
+		}
+	}
+
+	result := post(url, "secret", "application/json", `{"foo": "bar"}`)
+
+	if err := readPayload(t, reader).Reject(); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := <-result; code != 500 {
+		t.Fatalf("expected a 500 response, got %d", code)
+	}
+
+	// gzip-compressed request bodies are decoded
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	gzipWriter.Write([]byte(`[{"foo": "bar"}, {"foo": "baz"}]`))
+	gzipWriter.Close()
+
+	result = postWithEncoding(url, "secret", "application/json", "gzip", compressed.Bytes())
+	payload := readPayload(t, reader)
+
+	if value, _ := payload.Items()[1].Get("foo"); len(payload.Items()) != 2 || value != "baz" {
+		t.Fatalf("unexpected items in compressed request")
+	}
+
+	if err := payload.Acknowledge(); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := <-result; code != 200 {
+		t.Fatalf("expected a 200 response, got %d", code)
+	}
+
+	if code := <-postWithEncoding(url, "secret", "application/json", "gzip", []byte(`{"foo": "bar"}`)); code != 400 {
+		t.Fatalf("expected a 400 response for invalid gzip data, got %d", code)
+	}
+
+}
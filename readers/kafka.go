@@ -0,0 +1,420 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"context"
+	"errors"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/writers"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"sync"
+	"time"
+)
+
+/*
+The Kafka reader consumes records from a topic as a member of a consumer
+group. Offsets are only committed when a payload is acknowledged, and only
+up to the first payload (of a given partition) that has not been
+acknowledged yet. Records of unfinished payloads will therefore be delivered
+again after a restart or rebalance (at-least-once delivery). When a payload
+is rejected, we seek its partitions back to its first records, so that they
+(and all records after them) are delivered again right away.
+*/
+type KafkaReader struct {
+	writers.KafkaBase
+	Group        string
+	Start        string
+	ChunkSize    int
+	HeadersField string
+	payloads     []*KafkaPayload
+	offsets      *kafkaOffsets
+}
+
+type KafkaPayload struct {
+	items    []*kodex.Item
+	headers  map[string]interface{}
+	ranges   []*kafkaRange
+	offsets  *kafkaOffsets
+	resolved bool
+}
+
+// A range of records of a single partition that belongs to one payload
+type kafkaRange struct {
+	first *kgo.Record
+	last  *kgo.Record
+	done  bool
+}
+
+type kafkaPartition struct {
+	topic     string
+	partition int32
+}
+
+// Keeps track of the payloads per partition so we only commit offsets of
+// records for which all previous records have been acknowledged as well.
+type kafkaOffsets struct {
+	mutex      sync.Mutex
+	client     *kgo.Client
+	partitions map[kafkaPartition][]*kafkaRange
+	// offsets to which we need to seek back (because of rejected payloads)
+	rewinds map[kafkaPartition]kgo.EpochOffset
+}
+
+func (k *kafkaOffsets) add(record *kgo.Record) *kafkaRange {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	partition := kafkaPartition{record.Topic, record.Partition}
+	kr := &kafkaRange{first: record, last: record}
+	k.partitions[partition] = append(k.partitions[partition], kr)
+	return kr
+}
+
+// Drops the pending ranges of partitions that were revoked from us
+func (k *kafkaOffsets) drop(partitions map[string][]int32) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	for topic, ps := range partitions {
+		for _, partition := range ps {
+			delete(k.partitions, kafkaPartition{topic, partition})
+			delete(k.rewinds, kafkaPartition{topic, partition})
+		}
+	}
+}
+
+// Marks the partitions of the given ranges for seeking back to the first
+// record of the range. As all later records of the partition will be
+// delivered again as well, we drop their ranges, so that they do not block
+// commits (acknowledging them later on does not commit anything).
+func (k *kafkaOffsets) reject(ranges []*kafkaRange) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	for _, kr := range ranges {
+		partition := kafkaPartition{kr.first.Topic, kr.first.Partition}
+		pending := k.partitions[partition]
+		for i, pr := range pending {
+			if pr != kr {
+				continue
+			}
+			if i == 0 {
+				delete(k.partitions, partition)
+			} else {
+				k.partitions[partition] = pending[:i]
+			}
+			if rewind, ok := k.rewinds[partition]; !ok || kr.first.Offset < rewind.Offset {
+				k.rewinds[partition] = kgo.EpochOffset{
+					Epoch:  kr.first.LeaderEpoch,
+					Offset: kr.first.Offset,
+				}
+			}
+			break
+		}
+	}
+}
+
+// Returns the offsets to seek back to (if any) and resets them
+func (k *kafkaOffsets) popRewinds() map[string]map[int32]kgo.EpochOffset {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if len(k.rewinds) == 0 {
+		return nil
+	}
+
+	rewinds := make(map[string]map[int32]kgo.EpochOffset)
+
+	for partition, offset := range k.rewinds {
+		if _, ok := rewinds[partition.topic]; !ok {
+			rewinds[partition.topic] = make(map[int32]kgo.EpochOffset)
+		}
+		rewinds[partition.topic][partition.partition] = offset
+	}
+
+	k.rewinds = make(map[kafkaPartition]kgo.EpochOffset)
+
+	return rewinds
+}
+
+func (k *kafkaOffsets) acknowledge(ranges []*kafkaRange) error {
+	k.mutex.Lock()
+
+	commit := make([]*kgo.Record, 0)
+
+	for _, kr := range ranges {
+		kr.done = true
+		partition := kafkaPartition{kr.last.Topic, kr.last.Partition}
+		pending := k.partitions[partition]
+		var last *kgo.Record
+		for len(pending) > 0 && pending[0].done {
+			last = pending[0].last
+			pending = pending[1:]
+		}
+		if last != nil {
+			commit = append(commit, last)
+		}
+		if len(pending) == 0 {
+			delete(k.partitions, partition)
+		} else if _, ok := k.partitions[partition]; ok {
+			k.partitions[partition] = pending
+		}
+	}
+
+	k.mutex.Unlock()
+
+	if len(commit) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return k.client.CommitRecords(ctx, commit...)
+}
+
+func (f *KafkaPayload) EndOfStream() bool {
+	return false
+}
+
+func (f *KafkaPayload) Items() []*kodex.Item {
+	return f.items
+}
+
+func (f *KafkaPayload) Headers() map[string]interface{} {
+	return f.headers
+}
+
+func (f *KafkaPayload) Acknowledge() error {
+	if f.resolved {
+		return nil
+	}
+	f.resolved = true
+	return f.offsets.acknowledge(f.ranges)
+}
+
+func (f *KafkaPayload) Reject() error {
+	if f.resolved {
+		return nil
+	}
+	f.resolved = true
+	// we do not commit the offsets and seek back instead (on the next read),
+	// so the records will be delivered again
+	kodex.Log.Warning("Kafka payload rejected, its records will be delivered again")
+	f.offsets.reject(f.ranges)
+	return nil
+}
+
+func MakeKafkaReader(config map[string]interface{}) (kodex.Reader, error) {
+	if params, err := KafkaReaderForm.Validate(config); err != nil {
+		return nil, err
+	} else {
+		base, err := writers.MakeKafkaBase(params)
+		if err != nil {
+			return nil, err
+		}
+		return &KafkaReader{
+			KafkaBase:    base,
+			Group:        params["group"].(string),
+			Start:        params["start"].(string),
+			ChunkSize:    int(params["chunk-size"].(int64)),
+			HeadersField: params["headers-field"].(string),
+		}, nil
+	}
+}
+
+func (k *KafkaReader) Setup(stream kodex.Stream) error {
+
+	if k.Client != nil {
+		return nil
+	}
+
+	k.offsets = &kafkaOffsets{
+		partitions: make(map[kafkaPartition][]*kafkaRange),
+		rewinds:    make(map[kafkaPartition]kgo.EpochOffset),
+	}
+
+	start := kgo.NewOffset().AtStart()
+
+	if k.Start == "latest" {
+		start = kgo.NewOffset().AtEnd()
+	}
+
+	offsets := k.offsets
+
+	options := append(k.ClientOptions(),
+		kgo.ConsumerGroup(k.Group),
+		kgo.ConsumeTopics(k.Topic),
+		kgo.ConsumeResetOffset(start),
+		kgo.DisableAutoCommit(),
+		kgo.OnPartitionsRevoked(func(ctx context.Context, client *kgo.Client, partitions map[string][]int32) {
+			offsets.drop(partitions)
+		}),
+		kgo.OnPartitionsLost(func(ctx context.Context, client *kgo.Client, partitions map[string][]int32) {
+			offsets.drop(partitions)
+		}),
+	)
+
+	client, err := kgo.NewClient(options...)
+
+	if err != nil {
+		return err
+	}
+
+	k.Client = client
+	k.offsets.client = client
+
+	return nil
+}
+
+func (k *KafkaReader) Purge() error {
+	return nil
+}
+
+func (k *KafkaReader) Teardown() error {
+	k.payloads = nil
+	return k.KafkaBase.Teardown()
+}
+
+func (k *KafkaReader) recordHeaders(record *kgo.Record) map[string]interface{} {
+	headers := make(map[string]interface{}, len(record.Headers))
+	for _, header := range record.Headers {
+		headers[header.Key] = string(header.Value)
+	}
+	return headers
+}
+
+func sameHeaders(a, b map[string]interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Turns records into payloads. If record headers are mapped to the payload
+// headers, records with different headers end up in different payloads.
+func (k *KafkaReader) makePayloads(records []*kgo.Record) []*KafkaPayload {
+
+	payloads := make([]*KafkaPayload, 0)
+
+	var payload *KafkaPayload
+	var payloadHeaders map[string]interface{}
+	var partitions map[kafkaPartition]*kafkaRange
+
+	for _, record := range records {
+
+		recordHeaders := k.recordHeaders(record)
+
+		if payload == nil || (k.HeadersField == "" && !sameHeaders(recordHeaders, payloadHeaders)) {
+			headers := make(map[string]interface{}, len(k.Headers))
+			for key, value := range k.Headers {
+				headers[key] = value
+			}
+			if k.HeadersField == "" {
+				for key, value := range recordHeaders {
+					headers[key] = value
+				}
+			}
+			payload = &KafkaPayload{
+				items:   make([]*kodex.Item, 0),
+				headers: headers,
+				ranges:  make([]*kafkaRange, 0),
+				offsets: k.offsets,
+			}
+			payloadHeaders = recordHeaders
+			partitions = make(map[kafkaPartition]*kafkaRange)
+			payloads = append(payloads, payload)
+		}
+
+		// we keep track of the last record of each partition
+		partition := kafkaPartition{record.Topic, record.Partition}
+		if kr, ok := partitions[partition]; ok {
+			kr.last = record
+		} else {
+			kr := k.offsets.add(record)
+			partitions[partition] = kr
+			payload.ranges = append(payload.ranges, kr)
+		}
+
+		items, err := k.ParseValue(record.Value)
+
+		if err != nil {
+			// invalid records are skipped (but still acknowledged with the payload)
+			kodex.Log.Errorf("Cannot parse Kafka record (partition %d, offset %d): %v", record.Partition, record.Offset, err)
+			continue
+		}
+
+		for _, item := range items {
+			if k.KeyField != "" && record.Key != nil {
+				item.Set(k.KeyField, string(record.Key))
+			}
+			if k.HeadersField != "" {
+				item.Set(k.HeadersField, recordHeaders)
+			}
+			payload.items = append(payload.items, item)
+		}
+	}
+
+	return payloads
+}
+
+func (k *KafkaReader) Read() (kodex.Payload, error) {
+
+	if k.Client == nil {
+		return nil, errors.New("Kafka reader is not set up")
+	}
+
+	// we seek back here (and not when rejecting a payload), as seeking
+	// should not happen concurrently with polling
+	if rewinds := k.offsets.popRewinds(); rewinds != nil {
+		k.Client.SetOffsets(rewinds)
+	}
+
+	if len(k.payloads) == 0 {
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		fetches := k.Client.PollRecords(ctx, k.ChunkSize)
+
+		if fetches.IsClientClosed() {
+			return nil, errors.New("Kafka client is closed")
+		}
+
+		for _, fetchErr := range fetches.Errors() {
+			if !errors.Is(fetchErr.Err, context.DeadlineExceeded) && !errors.Is(fetchErr.Err, context.Canceled) {
+				// most fetch errors are retriable, so we only log them
+				kodex.Log.Errorf("Error fetching Kafka records (topic %s, partition %d): %v", fetchErr.Topic, fetchErr.Partition, fetchErr.Err)
+			}
+		}
+
+		k.payloads = k.makePayloads(fetches.Records())
+	}
+
+	if len(k.payloads) == 0 {
+		return nil, nil
+	}
+
+	payload := k.payloads[0]
+	k.payloads = k.payloads[1:]
+
+	return payload, nil
+}
@@ -0,0 +1,197 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers_test
+
+import (
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/readers"
+	"github.com/kiprotect/kodex/writers"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"testing"
+)
+
+func kafkaConfig(cluster *kfake.Cluster, extra map[string]interface{}) map[string]interface{} {
+	brokers := make([]interface{}, 0)
+	for _, addr := range cluster.ListenAddrs() {
+		brokers = append(brokers, addr)
+	}
+	config := map[string]interface{}{
+		"brokers":     brokers,
+		"topic":       "items",
+		"format":      "csv",
+		"csv-columns": []interface{}{"name", "value"},
+		"key-field":   "name",
+	}
+	for k, v := range extra {
+		config[k] = v
+	}
+	return config
+}
+
+func makeKafkaReader(t *testing.T, cluster *kfake.Cluster) kodex.Reader {
+	reader, err := readers.MakeKafkaReader(kafkaConfig(cluster, map[string]interface{}{
+		"group": "kodex",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reader.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+	return reader
+}
+
+// reads payloads until the given number of items was received
+func readKafkaItems(t *testing.T, reader kodex.Reader, n int) []kodex.Payload {
+	payloads := make([]kodex.Payload, 0)
+	items := 0
+	for i := 0; i < 30 && items < n; i++ {
+		payload, err := reader.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payload != nil {
+			payloads = append(payloads, payload)
+			items += len(payload.Items())
+		}
+	}
+	if items != n {
+		t.Fatalf("expected %d items, got %d", n, items)
+	}
+	return payloads
+}
+
+func writeKafkaItems(t *testing.T, cluster *kfake.Cluster, items []*kodex.Item) {
+	writer, err := writers.MakeKafkaWriter(kafkaConfig(cluster, map[string]interface{}{
+		"headers": map[string]interface{}{"source": "test"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Teardown()
+	if err := writer.Write(kodex.MakeBasicPayload(items, map[string]interface{}{}, false)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKafka(t *testing.T) {
+
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(2, "items"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer cluster.Close()
+
+	writeKafkaItems(t, cluster, []*kodex.Item{
+		kodex.MakeItem(map[string]interface{}{"name": "a", "value": "1"}),
+		kodex.MakeItem(map[string]interface{}{"name": "b", "value": "2"}),
+		kodex.MakeItem(map[string]interface{}{"name": "a", "value": "3"}),
+	})
+
+	reader := makeKafkaReader(t, cluster)
+
+	values := map[string]string{}
+
+	for _, payload := range readKafkaItems(t, reader, 3) {
+		if payload.Headers()["source"] != "test" {
+			t.Fatalf("expected record headers in the payload headers")
+		}
+		for _, item := range payload.Items() {
+			name, _ := item.Get("name")
+			value, _ := item.Get("value")
+			values[value.(string)] = name.(string)
+		}
+		if err := payload.Acknowledge(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if values["1"] != "a" || values["2"] != "b" || values["3"] != "a" {
+		t.Fatalf("unexpected items: %v", values)
+	}
+
+	if err := reader.Teardown(); err != nil {
+		t.Fatal(err)
+	}
+
+	writeKafkaItems(t, cluster, []*kodex.Item{
+		kodex.MakeItem(map[string]interface{}{"name": "c", "value": "4"}),
+	})
+
+	// acknowledged records should not be delivered again
+	reader = makeKafkaReader(t, cluster)
+
+	payloads := readKafkaItems(t, reader, 1)
+
+	if value, _ := payloads[0].Items()[0].Get("value"); value != "4" {
+		t.Fatalf("expected only the new item, got %v", value)
+	}
+
+	// we reject the payload, so its offsets should not be committed
+	if err := payloads[0].Reject(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reader.Teardown(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader = makeKafkaReader(t, cluster)
+
+	payloads = readKafkaItems(t, reader, 1)
+
+	if value, _ := payloads[0].Items()[0].Get("value"); value != "4" {
+		t.Fatalf("expected the rejected item again, got %v", value)
+	}
+
+	// rejected records are delivered again without a restart as well
+	if err := payloads[0].Reject(); err != nil {
+		t.Fatal(err)
+	}
+
+	payloads = readKafkaItems(t, reader, 1)
+
+	if value, _ := payloads[0].Items()[0].Get("value"); value != "4" {
+		t.Fatalf("expected the rejected item again, got %v", value)
+	}
+
+	// the rejection does not block later commits
+	if err := payloads[0].Acknowledge(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reader.Teardown(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader = makeKafkaReader(t, cluster)
+	defer reader.Teardown()
+
+	for i := 0; i < 3; i++ {
+		if payload, err := reader.Read(); err != nil {
+			t.Fatal(err)
+		} else if payload != nil {
+			t.Fatalf("expected no more items")
+		}
+	}
+
+}
@@ -0,0 +1,354 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/writers"
+	"io"
+	"os"
+	"sync"
+)
+
+/*
+The log reader reads payloads from a log written by the log writer. Like the
+Kafka reader, it only commits the offset up to the first payload that has not
+been acknowledged yet, so unfinished payloads are delivered again after a
+restart (at-least-once delivery). When a payload is rejected, the reader goes
+back to it, so that it (and all payloads after it) are delivered again right
+away. Payloads that are still rejected after 'max-retries' deliveries are
+dropped (and logged as errors), so that they do not block the log forever.
+*/
+type LogReader struct {
+	writers.LogBase
+	Consumer   string
+	MaxRetries int64
+	file       *os.File
+	base       int64
+	position   int64
+	offsets    *logOffsets
+}
+
+type LogPayload struct {
+	record   *writers.ChannelRecord
+	items    []*kodex.Item
+	entry    *logEntry
+	offsets  *logOffsets
+	resolved bool
+}
+
+// The log offsets of a payload and of the payload following it
+type logEntry struct {
+	start int64
+	end   int64
+	done  bool
+}
+
+type logOffsets struct {
+	mutex    sync.Mutex
+	path     string
+	consumer string
+	pending  []*logEntry
+	// the offset the reader needs to go back to (-1 if none)
+	rewind     int64
+	maxRetries int64
+	// how often the payloads at the given offsets were rejected
+	retries map[int64]int64
+}
+
+func (l *logOffsets) add(start, end int64) *logEntry {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	entry := &logEntry{start: start, end: end}
+	l.pending = append(l.pending, entry)
+	return entry
+}
+
+// Makes the reader go back to the given entry. As all later entries will be
+// delivered again as well, we drop them (acknowledging them later on does
+// not commit anything). Returns false if the entry was rejected too often.
+func (l *logOffsets) reject(entry *logEntry) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for i, pending := range l.pending {
+		if pending == entry {
+			if l.retries[entry.start] >= l.maxRetries {
+				return false
+			}
+			l.retries[entry.start]++
+			l.pending = l.pending[:i]
+			if l.rewind < 0 || entry.start < l.rewind {
+				l.rewind = entry.start
+			}
+			break
+		}
+	}
+
+	return true
+}
+
+// Returns the offset the reader needs to go back to (or -1) and resets it
+func (l *logOffsets) popRewind() int64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	rewind := l.rewind
+	l.rewind = -1
+	return rewind
+}
+
+func (l *logOffsets) acknowledge(entry *logEntry) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	entry.done = true
+
+	var commit int64 = -1
+
+	for len(l.pending) > 0 && l.pending[0].done {
+		commit = l.pending[0].end
+		delete(l.retries, l.pending[0].start)
+		l.pending = l.pending[1:]
+	}
+
+	if commit < 0 {
+		return nil
+	}
+
+	if err := writers.WriteLogOffset(l.path, l.consumer, commit); err != nil {
+		return err
+	}
+
+	// removing old segments is not critical, so we only log errors
+	if err := writers.CompactLog(l.path); err != nil {
+		kodex.Log.Errorf("Cannot compact log %s: %v", l.path, err)
+	}
+
+	return nil
+}
+
+func (l *LogPayload) EndOfStream() bool {
+	return l.record.EndOfStream
+}
+
+func (l *LogPayload) Items() []*kodex.Item {
+	return l.items
+}
+
+func (l *LogPayload) Headers() map[string]interface{} {
+	return l.record.Headers
+}
+
+func (l *LogPayload) Acknowledge() error {
+	if l.resolved {
+		return nil
+	}
+	l.resolved = true
+	return l.offsets.acknowledge(l.entry)
+}
+
+func (l *LogPayload) Reject() error {
+	if l.resolved {
+		return nil
+	}
+	l.resolved = true
+	// we do not commit the offset and go back to the payload instead (on the
+	// next read), so it will be delivered again
+	if !l.offsets.reject(l.entry) {
+		kodex.Log.Errorf("Dropping log payload at offset %d, as it was rejected %d times", l.entry.start, l.offsets.maxRetries+1)
+		return l.offsets.acknowledge(l.entry)
+	}
+	kodex.Log.Warning("Log payload rejected, it will be delivered again")
+	return nil
+}
+
+func MakeLogReader(config map[string]interface{}) (kodex.Reader, error) {
+	if params, err := LogReaderForm.Validate(config); err != nil {
+		return nil, err
+	} else {
+		return &LogReader{
+			LogBase:    writers.MakeLogBase(params),
+			Consumer:   params["consumer"].(string),
+			MaxRetries: params["max-retries"].(int64),
+		}, nil
+	}
+}
+
+func (l *LogReader) Setup(stream kodex.Stream) error {
+	return fmt.Errorf("the log reader can only be used as an internal channel")
+}
+
+func (l *LogReader) SetupWithModel(model kodex.Model) error {
+	if err := l.LogBase.SetupWithModel(model); err != nil {
+		return err
+	}
+	position, err := writers.ReadLogOffset(l.Path, l.Consumer)
+	if err != nil {
+		return err
+	}
+	l.position = position
+	l.offsets = &logOffsets{
+		path:       l.Path,
+		consumer:   l.Consumer,
+		pending:    make([]*logEntry, 0),
+		rewind:     -1,
+		maxRetries: l.MaxRetries,
+		retries:    make(map[int64]int64),
+	}
+	return nil
+}
+
+// Returns the number of payloads after the committed offset of our consumer,
+// i.e. the payloads that were not read or not acknowledged yet
+func (l *LogReader) QueueLength() int {
+	offset, err := writers.ReadLogOffset(l.Path, l.Consumer)
+	if err != nil {
+		kodex.Log.Error(err)
+		return 0
+	}
+	count, err := writers.CountLogRecords(l.Path, offset)
+	if err != nil {
+		kodex.Log.Error(err)
+		return 0
+	}
+	return int(count)
+}
+
+func (l *LogReader) Purge() error {
+	return nil
+}
+
+func (l *LogReader) Teardown() error {
+	return l.closeSegment()
+}
+
+func (l *LogReader) closeSegment() error {
+	if l.file == nil {
+		return nil
+	}
+	file := l.file
+	l.file = nil
+	return file.Close()
+}
+
+// Opens the segment that contains the current position
+func (l *LogReader) openSegment(segments []int64) error {
+	base := segments[0]
+	if l.position < base {
+		// the log was compacted past our position (e.g. by another consumer)
+		kodex.Log.Warningf("Log %s starts at offset %d, skipping to it", l.Path, base)
+		l.position = base
+	}
+	for _, segment := range segments {
+		if segment <= l.position {
+			base = segment
+		}
+	}
+	file, err := os.Open(writers.LogSegmentPath(l.Path, base))
+	if err != nil {
+		return err
+	}
+	l.file = file
+	l.base = base
+	return nil
+}
+
+// Returns the base offset of the segment following the current one (or -1)
+func (l *LogReader) nextSegment() (int64, error) {
+	segments, err := writers.LogSegments(l.Path)
+	if err != nil {
+		return -1, err
+	}
+	for _, segment := range segments {
+		if segment > l.base {
+			return segment, nil
+		}
+	}
+	return -1, nil
+}
+
+func (l *LogReader) Read() (kodex.Payload, error) {
+
+	if l.offsets == nil {
+		return nil, fmt.Errorf("log reader is not set up")
+	}
+
+	if rewind := l.offsets.popRewind(); rewind >= 0 {
+		// the rejected payload might be in an earlier segment
+		if err := l.closeSegment(); err != nil {
+			return nil, err
+		}
+		l.position = rewind
+	}
+
+	for {
+
+		if l.file == nil {
+			segments, err := writers.LogSegments(l.Path)
+			if err != nil {
+				return nil, err
+			}
+			if len(segments) == 0 {
+				return nil, nil
+			}
+			if err := l.openSegment(segments); err != nil {
+				return nil, err
+			}
+		}
+
+		data, err := writers.ReadLogRecord(l.file, l.position-l.base)
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// we're at the end of the segment, if the writer has moved on to
+			// a new segment we continue there
+			next, nextErr := l.nextSegment()
+			if nextErr != nil {
+				return nil, nextErr
+			}
+			if next < 0 {
+				return nil, nil
+			}
+			if err == io.ErrUnexpectedEOF {
+				kodex.Log.Warningf("Skipping incomplete record at offset %d of log %s", l.position, l.Path)
+			}
+			if err := l.closeSegment(); err != nil {
+				return nil, err
+			}
+			l.position = next
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		record, err := writers.DecodeChannelRecord(data)
+
+		if err != nil {
+			return nil, err
+		}
+
+		start := l.position
+		l.position += writers.LogHeaderSize + int64(len(data))
+
+		return &LogPayload{
+			record:  record,
+			items:   record.ItemsList(),
+			entry:   l.offsets.add(start, l.position),
+			offsets: l.offsets,
+		}, nil
+	}
+}
@@ -0,0 +1,46 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/kodex/writers"
+	"regexp"
+)
+
+var LogReaderForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the log reader form",
+	Fields: append([]forms.Field{
+		{
+			// the name under which the reader commits its offsets
+			Name: "consumer",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "default"},
+				forms.IsString{},
+				forms.MatchesRegex{Regexp: regexp.MustCompile(`^[\w\-]+$`)},
+			},
+		},
+		{
+			// how often a rejected payload is delivered again
+			Name: "max-retries",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(5)},
+				forms.IsInteger{HasMin: true, Min: 0},
+			},
+		},
+	}, writers.LogBaseForm.Fields...),
+}
@@ -0,0 +1,332 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers_test
+
+import (
+	"bytes"
+	"github.com/kiprotect/kodex"
+	pt "github.com/kiprotect/kodex/helpers/testing"
+	pf "github.com/kiprotect/kodex/helpers/testing/fixtures"
+	"github.com/kiprotect/kodex/writers"
+	"os"
+	"testing"
+)
+
+func setupChannel(t *testing.T, controller kodex.Controller, stream kodex.Stream) *kodex.InternalChannel {
+	channel := kodex.MakeInternalChannel()
+	if err := channel.Setup(controller, stream); err != nil {
+		t.Fatal(err)
+	}
+	return channel
+}
+
+func readChannelPayload(t *testing.T, channel *kodex.InternalChannel) kodex.Payload {
+	payload, err := channel.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload == nil {
+		t.Fatal("expected a payload")
+	}
+	return payload
+}
+
+func TestLogChannel(t *testing.T) {
+
+	var fixtureConfig = []pt.FC{
+		pt.FC{&pf.Settings{}, "settings"},
+		pt.FC{&pf.Controller{}, "controller"},
+		pt.FC{&pf.Project{Name: "test"}, "project"},
+		pt.FC{&pf.Stream{Name: "test", Project: "project"}, "stream"},
+	}
+
+	fixtures, err := pt.SetupFixtures(fixtureConfig)
+	defer pt.TeardownFixtures(fixtureConfig, fixtures)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	controller := fixtures["controller"].(kodex.Controller)
+	stream := fixtures["stream"].(kodex.Stream)
+
+	directory := t.TempDir()
+
+	controller.Settings().Set("internal-channel", map[string]interface{}{
+		"type": "log",
+		"config": map[string]interface{}{
+			"directory":    directory,
+			"segment-size": int64(1024),
+			"sync":         false,
+		},
+	})
+
+	channel := setupChannel(t, controller, stream)
+
+	for i := 0; i < 10; i++ {
+		items := []*kodex.Item{
+			kodex.MakeItem(map[string]interface{}{
+				"number": int64(i),
+				"data":   bytes.Repeat([]byte{byte(i)}, 200),
+			}),
+		}
+		if err := channel.Write(kodex.MakeBasicPayload(items, map[string]interface{}{"foo": "bar"}, i == 9)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the payloads span several segments
+	if length := channel.QueueLength(); length != 10 {
+		t.Fatalf("expected a queue length of 10, got %d", length)
+	}
+
+	for i := 0; i < 5; i++ {
+		payload := readChannelPayload(t, channel)
+		item := payload.Items()[0]
+		if number, _ := item.Get("number"); number != int64(i) {
+			t.Fatalf("expected payload %d, got %v", i, number)
+		}
+		// the types of the item values are preserved
+		if data, _ := item.Get("data"); !bytes.Equal(data.([]byte), bytes.Repeat([]byte{byte(i)}, 200)) {
+			t.Fatalf("unexpected data")
+		}
+		if payload.Headers()["foo"] != "bar" {
+			t.Fatalf("expected headers")
+		}
+		// we do not acknowledge the third payload
+		if i != 2 {
+			if err := payload.Acknowledge(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// only the payloads up to the unfinished one were committed
+	if length := channel.QueueLength(); length != 8 {
+		t.Fatalf("expected a queue length of 8, got %d", length)
+	}
+
+	if err := channel.Teardown(); err != nil {
+		t.Fatal(err)
+	}
+
+	// after a restart we receive all payloads after the last one that was
+	// acknowledged (along with all payloads that came before) again
+	channel = setupChannel(t, controller, stream)
+	defer channel.Teardown()
+
+	for i := 2; i < 10; i++ {
+		payload := readChannelPayload(t, channel)
+		if number, _ := payload.Items()[0].Get("number"); number != int64(i) {
+			t.Fatalf("expected payload %d, got %v", i, number)
+		}
+		if payload.EndOfStream() != (i == 9) {
+			t.Fatalf("expected end of stream only for the last payload")
+		}
+		if err := payload.Acknowledge(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if payload, err := channel.Read(); err != nil {
+		t.Fatal(err)
+	} else if payload != nil {
+		t.Fatalf("expected no more payloads")
+	}
+
+	// segments that have been read completely are removed
+	path := channel.InternalWriter.(*writers.LogWriter).Path
+
+	if segments, err := writers.LogSegments(path); err != nil {
+		t.Fatal(err)
+	} else if len(segments) != 1 {
+		t.Fatalf("expected a single segment, got %d", len(segments))
+	}
+
+}
+
+func TestLogRecovery(t *testing.T) {
+
+	var fixtureConfig = []pt.FC{
+		pt.FC{&pf.Settings{}, "settings"},
+		pt.FC{&pf.Controller{}, "controller"},
+		pt.FC{&pf.Project{Name: "test"}, "project"},
+		pt.FC{&pf.Stream{Name: "test", Project: "project"}, "stream"},
+	}
+
+	fixtures, err := pt.SetupFixtures(fixtureConfig)
+	defer pt.TeardownFixtures(fixtureConfig, fixtures)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	controller := fixtures["controller"].(kodex.Controller)
+	stream := fixtures["stream"].(kodex.Stream)
+
+	controller.Settings().Set("internal-channel", map[string]interface{}{
+		"type": "log",
+		"config": map[string]interface{}{
+			"directory": t.TempDir(),
+		},
+	})
+
+	write := func(channel *kodex.InternalChannel, value string) {
+		items := []*kodex.Item{kodex.MakeItem(map[string]interface{}{"value": value})}
+		if err := channel.Write(kodex.MakeBasicPayload(items, map[string]interface{}{}, false)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	channel := setupChannel(t, controller, stream)
+	write(channel, "first")
+	path := channel.InternalWriter.(*writers.LogWriter).Path
+
+	if err := channel.Teardown(); err != nil {
+		t.Fatal(err)
+	}
+
+	// we simulate a crash while writing a record
+	file, err := os.OpenFile(writers.LogSegmentPath(path, 0), os.O_WRONLY|os.O_APPEND, 0600)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := file.Write([]byte{0, 0, 1, 0, 1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	file.Close()
+
+	// the incomplete record is removed when the log is opened again
+	channel = setupChannel(t, controller, stream)
+	defer channel.Teardown()
+
+	write(channel, "second")
+
+	for _, value := range []string{"first", "second"} {
+		payload := readChannelPayload(t, channel)
+		if v, _ := payload.Items()[0].Get("value"); v != value {
+			t.Fatalf("expected %s, got %v", value, v)
+		}
+	}
+}
+
+func TestLogReject(t *testing.T) {
+
+	var fixtureConfig = []pt.FC{
+		pt.FC{&pf.Settings{}, "settings"},
+		pt.FC{&pf.Controller{}, "controller"},
+		pt.FC{&pf.Project{Name: "test"}, "project"},
+		pt.FC{&pf.Stream{Name: "test", Project: "project"}, "stream"},
+	}
+
+	fixtures, err := pt.SetupFixtures(fixtureConfig)
+	defer pt.TeardownFixtures(fixtureConfig, fixtures)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	controller := fixtures["controller"].(kodex.Controller)
+	stream := fixtures["stream"].(kodex.Stream)
+
+	controller.Settings().Set("internal-channel", map[string]interface{}{
+		"type": "log",
+		"config": map[string]interface{}{
+			"directory":    t.TempDir(),
+			"segment-size": int64(1024),
+			"sync":         false,
+		},
+	})
+
+	channel := setupChannel(t, controller, stream)
+
+	for i := 0; i < 4; i++ {
+		// the payloads span several segments
+		items := []*kodex.Item{kodex.MakeItem(map[string]interface{}{
+			"number": int64(i),
+			"data":   bytes.Repeat([]byte{byte(i)}, 400),
+		})}
+		if err := channel.Write(kodex.MakeBasicPayload(items, map[string]interface{}{}, false)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	read := func(expected int64) kodex.Payload {
+		payload := readChannelPayload(t, channel)
+		if number, _ := payload.Items()[0].Get("number"); number != expected {
+			t.Fatalf("expected payload %d, got %v", expected, number)
+		}
+		return payload
+	}
+
+	if err := read(0).Acknowledge(); err != nil {
+		t.Fatal(err)
+	}
+
+	rejected := read(1)
+	later := read(2)
+
+	if err := rejected.Reject(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the payload that was read after the rejected one does not commit
+	// anything, as it will be delivered again
+	if err := later.Acknowledge(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the rejected payload and all payloads after it are delivered again
+	// without a restart
+	for i := int64(1); i < 3; i++ {
+		if err := read(i).Acknowledge(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a payload that is rejected too often is dropped (by default after
+	// five retries)
+	for i := 0; i < 6; i++ {
+		if err := read(3).Reject(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if payload, err := channel.Read(); err != nil {
+		t.Fatal(err)
+	} else if payload != nil {
+		t.Fatalf("expected no more payloads")
+	}
+
+	if err := channel.Teardown(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the rejection does not block the commits
+	channel = setupChannel(t, controller, stream)
+	defer channel.Teardown()
+
+	if payload, err := channel.Read(); err != nil {
+		t.Fatal(err)
+	} else if payload != nil {
+		t.Fatalf("expected no more payloads after a restart")
+	}
+
+}
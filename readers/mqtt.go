@@ -0,0 +1,242 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"errors"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/writers"
+	"time"
+)
+
+/*
+The MQTT reader subscribes to one or more topics (which can contain
+wildcards). Messages are only acknowledged to the broker when the payload
+that contains them is acknowledged. For QoS 1 and 2 and a persistent session
+(i.e. 'clean-session' is false, which requires a fixed 'client-id'), the
+broker delivers messages of rejected or unfinished payloads again when we
+reconnect. Messages with QoS 0 are never delivered again.
+*/
+type MQTTReader struct {
+	writers.MQTTBase
+	Topics       []string
+	CleanSession bool
+	ChunkSize    int
+	TopicField   string
+	Headers      map[string]interface{}
+	messages     chan mqtt.Message
+	stop         chan bool
+}
+
+type MQTTPayload struct {
+	items    []*kodex.Item
+	headers  map[string]interface{}
+	messages []mqtt.Message
+	resolved bool
+}
+
+func (f *MQTTPayload) EndOfStream() bool {
+	return false
+}
+
+func (f *MQTTPayload) Items() []*kodex.Item {
+	return f.items
+}
+
+func (f *MQTTPayload) Headers() map[string]interface{} {
+	return f.headers
+}
+
+func (f *MQTTPayload) Acknowledge() error {
+	if f.resolved {
+		return nil
+	}
+	f.resolved = true
+	for _, message := range f.messages {
+		message.Ack()
+	}
+	return nil
+}
+
+func (f *MQTTPayload) Reject() error {
+	if f.resolved {
+		return nil
+	}
+	f.resolved = true
+	// we do not acknowledge the messages, so the broker will deliver them again
+	kodex.Log.Warning("MQTT payload rejected, its messages will be delivered again after reconnecting (QoS 1 and 2 with a persistent session only)")
+	return nil
+}
+
+func MakeMQTTReader(config map[string]interface{}) (kodex.Reader, error) {
+	if params, err := MQTTReaderForm.Validate(config); err != nil {
+		return nil, err
+	} else {
+		if !params["clean-session"].(bool) && params["client-id"].(string) == "" {
+			// with a random client ID we would never resume the session
+			return nil, errors.New("a persistent session requires a 'client-id'")
+		}
+		base, err := writers.MakeMQTTBase(params)
+		if err != nil {
+			return nil, err
+		}
+		topics := make([]string, 0)
+		for _, topic := range params["topics"].([]interface{}) {
+			topics = append(topics, topic.(string))
+		}
+		if len(topics) == 0 {
+			return nil, errors.New("at least one MQTT topic is required")
+		}
+		return &MQTTReader{
+			MQTTBase:     base,
+			Topics:       topics,
+			CleanSession: params["clean-session"].(bool),
+			ChunkSize:    int(params["chunk-size"].(int64)),
+			TopicField:   params["topic-field"].(string),
+			Headers:      params["headers"].(map[string]interface{}),
+		}, nil
+	}
+}
+
+func (m *MQTTReader) Setup(stream kodex.Stream) error {
+
+	if m.Client != nil {
+		return nil
+	}
+
+	options, err := m.ClientOptions()
+
+	if err != nil {
+		return err
+	}
+
+	messages := make(chan mqtt.Message, m.ChunkSize*2)
+	stop := make(chan bool)
+
+	handler := func(client mqtt.Client, message mqtt.Message) {
+		// we block until the message can be read (or the reader is torn
+		// down), which gives us backpressure towards the broker
+		select {
+		case messages <- message:
+		case <-stop:
+		}
+	}
+
+	filters := make(map[string]byte, len(m.Topics))
+
+	for _, topic := range m.Topics {
+		filters[topic] = m.QoS
+	}
+
+	options.SetAutoAckDisabled(true)
+	// messages need to arrive in order so we can acknowledge them in order
+	options.SetOrderMatters(true)
+	options.SetCleanSession(m.CleanSession)
+	options.SetDefaultPublishHandler(handler)
+	options.SetOnConnectHandler(func(client mqtt.Client) {
+		// we (re-)subscribe every time we connect
+		token := client.SubscribeMultiple(filters, handler)
+		if !token.WaitTimeout(m.Timeout) {
+			kodex.Log.Error("Timeout while subscribing to MQTT topics")
+		} else if err := token.Error(); err != nil {
+			kodex.Log.Errorf("Cannot subscribe to MQTT topics: %v", err)
+		}
+	})
+
+	m.messages = messages
+	m.stop = stop
+
+	if err := m.Connect(options); err != nil {
+		close(stop)
+		return err
+	}
+
+	return nil
+}
+
+func (m *MQTTReader) Purge() error {
+	return nil
+}
+
+func (m *MQTTReader) Teardown() error {
+	if m.stop != nil {
+		close(m.stop)
+		m.stop = nil
+	}
+	return m.MQTTBase.Teardown()
+}
+
+func (m *MQTTReader) makeItems(message mqtt.Message) []*kodex.Item {
+
+	items, err := m.ParseValue(message.Payload())
+
+	if err != nil {
+		// invalid messages are skipped (but still acknowledged with the payload)
+		kodex.Log.Errorf("Cannot parse MQTT message (topic %s): %v", message.Topic(), err)
+		return nil
+	}
+
+	if m.TopicField != "" {
+		for _, item := range items {
+			item.Set(m.TopicField, message.Topic())
+		}
+	}
+
+	return items
+}
+
+func (m *MQTTReader) Read() (kodex.Payload, error) {
+
+	if m.Client == nil {
+		return nil, errors.New("MQTT reader is not set up")
+	}
+
+	headers := make(map[string]interface{}, len(m.Headers))
+	for key, value := range m.Headers {
+		headers[key] = value
+	}
+
+	payload := &MQTTPayload{
+		items:    make([]*kodex.Item, 0),
+		headers:  headers,
+		messages: make([]mqtt.Message, 0),
+	}
+
+	// we wait for the first message...
+	select {
+	case message := <-m.messages:
+		payload.messages = append(payload.messages, message)
+		payload.items = append(payload.items, m.makeItems(message)...)
+	case <-time.After(time.Second):
+		return nil, nil
+	}
+
+	// ...and add all messages that are already waiting
+	for len(payload.messages) < m.ChunkSize {
+		select {
+		case message := <-m.messages:
+			payload.messages = append(payload.messages, message)
+			payload.items = append(payload.items, m.makeItems(message)...)
+			continue
+		default:
+		}
+		break
+	}
+
+	return payload, nil
+}
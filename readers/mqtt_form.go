@@ -0,0 +1,75 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/kodex/writers"
+)
+
+var MQTTReaderForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the MQTT reader form",
+	Fields: append([]forms.Field{
+		{
+			// the topics to subscribe to, which can contain wildcards ('+' and '#')
+			Name: "topics",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsList{
+					Validators: []forms.Validator{
+						forms.IsString{MinLength: 1},
+					},
+				},
+			},
+		},
+		{
+			// with a persistent session (i.e. if this is false), the broker
+			// keeps unacknowledged messages (QoS 1 and 2) while we are
+			// disconnected. This requires a fixed 'client-id', as the broker
+			// identifies the session by it.
+			Name: "clean-session",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: true},
+				forms.IsBoolean{},
+			},
+		},
+		{
+			// the maximum number of messages per payload
+			Name: "chunk-size",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(100)},
+				forms.IsInteger{HasMin: true, Min: 1, HasMax: true, Max: 10000},
+			},
+		},
+		{
+			// the item field that holds the topic of the message (optional)
+			Name: "topic-field",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// headers that are added to all payloads
+			Name: "headers",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: map[string]interface{}{}},
+				forms.IsStringMap{},
+			},
+		},
+	}, writers.MQTTBaseForm.Fields...),
+}
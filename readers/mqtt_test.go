@@ -0,0 +1,204 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers_test
+
+import (
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/readers"
+	"github.com/kiprotect/kodex/writers"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"net"
+	"testing"
+)
+
+// starts an embedded MQTT broker and returns its URL
+func startMQTTBroker(t *testing.T) string {
+
+	// we look for a free port first
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	server := mqtt.New(nil)
+
+	if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.AddListener(listeners.NewTCP("tcp", addr, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.Serve(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { server.Close() })
+
+	return fmt.Sprintf("tcp://%s", addr)
+}
+
+func makeMQTTReader(t *testing.T, broker string) kodex.Reader {
+	reader, err := readers.MakeMQTTReader(map[string]interface{}{
+		"broker":        broker,
+		"client-id":     "kodex-reader",
+		"clean-session": false,
+		"topics":        []interface{}{"devices/+/location"},
+		"topic-field":   "topic",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reader.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+	return reader
+}
+
+func TestMQTT(t *testing.T) {
+
+	broker := startMQTTBroker(t)
+	reader := makeMQTTReader(t, broker)
+
+	writer, err := writers.MakeMQTTWriter(map[string]interface{}{
+		"broker": broker,
+		"topic":  "devices/{device}/location",
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	defer writer.Teardown()
+
+	items := []*kodex.Item{
+		kodex.MakeItem(map[string]interface{}{"device": "a", "lat": 52.5}),
+		kodex.MakeItem(map[string]interface{}{"device": "b", "lat": 48.1}),
+	}
+
+	if err := writer.Write(kodex.MakeBasicPayload(items, map[string]interface{}{}, false)); err != nil {
+		t.Fatal(err)
+	}
+
+	// field values must not change the topic structure
+	for _, device := range []interface{}{"a/b", "#", ""} {
+		item := kodex.MakeItem(map[string]interface{}{"device": device})
+		if err := writer.Write(kodex.MakeBasicPayload([]*kodex.Item{item}, map[string]interface{}{}, false)); err == nil {
+			t.Fatalf("expected an error for device %v", device)
+		}
+	}
+
+	payloads := readKafkaItems(t, reader, 2)
+	received := make([]*kodex.Item, 0)
+
+	for _, payload := range payloads {
+		received = append(received, payload.Items()...)
+	}
+
+	for i, item := range received {
+		device, _ := items[i].Get("device")
+		if topic, _ := item.Get("topic"); topic != fmt.Sprintf("devices/%s/location", device) {
+			t.Fatalf("unexpected topic: %v", topic)
+		}
+		if lat, _ := item.Get("lat"); lat != items[i].All()["lat"] {
+			t.Fatalf("unexpected latitude: %v", lat)
+		}
+	}
+
+	// we reject the first payload...
+	if err := payloads[0].Reject(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, payload := range payloads[1:] {
+		if err := payload.Acknowledge(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reader.Teardown()
+
+	// ...so its messages should be delivered again when we reconnect
+	reader = makeMQTTReader(t, broker)
+	defer reader.Teardown()
+
+	redelivered := readKafkaItems(t, reader, len(payloads[0].Items()))
+	devices := map[interface{}]bool{}
+
+	for _, payload := range redelivered {
+		for _, item := range payload.Items() {
+			device, _ := item.Get("device")
+			devices[device] = true
+		}
+	}
+
+	for _, item := range payloads[0].Items() {
+		if device, _ := item.Get("device"); !devices[device] {
+			t.Fatalf("expected device '%v' to be delivered again", device)
+		}
+	}
+
+	for _, payload := range redelivered {
+		if err := payload.Acknowledge(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMQTTSessionValidation(t *testing.T) {
+
+	config := map[string]interface{}{
+		"broker":        "tcp://localhost:1883",
+		"topics":        []interface{}{"devices/+/location"},
+		"clean-session": false,
+	}
+
+	// a persistent session with a random client ID could never be resumed
+	if _, err := readers.MakeMQTTReader(config); err == nil {
+		t.Fatalf("expected an error for a persistent session without a client ID")
+	}
+
+	config["client-id"] = "kodex-reader"
+
+	if _, err := readers.MakeMQTTReader(config); err != nil {
+		t.Fatal(err)
+	}
+
+	// clean sessions are the default and work with random client IDs
+	reader, err := readers.MakeMQTTReader(map[string]interface{}{
+		"broker": "tcp://localhost:1883",
+		"topics": []interface{}{"devices/+/location"},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reader.(*readers.MQTTReader).CleanSession {
+		t.Fatalf("expected a clean session by default")
+	}
+}
@@ -41,9 +41,49 @@ var Readers = kodex.ReaderDefinitions{
 		Form:     BytesReaderForm,
 		Internal: true,
 	},
+	"http": kodex.ReaderDefinition{
+		Maker:    MakeHTTPReader,
+		Form:     HTTPReaderForm,
+		Internal: false,
+	},
 	"amqp": kodex.ReaderDefinition{
 		Maker:    MakeAMQPReader,
 		Form:     AMQPReaderForm,
 		Internal: false,
 	},
+	"kafka": kodex.ReaderDefinition{
+		Maker:    MakeKafkaReader,
+		Form:     KafkaReaderForm,
+		Internal: false,
+	},
+	"mqtt": kodex.ReaderDefinition{
+		Maker:    MakeMQTTReader,
+		Form:     MQTTReaderForm,
+		Internal: false,
+	},
+	"sql": kodex.ReaderDefinition{
+		Maker:    MakeSQLReader,
+		Form:     SQLReaderForm,
+		Internal: false,
+	},
+	"s3": kodex.ReaderDefinition{
+		Maker:    MakeS3Reader,
+		Form:     S3ReaderForm,
+		Internal: false,
+	},
+	"syslog": kodex.ReaderDefinition{
+		Maker:    MakeSyslogReader,
+		Form:     SyslogReaderForm,
+		Internal: false,
+	},
+	"log": kodex.ReaderDefinition{
+		Maker:    MakeLogReader,
+		Form:     LogReaderForm,
+		Internal: true,
+	},
+	"redis": kodex.ReaderDefinition{
+		Maker:    MakeRedisReader,
+		Form:     RedisReaderForm,
+		Internal: false,
+	},
 }
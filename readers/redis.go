@@ -0,0 +1,291 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"fmt"
+	"github.com/go-redis/redis"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/writers"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+The Redis reader reads payloads from a Redis stream as a member of a consumer
+group. Payloads are only acknowledged in Redis once they have been
+acknowledged by us. After a restart we therefore first deliver the pending
+(i.e. rejected or unfinished) payloads of our consumer again, before
+continuing with new ones (at-least-once delivery). When a payload is
+rejected, we read the pending payloads again as well, so that it is delivered
+again right away (along with the other payloads that are still in flight).
+Payloads that are still rejected after 'max-retries' deliveries are dropped
+(and logged as errors).
+*/
+type RedisReader struct {
+	writers.RedisBase
+	Group      string
+	Consumer   string
+	Block      time.Duration
+	MaxRetries int64
+	// the ID after which we read pending payloads ("" once all were read)
+	pendingID string
+	mutex     sync.Mutex
+	rejected  bool
+	// how often the payloads with the given IDs were rejected
+	retries map[string]int64
+}
+
+type RedisPayload struct {
+	record   *writers.ChannelRecord
+	items    []*kodex.Item
+	reader   *RedisReader
+	id       string
+	resolved bool
+}
+
+func (r *RedisPayload) EndOfStream() bool {
+	return r.record.EndOfStream
+}
+
+func (r *RedisPayload) Items() []*kodex.Item {
+	return r.items
+}
+
+func (r *RedisPayload) Headers() map[string]interface{} {
+	return r.record.Headers
+}
+
+func (r *RedisPayload) Acknowledge() error {
+	if r.resolved {
+		return nil
+	}
+	r.resolved = true
+	r.reader.mutex.Lock()
+	delete(r.reader.retries, r.id)
+	r.reader.mutex.Unlock()
+	return r.reader.acknowledge(r.id)
+}
+
+func (r *RedisReader) acknowledge(id string) error {
+	if r.Client == nil {
+		return fmt.Errorf("Redis reader was torn down")
+	}
+	return r.Client.XAck(r.Stream, r.Group, id).Err()
+}
+
+func (r *RedisPayload) Reject() error {
+	if r.resolved {
+		return nil
+	}
+	r.resolved = true
+	r.reader.mutex.Lock()
+	retries := r.reader.retries[r.id]
+	if retries >= r.reader.MaxRetries {
+		delete(r.reader.retries, r.id)
+		r.reader.mutex.Unlock()
+		kodex.Log.Errorf("Dropping Redis payload %s, as it was rejected %d times", r.id, retries+1)
+		return r.reader.acknowledge(r.id)
+	}
+	// the payload stays pending, so it will be delivered again when we
+	// read the pending payloads (on the next read)
+	r.reader.retries[r.id] = retries + 1
+	r.reader.rejected = true
+	r.reader.mutex.Unlock()
+	kodex.Log.Warning("Redis payload rejected, it will be delivered again")
+	return nil
+}
+
+func MakeRedisReader(config map[string]interface{}) (kodex.Reader, error) {
+	if params, err := RedisReaderForm.Validate(config); err != nil {
+		return nil, err
+	} else {
+		return &RedisReader{
+			RedisBase:  writers.MakeRedisBase(params),
+			Group:      params["group"].(string),
+			Consumer:   params["consumer"].(string),
+			Block:      time.Duration(params["block"].(int64)) * time.Millisecond,
+			MaxRetries: params["max-retries"].(int64),
+			retries:    make(map[string]int64),
+		}, nil
+	}
+}
+
+func (r *RedisReader) Setup(stream kodex.Stream) error {
+	return fmt.Errorf("the Redis reader can only be used as an internal channel")
+}
+
+func (r *RedisReader) SetupWithModel(model kodex.Model) error {
+	if err := r.RedisBase.SetupWithModel(model); err != nil {
+		return err
+	}
+	if err := r.Client.XGroupCreateMkStream(r.Stream, r.Group, "0").Err(); err != nil {
+		// the group might already exist
+		if !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return err
+		}
+	}
+	r.pendingID = "0"
+	return nil
+}
+
+// Returns the number of payloads that our group did not acknowledge yet, i.e.
+// the pending payloads and the ones that were not delivered yet
+func (r *RedisReader) QueueLength() int {
+	if r.Client == nil {
+		return 0
+	}
+	length, err := r.queueLength()
+	if err != nil {
+		kodex.Log.Error(err)
+		return 0
+	}
+	return int(length)
+}
+
+func (r *RedisReader) queueLength() (int64, error) {
+
+	// the client has no method for this command, so we issue it ourselves
+	cmd := redis.NewSliceCmd("xinfo", "groups", r.Stream)
+
+	if err := r.Client.Process(cmd); err != nil {
+		return 0, err
+	}
+
+	for _, group := range cmd.Val() {
+
+		// every group is returned as a list of keys and values
+		fields, _ := group.([]interface{})
+		info := make(map[string]interface{}, len(fields)/2)
+
+		for i := 0; i+1 < len(fields); i += 2 {
+			if key, ok := fields[i].(string); ok {
+				info[key] = fields[i+1]
+			}
+		}
+
+		if info["name"] != r.Group {
+			continue
+		}
+
+		pending, _ := info["pending"].(int64)
+
+		// Redis 7 tells us how many entries were not delivered yet
+		if lag, ok := info["lag"].(int64); ok {
+			return pending + lag, nil
+		}
+
+		lastDeliveredID, ok := info["last-delivered-id"].(string)
+
+		if !ok {
+			return 0, fmt.Errorf("last delivered ID of group %s missing", r.Group)
+		}
+
+		// otherwise we count the entries after the last delivered one
+		messages, err := r.Client.XRange(r.Stream, lastDeliveredID, "+").Result()
+
+		if err != nil {
+			return 0, err
+		}
+
+		undelivered := int64(len(messages))
+
+		if undelivered > 0 && messages[0].ID == lastDeliveredID {
+			undelivered--
+		}
+
+		return pending + undelivered, nil
+	}
+
+	return 0, fmt.Errorf("group %s does not exist", r.Group)
+}
+
+func (r *RedisReader) Purge() error {
+	return nil
+}
+
+func (r *RedisReader) Read() (kodex.Payload, error) {
+
+	if r.Client == nil {
+		return nil, fmt.Errorf("Redis reader is not set up")
+	}
+
+	r.mutex.Lock()
+	if r.rejected {
+		r.rejected = false
+		r.pendingID = "0"
+	}
+	r.mutex.Unlock()
+
+	id := ">"
+	block := r.Block
+
+	if r.pendingID != "" {
+		// we first read the payloads that we received but did not acknowledge
+		id = r.pendingID
+		block = -1
+	}
+
+	streams, err := r.Client.XReadGroup(&redis.XReadGroupArgs{
+		Group:    r.Group,
+		Consumer: r.Consumer,
+		Streams:  []string{r.Stream, id},
+		Count:    1,
+		Block:    block,
+	}).Result()
+
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if len(streams) == 0 || len(streams[0].Messages) == 0 {
+		if r.pendingID != "" {
+			// we continue with new payloads
+			r.pendingID = ""
+			return r.Read()
+		}
+		return nil, nil
+	}
+
+	message := streams[0].Messages[0]
+
+	if r.pendingID != "" {
+		r.pendingID = message.ID
+	}
+
+	data, ok := message.Values["payload"].(string)
+
+	if !ok {
+		return nil, fmt.Errorf("invalid Redis message %s", message.ID)
+	}
+
+	record, err := writers.DecodeChannelRecord([]byte(data))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &RedisPayload{
+		record: record,
+		items:  record.ItemsList(),
+		reader: r,
+		id:     message.ID,
+	}, nil
+}
@@ -0,0 +1,61 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/kodex/writers"
+)
+
+var RedisReaderForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the Redis reader form",
+	Fields: append([]forms.Field{
+		{
+			// the consumer group (payloads are acknowledged for this group)
+			Name: "group",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "kodex"},
+				forms.IsString{},
+			},
+		},
+		{
+			// the name of the consumer within the group. Processes that read
+			// the same channels concurrently need different names.
+			Name: "consumer",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "default"},
+				forms.IsString{},
+			},
+		},
+		{
+			// how long to wait for new payloads (in milliseconds)
+			Name: "block",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(100)},
+				forms.IsInteger{HasMin: true, Min: 1, HasMax: true, Max: 10000},
+			},
+		},
+		{
+			// how often a rejected payload is delivered again
+			Name: "max-retries",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(5)},
+				forms.IsInteger{HasMin: true, Min: 0},
+			},
+		},
+	}, writers.RedisBaseForm.Fields...),
+}
@@ -0,0 +1,116 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers_test
+
+import (
+	"github.com/kiprotect/kodex"
+	pt "github.com/kiprotect/kodex/helpers/testing"
+	pf "github.com/kiprotect/kodex/helpers/testing/fixtures"
+	"testing"
+)
+
+func TestRedisChannel(t *testing.T) {
+
+	var fixtureConfig = []pt.FC{
+		pt.FC{&pf.Settings{}, "settings"},
+		pt.FC{&pf.Controller{}, "controller"},
+		pt.FC{&pf.Project{Name: "test"}, "project"},
+		pt.FC{&pf.Stream{Name: "test", Project: "project"}, "stream"},
+	}
+
+	fixtures, err := pt.SetupFixtures(fixtureConfig)
+	defer pt.TeardownFixtures(fixtureConfig, fixtures)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	controller := fixtures["controller"].(kodex.Controller)
+	stream := fixtures["stream"].(kodex.Stream)
+
+	config, err := controller.Settings().Get("testing.redis")
+
+	if err != nil {
+		kodex.Log.Info("Skipping test, no Redis config specified...")
+		return
+	}
+
+	controller.Settings().Set("internal-channel", map[string]interface{}{
+		"type":   "redis",
+		"config": config,
+	})
+
+	channel := setupChannel(t, controller, stream)
+
+	for _, value := range []string{"first", "second"} {
+		items := []*kodex.Item{kodex.MakeItem(map[string]interface{}{"value": value})}
+		if err := channel.Write(kodex.MakeBasicPayload(items, map[string]interface{}{}, false)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if length := channel.QueueLength(); length != 2 {
+		t.Fatalf("expected a queue length of 2, got %d", length)
+	}
+
+	// we reject the first payload
+	if err := readChannelPayload(t, channel).Reject(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the rejected payload is delivered again right away (we leave it
+	// unfinished this time)
+	payload := readChannelPayload(t, channel)
+
+	if value, _ := payload.Items()[0].Get("value"); value != "first" {
+		t.Fatalf("expected the rejected payload, got %v", value)
+	}
+
+	// we acknowledge the second one
+	payload = readChannelPayload(t, channel)
+
+	if value, _ := payload.Items()[0].Get("value"); value != "second" {
+		t.Fatalf("expected the second payload, got %v", value)
+	}
+
+	if err := payload.Acknowledge(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := channel.Teardown(); err != nil {
+		t.Fatal(err)
+	}
+
+	// after a restart we receive the unfinished payload again
+	channel = setupChannel(t, controller, stream)
+	defer channel.Teardown()
+
+	// the unfinished payload is still pending
+	if length := channel.QueueLength(); length != 1 {
+		t.Fatalf("expected a queue length of 1, got %d", length)
+	}
+
+	payload = readChannelPayload(t, channel)
+
+	if value, _ := payload.Items()[0].Get("value"); value != "first" {
+		t.Fatalf("expected the rejected payload, got %v", value)
+	}
+
+	if err := payload.Acknowledge(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,456 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"context"
+	"errors"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/writers"
+	"github.com/minio/minio-go/v7"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+The S3 reader lists the objects with a given prefix and reads them one after
+the other in key order. Once all items of an object have been acknowledged,
+the object is marked as processed in the state of the source so it won't be
+read again. The state contains a watermark (the last key up to which all
+objects have been processed) and the keys of processed objects beyond it.
+Objects with rejected or unfinished payloads are therefore read again after a
+restart (at-least-once delivery). Objects that are added later with a key
+that sorts before the watermark are not read.
+*/
+type S3Reader struct {
+	writers.S3Base
+	Format       string
+	Compressed   bool
+	ChunkSize    int
+	Follow       bool
+	PollInterval time.Duration
+	KeyField     string
+	Headers      map[string]interface{}
+	source       kodex.Source
+	objects      *s3Objects
+	queue        []string
+	current      *s3Object
+	body         *minio.Object
+	decoder      *ItemDecoder
+	lastPoll     time.Time
+	end          bool
+}
+
+type s3Object struct {
+	key      string
+	pending  int
+	read     bool
+	rejected bool
+}
+
+// Keeps track of objects and stores the keys of processed objects
+type s3Objects struct {
+	mutex     sync.Mutex
+	source    kodex.Source
+	watermark string
+	// sorted keys beyond the watermark that we know of
+	listed    []string
+	seen      map[string]bool
+	processed map[string]bool
+}
+
+// Adds a listed key and returns true if the object should be read
+func (s *s3Objects) list(key string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if key <= s.watermark || s.seen[key] {
+		return false
+	}
+
+	s.seen[key] = true
+
+	i := sort.SearchStrings(s.listed, key)
+	s.listed = append(s.listed, "")
+	copy(s.listed[i+1:], s.listed[i:])
+	s.listed[i] = key
+
+	if s.processed[key] {
+		s.advance()
+		return false
+	}
+
+	return true
+}
+
+// Moves the watermark beyond all contiguous processed keys (the caller must
+// hold the mutex)
+func (s *s3Objects) advance() {
+	for len(s.listed) > 0 && s.processed[s.listed[0]] {
+		s.watermark = s.listed[0]
+		delete(s.processed, s.watermark)
+		delete(s.seen, s.watermark)
+		s.listed = s.listed[1:]
+	}
+	// processed keys from the state that no longer exist
+	for key := range s.processed {
+		if key <= s.watermark {
+			delete(s.processed, key)
+		}
+	}
+}
+
+func (s *s3Objects) add(object *s3Object) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	object.pending++
+}
+
+// Marks an object as (not) fully read
+func (s *s3Objects) setRead(object *s3Object, read bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	object.read = read
+	object.rejected = object.rejected || !read
+	return s.update(object)
+}
+
+func (s *s3Objects) resolve(object *s3Object, rejected bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	object.pending--
+	object.rejected = object.rejected || rejected
+	return s.update(object)
+}
+
+// Marks an object as processed once all of its payloads are acknowledged
+// and stores the watermark and the processed keys beyond it (the caller must
+// hold the mutex)
+func (s *s3Objects) update(object *s3Object) error {
+
+	if !object.read || object.rejected || object.pending > 0 {
+		return nil
+	}
+
+	s.processed[object.key] = true
+	s.advance()
+
+	if s.source == nil {
+		return nil
+	}
+
+	keys := make([]string, 0, len(s.processed))
+
+	for key := range s.processed {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	keysList := make([]interface{}, len(keys))
+
+	for i, key := range keys {
+		keysList[i] = key
+	}
+
+	state := map[string]interface{}{}
+
+	for key, value := range s.source.State() {
+		state[key] = value
+	}
+
+	state["watermark"] = s.watermark
+	state["keys"] = keysList
+
+	if err := s.source.SetState(state); err != nil {
+		return err
+	}
+
+	return s.source.Save()
+}
+
+type S3Payload struct {
+	items       []*kodex.Item
+	headers     map[string]interface{}
+	endOfStream bool
+	object      *s3Object
+	objects     *s3Objects
+	resolved    bool
+}
+
+func (f *S3Payload) EndOfStream() bool {
+	return f.endOfStream
+}
+
+func (f *S3Payload) Items() []*kodex.Item {
+	return f.items
+}
+
+func (f *S3Payload) Headers() map[string]interface{} {
+	return f.headers
+}
+
+func (f *S3Payload) Acknowledge() error {
+	if f.resolved || f.object == nil {
+		return nil
+	}
+	f.resolved = true
+	return f.objects.resolve(f.object, false)
+}
+
+func (f *S3Payload) Reject() error {
+	if f.resolved || f.object == nil {
+		return nil
+	}
+	f.resolved = true
+	// we do not store the key, so the object will be read again
+	kodex.Log.Warningf("S3 payload rejected, object '%s' will be read again after a restart", f.object.key)
+	return f.objects.resolve(f.object, true)
+}
+
+func MakeS3Reader(config map[string]interface{}) (kodex.Reader, error) {
+	if params, err := S3ReaderForm.Validate(config); err != nil {
+		return nil, err
+	} else {
+		base, err := writers.MakeS3Base(params)
+		if err != nil {
+			return nil, err
+		}
+		return &S3Reader{
+			S3Base:       base,
+			Format:       params["format"].(string),
+			Compressed:   params["compressed"].(bool),
+			ChunkSize:    int(params["chunk-size"].(int64)),
+			Follow:       params["follow"].(bool),
+			PollInterval: time.Duration(params["poll-interval"].(int64)) * time.Second,
+			KeyField:     params["key-field"].(string),
+			Headers:      params["headers"].(map[string]interface{}),
+		}, nil
+	}
+}
+
+func (s *S3Reader) SetupWithSource(source kodex.Source) error {
+	s.source = source
+	return s.Setup(nil)
+}
+
+func (s *S3Reader) Setup(stream kodex.Stream) error {
+
+	if s.Client != nil {
+		return nil
+	}
+
+	s.objects = &s3Objects{
+		source:    s.source,
+		seen:      make(map[string]bool),
+		processed: make(map[string]bool),
+	}
+
+	if s.source != nil {
+		state := s.source.State()
+		if watermark, ok := state["watermark"].(string); ok {
+			s.objects.watermark = watermark
+		}
+		// processed keys are moved into the watermark once they are listed
+		if keys, ok := state["keys"].([]interface{}); ok {
+			for _, key := range keys {
+				if strKey, ok := key.(string); ok && strKey > s.objects.watermark {
+					s.objects.processed[strKey] = true
+				}
+			}
+		}
+	}
+
+	s.queue = nil
+	s.lastPoll = time.Time{}
+	s.end = false
+
+	return s.Connect()
+}
+
+func (s *S3Reader) Purge() error {
+	return nil
+}
+
+func (s *S3Reader) Teardown() error {
+	s.closeObject()
+	s.Client = nil
+	return nil
+}
+
+func (s *S3Reader) closeObject() {
+	if s.decoder != nil {
+		s.decoder.Close()
+		s.decoder = nil
+	}
+	if s.body != nil {
+		s.body.Close()
+		s.body = nil
+	}
+	s.current = nil
+}
+
+// Lists all objects that we haven't seen yet
+func (s *S3Reader) list() error {
+
+	ctx, cancel := s.Context()
+	defer cancel()
+
+	for object := range s.Client.ListObjects(ctx, s.Bucket, minio.ListObjectsOptions{
+		Prefix:    s.Prefix,
+		Recursive: true,
+	}) {
+		if object.Err != nil {
+			return object.Err
+		}
+		// we skip "directories" and objects that we have already seen
+		if strings.HasSuffix(object.Key, "/") || !s.objects.list(object.Key) {
+			continue
+		}
+		s.queue = append(s.queue, object.Key)
+	}
+
+	return nil
+}
+
+// Opens the next object, returns false if there is none
+func (s *S3Reader) openObject() (bool, error) {
+
+	if len(s.queue) == 0 {
+
+		if !s.Follow && !s.lastPoll.IsZero() {
+			// we have read all objects
+			s.end = true
+			return false, nil
+		}
+
+		// we wait before listing the objects again (but not too long at a
+		// time so that the reader can be stopped in between)
+		if wait := s.PollInterval - time.Since(s.lastPoll); wait > 0 {
+			if wait > time.Second {
+				wait = time.Second
+			}
+			time.Sleep(wait)
+			return false, nil
+		}
+
+		s.lastPoll = time.Now()
+
+		if err := s.list(); err != nil {
+			return false, err
+		}
+
+		if len(s.queue) == 0 {
+			if !s.Follow {
+				s.end = true
+			}
+			return false, nil
+		}
+	}
+
+	key := s.queue[0]
+	s.queue = s.queue[1:]
+
+	// the object context must remain valid while we read from it
+	body, err := s.Client.GetObject(context.Background(), s.Bucket, key, minio.GetObjectOptions{})
+
+	if err != nil {
+		return false, err
+	}
+
+	decoder, err := MakeItemDecoder(body, s.Format, s.Compressed || strings.HasSuffix(key, ".gz"))
+
+	if err != nil {
+		body.Close()
+		kodex.Log.Errorf("Cannot read S3 object '%s': %v", key, err)
+		return false, nil
+	}
+
+	s.body = body
+	s.decoder = decoder
+	s.current = &s3Object{key: key}
+
+	return true, nil
+}
+
+func (s *S3Reader) Read() (kodex.Payload, error) {
+
+	if s.Client == nil {
+		return nil, errors.New("S3 reader is not set up")
+	}
+
+	headers := make(map[string]interface{}, len(s.Headers))
+	for key, value := range s.Headers {
+		headers[key] = value
+	}
+
+	payload := &S3Payload{
+		headers: headers,
+		objects: s.objects,
+	}
+
+	if s.current == nil && !s.end {
+		if ok, err := s.openObject(); err != nil {
+			return nil, err
+		} else if !ok && !s.end {
+			return nil, nil
+		}
+	}
+
+	if s.end {
+		payload.items = []*kodex.Item{}
+		payload.endOfStream = true
+		return payload, nil
+	}
+
+	object := s.current
+	items, eof, err := s.decoder.Next(s.ChunkSize)
+
+	if err != nil {
+		// we skip invalid objects (they will be read again after a restart)
+		kodex.Log.Errorf("Cannot read S3 object '%s': %v", object.key, err)
+		s.closeObject()
+		return nil, s.objects.setRead(object, false)
+	}
+
+	if s.KeyField != "" {
+		for _, item := range items {
+			item.Set(s.KeyField, object.key)
+		}
+	}
+
+	if len(items) > 0 {
+		s.objects.add(object)
+		payload.items = items
+		payload.object = object
+	}
+
+	if eof {
+		s.closeObject()
+		if err := s.objects.setRead(object, true); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	return payload, nil
+}
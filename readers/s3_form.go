@@ -0,0 +1,83 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/kodex/writers"
+)
+
+var S3ReaderForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the S3 reader form",
+	Fields: append([]forms.Field{
+		{
+			Name: "format",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "json"},
+				forms.IsIn{Choices: []interface{}{"json", "csv"}},
+			},
+		},
+		{
+			// objects with a '.gz' suffix are always decompressed
+			Name: "compressed",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: false},
+				forms.IsBoolean{},
+			},
+		},
+		{
+			// the maximum number of items per payload
+			Name: "chunk-size",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(100)},
+				forms.IsInteger{HasMin: true, Min: 1, HasMax: true, Max: 10000},
+			},
+		},
+		{
+			// if set, we keep polling for new objects instead of ending the
+			// stream once all objects have been read
+			Name: "follow",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: false},
+				forms.IsBoolean{},
+			},
+		},
+		{
+			// how long to wait before listing objects again when following (in seconds)
+			Name: "poll-interval",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(30)},
+				forms.IsInteger{HasMin: true, Min: 1, HasMax: true, Max: 86400},
+			},
+		},
+		{
+			// the item field that holds the key of the object (optional)
+			Name: "key-field",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "headers",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: map[string]interface{}{}},
+				forms.IsStringMap{},
+			},
+		},
+	}, writers.S3BaseForm.Fields...),
+}
@@ -0,0 +1,59 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"testing"
+)
+
+func TestS3Watermark(t *testing.T) {
+
+	objects := &s3Objects{
+		seen:      map[string]bool{},
+		processed: map[string]bool{"c": true},
+	}
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if read := objects.list(key); read == (key == "c") {
+			t.Fatalf("unexpected read status for key '%s'", key)
+		}
+	}
+
+	// objects that are processed out of order are kept beyond the watermark
+	for _, key := range []string{"b", "d"} {
+		if err := objects.update(&s3Object{key: key, read: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if objects.watermark != "" || len(objects.processed) != 3 {
+		t.Fatalf("unexpected watermark '%s' with %v", objects.watermark, objects.processed)
+	}
+
+	if err := objects.update(&s3Object{key: "a", read: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if objects.watermark != "d" || len(objects.processed) != 0 || len(objects.listed) != 0 {
+		t.Fatalf("unexpected watermark '%s' with %v", objects.watermark, objects.processed)
+	}
+
+	// keys before the watermark are not read again
+	if objects.list("b") || !objects.list("e") {
+		t.Fatalf("unexpected read status")
+	}
+}
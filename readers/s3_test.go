@@ -0,0 +1,234 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers_test
+
+import (
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/kiprotect/kodex"
+	pt "github.com/kiprotect/kodex/helpers/testing"
+	pf "github.com/kiprotect/kodex/helpers/testing/fixtures"
+	"github.com/kiprotect/kodex/writers"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// starts a local S3 stand-in with an 'archive' bucket
+func startS3Server(t *testing.T) string {
+	backend := s3mem.New()
+	if err := backend.CreateBucket("archive"); err != nil {
+		t.Fatal(err)
+	}
+	handler := gofakes3.New(backend).Server()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the stand-in treats an empty delimiter as a delimiter when listing
+		// objects (unlike S3 and MinIO), so we remove it
+		if query := r.URL.Query(); query.Has("delimiter") && query.Get("delimiter") == "" {
+			query.Del("delimiter")
+			r.URL.RawQuery = query.Encode()
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func s3Config(endpoint string, extra map[string]interface{}) map[string]interface{} {
+	config := map[string]interface{}{
+		"endpoint":   endpoint,
+		"bucket":     "archive",
+		"prefix":     "items/",
+		"access-key": "kodex",
+		"secret-key": "kodex",
+	}
+	for k, v := range extra {
+		config[k] = v
+	}
+	return config
+}
+
+func writeS3Items(t *testing.T, config map[string]interface{}, items []*kodex.Item) {
+	writer, err := writers.MakeS3Writer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Write(kodex.MakeBasicPayload(items, map[string]interface{}{}, false)); err != nil {
+		t.Fatal(err)
+	}
+	// remaining items are uploaded on teardown
+	if err := writer.Teardown(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// reads all payloads until the end of the stream
+func readS3Payloads(t *testing.T, source kodex.Source) (kodex.Reader, []kodex.Payload) {
+	reader, err := source.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reader.(kodex.StatefulReader).SetupWithSource(source); err != nil {
+		t.Fatal(err)
+	}
+	payloads := make([]kodex.Payload, 0)
+	for i := 0; i < 100; i++ {
+		payload, err := reader.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payload == nil {
+			continue
+		}
+		if payload.EndOfStream() {
+			return reader, payloads
+		}
+		payloads = append(payloads, payload)
+	}
+	t.Fatal("end of stream not reached")
+	return nil, nil
+}
+
+func TestS3(t *testing.T) {
+
+	endpoint := startS3Server(t)
+
+	items := make([]*kodex.Item, 0)
+
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		items = append(items, kodex.MakeItem(map[string]interface{}{"name": name, "value": "1"}))
+	}
+
+	// compressed JSON objects that are rotated by size (3 items each)...
+	writeS3Items(t, s3Config(endpoint, map[string]interface{}{
+		"base-name":       "json",
+		"compress":        true,
+		"max-object-size": 70,
+	}), items)
+
+	// ...and a single CSV object
+	writeS3Items(t, s3Config(endpoint, map[string]interface{}{
+		"base-name":   "csv",
+		"format":      "csv",
+		"csv-columns": []interface{}{"name", "value"},
+	}), items[:2])
+
+	var fixtureConfig = []pt.FC{
+		pt.FC{&pf.Settings{}, "settings"},
+		pt.FC{&pf.Controller{}, "controller"},
+		pt.FC{&pf.Project{Name: "test"}, "project"},
+		pt.FC{&pf.Source{Name: "json", Project: "project", SourceType: "s3", Config: s3Config(endpoint, map[string]interface{}{
+			"prefix":     "items/json",
+			"chunk-size": 2,
+			"key-field":  "key",
+		})}, "jsonSource"},
+		pt.FC{&pf.Source{Name: "csv", Project: "project", SourceType: "s3", Config: s3Config(endpoint, map[string]interface{}{
+			"prefix": "items/csv",
+			"format": "csv",
+		})}, "csvSource"},
+	}
+
+	fixtures, err := pt.SetupFixtures(fixtureConfig)
+	defer pt.TeardownFixtures(fixtureConfig, fixtures)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := fixtures["jsonSource"].(kodex.Source)
+	reader, payloads := readS3Payloads(t, source)
+
+	keys := map[interface{}]int{}
+	n := 0
+
+	for _, payload := range payloads {
+		for _, item := range payload.Items() {
+			key, _ := item.Get("key")
+			keys[key]++
+			n++
+		}
+	}
+
+	if n != 5 || len(keys) != 2 {
+		t.Fatalf("expected 5 items in 2 objects, got %d items in %d objects", n, len(keys))
+	}
+
+	// we reject the last payload, so its object should be read again
+	rejectedKey, _ := payloads[len(payloads)-1].Items()[0].Get("key")
+
+	for i, payload := range payloads {
+		if i == len(payloads)-1 {
+			err = payload.Reject()
+		} else {
+			err = payload.Acknowledge()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reader.Teardown()
+
+	// the first object is processed, the rejected one is not
+	if watermark := source.State()["watermark"]; watermark == rejectedKey || watermark == "" {
+		t.Fatalf("expected the first object as watermark, got %v", watermark)
+	}
+
+	if processed := source.State()["keys"].([]interface{}); len(processed) != 0 {
+		t.Fatalf("expected no processed objects beyond the watermark, got %v", processed)
+	}
+
+	// only the object of the rejected payload is read again
+	reader, payloads = readS3Payloads(t, source)
+	defer reader.Teardown()
+
+	n = 0
+
+	for _, payload := range payloads {
+		n += len(payload.Items())
+		if err := payload.Acknowledge(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n != keys[rejectedKey] {
+		t.Fatalf("expected %d items, got %d", keys[rejectedKey], n)
+	}
+
+	if watermark := source.State()["watermark"]; watermark != rejectedKey {
+		t.Fatalf("expected watermark %v, got %v", rejectedKey, watermark)
+	}
+
+	if processed := source.State()["keys"].([]interface{}); len(processed) != 0 {
+		t.Fatalf("expected no processed objects beyond the watermark, got %v", processed)
+	}
+
+	// CSV objects are decoded using their header row
+	csvReader, payloads := readS3Payloads(t, fixtures["csvSource"].(kodex.Source))
+	defer csvReader.Teardown()
+
+	if len(payloads) != 1 || len(payloads[0].Items()) != 2 {
+		t.Fatalf("expected one payload with 2 items")
+	}
+
+	if name, _ := payloads[0].Items()[1].Get("name"); name != "b" {
+		t.Fatalf("expected name 'b', got %v", name)
+	}
+}
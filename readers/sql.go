@@ -0,0 +1,398 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/writers"
+	"sync"
+	"time"
+)
+
+/*
+The SQL reader pages through the rows of a query, ordered by a cursor column.
+The cursor of the last acknowledged row is stored in the state of the source,
+so a restarted reader continues after it. Rows of rejected or unfinished
+payloads are therefore read again after a restart (at-least-once delivery).
+
+As we continue after the last cursor value, rows that share it would be
+skipped. If the cursor column is not unique (e.g. a timestamp), a tiebreaker
+column (e.g. the primary key) orders the rows with the same cursor value, and
+we page through the query by both columns. Without a tiebreaker, we fail when
+we encounter duplicate cursor values.
+*/
+type SQLReader struct {
+	writers.SQLBase
+	Query        string
+	Cursor       string
+	Tiebreaker   string
+	StartCursor  interface{}
+	ChunkSize    int
+	Follow       bool
+	PollInterval time.Duration
+	Headers      map[string]interface{}
+	source       kodex.Source
+	cursor       interface{}
+	tiebreaker   interface{}
+	lastPoll     time.Time
+	end          bool
+	pages        *sqlPages
+}
+
+type SQLPayload struct {
+	items       []*kodex.Item
+	headers     map[string]interface{}
+	endOfStream bool
+	page        *sqlPage
+	pages       *sqlPages
+	resolved    bool
+}
+
+type sqlPage struct {
+	cursor     interface{}
+	tiebreaker interface{}
+	done       bool
+}
+
+// Keeps track of the pages that were read, so we only store the cursor of
+// pages for which all previous pages have been acknowledged as well.
+type sqlPages struct {
+	mutex   sync.Mutex
+	source  kodex.Source
+	pending []*sqlPage
+}
+
+func (s *sqlPages) add(cursor, tiebreaker interface{}) *sqlPage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	page := &sqlPage{cursor: cursor, tiebreaker: tiebreaker}
+	s.pending = append(s.pending, page)
+	return page
+}
+
+func (s *sqlPages) acknowledge(page *sqlPage) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	page.done = true
+
+	var last *sqlPage
+
+	for len(s.pending) > 0 && s.pending[0].done {
+		last = s.pending[0]
+		s.pending = s.pending[1:]
+	}
+
+	if last == nil || s.source == nil {
+		return nil
+	}
+
+	state := map[string]interface{}{}
+
+	for key, value := range s.source.State() {
+		state[key] = value
+	}
+
+	state["cursor"] = last.cursor
+
+	if last.tiebreaker != nil {
+		state["tiebreaker"] = last.tiebreaker
+	}
+
+	if err := s.source.SetState(state); err != nil {
+		return err
+	}
+
+	return s.source.Save()
+}
+
+func (f *SQLPayload) EndOfStream() bool {
+	return f.endOfStream
+}
+
+func (f *SQLPayload) Items() []*kodex.Item {
+	return f.items
+}
+
+func (f *SQLPayload) Headers() map[string]interface{} {
+	return f.headers
+}
+
+func (f *SQLPayload) Acknowledge() error {
+	if f.resolved || f.page == nil {
+		return nil
+	}
+	f.resolved = true
+	return f.pages.acknowledge(f.page)
+}
+
+func (f *SQLPayload) Reject() error {
+	if f.resolved || f.page == nil {
+		return nil
+	}
+	f.resolved = true
+	// we do not store the cursor, so the rows will be read again
+	kodex.Log.Warning("SQL payload rejected, its rows will be read again after a restart")
+	return nil
+}
+
+func MakeSQLReader(config map[string]interface{}) (kodex.Reader, error) {
+	if params, err := SQLReaderForm.Validate(config); err != nil {
+		return nil, err
+	} else {
+		// the tiebreaker is optional
+		tiebreaker, _ := params["tiebreaker"].(string)
+		return &SQLReader{
+			SQLBase:      writers.MakeSQLBase(params),
+			Query:        params["query"].(string),
+			Cursor:       params["cursor"].(string),
+			Tiebreaker:   tiebreaker,
+			StartCursor:  params["start-cursor"],
+			ChunkSize:    int(params["chunk-size"].(int64)),
+			Follow:       params["follow"].(bool),
+			PollInterval: time.Duration(params["poll-interval"].(int64)) * time.Second,
+			Headers:      params["headers"].(map[string]interface{}),
+		}, nil
+	}
+}
+
+func (s *SQLReader) SetupWithSource(source kodex.Source) error {
+	s.source = source
+	return s.Setup(nil)
+}
+
+func (s *SQLReader) Setup(stream kodex.Stream) error {
+
+	if s.DB != nil {
+		return nil
+	}
+
+	s.cursor = s.StartCursor
+	s.tiebreaker = nil
+
+	if s.source != nil {
+		state := s.source.State()
+		if cursor, ok := state["cursor"]; ok {
+			s.cursor = cursor
+			if s.Tiebreaker != "" {
+				s.tiebreaker = state["tiebreaker"]
+			}
+		}
+	}
+
+	s.pages = &sqlPages{source: s.source}
+	s.lastPoll = time.Time{}
+	s.end = false
+
+	return s.Open()
+}
+
+func (s *SQLReader) Purge() error {
+	return nil
+}
+
+func (s *SQLReader) Teardown() error {
+	return s.SQLBase.Teardown()
+}
+
+// Returns the statement and arguments for reading the next page
+func (s *SQLReader) Statement() (string, []interface{}) {
+
+	cursor := s.Quote(s.Cursor)
+	args := make([]interface{}, 0, 3)
+	statement := fmt.Sprintf("SELECT * FROM (%s) kodex_rows", s.Query)
+
+	if s.cursor != nil && s.tiebreaker != nil {
+		// we continue after the last row with the same cursor value
+		args = append(args, s.cursor, s.cursor, s.tiebreaker)
+		statement += fmt.Sprintf(" WHERE %s > %s OR (%s = %s AND %s > %s)",
+			cursor, s.Placeholder(1),
+			cursor, s.Placeholder(2),
+			s.Quote(s.Tiebreaker), s.Placeholder(3))
+	} else if s.cursor != nil {
+		args = append(args, s.cursor)
+		statement += fmt.Sprintf(" WHERE %s > %s", cursor, s.Placeholder(1))
+	}
+
+	limit := s.ChunkSize
+
+	if s.Tiebreaker != "" {
+		statement += fmt.Sprintf(" ORDER BY %s, %s", cursor, s.Quote(s.Tiebreaker))
+	} else {
+		statement += fmt.Sprintf(" ORDER BY %s", cursor)
+		// we read one more row to see if the next page would start with the
+		// same cursor value (as we would skip that row otherwise)
+		limit++
+	}
+
+	statement += fmt.Sprintf(" LIMIT %d", limit)
+
+	return statement, args
+}
+
+func sameSQLValue(a, b interface{}) bool {
+	if at, ok := a.(time.Time); ok {
+		bt, ok := b.(time.Time)
+		return ok && at.Equal(bt)
+	}
+	return a == b
+}
+
+// Returns the value of the given column, which must not be NULL
+func sqlColumn(itemMap map[string]interface{}, column, kind string) (interface{}, error) {
+	if value, ok := itemMap[column]; !ok {
+		return nil, fmt.Errorf("%s column '%s' is missing in query results", kind, column)
+	} else if value == nil {
+		return nil, fmt.Errorf("%s column '%s' must not be NULL", kind, column)
+	} else {
+		return value, nil
+	}
+}
+
+func (s *SQLReader) readItems() ([]*kodex.Item, interface{}, interface{}, error) {
+
+	ctx, cancel := s.Context()
+	defer cancel()
+
+	statement, args := s.Statement()
+
+	rows, err := s.DB.QueryContext(ctx, statement, args...)
+
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	items := make([]*kodex.Item, 0, s.ChunkSize)
+	var cursor, tiebreaker interface{}
+
+	for rows.Next() {
+
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, nil, nil, err
+		}
+
+		itemMap := make(map[string]interface{}, len(columns))
+
+		for i, column := range columns {
+			itemMap[column] = writers.SQLValue(values[i])
+		}
+
+		rowCursor, err := sqlColumn(itemMap, s.Cursor, "cursor")
+
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		if s.Tiebreaker != "" {
+			if tiebreaker, err = sqlColumn(itemMap, s.Tiebreaker, "tiebreaker"); err != nil {
+				return nil, nil, nil, err
+			}
+		} else if len(items) > 0 && sameSQLValue(rowCursor, cursor) {
+			// the rows are ordered, so duplicates follow each other
+			return nil, nil, nil, fmt.Errorf("cursor column '%s' has duplicate values, please use a unique cursor or specify a tiebreaker", s.Cursor)
+		}
+
+		if len(items) == s.ChunkSize {
+			// this is the additional row, which belongs to the next page
+			break
+		}
+
+		cursor = rowCursor
+		items = append(items, kodex.MakeItem(itemMap))
+	}
+
+	return items, cursor, tiebreaker, rows.Err()
+}
+
+func (s *SQLReader) Read() (kodex.Payload, error) {
+
+	if s.DB == nil {
+		return nil, errors.New("SQL reader is not set up")
+	}
+
+	headers := make(map[string]interface{}, len(s.Headers))
+	for key, value := range s.Headers {
+		headers[key] = value
+	}
+
+	payload := &SQLPayload{
+		headers: headers,
+		pages:   s.pages,
+	}
+
+	if s.end {
+		// we end the stream with an empty payload, since the end of stream
+		// payload is replaced by the source reader (and can't be acknowledged)
+		payload.items = []*kodex.Item{}
+		payload.endOfStream = true
+		return payload, nil
+	}
+
+	// if there were no new rows, we wait before polling again (but not too
+	// long at a time so that the reader can be stopped in between)
+	if wait := s.PollInterval - time.Since(s.lastPoll); wait > 0 {
+		if wait > time.Second {
+			wait = time.Second
+		}
+		time.Sleep(wait)
+		return nil, nil
+	}
+
+	items, cursor, tiebreaker, err := s.readItems()
+
+	if err != nil {
+		return nil, err
+	}
+
+	payload.items = items
+
+	if len(items) < s.ChunkSize {
+		if !s.Follow {
+			// we have read all rows
+			s.end = true
+			payload.endOfStream = len(items) == 0
+		} else if len(items) == 0 {
+			s.lastPoll = time.Now()
+			return nil, nil
+		}
+	}
+
+	if len(items) > 0 {
+		payload.page = s.pages.add(cursor, tiebreaker)
+		s.cursor = cursor
+		s.tiebreaker = tiebreaker
+	}
+
+	return payload, nil
+}
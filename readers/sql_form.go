@@ -0,0 +1,96 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/kodex/writers"
+)
+
+var SQLReaderForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the SQL reader form",
+	Fields: append([]forms.Field{
+		{
+			// the query to read rows from, e.g. 'SELECT * FROM locations'
+			Name: "query",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{MinLength: 1},
+			},
+		},
+		{
+			// a column of the query with monotonically increasing values (e.g.
+			// an auto-incrementing ID), used to page through it
+			Name: "cursor",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{},
+				writers.IsSQLIdentifier,
+			},
+		},
+		{
+			// a column that orders rows with the same cursor value (e.g. the
+			// primary key), required if the cursor values are not unique
+			Name: "tiebreaker",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsString{},
+				writers.IsSQLIdentifier,
+			},
+		},
+		{
+			// the cursor value to start after if the source has no cursor yet
+			Name: "start-cursor",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.CanBeAnything{},
+			},
+		},
+		{
+			// the maximum number of rows per payload
+			Name: "chunk-size",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(100)},
+				forms.IsInteger{HasMin: true, Min: 1, HasMax: true, Max: 10000},
+			},
+		},
+		{
+			// if set, we keep polling for new rows instead of ending the
+			// stream once all rows have been read
+			Name: "follow",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: false},
+				forms.IsBoolean{},
+			},
+		},
+		{
+			// how long to wait before polling again when following (in seconds)
+			Name: "poll-interval",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(5)},
+				forms.IsInteger{HasMin: true, Min: 1, HasMax: true, Max: 86400},
+			},
+		},
+		{
+			Name: "headers",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: map[string]interface{}{}},
+				forms.IsStringMap{},
+			},
+		},
+	}, writers.SQLBaseForm.Fields...),
+}
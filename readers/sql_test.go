@@ -0,0 +1,301 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers_test
+
+import (
+	"database/sql"
+	"github.com/kiprotect/kodex"
+	pt "github.com/kiprotect/kodex/helpers/testing"
+	pf "github.com/kiprotect/kodex/helpers/testing/fixtures"
+	"github.com/kiprotect/kodex/writers"
+	"path/filepath"
+	"testing"
+)
+
+func writeSQLItems(t *testing.T, config map[string]interface{}, items []*kodex.Item) {
+	writer, err := writers.MakeSQLWriter(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Teardown()
+	if err := writer.Write(kodex.MakeBasicPayload(items, map[string]interface{}{}, false)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func makeSQLReader(t *testing.T, source kodex.Source) kodex.Reader {
+	reader, err := source.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	statefulReader, ok := reader.(kodex.StatefulReader)
+	if !ok {
+		t.Fatal("expected a stateful reader")
+	}
+	if err := statefulReader.SetupWithSource(source); err != nil {
+		t.Fatal(err)
+	}
+	return reader
+}
+
+func readSQLIDs(t *testing.T, reader kodex.Reader) ([]int64, kodex.Payload) {
+	payload, err := reader.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]int64, 0)
+	for _, item := range payload.Items() {
+		id, _ := item.Get("id")
+		ids = append(ids, id.(int64))
+	}
+	return ids, payload
+}
+
+func TestSQL(t *testing.T) {
+
+	dsn := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := sql.Open("sqlite", dsn)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE locations (id INTEGER PRIMARY KEY, device TEXT, lat REAL)"); err != nil {
+		t.Fatal(err)
+	}
+
+	writerConfig := map[string]interface{}{
+		"driver": "sqlite",
+		"dsn":    dsn,
+		"table":  "locations",
+		"columns": map[string]interface{}{
+			"id":     "id",
+			"device": "device",
+			"lat":    "position.lat",
+		},
+		"max-batch-size": 2,
+	}
+
+	items := make([]*kodex.Item, 0)
+
+	for i := 1; i <= 5; i++ {
+		items = append(items, kodex.MakeItem(map[string]interface{}{
+			"id":       i,
+			"device":   "a",
+			"position": map[string]interface{}{"lat": 52.5},
+		}))
+	}
+
+	writeSQLItems(t, writerConfig, items)
+
+	// writing the same IDs again fails...
+	writer, err := writers.MakeSQLWriter(writerConfig)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Write(kodex.MakeBasicPayload(items[:1], map[string]interface{}{}, false)); err == nil {
+		t.Fatal("expected a conflict")
+	}
+
+	writer.Teardown()
+
+	// ...unless we perform an upsert
+	writerConfig["mode"] = "upsert"
+	writerConfig["key-columns"] = []interface{}{"id"}
+
+	writeSQLItems(t, writerConfig, []*kodex.Item{
+		kodex.MakeItem(map[string]interface{}{"id": 5, "device": "b"}),
+		kodex.MakeItem(map[string]interface{}{"id": 6, "device": "b"}),
+	})
+
+	var device string
+
+	if err := db.QueryRow("SELECT device FROM locations WHERE id = 5").Scan(&device); err != nil {
+		t.Fatal(err)
+	} else if device != "b" {
+		t.Fatalf("expected device 'b', got '%s'", device)
+	}
+
+	var fixtureConfig = []pt.FC{
+		pt.FC{&pf.Settings{}, "settings"},
+		pt.FC{&pf.Controller{}, "controller"},
+		pt.FC{&pf.Project{Name: "test"}, "project"},
+		pt.FC{&pf.Source{Name: "locations", Project: "project", SourceType: "sql", Config: map[string]interface{}{
+			"driver":     "sqlite",
+			"dsn":        dsn,
+			"query":      "SELECT id, device FROM locations",
+			"cursor":     "id",
+			"chunk-size": 2,
+		}}, "source"},
+	}
+
+	fixtures, err := pt.SetupFixtures(fixtureConfig)
+	defer pt.TeardownFixtures(fixtureConfig, fixtures)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := fixtures["source"].(kodex.Source)
+	reader := makeSQLReader(t, source)
+
+	firstIDs, first := readSQLIDs(t, reader)
+	secondIDs, second := readSQLIDs(t, reader)
+
+	if len(firstIDs) != 2 || firstIDs[0] != 1 || len(secondIDs) != 2 || secondIDs[0] != 3 {
+		t.Fatalf("unexpected IDs: %v, %v", firstIDs, secondIDs)
+	}
+
+	// the cursor is only stored once all previous pages are acknowledged
+	if err := second.Acknowledge(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := source.State()["cursor"]; ok {
+		t.Fatal("expected no cursor")
+	}
+
+	if err := first.Acknowledge(); err != nil {
+		t.Fatal(err)
+	}
+
+	if cursor := source.State()["cursor"]; cursor != int64(4) {
+		t.Fatalf("expected cursor 4, got %v", cursor)
+	}
+
+	reader.Teardown()
+
+	// a new reader continues after the stored cursor
+	reader = makeSQLReader(t, source)
+	defer reader.Teardown()
+
+	ids, payload := readSQLIDs(t, reader)
+
+	if len(ids) != 2 || ids[0] != 5 || ids[1] != 6 || payload.EndOfStream() {
+		t.Fatalf("unexpected IDs: %v", ids)
+	}
+
+	if ids, payload := readSQLIDs(t, reader); len(ids) != 0 || !payload.EndOfStream() {
+		t.Fatalf("expected the end of the stream, got %v", ids)
+	}
+}
+
+func TestSQLDuplicateCursors(t *testing.T) {
+
+	dsn := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := sql.Open("sqlite", dsn)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE events (id INTEGER PRIMARY KEY, created INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the third chunk would start with the last cursor value of the second one
+	if _, err := db.Exec("INSERT INTO events (id, created) VALUES (1, 1), (2, 2), (3, 3), (4, 4), (5, 4), (6, 5)"); err != nil {
+		t.Fatal(err)
+	}
+
+	sourceConfig := func(tiebreaker string) map[string]interface{} {
+		config := map[string]interface{}{
+			"driver":     "sqlite",
+			"dsn":        dsn,
+			"query":      "SELECT id, created FROM events",
+			"cursor":     "created",
+			"chunk-size": 2,
+		}
+		if tiebreaker != "" {
+			config["tiebreaker"] = tiebreaker
+		}
+		return config
+	}
+
+	var fixtureConfig = []pt.FC{
+		pt.FC{&pf.Settings{}, "settings"},
+		pt.FC{&pf.Controller{}, "controller"},
+		pt.FC{&pf.Project{Name: "test"}, "project"},
+		pt.FC{&pf.Source{Name: "events", Project: "project", SourceType: "sql", Config: sourceConfig("")}, "source"},
+		pt.FC{&pf.Source{Name: "events-by-id", Project: "project", SourceType: "sql", Config: sourceConfig("id")}, "tiebreakerSource"},
+	}
+
+	fixtures, err := pt.SetupFixtures(fixtureConfig)
+	defer pt.TeardownFixtures(fixtureConfig, fixtures)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without a tiebreaker, we cannot page through duplicate cursor values
+	reader := makeSQLReader(t, fixtures["source"].(kodex.Source))
+
+	if _, payload := readSQLIDs(t, reader); payload.EndOfStream() {
+		t.Fatalf("expected the first page")
+	}
+
+	if _, err := reader.Read(); err == nil {
+		t.Fatalf("expected an error because of duplicate cursor values")
+	}
+
+	reader.Teardown()
+
+	source := fixtures["tiebreakerSource"].(kodex.Source)
+	reader = makeSQLReader(t, source)
+
+	firstIDs, first := readSQLIDs(t, reader)
+	secondIDs, second := readSQLIDs(t, reader)
+
+	if len(firstIDs) != 2 || firstIDs[0] != 1 || len(secondIDs) != 2 || secondIDs[0] != 3 {
+		t.Fatalf("unexpected IDs: %v, %v", firstIDs, secondIDs)
+	}
+
+	for _, payload := range []kodex.Payload{first, second} {
+		if err := payload.Acknowledge(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if tiebreaker := source.State()["tiebreaker"]; tiebreaker != int64(4) {
+		t.Fatalf("expected tiebreaker 4, got %v", tiebreaker)
+	}
+
+	reader.Teardown()
+
+	// a new reader continues after the last row with the stored cursor value
+	reader = makeSQLReader(t, source)
+	defer reader.Teardown()
+
+	if ids, _ := readSQLIDs(t, reader); len(ids) != 2 || ids[0] != 5 || ids[1] != 6 {
+		t.Fatalf("unexpected IDs: %v", ids)
+	}
+}
@@ -0,0 +1,405 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/writers"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+The syslog reader listens for syslog messages via UDP, TCP or TLS and turns
+them into items (see writers.SyslogMessage). Both RFC 5424 and RFC 3164
+messages are accepted. With TCP and TLS, messages can be framed with octet
+counting or newlines (RFC 6587). Messages that can't be parsed are kept as
+items with only a 'msg' field.
+
+Syslog has no acknowledgements, so messages that have been received but
+not yet processed are lost when the reader stops.
+*/
+type SyslogReader struct {
+	Address        string
+	Protocol       string
+	CertFile       string
+	KeyFile        string
+	ClientCAFile   string
+	ChunkSize      int
+	MaxMessageSize int
+	PeerField      string
+	Headers        map[string]interface{}
+	items          chan *kodex.Item
+	stop           chan bool
+	listener       net.Listener
+	packetConn     net.PacketConn
+	conns          map[net.Conn]bool
+	wg             sync.WaitGroup
+	mutex          sync.Mutex
+}
+
+func MakeSyslogReader(config map[string]interface{}) (kodex.Reader, error) {
+	if params, err := SyslogReaderForm.Validate(config); err != nil {
+		return nil, err
+	} else {
+		reader := &SyslogReader{
+			Address:        params["address"].(string),
+			Protocol:       params["protocol"].(string),
+			CertFile:       params["cert-file"].(string),
+			KeyFile:        params["key-file"].(string),
+			ClientCAFile:   params["client-ca-file"].(string),
+			ChunkSize:      int(params["chunk-size"].(int64)),
+			MaxMessageSize: int(params["max-message-size"].(int64)),
+			PeerField:      params["peer-field"].(string),
+			Headers:        params["headers"].(map[string]interface{}),
+		}
+		if reader.Protocol == "tls" && (reader.CertFile == "" || reader.KeyFile == "") {
+			return nil, fmt.Errorf("the 'tls' protocol requires a certificate and a key file")
+		}
+		if reader.Protocol != "tls" && (reader.CertFile != "" || reader.ClientCAFile != "") {
+			return nil, fmt.Errorf("certificates can only be used with the 'tls' protocol")
+		}
+		return reader, nil
+	}
+}
+
+// Returns the address the reader listens on (useful if the port is chosen
+// automatically, e.g. with 'localhost:0')
+func (s *SyslogReader) Addr() net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.listener != nil {
+		return s.listener.Addr()
+	}
+	if s.packetConn != nil {
+		return s.packetConn.LocalAddr()
+	}
+	return nil
+}
+
+func (s *SyslogReader) Setup(stream kodex.Stream) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.items != nil {
+		return nil
+	}
+
+	if s.Protocol == "udp" {
+		packetConn, err := net.ListenPacket("udp", s.Address)
+		if err != nil {
+			return err
+		}
+		s.packetConn = packetConn
+	} else {
+		listener, err := net.Listen("tcp", s.Address)
+		if err != nil {
+			return err
+		}
+		if s.Protocol == "tls" {
+			config, err := ServerTLSConfig(s.CertFile, s.KeyFile, s.ClientCAFile)
+			if err != nil {
+				listener.Close()
+				return err
+			}
+			listener = tls.NewListener(listener, config)
+		}
+		s.listener = listener
+	}
+
+	s.items = make(chan *kodex.Item, s.ChunkSize*2)
+	s.stop = make(chan bool)
+	s.conns = make(map[net.Conn]bool)
+
+	s.wg.Add(1)
+
+	if s.packetConn != nil {
+		go s.readPackets(s.packetConn)
+		kodex.Log.Infof("Syslog reader listening on %s (udp)...", s.packetConn.LocalAddr())
+	} else {
+		go s.accept(s.listener)
+		kodex.Log.Infof("Syslog reader listening on %s (%s)...", s.listener.Addr(), s.Protocol)
+	}
+
+	return nil
+}
+
+func (s *SyslogReader) Teardown() error {
+
+	s.mutex.Lock()
+
+	if s.stop == nil {
+		s.mutex.Unlock()
+		return nil
+	}
+
+	close(s.stop)
+
+	if s.packetConn != nil {
+		s.packetConn.Close()
+		s.packetConn = nil
+	}
+
+	if s.listener != nil {
+		s.listener.Close()
+		s.listener = nil
+	}
+
+	for conn := range s.conns {
+		conn.Close()
+	}
+
+	s.stop = nil
+	s.items = nil
+
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+
+	return nil
+}
+
+func (s *SyslogReader) Purge() error {
+	return nil
+}
+
+// Passes a message on to Read, returns false if the reader was stopped
+func (s *SyslogReader) deliver(data []byte, peer net.Addr, items chan *kodex.Item, stop chan bool) bool {
+
+	var item *kodex.Item
+
+	if message, err := writers.ParseSyslogMessage(data); err != nil {
+		msg := strings.TrimRight(string(data), "\r\n\x00")
+		if msg == "" {
+			return true
+		}
+		kodex.Log.Debugf("Cannot parse syslog message: %v", err)
+		item = kodex.MakeItem(map[string]interface{}{"msg": msg})
+	} else {
+		item = message.Item()
+	}
+
+	if s.PeerField != "" && peer != nil {
+		host, _, err := net.SplitHostPort(peer.String())
+		if err != nil {
+			host = peer.String()
+		}
+		item.Set(s.PeerField, host)
+	}
+
+	// we block until the item can be read, which gives us backpressure
+	// with TCP and TLS
+	select {
+	case items <- item:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+func (s *SyslogReader) readPackets(packetConn net.PacketConn) {
+
+	defer s.wg.Done()
+
+	s.mutex.Lock()
+	items, stop := s.items, s.stop
+	s.mutex.Unlock()
+
+	buffer := make([]byte, s.MaxMessageSize)
+
+	for {
+		n, peer, err := packetConn.ReadFrom(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			kodex.Log.Errorf("Cannot read syslog message: %v", err)
+			continue
+		}
+		data := make([]byte, n)
+		copy(data, buffer[:n])
+		if !s.deliver(data, peer, items, stop) {
+			return
+		}
+	}
+}
+
+func (s *SyslogReader) accept(listener net.Listener) {
+
+	defer s.wg.Done()
+
+	for {
+		conn, err := listener.Accept()
+
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			kodex.Log.Errorf("Cannot accept syslog connection: %v", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		s.mutex.Lock()
+
+		if s.stop == nil {
+			s.mutex.Unlock()
+			conn.Close()
+			return
+		}
+
+		s.conns[conn] = true
+		items, stop := s.items, s.stop
+		s.wg.Add(1)
+
+		s.mutex.Unlock()
+
+		go s.handle(conn, items, stop)
+	}
+}
+
+func (s *SyslogReader) handle(conn net.Conn, items chan *kodex.Item, stop chan bool) {
+
+	defer s.wg.Done()
+
+	defer func() {
+		conn.Close()
+		s.mutex.Lock()
+		delete(s.conns, conn)
+		s.mutex.Unlock()
+	}()
+
+	reader := bufio.NewReaderSize(conn, s.MaxMessageSize+16)
+
+	for {
+		data, err := s.readFrame(reader)
+
+		if len(data) > 0 && !s.deliver(data, conn.RemoteAddr(), items, stop) {
+			return
+		}
+
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				kodex.Log.Errorf("Cannot read syslog message from %s: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+	}
+}
+
+// Reads a message with octet counting (e.g. '11 <13>1 - ...') or newline
+// framing (RFC 6587)
+func (s *SyslogReader) readFrame(reader *bufio.Reader) ([]byte, error) {
+
+	first, err := reader.Peek(1)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if first[0] >= '0' && first[0] <= '9' {
+
+		prefix, err := reader.ReadSlice(' ')
+
+		if err != nil {
+			if err == bufio.ErrBufferFull {
+				return nil, fmt.Errorf("invalid message length")
+			}
+			return nil, err
+		}
+
+		length, err := strconv.Atoi(string(prefix[:len(prefix)-1]))
+
+		if err != nil || length <= 0 {
+			return nil, fmt.Errorf("invalid message length")
+		}
+
+		if length > s.MaxMessageSize {
+			return nil, fmt.Errorf("message is too long (%d bytes)", length)
+		}
+
+		data := make([]byte, length)
+
+		if _, err := io.ReadFull(reader, data); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, fmt.Errorf("incomplete message")
+			}
+			return nil, err
+		}
+
+		return data, nil
+	}
+
+	line, err := reader.ReadSlice('\n')
+
+	if err == bufio.ErrBufferFull {
+		return nil, fmt.Errorf("message is too long")
+	}
+
+	data := make([]byte, len(line))
+	copy(data, line)
+
+	return data, err
+}
+
+func (s *SyslogReader) Read() (kodex.Payload, error) {
+
+	s.mutex.Lock()
+	items := s.items
+	s.mutex.Unlock()
+
+	if items == nil {
+		return nil, errors.New("syslog reader is not set up")
+	}
+
+	headers := make(map[string]interface{}, len(s.Headers))
+	for key, value := range s.Headers {
+		headers[key] = value
+	}
+
+	payloadItems := make([]*kodex.Item, 0)
+
+	// we wait for the first message...
+	select {
+	case item := <-items:
+		payloadItems = append(payloadItems, item)
+	case <-time.After(time.Second):
+		return nil, nil
+	}
+
+	// ...and add all messages that are already waiting
+	for len(payloadItems) < s.ChunkSize {
+		select {
+		case item := <-items:
+			payloadItems = append(payloadItems, item)
+			continue
+		default:
+		}
+		break
+	}
+
+	return kodex.MakeBasicPayload(payloadItems, headers, false), nil
+}
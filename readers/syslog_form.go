@@ -0,0 +1,97 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"github.com/kiprotect/go-helpers/forms"
+)
+
+var SyslogReaderForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the syslog reader form",
+	Fields: []forms.Field{
+		{
+			// the address to listen on (e.g. ':514')
+			Name: "address",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{MinLength: 1},
+			},
+		},
+		{
+			Name: "protocol",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "udp"},
+				forms.IsIn{Choices: []interface{}{"udp", "tcp", "tls"}},
+			},
+		},
+		{
+			// certificate and key for TLS
+			Name: "cert-file",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "key-file",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// if given, clients need to present a certificate signed by this CA
+			Name: "client-ca-file",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// the maximum number of messages per payload
+			Name: "chunk-size",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(100)},
+				forms.IsInteger{HasMin: true, Min: 1, HasMax: true, Max: 10000},
+			},
+		},
+		{
+			// longer messages are truncated (UDP) or close the connection (TCP and TLS)
+			Name: "max-message-size",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(64 * 1024)},
+				forms.IsInteger{HasMin: true, Min: 480, HasMax: true, Max: 16 * 1024 * 1024},
+			},
+		},
+		{
+			// the item field that holds the IP address of the sender (optional)
+			Name: "peer-field",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// headers that are added to all payloads
+			Name: "headers",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: map[string]interface{}{}},
+				forms.IsStringMap{},
+			},
+		},
+	},
+}
@@ -0,0 +1,189 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers_test
+
+import (
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/readers"
+	"github.com/kiprotect/kodex/writers"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func makeSyslogReader(t *testing.T, protocol string) *readers.SyslogReader {
+	reader, err := readers.MakeSyslogReader(map[string]interface{}{
+		"address":    "127.0.0.1:0",
+		"protocol":   protocol,
+		"peer-field": "peer",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reader.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+	return reader.(*readers.SyslogReader)
+}
+
+func writeSyslogItems(t *testing.T, config map[string]interface{}, items []*kodex.Item) {
+	writer, err := writers.MakeSyslogWriter(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Teardown()
+	if err := writer.Write(kodex.MakeBasicPayload(items, map[string]interface{}{}, false)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyslog(t *testing.T) {
+
+	for _, protocol := range []string{"udp", "tcp"} {
+
+		reader := makeSyslogReader(t, protocol)
+
+		items := []*kodex.Item{
+			kodex.MakeItem(map[string]interface{}{
+				"severity":  int64(3),
+				"timestamp": "2023-10-11T22:14:15.003Z",
+				"hostname":  "host.example.com",
+				"app":       "sshd",
+				"procid":    "4711",
+				"msgid":     "AUTH",
+				"structured_data": map[string]interface{}{
+					"user@32473": map[string]interface{}{
+						"name": "alice",
+						"note": "a \"quoted\" [value]",
+					},
+				},
+				"msg": "failed login\nfrom 10.0.0.1",
+			}),
+			// missing fields are taken from the defaults of the writer
+			kodex.MakeItem(map[string]interface{}{"msg": "hello"}),
+		}
+
+		writeSyslogItems(t, map[string]interface{}{
+			"address":  reader.Addr().String(),
+			"protocol": protocol,
+			"hostname": "relay",
+		}, items)
+
+		payloads := readKafkaItems(t, reader, 2)
+		received := make([]*kodex.Item, 0)
+
+		for _, payload := range payloads {
+			received = append(received, payload.Items()...)
+		}
+
+		expected := map[string]interface{}{
+			"facility":        int64(1),
+			"severity":        int64(3),
+			"timestamp":       "2023-10-11T22:14:15.003Z",
+			"hostname":        "host.example.com",
+			"app":             "sshd",
+			"procid":          "4711",
+			"msgid":           "AUTH",
+			"structured_data": items[0].All()["structured_data"],
+			"msg":             "failed login\nfrom 10.0.0.1",
+			"peer":            "127.0.0.1",
+		}
+
+		if !reflect.DeepEqual(received[0].All(), expected) {
+			t.Fatalf("%s: unexpected item: %v", protocol, received[0].All())
+		}
+
+		if received[1].All()["hostname"] != "relay" || received[1].All()["app"] != "kodex" || received[1].All()["severity"] != int64(6) {
+			t.Fatalf("%s: unexpected item: %v", protocol, received[1].All())
+		}
+
+		if err := reader.Teardown(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSyslogRFC3164(t *testing.T) {
+
+	reader := makeSyslogReader(t, "tcp")
+	defer reader.Teardown()
+
+	conn, err := net.Dial("tcp", reader.Addr().String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cronMessage := "<13>Oct  1 08:00:00 cron: x"
+
+	// newline and octet-counting framing can be mixed
+	messages := "<34>Oct 11 22:14:15 mymachine su[123]: 'su root' failed for lonvick\n" +
+		fmt.Sprintf("%d %s", len(cronMessage), cronMessage) +
+		"not a syslog message\n"
+
+	if _, err := conn.Write([]byte(messages)); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Close()
+
+	payloads := readKafkaItems(t, reader, 3)
+	received := make([]*kodex.Item, 0)
+
+	for _, payload := range payloads {
+		received = append(received, payload.Items()...)
+	}
+
+	first := received[0].All()
+
+	if first["facility"] != int64(4) || first["severity"] != int64(2) || first["hostname"] != "mymachine" || first["app"] != "su" || first["procid"] != "123" || first["msg"] != "'su root' failed for lonvick" {
+		t.Fatalf("unexpected item: %v", first)
+	}
+
+	// without a hostname, the tag comes right after the timestamp
+	if second := received[1].All(); second["hostname"] != nil || second["app"] != "cron" || second["msg"] != "x" {
+		t.Fatalf("unexpected item: %v", second)
+	}
+
+	// messages that can't be parsed are kept
+	if third := received[2].All(); third["msg"] != "not a syslog message" || third["peer"] != "127.0.0.1" {
+		t.Fatalf("unexpected item: %v", third)
+	}
+
+	// the writer can produce RFC 3164 messages as well
+	writeSyslogItems(t, map[string]interface{}{
+		"address":  reader.Addr().String(),
+		"protocol": "tcp",
+		"framing":  "newline",
+		"format":   "rfc3164",
+	}, []*kodex.Item{kodex.MakeItem(map[string]interface{}{
+		"hostname": "relay",
+		"app":      "su",
+		"procid":   "123",
+		"msg":      "'su root' failed for [redacted]",
+	})})
+
+	payloads = readKafkaItems(t, reader, 1)
+
+	if item := payloads[0].Items()[0].All(); item["hostname"] != "relay" || item["app"] != "su" || item["procid"] != "123" || item["msg"] != "'su root' failed for [redacted]" {
+		t.Fatalf("unexpected item: %v", item)
+	}
+}
@@ -0,0 +1,257 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kodex
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A recurring schedule on which the sources of a stream are read (e.g. for
+// nightly batch exports)
+type Recurrence interface {
+	// Returns the first time after the given one at which the schedule fires
+	Next(time.Time) time.Time
+}
+
+// Fires at a fixed interval (e.g. '10m' or '@every 10m')
+type IntervalSchedule struct {
+	Interval time.Duration
+}
+
+func (s *IntervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(s.Interval)
+}
+
+// Fires on a standard cron expression with five fields (minute, hour, day of
+// the month, month and day of the week), e.g. '30 2 * * 1-5'. Fields can
+// contain lists (1,15), ranges (1-5) and steps (*/15 or 0-30/10).
+type CronSchedule struct {
+	Minutes     uint64
+	Hours       uint64
+	DaysOfMonth uint64
+	Months      uint64
+	DaysOfWeek  uint64
+	// if both days of the month and days of the week are restricted, a day
+	// that matches either of them matches (as in cron)
+	AnyDay bool
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of the month", 1, 31},
+	{"month", 1, 12},
+	{"day of the week", 0, 7},
+}
+
+// Parses a schedule, which is either an interval or a cron expression
+func ParseSchedule(schedule string) (Recurrence, error) {
+
+	schedule = strings.TrimSpace(schedule)
+
+	if expression, ok := cronDescriptors[schedule]; ok {
+		schedule = expression
+	}
+
+	if strings.HasPrefix(schedule, "@every ") {
+		return parseInterval(strings.TrimPrefix(schedule, "@every "))
+	}
+
+	if _, err := time.ParseDuration(schedule); err == nil {
+		return parseInterval(schedule)
+	}
+
+	return parseCronExpression(schedule)
+}
+
+func parseInterval(value string) (Recurrence, error) {
+
+	interval, err := time.ParseDuration(strings.TrimSpace(value))
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid interval: %v", err)
+	}
+
+	if interval <= 0 {
+		return nil, fmt.Errorf("the interval must be positive")
+	}
+
+	return &IntervalSchedule{Interval: interval}, nil
+}
+
+func parseCronExpression(expression string) (Recurrence, error) {
+
+	parts := strings.Fields(expression)
+
+	if len(parts) != len(cronFields) {
+		return nil, fmt.Errorf("invalid schedule '%s': expected an interval or a cron expression with %d fields", expression, len(cronFields))
+	}
+
+	values := make([]uint64, len(parts))
+
+	for i, part := range parts {
+		value, err := parseCronField(part, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule '%s': %v", expression, err)
+		}
+		values[i] = value
+	}
+
+	// Sunday can be written as 0 or 7
+	if values[4]&(1<<7) != 0 {
+		values[4] = (values[4] | 1) &^ (1 << 7)
+	}
+
+	schedule := &CronSchedule{
+		Minutes:     values[0],
+		Hours:       values[1],
+		DaysOfMonth: values[2],
+		Months:      values[3],
+		DaysOfWeek:  values[4],
+		// as in cron, a field that starts with '*' (e.g. '*/2') counts as
+		// unrestricted here
+		AnyDay: !strings.HasPrefix(parts[2], "*") && !strings.HasPrefix(parts[4], "*"),
+	}
+
+	// e.g. '0 0 30 2 *' (February 30th) is valid but never fires
+	if schedule.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("invalid schedule '%s': it never fires", expression)
+	}
+
+	return schedule, nil
+}
+
+// Returns the values of a cron field as a bit set
+func parseCronField(value string, field cronField) (uint64, error) {
+
+	var bits uint64
+
+	for _, item := range strings.Split(value, ",") {
+
+		rangeValue, stepValue, hasStep := strings.Cut(item, "/")
+		step := 1
+
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepValue); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step '%s' for the %s", stepValue, field.name)
+			}
+		}
+
+		start, end := field.min, field.max
+
+		if rangeValue != "*" {
+			startValue, endValue, isRange := strings.Cut(rangeValue, "-")
+			var err error
+			if start, err = strconv.Atoi(startValue); err != nil {
+				return 0, fmt.Errorf("invalid value '%s' for the %s", startValue, field.name)
+			}
+			end = start
+			if isRange {
+				if end, err = strconv.Atoi(endValue); err != nil {
+					return 0, fmt.Errorf("invalid value '%s' for the %s", endValue, field.name)
+				}
+			} else if hasStep {
+				// e.g. '5/15' means every 15 minutes starting at minute 5
+				end = field.max
+			}
+		}
+
+		if start < field.min || end > field.max || start > end {
+			return 0, fmt.Errorf("invalid range '%s' for the %s (allowed: %d-%d)", rangeValue, field.name, field.min, field.max)
+		}
+
+		for i := start; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+
+	return bits, nil
+}
+
+func (s *CronSchedule) matchesDay(t time.Time) bool {
+	dayOfMonth := s.DaysOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeek := s.DaysOfWeek&(1<<uint(t.Weekday())) != 0
+	if s.AnyDay {
+		return dayOfMonth || dayOfWeek
+	}
+	return dayOfMonth && dayOfWeek
+}
+
+func (s *CronSchedule) Next(t time.Time) time.Time {
+
+	// we start at the next full minute
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// we give up after a few years (e.g. for February 30th)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.Months&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		} else if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		} else if s.Hours&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		} else if s.Minutes&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+		} else {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// Returns the schedule of a stream (or nil if the stream has none)
+func StreamSchedule(stream Stream) (Recurrence, error) {
+	if stream.Schedule() == "" {
+		return nil, nil
+	}
+	return ParseSchedule(stream.Schedule())
+}
+
+type IsSchedule struct{}
+
+func (i IsSchedule) Validate(value interface{}, values map[string]interface{}) (interface{}, error) {
+	if strValue, ok := value.(string); !ok {
+		return nil, fmt.Errorf("expected a string")
+	} else if strValue == "" {
+		return strValue, nil
+	} else if _, err := ParseSchedule(strValue); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
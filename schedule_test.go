@@ -0,0 +1,76 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kodex_test
+
+import (
+	"github.com/kiprotect/kodex"
+	"testing"
+	"time"
+)
+
+func TestSchedules(t *testing.T) {
+
+	// a Wednesday
+	now := time.Date(2024, 5, 15, 10, 17, 30, 0, time.UTC)
+
+	for _, test := range []struct {
+		schedule string
+		next     time.Time
+	}{
+		{"10m", now.Add(10 * time.Minute)},
+		{"@every 1h30m", now.Add(90 * time.Minute)},
+		{"* * * * *", time.Date(2024, 5, 15, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 5, 15, 10, 30, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2024, 5, 16, 2, 30, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 5, 16, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2024, 5, 15, 13, 0, 0, 0, time.UTC)},
+		{"0 0 * * 6,7", time.Date(2024, 5, 18, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2024, 5, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// if both days are restricted, either of them matches
+		{"0 0 20 * 5", time.Date(2024, 5, 17, 0, 0, 0, 0, time.UTC)},
+		// ...but a field starting with '*' does not count as restricted
+		{"0 0 */2 * 1", time.Date(2024, 5, 27, 0, 0, 0, 0, time.UTC)},
+	} {
+		schedule, err := kodex.ParseSchedule(test.schedule)
+
+		if err != nil {
+			t.Fatalf("cannot parse '%s': %v", test.schedule, err)
+		}
+
+		if next := schedule.Next(now); !next.Equal(test.next) {
+			t.Errorf("expected '%s' to fire at %v, got %v", test.schedule, test.next, next)
+		}
+	}
+
+	for _, schedule := range []string{"", "-5m", "* * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "@sometimes", "0 0 30 2 *", "0 0 31 4,6 *"} {
+		if _, err := kodex.ParseSchedule(schedule); err == nil {
+			t.Errorf("expected an error for '%s'", schedule)
+		}
+	}
+
+	// an empty schedule means that the sources are read continuously
+	if _, err := (kodex.IsSchedule{}).Validate("", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// schedules that never fire are invalid
+	if _, err := (kodex.IsSchedule{}).Validate("0 0 30 2 *", nil); err == nil {
+		t.Fatalf("expected an error for a schedule that never fires")
+	}
+}
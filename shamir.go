@@ -0,0 +1,162 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kodex
+
+import (
+	"fmt"
+)
+
+/*
+Shamir's secret sharing over GF(2^8), using the AES polynomial. Each share
+consists of its x coordinate (one byte) followed by the y coordinates for
+each byte of the secret.
+*/
+
+var gfExp [510]byte
+var gfLog [256]byte
+
+func init() {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		gfExp[i] = x
+		gfExp[i+255] = x
+		gfLog[x] = byte(i)
+		// we multiply by the generator 3
+		x = x ^ gfMulSlow(x, 2)
+	}
+}
+
+func gfMulSlow(a, b byte) byte {
+	var p byte
+	for b > 0 {
+		if b&1 == 1 {
+			p ^= a
+		}
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0x1b
+		}
+		b >>= 1
+	}
+	return p
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// Splits the secret into the given number of shares, of which threshold
+// shares are required to reconstruct the secret.
+func SplitSecret(secret []byte, shares, threshold int) ([][]byte, error) {
+
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("secret is empty")
+	}
+
+	if shares < 2 || shares > 255 {
+		return nil, fmt.Errorf("number of shares must be between 2 and 255")
+	}
+
+	if threshold < 2 || threshold > shares {
+		return nil, fmt.Errorf("threshold must be between 2 and the number of shares")
+	}
+
+	result := make([][]byte, shares)
+
+	for i := range result {
+		result[i] = make([]byte, len(secret)+1)
+		result[i][0] = byte(i + 1)
+	}
+
+	for j, value := range secret {
+		// we generate a random polynomial with the secret as constant term
+		coefficients, err := RandomBytes(threshold - 1)
+		if err != nil {
+			return nil, err
+		}
+		for i := range result {
+			x := result[i][0]
+			// we evaluate the polynomial using Horner's method
+			var y byte
+			for k := len(coefficients) - 1; k >= 0; k-- {
+				y = gfMul(y, x) ^ coefficients[k]
+			}
+			result[i][j+1] = gfMul(y, x) ^ value
+		}
+	}
+
+	return result, nil
+}
+
+// Reconstructs a secret from the given shares. Note that if fewer shares than
+// the threshold are given, the result will be a random value, so it should
+// always be verified.
+func CombineShares(shares [][]byte) ([]byte, error) {
+
+	if len(shares) < 2 {
+		return nil, fmt.Errorf("at least two shares are required")
+	}
+
+	length := len(shares[0])
+
+	if length < 2 {
+		return nil, fmt.Errorf("invalid share")
+	}
+
+	seen := map[byte]bool{}
+
+	for _, share := range shares {
+		if len(share) != length {
+			return nil, fmt.Errorf("shares have different lengths")
+		}
+		if share[0] == 0 || seen[share[0]] {
+			return nil, fmt.Errorf("invalid or duplicate share")
+		}
+		seen[share[0]] = true
+	}
+
+	secret := make([]byte, length-1)
+
+	for j := range secret {
+		// we use Lagrange interpolation to compute the value at x = 0
+		var value byte
+		for i, share := range shares {
+			basis := byte(1)
+			for k, other := range shares {
+				if i == k {
+					continue
+				}
+				basis = gfMul(basis, gfDiv(other[0], other[0]^share[0]))
+			}
+			value ^= gfMul(share[j+1], basis)
+		}
+		secret[j] = value
+	}
+
+	return secret, nil
+}
@@ -0,0 +1,70 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kodex_test
+
+import (
+	"bytes"
+	"github.com/kiprotect/kodex"
+	"testing"
+)
+
+func TestShamir(t *testing.T) {
+
+	secret := []byte("this is a very secret value")
+
+	shares, err := kodex.SplitSecret(secret, 5, 3)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every combination of three shares should work
+	for i := 0; i < 5; i++ {
+		for j := i + 1; j < 5; j++ {
+			for k := j + 1; k < 5; k++ {
+				combined, err := kodex.CombineShares([][]byte{shares[i], shares[j], shares[k]})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(combined, secret) {
+					t.Fatalf("shares %d, %d and %d did not reconstruct the secret", i, j, k)
+				}
+			}
+		}
+	}
+
+	if combined, err := kodex.CombineShares(shares); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(combined, secret) {
+		t.Fatalf("all shares did not reconstruct the secret")
+	}
+
+	if combined, err := kodex.CombineShares(shares[:2]); err != nil {
+		t.Fatal(err)
+	} else if bytes.Equal(combined, secret) {
+		t.Fatalf("two shares should not reconstruct the secret")
+	}
+
+	if _, err := kodex.CombineShares([][]byte{shares[0], shares[0]}); err == nil {
+		t.Fatalf("expected an error for duplicate shares")
+	}
+
+	if _, err := kodex.SplitSecret(secret, 3, 4); err == nil {
+		t.Fatalf("expected an error for an invalid threshold")
+	}
+
+}
@@ -49,6 +49,10 @@ type Source interface {
 	SetData(interface{}) error
 	Data() interface{}
 
+	// State that readers keep for this source (e.g. a cursor)
+	State() map[string]interface{}
+	SetState(map[string]interface{}) error
+
 	Project() Project
 
 	Service() Service
@@ -53,6 +53,10 @@ type Stream interface {
 	SetName(string) error
 	Description() string
 	SetDescription(string) error
+	// the schedule on which the sources of the stream are read (a cron
+	// expression or an interval, empty if they are read continuously)
+	Schedule() string
+	SetSchedule(string) error
 
 	SetData(interface{}) error
 	Data() interface{}
@@ -146,6 +150,7 @@ func (b *BaseStream) MarshalJSON() ([]byte, error) {
 		"name":        b.Self.Name(),
 		"status":      b.Self.Status(),
 		"description": b.Self.Description(),
+		"schedule":    b.Self.Schedule(),
 		"projectID":   hex.EncodeToString(b.Self.Project().ID()),
 		"data":        b.Self.Data(),
 		"configs":     configs,
@@ -170,6 +175,8 @@ func (b *BaseStream) update(params map[string]interface{}) error {
 			err = b.Self.SetName(value.(string))
 		case "description":
 			err = b.Self.SetDescription(value.(string))
+		case "schedule":
+			err = b.Self.SetSchedule(value.(string))
 		case "data":
 			err = b.Self.SetData(value)
 		}
@@ -217,9 +224,17 @@ var StreamForm = forms.Form{
 			Validators: append([]forms.Validator{
 				forms.IsOptional{Default: ""}}, DescriptionValidators...),
 		},
+		{
+			Name: "schedule",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+				IsSchedule{},
+			},
+		},
 		{
 			Name:       "data",
-			Validators: []forms.Validator{forms.IsOptional{}, forms.IsStringMap{}},
+			Validators: []forms.Validator{forms.IsOptional{}, forms.IsStringMap{}, IsProcessingData{Partitioned: true}},
 		},
 	},
 }
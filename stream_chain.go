@@ -0,0 +1,254 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kodex
+
+import (
+	"fmt"
+	"github.com/kiprotect/go-helpers/forms"
+	"strings"
+	"time"
+)
+
+// Destinations of this type write to the internal channel of another stream
+// of the same project, which processes the items further (stream chaining).
+const StreamDestinationType = "stream"
+
+var StreamWriterForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the stream writer form",
+	Fields: []forms.Field{
+		{
+			// the name of the stream to write to
+			Name: "stream",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{MinLength: 1},
+			},
+		},
+		{
+			// how many payloads may wait in the channel of the stream before
+			// writes block (0 for no limit)
+			Name: "max-pending",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(64)},
+				forms.IsInteger{HasMin: true, Min: 0},
+			},
+		},
+		{
+			// how long a write may block (in milliseconds) before it fails
+			Name: "timeout",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(60000)},
+				forms.IsInteger{HasMin: true, Min: 1},
+			},
+		},
+	},
+}
+
+// Writes payloads to the internal channel of another stream. If the stream
+// does not keep up, writes block, which in turn blocks the workers of the
+// writing stream (backpressure).
+type StreamWriter struct {
+	Stream     string
+	MaxPending int
+	Timeout    time.Duration
+	channel    *InternalChannel
+}
+
+func MakeStreamWriter(config map[string]interface{}) (Writer, error) {
+	params, err := StreamWriterForm.Validate(config)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamWriter{
+		Stream:     params["stream"].(string),
+		MaxPending: int(params["max-pending"].(int64)),
+		Timeout:    time.Duration(params["timeout"].(int64)) * time.Millisecond,
+	}, nil
+}
+
+func (s *StreamWriter) Setup(config Config) error {
+	stream, err := chainedStream(config.Stream().Project(), s.Stream)
+	if err != nil {
+		return err
+	}
+	channel := MakeInternalChannel()
+	if err := channel.Setup(stream.Project().Controller(), stream); err != nil {
+		return err
+	}
+	s.channel = channel
+	return nil
+}
+
+func (s *StreamWriter) Teardown() error {
+	if s.channel == nil {
+		return nil
+	}
+	err := s.channel.Teardown()
+	s.channel = nil
+	return err
+}
+
+// The end of the writing stream is not the end of the stream we write to
+// (which might have other sources), so we only pass on the items.
+func (s *StreamWriter) Write(payload Payload) error {
+
+	if s.channel == nil {
+		return fmt.Errorf("stream writer is not set up")
+	}
+
+	if payload.EndOfStream() && len(payload.Items()) == 0 {
+		return payload.Acknowledge()
+	}
+
+	if s.MaxPending > 0 {
+		deadline := time.Now().Add(s.Timeout)
+		for s.channel.QueueLength() >= s.MaxPending {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("stream '%s' is not accepting payloads", s.Stream)
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
+
+	if payload.EndOfStream() {
+		lineage := MakeLineage(payload)
+		defer lineage.Acknowledge()
+		payload = lineage.Derive(payload.Items(), payload.Headers(), false)
+	}
+
+	return s.channel.Write(payload)
+}
+
+// Returns the name of the stream a destination writes to (if any)
+func ChainedStreamName(destination Destination) (string, bool) {
+	if destination.DestinationType() != StreamDestinationType {
+		return "", false
+	}
+	name, ok := destination.ConfigData()["stream"].(string)
+	return name, ok
+}
+
+func chainedStream(project Project, name string) (Stream, error) {
+	streams, err := project.Controller().Streams(map[string]interface{}{
+		"project.id": project.ID(),
+		"name":       name,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(streams) != 1 {
+		return nil, fmt.Errorf("stream '%s' does not exist", name)
+	}
+	return streams[0], nil
+}
+
+// Returns the streams to which a stream writes via its (not disabled)
+// destinations
+func ChainedStreams(stream Stream) ([]Stream, error) {
+
+	configs, err := stream.Configs()
+
+	if err != nil {
+		return nil, err
+	}
+
+	streams := make([]Stream, 0)
+	names := map[string]bool{}
+
+	for _, config := range configs {
+		destinations, err := config.Destinations()
+		if err != nil {
+			return nil, err
+		}
+		for _, destinationMaps := range destinations {
+			for _, destinationMap := range destinationMaps {
+				if destinationMap.Status() == DisabledDestination {
+					continue
+				}
+				name, ok := ChainedStreamName(destinationMap.Destination())
+				if !ok || names[name] {
+					continue
+				}
+				chained, err := chainedStream(stream.Project(), name)
+				if err != nil {
+					return nil, err
+				}
+				names[name] = true
+				streams = append(streams, chained)
+			}
+		}
+	}
+
+	return streams, nil
+}
+
+// Checks that the streams of a project do not form a cycle via stream
+// destinations, as items would be processed forever
+func CheckStreamChains(project Project) error {
+
+	streams, err := project.Controller().Streams(map[string]interface{}{"project.id": project.ID()})
+
+	if err != nil {
+		return err
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+
+	state := map[string]int{}
+	path := make([]string, 0)
+
+	var visit func(stream Stream) error
+
+	visit = func(stream Stream) error {
+		name := stream.Name()
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			for i, pathName := range path {
+				if pathName == name {
+					return fmt.Errorf("streams form a cycle: %s", strings.Join(append(path[i:], name), " -> "))
+				}
+			}
+		}
+		state[name] = visiting
+		path = append(path, name)
+		chained, err := ChainedStreams(stream)
+		if err != nil {
+			return fmt.Errorf("error checking stream '%s': %v", name, err)
+		}
+		for _, chainedStream := range chained {
+			if err := visit(chainedStream); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		return nil
+	}
+
+	for _, stream := range streams {
+		if err := visit(stream); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,435 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kodex
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/go-helpers/maps"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+	"sync"
+	"time"
+)
+
+/*
+The undo guard implements a four-eyes principle for undoing actions (e.g.
+depseudonymization). 'kodex shares generate' creates an X25519 key pair and
+splits the private key into N shares using Shamir's secret sharing. Only the
+public key is stored in the settings:
+
+	undo:
+	  threshold: 2
+	  public-key: [hex value printed by 'kodex shares generate']
+	  session-ttl: 900 # seconds
+
+If an undo guard is configured, action parameters are only stored sealed
+with the public key (see UndoGuard.ParameterGroup). The process that
+generated the parameters keeps them in memory, but all other processes (and
+anyone with access to the parameter store) need the private key, and hence
+M of the shares, to undo actions. As a consequence, each process generates
+its own parameters, so pseudonyms are not consistent between processes.
+Actions can't use a key or a root secret either, as anyone holding them could
+undo actions without the shares.
+
+Shares can be provided to the whole process (e.g. via the command line),
+which unlocks undo operations until the process exits, or collected in an
+undo session (e.g. via the API), one share per holder. A session can only be
+used once and only by the user that created it, and it expires after the
+session TTL.
+*/
+type UndoGuard struct {
+	threshold  int
+	publicKey  *[32]byte
+	privateKey *[32]byte
+	sessionTTL time.Duration
+	// binds the sealed parameters to this process
+	epoch []byte
+	// the parameters that this process has sealed
+	sealed   map[string]interface{}
+	sessions map[string]*UndoSession
+	mutex    sync.Mutex
+}
+
+type UndoSession struct {
+	ID         []byte
+	ExpiresAt  time.Time
+	Unlocked   bool
+	creator    string
+	privateKey *[32]byte
+	shares     map[string][]byte
+}
+
+func (u *UndoSession) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"id":         hex.EncodeToString(u.ID),
+		"expires_at": u.ExpiresAt,
+		"unlocked":   u.Unlocked,
+		"shares":     len(u.shares),
+	})
+}
+
+type IsUndoPublicKey struct{}
+
+func (f IsUndoPublicKey) Validate(input interface{}, values map[string]interface{}) (interface{}, error) {
+	if key, ok := input.([]byte); !ok || len(key) != 32 {
+		return nil, fmt.Errorf("expected a 32 byte public key")
+	}
+	return input, nil
+}
+
+var UndoGuardForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the undo settings",
+	Fields: []forms.Field{
+		{
+			Name: "threshold",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsInteger{HasMin: true, Min: 2, HasMax: true, Max: 255},
+			},
+		},
+		{
+			Name: "public-key",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsBytes{Encoding: "hex"},
+				IsUndoPublicKey{},
+			},
+		},
+		{
+			Name: "session-ttl",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(900)},
+				forms.IsInteger{HasMin: true, Min: 1},
+			},
+		},
+	},
+}
+
+var undoGuardMutex sync.Mutex
+
+// Returns the undo guard for the given controller, or nil if no undo guard
+// is configured. The guard is stored as a controller variable so that it
+// is shared between all users of the controller.
+func GetUndoGuard(controller Controller) (*UndoGuard, error) {
+	undoGuardMutex.Lock()
+	defer undoGuardMutex.Unlock()
+
+	if guard, ok := controller.GetVar("undo-guard"); ok {
+		return guard.(*UndoGuard), nil
+	}
+
+	guard, err := MakeUndoGuard(controller.Settings())
+
+	if err != nil {
+		return nil, err
+	}
+
+	if guard == nil {
+		return nil, nil
+	}
+
+	if err := controller.SetVar("undo-guard", guard); err != nil {
+		return nil, err
+	}
+
+	return guard, nil
+}
+
+// Creates an undo guard from the settings. Returns nil if the settings do
+// not contain an undo configuration.
+func MakeUndoGuard(settings Settings) (*UndoGuard, error) {
+	config, err := settings.Get("undo")
+
+	if err != nil {
+		return nil, nil
+	}
+
+	configMap, ok := maps.ToStringMap(config)
+
+	if !ok {
+		return nil, fmt.Errorf("not a valid undo config")
+	}
+
+	params, err := UndoGuardForm.Validate(configMap)
+
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey := &[32]byte{}
+	copy(publicKey[:], params["public-key"].([]byte))
+
+	return &UndoGuard{
+		threshold:  int(params["threshold"].(int64)),
+		publicKey:  publicKey,
+		sessionTTL: time.Duration(params["session-ttl"].(int64)) * time.Second,
+		epoch:      RandomID(),
+		sealed:     map[string]interface{}{},
+		sessions:   map[string]*UndoSession{},
+	}, nil
+}
+
+// Generates a key pair for an undo guard. The private key should be split
+// into shares (see SplitSecret) and then discarded.
+func GenerateUndoKey() ([]byte, []byte, error) {
+	publicKey, privateKey, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return publicKey[:], privateKey[:], nil
+}
+
+func (u *UndoGuard) Threshold() int {
+	return u.threshold
+}
+
+// Rebuilds the private key from the given shares
+func (u *UndoGuard) combine(shares [][]byte) (*[32]byte, error) {
+	if len(shares) < u.threshold {
+		return nil, fmt.Errorf("at least %d shares are required", u.threshold)
+	}
+	secret, err := CombineShares(shares)
+	if err != nil {
+		return nil, err
+	}
+	if len(secret) != 32 {
+		return nil, fmt.Errorf("the given shares are invalid")
+	}
+	publicKey, err := curve25519.X25519(secret, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(publicKey, u.publicKey[:]) != 1 {
+		return nil, fmt.Errorf("the given shares are invalid")
+	}
+	privateKey := &[32]byte{}
+	copy(privateKey[:], secret)
+	return privateKey, nil
+}
+
+// Unlocks undo operations for the whole process, until it exits
+func (u *UndoGuard) Unlock(shares [][]byte) error {
+	privateKey, err := u.combine(shares)
+	if err != nil {
+		return err
+	}
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.privateKey = privateKey
+	return nil
+}
+
+// Checks if undo operations are permitted for the whole process
+func (u *UndoGuard) Unlocked() error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if u.privateKey != nil {
+		return nil
+	}
+	return fmt.Errorf("undo is locked, %d shares are required to unlock it", u.threshold)
+}
+
+// Seals data with the public key, so that it can only be opened with the
+// private key (i.e. with the shares)
+func (u *UndoGuard) Seal(data []byte) ([]byte, error) {
+	return box.SealAnonymous(nil, data, u.publicKey, rand.Reader)
+}
+
+// Opens sealed data, which requires the process to be unlocked
+func (u *UndoGuard) Open(sealed []byte) ([]byte, error) {
+	u.mutex.Lock()
+	privateKey := u.privateKey
+	u.mutex.Unlock()
+	if privateKey == nil {
+		return nil, fmt.Errorf("undo is locked, %d shares are required to unlock it", u.threshold)
+	}
+	return u.open(sealed, privateKey)
+}
+
+func (u *UndoGuard) open(sealed []byte, privateKey *[32]byte) ([]byte, error) {
+	if data, ok := box.OpenAnonymous(nil, sealed, u.publicKey, privateKey); !ok {
+		return nil, fmt.Errorf("cannot open sealed data")
+	} else {
+		return data, nil
+	}
+}
+
+// Returns the parameter group under which this process stores its sealed
+// parameters. As other processes can't open them, every process needs its
+// own parameters.
+func (u *UndoGuard) ParameterGroup(parameterGroup *ParameterGroup) (*ParameterGroup, error) {
+	data := map[string]interface{}{
+		"group":  parameterGroup.Data(),
+		"sealed": hex.EncodeToString(u.epoch),
+	}
+	hash, err := StructuredHash(map[string]interface{}{
+		"group":  parameterGroup.Hash(),
+		"sealed": u.epoch,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ParameterGroup{data: data, hash: hash}, nil
+}
+
+// Seals the given parameters and keeps them in memory, so that this process
+// can use them without the private key
+func (u *UndoGuard) sealParameters(id []byte, params interface{}) ([]byte, error) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := u.Seal(data)
+	if err != nil {
+		return nil, err
+	}
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.sealed[string(id)] = params
+	return sealed, nil
+}
+
+// Returns the content of sealed parameters, which requires the process to
+// be unlocked unless the parameters were sealed by this process
+func (u *UndoGuard) OpenParameters(parameters *Parameters) (interface{}, error) {
+	u.mutex.Lock()
+	params, ok := u.sealed[string(parameters.ID())]
+	u.mutex.Unlock()
+	if ok {
+		return params, nil
+	}
+	data, err := u.Open(parameters.Sealed())
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+func (u *UndoGuard) expireSessions() {
+	now := time.Now()
+	for id, session := range u.sessions {
+		if now.After(session.ExpiresAt) {
+			delete(u.sessions, id)
+		}
+	}
+}
+
+// Creates a new undo session for the given user, which expires after the
+// session TTL
+func (u *UndoGuard) MakeSession(creator string) *UndoSession {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.expireSessions()
+	session := &UndoSession{
+		ID:        RandomID(),
+		ExpiresAt: time.Now().Add(u.sessionTTL),
+		creator:   creator,
+		shares:    map[string][]byte{},
+	}
+	u.sessions[string(session.ID)] = session
+	return session
+}
+
+func (u *UndoGuard) Session(id []byte) (*UndoSession, error) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.expireSessions()
+	if session, ok := u.sessions[string(id)]; !ok {
+		return nil, fmt.Errorf("undo session not found or expired")
+	} else {
+		return session, nil
+	}
+}
+
+// Adds a share from the given holder to an undo session. Each holder can
+// only provide a single share. Once enough shares are present, the session
+// gets unlocked (if the shares are valid) and its expiration time is reset.
+func (u *UndoGuard) AddShare(id []byte, holder string, share []byte) (*UndoSession, error) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.expireSessions()
+
+	session, ok := u.sessions[string(id)]
+
+	if !ok {
+		return nil, fmt.Errorf("undo session not found or expired")
+	}
+
+	if session.Unlocked {
+		return session, nil
+	}
+
+	if _, ok := session.shares[holder]; ok {
+		return nil, fmt.Errorf("a share was already provided by this holder")
+	}
+
+	session.shares[holder] = share
+
+	if len(session.shares) < u.threshold {
+		return session, nil
+	}
+
+	shares := make([][]byte, 0, len(session.shares))
+
+	for _, share := range session.shares {
+		shares = append(shares, share)
+	}
+
+	privateKey, err := u.combine(shares)
+
+	if err != nil {
+		// we discard the session as we can't tell which share is invalid
+		delete(u.sessions, string(id))
+		return nil, err
+	}
+
+	session.Unlocked = true
+	session.privateKey = privateKey
+	session.ExpiresAt = time.Now().Add(u.sessionTTL)
+
+	return session, nil
+}
+
+// Opens sealed data with an unlocked undo session. Only the user that
+// created the session can use it, and only once.
+func (u *UndoGuard) OpenWithSession(id []byte, user string, sealed []byte) ([]byte, error) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.expireSessions()
+
+	session, ok := u.sessions[string(id)]
+
+	if !ok || session.creator != user {
+		return nil, fmt.Errorf("undo session not found or expired")
+	}
+
+	if !session.Unlocked {
+		return nil, fmt.Errorf("undo session is not unlocked yet, %d shares are required", u.threshold)
+	}
+
+	delete(u.sessions, string(id))
+
+	return u.open(sealed, session.privateKey)
+}
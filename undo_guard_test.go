@@ -0,0 +1,305 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kodex_test
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/actions"
+	pt "github.com/kiprotect/kodex/helpers/testing"
+	pf "github.com/kiprotect/kodex/helpers/testing/fixtures"
+	"github.com/kiprotect/kodex/parameters"
+	"strings"
+	"testing"
+	"time"
+)
+
+func undoSettings(t *testing.T, sessionTTL int) (map[string]interface{}, [][]byte) {
+
+	publicKey, privateKey, err := kodex.GenerateUndoKey()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shares, err := kodex.SplitSecret(privateKey, 3, 2)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]interface{}{
+		"threshold":   2,
+		"public-key":  hex.EncodeToString(publicKey),
+		"session-ttl": sessionTTL,
+	}, shares
+}
+
+func TestUndoGuard(t *testing.T) {
+
+	var fixtureConfig = []pt.FC{
+		pt.FC{&pf.Settings{}, "settings"},
+		pt.FC{&pf.Controller{}, "controller"},
+	}
+
+	fixtures, err := pt.SetupFixtures(fixtureConfig)
+	defer pt.TeardownFixtures(fixtureConfig, fixtures)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	controller := fixtures["controller"].(kodex.Controller)
+
+	if guard, err := kodex.GetUndoGuard(controller); err != nil {
+		t.Fatal(err)
+	} else if guard != nil {
+		t.Fatalf("expected no undo guard without settings")
+	}
+
+	settings, shares := undoSettings(t, 1)
+	_, otherShares := undoSettings(t, 1)
+
+	controller.Settings().Set("undo", settings)
+
+	guard, err := kodex.GetUndoGuard(controller)
+
+	if err != nil {
+		t.Fatal(err)
+	} else if guard == nil {
+		t.Fatalf("expected an undo guard")
+	}
+
+	if err := guard.Unlocked(); err == nil {
+		t.Fatalf("guard should be locked")
+	}
+
+	if err := guard.Unlock(shares[:1]); err == nil {
+		t.Fatalf("a single share should not unlock the guard")
+	}
+
+	if err := guard.Unlock(otherShares[:2]); err == nil {
+		t.Fatalf("shares of another secret should not unlock the guard")
+	}
+
+	if err := guard.Unlock([][]byte{shares[0], shares[2]}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := guard.Unlocked(); err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := guard.Seal([]byte("key"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session := guard.MakeSession("alice")
+
+	if _, err := guard.OpenWithSession(session.ID, "alice", sealed); err == nil {
+		t.Fatalf("session should not be unlocked yet")
+	}
+
+	if _, err := guard.AddShare(session.ID, "alice", shares[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := guard.AddShare(session.ID, "alice", shares[1]); err == nil {
+		t.Fatalf("a holder should only be able to add a single share")
+	}
+
+	if session, err := guard.AddShare(session.ID, "bob", shares[1]); err != nil {
+		t.Fatal(err)
+	} else if !session.Unlocked {
+		t.Fatalf("session should be unlocked")
+	}
+
+	// only the creator of the session can use it, and only once
+	if _, err := guard.OpenWithSession(session.ID, "bob", sealed); err == nil {
+		t.Fatalf("only the creator should be able to use the session")
+	}
+
+	if key, err := guard.OpenWithSession(session.ID, "alice", sealed); err != nil {
+		t.Fatal(err)
+	} else if string(key) != "key" {
+		t.Fatalf("unexpected key: %s", key)
+	}
+
+	if _, err := guard.OpenWithSession(session.ID, "alice", sealed); err == nil {
+		t.Fatalf("the session should only be usable once")
+	}
+
+	// invalid shares discard the session
+	session = guard.MakeSession("alice")
+
+	guard.AddShare(session.ID, "alice", shares[0])
+
+	if _, err := guard.AddShare(session.ID, "bob", otherShares[1]); err == nil {
+		t.Fatalf("expected an error for an invalid share")
+	}
+
+	if _, err := guard.Session(session.ID); err == nil {
+		t.Fatalf("session should have been discarded")
+	}
+
+	// sessions expire after the session TTL, while unlocking the process
+	// lasts until it exits
+	session = guard.MakeSession("alice")
+
+	guard.AddShare(session.ID, "alice", shares[0])
+	guard.AddShare(session.ID, "bob", shares[1])
+
+	time.Sleep(1100 * time.Millisecond)
+
+	if _, err := guard.OpenWithSession(session.ID, "alice", sealed); err == nil {
+		t.Fatalf("session should have expired")
+	}
+
+	if err := guard.Unlocked(); err != nil {
+		t.Fatalf("process unlock should not expire: %v", err)
+	}
+}
+
+func TestSealedParameters(t *testing.T) {
+
+	var fixtureConfig = []pt.FC{
+		pt.FC{&pf.Settings{}, "settings"},
+	}
+
+	fixtures, err := pt.SetupFixtures(fixtureConfig)
+	defer pt.TeardownFixtures(fixtureConfig, fixtures)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings := fixtures["settings"].(kodex.Settings)
+	undoSettings, shares := undoSettings(t, 900)
+	settings.Set("undo", undoSettings)
+
+	definitions := &kodex.Definitions{ActionDefinitions: actions.Actions}
+
+	store, err := parameters.MakeInMemoryParameterStore(map[string]interface{}{}, definitions)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every guard stands for a separate process
+	process := func() (*kodex.Processor, *kodex.UndoGuard) {
+		pseudonymize, err := kodex.MakeAction("pseudonymize", "", "pseudonymize", []byte("pseudonymize"), map[string]interface{}{
+			"key":    "name",
+			"method": "merengue",
+			"config": map[string]interface{}{},
+		}, definitions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parameterSet, err := kodex.MakeParameterSet([]kodex.Action{pseudonymize}, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		processor, err := kodex.MakeProcessor(parameterSet, kodex.MakeInMemoryChannelWriter(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		guard, err := kodex.MakeUndoGuard(settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		processor.SetUndoGuard(guard)
+		return processor, guard
+	}
+
+	processor, _ := process()
+
+	items, err := processor.Process([]*kodex.Item{kodex.MakeItem(map[string]interface{}{"name": "max"})}, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pseudonym, _ := items[0].Get("name")
+
+	// the store only contains the sealed parameters
+	allParameters, err := store.AllParameters()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(allParameters) != 1 || allParameters[0].Parameters() != nil || allParameters[0].Sealed() == nil {
+		t.Fatalf("expected sealed parameters")
+	}
+
+	if data, err := json.Marshal(allParameters[0]); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(string(data), "\"parameters\":null") {
+		t.Fatalf("the stored parameters contain a key: %s", data)
+	}
+
+	kip, _ := items[0].Get("_kip")
+	kipBytes, _ := hex.DecodeString(kip.(string))
+
+	// another process can neither use nor open the parameters
+	otherProcessor, otherGuard := process()
+
+	if otherItems, err := otherProcessor.Process([]*kodex.Item{kodex.MakeItem(map[string]interface{}{"name": "max"})}, nil); err != nil {
+		t.Fatal(err)
+	} else if name, _ := otherItems[0].Get("name"); name == pseudonym {
+		t.Fatalf("another process should use its own parameters")
+	}
+
+	undoParameterSet, err := store.ParameterSet(kipBytes)
+
+	if err != nil || undoParameterSet == nil {
+		t.Fatalf("parameter set not found")
+	}
+
+	undoProcessor, err := kodex.MakeProcessor(undoParameterSet, kodex.MakeInMemoryChannelWriter(), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	undoProcessor.SetUndoGuard(otherGuard)
+
+	if _, err := otherGuard.OpenParameters(allParameters[0]); err == nil {
+		t.Fatalf("sealed parameters should not open without the shares")
+	}
+
+	if _, err := undoProcessor.Undo([]*kodex.Item{items[0].Copy()}, nil); err == nil {
+		t.Fatalf("undo should fail without the shares")
+	}
+
+	if err := otherGuard.Unlock(shares[1:]); err != nil {
+		t.Fatal(err)
+	}
+
+	undoneItems, err := undoProcessor.Undo([]*kodex.Item{items[0].Copy()}, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name, _ := undoneItems[0].Get("name"); name != "max" {
+		t.Fatalf("expected the original name, got %v", name)
+	}
+}
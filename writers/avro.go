@@ -0,0 +1,131 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/linkedin/goavro/v2"
+	"io"
+)
+
+// Encodes items as an Avro object container file
+type AvroEncoder struct {
+	typedEncoder
+	output   io.Writer
+	compress bool
+	writer   *goavro.OCFWriter
+	schema   Schema
+	types    []string
+	records  []interface{}
+}
+
+func MakeAvroEncoder(output io.Writer, format FileFormat) *AvroEncoder {
+	encoder := &AvroEncoder{
+		output:   output,
+		compress: format.Compress,
+	}
+	encoder.Schema = format.Schema
+	encoder.rows = encoder
+	return encoder
+}
+
+func avroType(columnType string) string {
+	switch columnType {
+	case IntColumn:
+		return "long"
+	case FloatColumn:
+		return "double"
+	case BoolColumn:
+		return "boolean"
+	default:
+		return "string"
+	}
+}
+
+func (a *AvroEncoder) setup(schema Schema) error {
+
+	fields := make([]interface{}, len(schema))
+	a.types = make([]string, len(schema))
+
+	for i, column := range schema {
+		if !columnName.MatchString(column.Name) {
+			return fmt.Errorf("'%s' is not a valid Avro field name", column.Name)
+		}
+		a.types[i] = avroType(column.Type)
+		fields[i] = map[string]interface{}{
+			"name":    column.Name,
+			"type":    []interface{}{"null", a.types[i]},
+			"default": nil,
+		}
+	}
+
+	avroSchema, err := json.Marshal(map[string]interface{}{
+		"type":   "record",
+		"name":   "item",
+		"fields": fields,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	config := goavro.OCFConfig{
+		W:      a.output,
+		Schema: string(avroSchema),
+	}
+
+	if a.compress {
+		config.CompressionName = goavro.CompressionDeflateLabel
+	}
+
+	a.writer, err = goavro.NewOCFWriter(config)
+	a.schema = schema
+
+	return err
+}
+
+func (a *AvroEncoder) writeRow(values []interface{}) error {
+
+	record := make(map[string]interface{}, len(values))
+
+	for i, value := range values {
+		if value == nil {
+			record[a.schema[i].Name] = nil
+		} else {
+			record[a.schema[i].Name] = goavro.Union(a.types[i], value)
+		}
+	}
+
+	a.records = append(a.records, record)
+
+	// records are written in blocks
+	if len(a.records) >= 1000 {
+		return a.close()
+	}
+
+	return nil
+}
+
+func (a *AvroEncoder) close() error {
+	if len(a.records) == 0 {
+		return nil
+	}
+	records := a.records
+	a.records = nil
+	return a.writer.Append(records)
+}
@@ -0,0 +1,75 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers
+
+import (
+	"bytes"
+	"encoding/gob"
+	"github.com/kiprotect/kodex"
+	"time"
+)
+
+// Payloads of durable internal channels are serialized with gob, which
+// (unlike JSON) preserves the types of item values (e.g. byte slices,
+// integers and times), so items look the same after the channel as before.
+type ChannelRecord struct {
+	Items       []map[string]interface{}
+	Headers     map[string]interface{}
+	EndOfStream bool
+}
+
+func init() {
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+	gob.Register([]map[string]interface{}{})
+	gob.Register(time.Time{})
+}
+
+func EncodeChannelRecord(payload kodex.Payload) ([]byte, error) {
+	record := ChannelRecord{
+		Items:       make([]map[string]interface{}, len(payload.Items())),
+		Headers:     payload.Headers(),
+		EndOfStream: payload.EndOfStream(),
+	}
+	for i, item := range payload.Items() {
+		record.Items[i] = item.All()
+	}
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(&record); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func DecodeChannelRecord(data []byte) (*ChannelRecord, error) {
+	record := &ChannelRecord{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(record); err != nil {
+		return nil, err
+	}
+	if record.Headers == nil {
+		record.Headers = map[string]interface{}{}
+	}
+	return record, nil
+}
+
+func (c *ChannelRecord) ItemsList() []*kodex.Item {
+	items := make([]*kodex.Item, len(c.Items))
+	for i, item := range c.Items {
+		items[i] = kodex.MakeItem(item)
+	}
+	return items
+}
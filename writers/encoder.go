@@ -0,0 +1,232 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/kodex"
+	"io"
+)
+
+// Encodes items for the file and S3 writers
+type ItemEncoder interface {
+	Encode(*kodex.Item) error
+	// Writes out all data that has been encoded so far (if the format allows it)
+	Flush() error
+	// Completes the encoding (the underlying writer is not closed)
+	Close() error
+	// Returns the number of bytes encoded so far (before compression)
+	Size() int
+}
+
+var FileFormatFields = []forms.Field{
+	{
+		Name: "format",
+		Validators: []forms.Validator{
+			forms.IsOptional{Default: "json"},
+			forms.IsIn{Choices: []interface{}{"json", "csv", "parquet", "avro"}},
+		},
+	},
+	{
+		// the columns of CSV files (required for the CSV format)
+		Name: "csv-columns",
+		Validators: []forms.Validator{
+			forms.IsOptional{Default: []interface{}{}},
+			forms.IsList{
+				Validators: []forms.Validator{
+					forms.IsString{},
+				},
+			},
+		},
+	},
+	{
+		// the column types for Parquet and Avro, e.g. {'lat': 'float'}. If not
+		// given, the schema is inferred from the first batch of items.
+		Name: "schema",
+		Validators: []forms.Validator{
+			forms.IsOptional{},
+			forms.IsStringMap{},
+		},
+	},
+	{
+		// gzip for JSON and CSV, Snappy for Parquet and Deflate for Avro
+		Name: "compress",
+		Validators: []forms.Validator{
+			forms.IsOptional{Default: false},
+			forms.IsBoolean{},
+		},
+	},
+}
+
+type FileFormat struct {
+	ValueFormat
+	// the declared schema for typed formats (nil if it should be inferred)
+	Schema   Schema
+	Compress bool
+}
+
+func MakeFileFormat(params map[string]interface{}) (FileFormat, error) {
+
+	format := FileFormat{
+		ValueFormat: ValueFormat{
+			Format:     params["format"].(string),
+			CSVColumns: toStringList(params["csv-columns"].([]interface{})),
+		},
+		Compress: params["compress"].(bool),
+	}
+
+	if format.Format == "csv" && len(format.CSVColumns) == 0 {
+		return format, fmt.Errorf("the CSV format requires a list of CSV columns")
+	}
+
+	if columns, ok := params["schema"].(map[string]interface{}); ok {
+		if !format.Typed() {
+			return format, fmt.Errorf("a schema can only be used with the Parquet and Avro formats")
+		}
+		schema, err := MakeSchema(columns)
+		if err != nil {
+			return format, err
+		}
+		format.Schema = schema
+	}
+
+	return format, nil
+}
+
+// Returns true for formats with typed columns (which need a schema)
+func (f FileFormat) Typed() bool {
+	return f.Format == "parquet" || f.Format == "avro"
+}
+
+func (f FileFormat) Extension() string {
+	if f.Compress && !f.Typed() {
+		// typed formats are compressed internally
+		return fmt.Sprintf("%s.gz", f.Format)
+	}
+	return f.Format
+}
+
+func (f FileFormat) ContentType() string {
+	switch {
+	case f.Compress && !f.Typed():
+		return "application/gzip"
+	case f.Format == "csv":
+		return "text/csv"
+	case f.Format == "json":
+		return "application/x-ndjson"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+func MakeItemEncoder(writer io.Writer, format FileFormat) ItemEncoder {
+	switch format.Format {
+	case "parquet":
+		return MakeParquetEncoder(writer, format)
+	case "avro":
+		return MakeAvroEncoder(writer, format)
+	default:
+		return MakeLineEncoder(writer, format)
+	}
+}
+
+// Encodes items as JSON lines or as CSV data with a header row, optionally
+// compressed with gzip.
+type LineEncoder struct {
+	ValueFormat
+	writer        io.Writer
+	gzWriter      *gzip.Writer
+	headerWritten bool
+	size          int
+}
+
+func MakeLineEncoder(writer io.Writer, format FileFormat) *LineEncoder {
+
+	encoder := &LineEncoder{
+		ValueFormat: format.ValueFormat,
+		writer:      writer,
+	}
+
+	if format.Compress {
+		encoder.gzWriter = gzip.NewWriter(writer)
+		encoder.writer = encoder.gzWriter
+	}
+
+	return encoder
+}
+
+func (e *LineEncoder) Encode(item *kodex.Item) error {
+
+	if e.Format == "csv" && !e.headerWritten {
+		buf := &bytes.Buffer{}
+		writer := csv.NewWriter(buf)
+		if err := writer.Write(e.CSVColumns); err != nil {
+			return err
+		}
+		writer.Flush()
+		if _, err := e.writer.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		e.size += buf.Len()
+		e.headerWritten = true
+	}
+
+	data, err := e.SerializeItem(item)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := e.writer.Write(data); err != nil {
+		return err
+	}
+
+	if _, err := e.writer.Write([]byte("\n")); err != nil {
+		return err
+	}
+
+	e.size += len(data) + 1
+
+	return nil
+}
+
+func (e *LineEncoder) Size() int {
+	return e.size
+}
+
+// Skips the CSV header row (e.g. when appending to an existing file)
+func (e *LineEncoder) SkipHeader() {
+	e.headerWritten = true
+}
+
+func (e *LineEncoder) Flush() error {
+	if e.gzWriter != nil {
+		return e.gzWriter.Flush()
+	}
+	return nil
+}
+
+func (e *LineEncoder) Close() error {
+	if e.gzWriter != nil {
+		return e.gzWriter.Close()
+	}
+	return nil
+}
@@ -17,97 +17,255 @@
 package writers
 
 import (
-	"compress/gzip"
+	"errors"
 	"fmt"
 	"github.com/kiprotect/kodex"
-	"io"
 	"os"
 	"path/filepath"
 	"sync"
 	"time"
 )
 
+/*
+The file writer writes items to a file, which is kept open between writes.
+If rotation is enabled (by interval, size or number of items), the active
+file is written under a temporary name and renamed atomically once it is
+rotated, so other processes never see partially written files.
+*/
 type FileWriter struct {
-	BasePath string
-	Name     string
-	Format   string
-	Compress bool
-	AddTime  bool
-	mutex    *sync.Mutex
+	FileFormat
+	BasePath       string
+	Name           string
+	AddTime        bool
+	RotateInterval time.Duration
+	MaxFileSize    int64
+	MaxItems       int
+	mutex          *sync.Mutex
+	file           *os.File
+	encoder        ItemEncoder
+	tempPath       string
+	started        time.Time
+	initialSize    int64
+	items          int
+	stop           chan bool
+	done           chan bool
+}
+
+func (s *FileWriter) rotating() bool {
+	return s.RotateInterval > 0 || s.MaxFileSize > 0 || s.MaxItems > 0
+}
+
+// Typed formats (e.g. Parquet) can't be appended to, so we always write
+// them under a temporary name as well
+func (s *FileWriter) usesTempFile() bool {
+	return s.rotating() || s.Typed()
 }
 
 func (s *FileWriter) Teardown() error {
-	return nil
+
+	s.mutex.Lock()
+	stop, done := s.stop, s.done
+	s.stop, s.done = nil, nil
+	s.mutex.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.finalize()
 }
 
 func (s *FileWriter) Setup(config kodex.Config) error {
-	if s.BasePath == "" {
-		return nil
-	}
-	info, err := os.Stat(s.BasePath)
-	if err != nil {
-		return err
+	if s.BasePath != "" {
+		info, err := os.Stat(s.BasePath)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("path must be a directory")
+		}
 	}
-	if !info.IsDir() {
-		return fmt.Errorf("path must be a directory")
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.RotateInterval > 0 && s.stop == nil {
+		s.stop = make(chan bool)
+		s.done = make(chan bool)
+		go s.rotate(s.stop, s.done)
 	}
 
 	return nil
 }
 
-func (s *FileWriter) Write(payload kodex.Payload) error {
-
-	var fileName, extension string
+// Rotates files once their interval has passed, even if no more items are
+// written.
+func (s *FileWriter) rotate(stop, done chan bool) {
+	defer close(done)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.mutex.Lock()
+			if s.file != nil && s.rotationDue() {
+				if err := s.finalize(); err != nil {
+					kodex.Log.Errorf("Cannot rotate file: %v", err)
+				}
+			}
+			s.mutex.Unlock()
+		}
+	}
+}
 
-	if s.Compress {
-		extension = fmt.Sprintf("%s.gz", s.Format)
-	} else {
-		extension = s.Format
+func (s *FileWriter) rotationDue() bool {
+	if s.RotateInterval > 0 && time.Since(s.started) >= s.RotateInterval {
+		return true
 	}
-	if s.AddTime {
-		tn := time.Now().UTC().Unix()
-		//we rotate the files every 60 seconds
-		ts := tn - (tn % 60)
-		fileName = fmt.Sprintf("%s-%d.%s", s.Name, ts, extension)
+	if s.MaxFileSize > 0 && s.initialSize+int64(s.encoder.Size()) >= s.MaxFileSize {
+		return true
+	}
+	if s.MaxItems > 0 && s.items >= s.MaxItems {
+		return true
+	}
+	return false
+}
+
+// Opens a new file (the caller must hold the mutex)
+func (s *FileWriter) open() error {
+
+	var path string
+
+	s.started = time.Now()
+	s.items = 0
+	s.initialSize = 0
+
+	if s.usesTempFile() {
+		// the active file is hidden and gets a unique name
+		path = filepath.Join(s.BasePath, fmt.Sprintf(".%s-%d-%x.tmp", s.Name, s.started.UTC().Unix(), kodex.RandomID()[:4]))
 	} else {
-		fileName = fmt.Sprintf("%s.%s", s.Name, extension)
+		path = filepath.Join(s.BasePath, fmt.Sprintf("%s.%s", s.Name, s.Extension()))
 	}
 
-	fullPath := filepath.Join(s.BasePath, fileName)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666)
 
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
 
-	f, err := os.OpenFile(fullPath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666)
 	if err != nil {
+		f.Close()
 		return err
 	}
 
-	defer f.Close()
-	defer f.Sync()
+	s.file = f
+	s.tempPath = path
+	s.initialSize = info.Size()
+	s.encoder = MakeItemEncoder(f, s.FileFormat)
+
+	if lineEncoder, ok := s.encoder.(*LineEncoder); ok && s.initialSize > 0 {
+		// we append to an existing file that already has a header
+		lineEncoder.SkipHeader()
+	}
+
+	return nil
+}
 
-	var writer io.Writer = f
+// Closes the current file and moves it to its final name if we rotate
+// files (the caller must hold the mutex)
+func (s *FileWriter) finalize() error {
 
-	if s.Compress {
-		gzWriter := gzip.NewWriter(writer)
-		writer = gzWriter
-		defer gzWriter.Close()
-		defer gzWriter.Flush()
+	if s.file == nil {
+		return nil
 	}
 
-	for _, item := range payload.Items() {
-		serializedItem, err := item.Serialize(s.Format)
-		if err != nil {
-			return err
+	file := s.file
+	s.file = nil
+
+	if err := s.encoder.Close(); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if !s.usesTempFile() {
+		return nil
+	}
+
+	baseName := s.Name
+
+	if s.rotating() {
+		baseName = fmt.Sprintf("%s-%d", s.Name, s.started.UTC().Unix())
+	}
+
+	for i := 0; ; i++ {
+		name := fmt.Sprintf("%s.%s", baseName, s.Extension())
+		if i > 0 {
+			name = fmt.Sprintf("%s-%d.%s", baseName, i, s.Extension())
 		}
-		_, err = writer.Write(serializedItem)
-		if err != nil {
+		path := filepath.Join(s.BasePath, name)
+		// linking fails if the file exists, so we never overwrite files
+		if err := os.Link(s.tempPath, path); err == nil {
+			return os.Remove(s.tempPath)
+		} else if !errors.Is(err, os.ErrExist) {
 			return err
 		}
-		_, err = writer.Write([]byte("\n"))
-		if err != nil {
+	}
+}
+
+func (s *FileWriter) Write(payload kodex.Payload) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, item := range payload.Items() {
+		if s.file != nil && s.rotating() && s.rotationDue() {
+			if err := s.finalize(); err != nil {
+				return err
+			}
+		}
+		if s.file == nil {
+			if err := s.open(); err != nil {
+				return err
+			}
+		}
+		if err := s.encoder.Encode(item); err != nil {
 			return err
 		}
+		s.items++
+	}
+
+	if s.file == nil {
+		return nil
+	}
+
+	if err := s.encoder.Flush(); err != nil {
+		return err
+	}
+
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+
+	// we rotate right away once a limit is reached
+	if s.rotating() && s.rotationDue() {
+		return s.finalize()
 	}
 
 	return nil
@@ -118,13 +276,24 @@ func MakeFileWriter(config map[string]interface{}) (kodex.Writer, error) {
 	if params, err := FileWriterForm.Validate(config); err != nil {
 		return nil, err
 	} else {
-		return &FileWriter{
-			BasePath: params["path"].(string),
-			Name:     params["base-name"].(string),
-			AddTime:  params["add-time"].(bool),
-			Compress: params["compress"].(bool),
-			Format:   params["format"].(string),
-			mutex:    &sync.Mutex{},
-		}, nil
+		format, err := MakeFileFormat(params)
+		if err != nil {
+			return nil, err
+		}
+		writer := &FileWriter{
+			FileFormat:     format,
+			BasePath:       params["path"].(string),
+			Name:           params["base-name"].(string),
+			AddTime:        params["add-time"].(bool),
+			RotateInterval: time.Duration(params["rotate-interval"].(int64)) * time.Second,
+			MaxFileSize:    params["max-file-size"].(int64),
+			MaxItems:       int(params["max-items"].(int64)),
+			mutex:          &sync.Mutex{},
+		}
+		if writer.AddTime && !writer.rotating() {
+			// we rotate the files every 60 seconds
+			writer.RotateInterval = 60 * time.Second
+		}
+		return writer, nil
 	}
 }
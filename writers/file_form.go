@@ -22,7 +22,7 @@ import (
 
 var FileWriterForm = forms.Form{
 	ErrorMsg: "invalid data encountered in the file writer form",
-	Fields: []forms.Field{
+	Fields: append([]forms.Field{
 		{
 			Name: "path",
 			Validators: []forms.Validator{
@@ -38,25 +38,38 @@ var FileWriterForm = forms.Form{
 			},
 		},
 		{
-			Name: "format",
+			// rotates files every 60 seconds (unless a different rotation
+			// is configured), rotated files always have the time in their name
+			Name: "add-time",
 			Validators: []forms.Validator{
-				forms.IsOptional{Default: "json"},
-				forms.IsIn{Choices: []interface{}{"json", "csv"}},
+				forms.IsOptional{Default: false},
+				forms.IsBoolean{},
 			},
 		},
 		{
-			Name: "compress",
+			// rotates files after the given number of seconds (0 = never)
+			Name: "rotate-interval",
 			Validators: []forms.Validator{
-				forms.IsOptional{Default: false},
-				forms.IsBoolean{},
+				forms.IsOptional{Default: int64(0)},
+				forms.IsInteger{HasMin: true, Min: 0, HasMax: true, Max: 31 * 86400},
 			},
 		},
 		{
-			Name: "add-time",
+			// rotates files once they reach the given size (in bytes, before
+			// compression, 0 = never)
+			Name: "max-file-size",
 			Validators: []forms.Validator{
-				forms.IsOptional{Default: false},
-				forms.IsBoolean{},
+				forms.IsOptional{Default: int64(0)},
+				forms.IsInteger{HasMin: true, Min: 0},
+			},
+		},
+		{
+			// rotates files once they contain the given number of items (0 = never)
+			Name: "max-items",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(0)},
+				forms.IsInteger{HasMin: true, Min: 0},
 			},
 		},
-	},
+	}, FileFormatFields...),
 }
@@ -0,0 +1,303 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers_test
+
+import (
+	"compress/gzip"
+	goparquet "github.com/fraugster/parquet-go"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/writers"
+	"github.com/linkedin/goavro/v2"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func makeFileWriter(t *testing.T, config map[string]interface{}) kodex.Writer {
+	writer, err := writers.MakeFileWriter(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+	return writer
+}
+
+func writeFileItems(t *testing.T, writer kodex.Writer, names ...string) {
+	items := make([]*kodex.Item, len(names))
+	for i, name := range names {
+		items[i] = kodex.MakeItem(map[string]interface{}{"name": name})
+	}
+	if err := writer.Write(kodex.MakeBasicPayload(items, map[string]interface{}{}, false)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// returns the finalized and temporary files in a directory
+func listFiles(t *testing.T, dir string) ([]string, []string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make([]string, 0)
+	tempFiles := make([]string, 0)
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			tempFiles = append(tempFiles, entry.Name())
+		} else {
+			files = append(files, entry.Name())
+		}
+	}
+	return files, tempFiles
+}
+
+func readFile(t *testing.T, path string, compressed bool) string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var reader io.Reader = f
+	if compressed {
+		gzReader, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// every file should consist of a single gzip stream
+		gzReader.Multistream(false)
+		reader = gzReader
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestFileWriterRotation(t *testing.T) {
+
+	dir := t.TempDir()
+
+	writer := makeFileWriter(t, map[string]interface{}{
+		"path":      dir,
+		"base-name": "items",
+		"compress":  true,
+		"max-items": 3,
+	})
+
+	writeFileItems(t, writer, "a", "b")
+	writeFileItems(t, writer, "c", "d")
+
+	// the first file is complete, the second one is still active
+	files, tempFiles := listFiles(t, dir)
+
+	if len(files) != 1 || len(tempFiles) != 1 {
+		t.Fatalf("expected one finalized and one temporary file, got %v and %v", files, tempFiles)
+	}
+
+	if content := readFile(t, filepath.Join(dir, files[0]), true); content != "{\"name\":\"a\"}\n{\"name\":\"b\"}\n{\"name\":\"c\"}\n" {
+		t.Fatalf("unexpected content: %s", content)
+	}
+
+	if err := writer.Teardown(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the active file is finalized on teardown
+	files, tempFiles = listFiles(t, dir)
+
+	if len(files) != 2 || len(tempFiles) != 0 {
+		t.Fatalf("expected two finalized files, got %v and %v", files, tempFiles)
+	}
+
+	for _, file := range files {
+		if !strings.HasPrefix(file, "items-") || !strings.HasSuffix(file, ".json.gz") {
+			t.Fatalf("unexpected file name: %s", file)
+		}
+	}
+}
+
+func TestFileWriterAppend(t *testing.T) {
+
+	dir := t.TempDir()
+	config := map[string]interface{}{
+		"path":        dir,
+		"base-name":   "items",
+		"format":      "csv",
+		"csv-columns": []interface{}{"name"},
+	}
+
+	// without rotation, we append to the same file
+	for _, name := range []string{"a", "b"} {
+		writer := makeFileWriter(t, config)
+		writeFileItems(t, writer, name)
+		if err := writer.Teardown(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the CSV header is only written once
+	if content := readFile(t, filepath.Join(dir, "items.csv"), false); content != "name\na\nb\n" {
+		t.Fatalf("unexpected content: %s", content)
+	}
+}
+
+func writeTypedItems(t *testing.T, writer kodex.Writer, values ...map[string]interface{}) error {
+	items := make([]*kodex.Item, len(values))
+	for i, value := range values {
+		items[i] = kodex.MakeItem(value)
+	}
+	return writer.Write(kodex.MakeBasicPayload(items, map[string]interface{}{}, false))
+}
+
+func TestFileWriterParquet(t *testing.T) {
+
+	dir := t.TempDir()
+	config := map[string]interface{}{
+		"path":      dir,
+		"base-name": "items",
+		"format":    "parquet",
+		"compress":  true,
+	}
+
+	// Parquet files can't be appended to, so we get one file per run
+	for i := 0; i < 2; i++ {
+		writer := makeFileWriter(t, config)
+		// the schema is inferred from the first batch
+		if err := writeTypedItems(t, writer,
+			map[string]interface{}{"name": "a", "value": 1},
+			map[string]interface{}{"name": "b", "value": 2.5, "tags": []interface{}{"x"}},
+		); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Teardown(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, tempFiles := listFiles(t, dir)
+
+	if len(files) != 2 || len(tempFiles) != 0 || files[0] != "items-1.parquet" || files[1] != "items.parquet" {
+		t.Fatalf("unexpected files: %v and %v", files, tempFiles)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "items.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	reader, err := goparquet.NewFileReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := make([]map[string]interface{}, 0)
+
+	for {
+		row, err := reader.NextRow()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(rows))
+	}
+
+	// integers and floats are widened to a float column
+	if string(rows[0]["name"].([]byte)) != "a" || rows[0]["value"] != 1.0 || rows[0]["tags"] != nil {
+		t.Fatalf("unexpected row: %v", rows[0])
+	}
+
+	if rows[1]["value"] != 2.5 || string(rows[1]["tags"].([]byte)) != "[\"x\"]" {
+		t.Fatalf("unexpected row: %v", rows[1])
+	}
+}
+
+func TestFileWriterAvro(t *testing.T) {
+
+	dir := t.TempDir()
+
+	writer := makeFileWriter(t, map[string]interface{}{
+		"path":      dir,
+		"base-name": "items",
+		"format":    "avro",
+		"compress":  true,
+		"schema":    map[string]interface{}{"name": "string", "count": "int"},
+	})
+
+	if err := writeTypedItems(t, writer,
+		map[string]interface{}{"name": "a", "count": 1},
+		map[string]interface{}{"name": "b"},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// values of the wrong type are rejected
+	if err := writeTypedItems(t, writer, map[string]interface{}{"count": "many"}); err == nil || !strings.Contains(err.Error(), "count") {
+		t.Fatalf("expected a type mismatch error, got %v", err)
+	}
+
+	// so are fields that aren't in the schema
+	if err := writeTypedItems(t, writer, map[string]interface{}{"name": "c", "size": 1}); err == nil || !strings.Contains(err.Error(), "size") {
+		t.Fatalf("expected an unknown field error, got %v", err)
+	}
+
+	if err := writer.Teardown(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "items.avro"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	reader, err := goavro.NewOCFReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := make([]map[string]interface{}, 0)
+
+	for reader.Scan() {
+		record, err := reader.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record.(map[string]interface{}))
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+
+	if records[0]["name"].(map[string]interface{})["string"] != "a" || records[0]["count"].(map[string]interface{})["long"] != int64(1) {
+		t.Fatalf("unexpected record: %v", records[0])
+	}
+
+	if records[1]["count"] != nil {
+		t.Fatalf("expected a null count, got %v", records[1]["count"])
+	}
+}
@@ -17,22 +17,72 @@
 package writers
 
 import (
-	"bufio"
 	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/hex"
 	"fmt"
 	"github.com/kiprotect/kodex"
+	"io"
+	"io/ioutil"
+	"math/rand"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 )
 
+/*
+The HTTP writer sends items as newline-delimited JSON to a given URL. Items
+are split into batches (limited by number of items and size), and each batch
+is retried with exponential backoff (and jitter) if the server responds with
+a 5xx or 429 status code or if a network error occurs. Other non-2xx
+responses are treated as permanent errors.
+
+With HMAC authentication, each request carries the headers
+
+	X-Kodex-Timestamp: [unix timestamp]
+	X-Kodex-Signature: sha256=[hex(HMAC-SHA256(secret, timestamp + "." + body))]
+
+where the body is the exact (possibly compressed) request body.
+*/
 type HTTPWriter struct {
-	Format  string
-	URL     string
-	Config  kodex.Config
-	Headers map[string]interface{}
+	Format        string
+	URL           string
+	Config        kodex.Config
+	Headers       map[string]interface{}
+	Retries       int
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+	MaxBatchSize  int
+	MaxBatchBytes int
+	Gzip          bool
+	Auth          *HTTPAuth
+	Client        *http.Client
+}
+
+type HTTPAuth struct {
+	Type     string
+	Token    string
+	Username string
+	Password string
+	Secret   string
+}
+
+// an error that should not be retried
+type permanentError struct {
+	err error
+}
+
+func (p *permanentError) Error() string {
+	return p.err.Error()
 }
 
 func (s *HTTPWriter) Teardown() error {
+	s.Client.CloseIdleConnections()
 	return nil
 }
 
@@ -41,31 +91,58 @@ func (s *HTTPWriter) Setup(config kodex.Config) error {
 	return nil
 }
 
-func (s *HTTPWriter) Write(payload kodex.Payload) error {
+// Splits the serialized items into batches of at most MaxBatchSize items
+// and MaxBatchBytes bytes (a single item larger than that forms its own batch).
+func (s *HTTPWriter) batches(items []*kodex.Item) ([][]byte, error) {
 
-	b := make([]byte, 0)
-	buf := bytes.NewBuffer(b)
-	writer := bufio.NewWriter(buf)
+	batches := make([][]byte, 0)
+	buf := &bytes.Buffer{}
+	n := 0
 
-	for _, item := range payload.Items() {
+	for _, item := range items {
 		serializedItem, err := item.Serialize(s.Format)
 		if err != nil {
-			return err
-		}
-		_, err = writer.Write(serializedItem)
-		if err != nil {
-			return err
+			return nil, err
 		}
-		_, err = writer.Write([]byte("\n"))
-		if err != nil {
-			return err
+		if n > 0 && (n >= s.MaxBatchSize || buf.Len()+len(serializedItem)+1 > s.MaxBatchBytes) {
+			batches = append(batches, buf.Bytes())
+			buf = &bytes.Buffer{}
+			n = 0
 		}
+		buf.Write(serializedItem)
+		buf.Write([]byte("\n"))
+		n++
 	}
 
-	writer.Flush()
+	if n > 0 {
+		batches = append(batches, buf.Bytes())
+	}
+
+	return batches, nil
+}
+
+func (s *HTTPWriter) body(data []byte) ([]byte, error) {
+	if !s.Gzip {
+		return data, nil
+	}
+	buf := &bytes.Buffer{}
+	writer := gzip.NewWriter(buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *HTTPWriter) request(body []byte) (*http.Request, error) {
+
+	req, err := http.NewRequest("POST", s.URL, bytes.NewReader(body))
 
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", s.URL, buf)
+	if err != nil {
+		return nil, err
+	}
 
 	for k, v := range s.Headers {
 		req.Header.Add(k, v.(string))
@@ -77,21 +154,251 @@ func (s *HTTPWriter) Write(payload kodex.Payload) error {
 		req.Header.Add("X-KIP-Config", hex.EncodeToString(s.Config.ID()))
 	}
 
-	req.Header.Add("Content-Type", fmt.Sprintf("application/%s", s.Format))
+	req.Header.Set("Content-Type", fmt.Sprintf("application/%s", s.Format))
+
+	if s.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	if s.Auth != nil {
+		switch s.Auth.Type {
+		case "bearer":
+			req.Header.Set("Authorization", "Bearer "+s.Auth.Token)
+		case "basic":
+			req.SetBasicAuth(s.Auth.Username, s.Auth.Password)
+		case "hmac":
+			timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+			req.Header.Set("X-Kodex-Timestamp", timestamp)
+			req.Header.Set("X-Kodex-Signature", "sha256="+HMACSignature([]byte(s.Auth.Secret), timestamp, body))
+		}
+	}
+
+	return req, nil
+}
+
+// Returns the hex-encoded HMAC-SHA256 signature of a request body
+func HMACSignature(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *HTTPWriter) send(body []byte) error {
+
+	// we create a new request for every attempt (e.g. to update signatures)
+	req, err := s.request(body)
+
+	if err != nil {
+		return &permanentError{err}
+	}
+
+	resp, err := s.Client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	// we read (parts of) the response so the connection can be reused
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	err = fmt.Errorf("server responded with status %d: %s", resp.StatusCode, bytes.TrimSpace(message))
+
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return err
+	}
 
-	_, err = client.Do(req)
+	return &permanentError{err}
+}
 
-	return err
+// Returns the delay before the given retry (starting with 0), using
+// exponential backoff with jitter.
+func (s *HTTPWriter) backoff(retry int) time.Duration {
+	delay := s.RetryDelay
+	for i := 0; i < retry && delay < s.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > s.MaxRetryDelay {
+		delay = s.MaxRetryDelay
+	}
+	// we wait between half and the full delay
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func (s *HTTPWriter) Write(payload kodex.Payload) error {
+
+	batches, err := s.batches(payload.Items())
+
+	if err != nil {
+		return err
+	}
+
+	for _, batch := range batches {
+
+		body, err := s.body(batch)
+
+		if err != nil {
+			return err
+		}
+
+		for retry := 0; ; retry++ {
+			err = s.send(body)
+			if err == nil {
+				break
+			}
+			if _, ok := err.(*permanentError); ok || retry >= s.Retries {
+				return err
+			}
+			delay := s.backoff(retry)
+			kodex.Log.Warningf("HTTP request failed (%v), retrying in %v...", err, delay)
+			time.Sleep(delay)
+		}
+	}
+
+	return nil
+}
+
+func makeHTTPAuth(params map[string]interface{}) (*HTTPAuth, error) {
+
+	auth := &HTTPAuth{
+		Type:     params["type"].(string),
+		Token:    params["token"].(string),
+		Username: params["username"].(string),
+		Password: params["password"].(string),
+		Secret:   params["secret"].(string),
+	}
+
+	var value string
+
+	if env := params["env"].(string); env != "" {
+		if value = os.Getenv(env); value == "" {
+			return nil, fmt.Errorf("environment variable '%s' is empty", env)
+		}
+	}
+
+	switch auth.Type {
+	case "bearer":
+		if value != "" {
+			auth.Token = value
+		}
+		if auth.Token == "" {
+			return nil, fmt.Errorf("bearer auth requires a token")
+		}
+	case "basic":
+		if value != "" {
+			auth.Password = value
+		}
+		if auth.Username == "" {
+			return nil, fmt.Errorf("basic auth requires a username")
+		}
+	case "hmac":
+		if value != "" {
+			auth.Secret = value
+		}
+		if auth.Secret == "" {
+			return nil, fmt.Errorf("HMAC auth requires a secret")
+		}
+	}
+
+	return auth, nil
+}
+
+// Returns a TLS config with the given client certificate and CA (or nil
+// if neither is given).
+func ClientTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+
+	if certFile == "" && keyFile == "" && caFile == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("please specify both a certificate and a key file")
+	}
+
+	if certFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	if caFile != "" {
+		caData, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("no valid certificates found in '%s'", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+func makeHTTPClient(params map[string]interface{}) (*http.Client, error) {
+
+	tlsConfig, err := ClientTLSConfig(params["cert-file"].(string), params["key-file"].(string), params["ca-file"].(string))
+
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(params["timeout"].(int64)) * time.Second,
+	}, nil
 }
 
 func MakeHTTPWriter(config map[string]interface{}) (kodex.Writer, error) {
 	if params, err := HTTPWriterForm.Validate(config); err != nil {
 		return nil, err
 	} else {
-		return &HTTPWriter{
-			Format:  params["format"].(string),
-			URL:     params["url"].(string),
-			Headers: params["headers"].(map[string]interface{}),
-		}, nil
+
+		client, err := makeHTTPClient(params)
+
+		if err != nil {
+			return nil, err
+		}
+
+		writer := &HTTPWriter{
+			Format:        params["format"].(string),
+			URL:           params["url"].(string),
+			Headers:       params["headers"].(map[string]interface{}),
+			Retries:       int(params["retries"].(int64)),
+			RetryDelay:    time.Duration(params["retry-delay"].(int64)) * time.Millisecond,
+			MaxRetryDelay: time.Duration(params["max-retry-delay"].(int64)) * time.Millisecond,
+			MaxBatchSize:  int(params["max-batch-size"].(int64)),
+			MaxBatchBytes: int(params["max-batch-bytes"].(int64)),
+			Gzip:          params["gzip"].(bool),
+			Client:        client,
+		}
+
+		if authParams, ok := params["auth"].(map[string]interface{}); ok {
+			if writer.Auth, err = makeHTTPAuth(authParams); err != nil {
+				return nil, err
+			}
+		}
+
+		return writer, nil
 	}
 }
@@ -20,26 +20,79 @@ import (
 	"github.com/kiprotect/go-helpers/forms"
 )
 
+var HTTPAuthForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the HTTP auth form",
+	Fields: []forms.Field{
+		{
+			Name: "type",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsIn{Choices: []interface{}{"bearer", "basic", "hmac"}},
+			},
+		},
+		{
+			// bearer token
+			Name: "token",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "username",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "password",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// secret for HMAC request signatures
+			Name: "secret",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// name of an environment variable that contains the token,
+			// password or secret (instead of specifying it directly)
+			Name: "env",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+	},
+}
+
 var HTTPWriterForm = forms.Form{
 	ErrorMsg: "invalid data encountered in the HTTP writer form",
 	Fields: []forms.Field{
 		{
 			Name: "format",
 			Validators: []forms.Validator{
+				forms.IsOptional{Default: "json"},
 				forms.IsIn{Choices: []interface{}{"json"}},
 			},
 		},
 		{
 			Name: "url",
 			Validators: []forms.Validator{
-				forms.IsOptional{Default: false},
+				forms.IsRequired{},
 				forms.IsString{},
 			},
 		},
 		{
 			Name: "headers",
 			Validators: []forms.Validator{
-				forms.IsOptional{Default: false},
+				forms.IsOptional{Default: map[string]interface{}{}},
 				forms.IsStringMap{
 					Form: &forms.Form{
 						Fields: []forms.Field{
@@ -54,5 +107,92 @@ var HTTPWriterForm = forms.Form{
 				},
 			},
 		},
+		{
+			// request timeout (in seconds)
+			Name: "timeout",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(30)},
+				forms.IsInteger{HasMin: true, Min: 1, HasMax: true, Max: 3600},
+			},
+		},
+		{
+			// how often we retry a request after server or network errors
+			Name: "retries",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(5)},
+				forms.IsInteger{HasMin: true, Min: 0, HasMax: true, Max: 100},
+			},
+		},
+		{
+			// initial delay between retries (in milliseconds), doubled after each retry
+			Name: "retry-delay",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(500)},
+				forms.IsInteger{HasMin: true, Min: 1},
+			},
+		},
+		{
+			// maximum delay between retries (in milliseconds)
+			Name: "max-retry-delay",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(30000)},
+				forms.IsInteger{HasMin: true, Min: 1},
+			},
+		},
+		{
+			// maximum number of items per request
+			Name: "max-batch-size",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(1000)},
+				forms.IsInteger{HasMin: true, Min: 1},
+			},
+		},
+		{
+			// maximum (uncompressed) size of a request body (in bytes)
+			Name: "max-batch-bytes",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(5 * 1024 * 1024)},
+				forms.IsInteger{HasMin: true, Min: 1},
+			},
+		},
+		{
+			Name: "gzip",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: false},
+				forms.IsBoolean{},
+			},
+		},
+		{
+			Name: "auth",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsStringMap{
+					Form: &HTTPAuthForm,
+				},
+			},
+		},
+		{
+			// client certificate (for mTLS)
+			Name: "cert-file",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "key-file",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// CA for verifying the server certificate (system CAs are used otherwise)
+			Name: "ca-file",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
 	},
 }
@@ -0,0 +1,165 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers_test
+
+import (
+	"compress/gzip"
+	"crypto/hmac"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/writers"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func makeItems(n int) []*kodex.Item {
+	items := make([]*kodex.Item, n)
+	for i := 0; i < n; i++ {
+		items[i] = kodex.MakeItem(map[string]interface{}{"i": i})
+	}
+	return items
+}
+
+func TestHTTPWriter(t *testing.T) {
+
+	var mutex sync.Mutex
+	var bodies []string
+	failures := 2
+	requests := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		requests++
+
+		if failures > 0 {
+			failures--
+			w.WriteHeader(503)
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		signature := "sha256=" + writers.HMACSignature([]byte("secret"), r.Header.Get("X-Kodex-Timestamp"), body)
+
+		if !hmac.Equal([]byte(signature), []byte(r.Header.Get("X-Kodex-Signature"))) {
+			w.WriteHeader(401)
+			return
+		}
+
+		if r.Header.Get("Content-Encoding") != "gzip" {
+			w.WriteHeader(400)
+			return
+		}
+
+		reader, err := gzip.NewReader(strings.NewReader(string(body)))
+
+		if err != nil {
+			w.WriteHeader(400)
+			return
+		}
+
+		data, err := ioutil.ReadAll(reader)
+
+		if err != nil {
+			w.WriteHeader(400)
+			return
+		}
+
+		bodies = append(bodies, string(data))
+	}))
+
+	defer server.Close()
+
+	writer, err := writers.MakeHTTPWriter(map[string]interface{}{
+		"url":            server.URL,
+		"retry-delay":    1,
+		"max-batch-size": 2,
+		"gzip":           true,
+		"auth": map[string]interface{}{
+			"type":   "hmac",
+			"secret": "secret",
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Write(kodex.MakeBasicPayload(makeItems(5), map[string]interface{}{}, false)); err != nil {
+		t.Fatal(err)
+	}
+
+	// two failed requests and three batches
+	if requests != 5 {
+		t.Fatalf("expected 5 requests, got %d", requests)
+	}
+
+	if len(bodies) != 3 || bodies[2] != "{\"i\":4}\n" {
+		t.Fatalf("unexpected batches: %v", bodies)
+	}
+
+	// errors other than 5xx should not be retried
+	badWriter, err := writers.MakeHTTPWriter(map[string]interface{}{
+		"url":         server.URL,
+		"retry-delay": 1,
+		"auth": map[string]interface{}{
+			"type":   "hmac",
+			"secret": "wrong",
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests = 0
+
+	if err := badWriter.Write(kodex.MakeBasicPayload(makeItems(1), map[string]interface{}{}, false)); err == nil {
+		t.Fatalf("expected an error")
+	} else if requests != 1 {
+		t.Fatalf("expected 1 request, got %d", requests)
+	}
+
+	// server errors are retried until the retries are exhausted
+	failures = 10
+	requests = 0
+
+	if err := writer.Write(kodex.MakeBasicPayload(makeItems(1), map[string]interface{}{}, false)); err == nil {
+		t.Fatalf("expected an error")
+	} else if requests != 6 {
+		t.Fatalf("expected %d requests, got %d", 6, requests)
+	}
+
+	if _, err := writers.MakeHTTPWriter(map[string]interface{}{
+		"url":  server.URL,
+		"auth": map[string]interface{}{"type": "bearer"},
+	}); err == nil {
+		t.Fatalf("expected an error for bearer auth without a token")
+	}
+
+}
@@ -0,0 +1,185 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"time"
+)
+
+// Functionality shared by the Kafka reader and writer
+type KafkaBase struct {
+	ValueFormat
+	Brokers  []string
+	Topic    string
+	KeyField string
+	Headers  map[string]interface{}
+	Client   *kgo.Client
+}
+
+func MakeKafkaBase(params map[string]interface{}) (KafkaBase, error) {
+
+	valueFormat, err := MakeValueFormat(params)
+
+	if err != nil {
+		return KafkaBase{}, err
+	}
+
+	base := KafkaBase{
+		ValueFormat: valueFormat,
+		Brokers:     toStringList(params["brokers"].([]interface{})),
+		Topic:       params["topic"].(string),
+		KeyField:    params["key-field"].(string),
+		Headers:     params["headers"].(map[string]interface{}),
+	}
+
+	if len(base.Brokers) == 0 {
+		return base, fmt.Errorf("please specify at least one broker")
+	}
+
+	return base, nil
+}
+
+func (k *KafkaBase) ClientOptions() []kgo.Opt {
+	return []kgo.Opt{
+		kgo.SeedBrokers(k.Brokers...),
+	}
+}
+
+func (k *KafkaBase) Teardown() error {
+	if k.Client != nil {
+		k.Client.Close()
+		k.Client = nil
+	}
+	return nil
+}
+
+type KafkaWriter struct {
+	KafkaBase
+	Config  kodex.Config
+	Timeout time.Duration
+}
+
+func MakeKafkaWriter(config map[string]interface{}) (kodex.Writer, error) {
+	if params, err := KafkaWriterForm.Validate(config); err != nil {
+		return nil, err
+	} else {
+		base, err := MakeKafkaBase(params)
+		if err != nil {
+			return nil, err
+		}
+		return &KafkaWriter{
+			KafkaBase: base,
+			Timeout:   time.Duration(params["timeout"].(int64)) * time.Second,
+		}, nil
+	}
+}
+
+func (k *KafkaWriter) Setup(config kodex.Config) error {
+
+	k.Config = config
+
+	if k.Client != nil {
+		return nil
+	}
+
+	options := append(k.ClientOptions(),
+		kgo.DefaultProduceTopic(k.Topic),
+		// records with the same key always end up in the same partition
+		kgo.RecordPartitioner(kgo.StickyKeyPartitioner(nil)),
+	)
+
+	client, err := kgo.NewClient(options...)
+
+	if err != nil {
+		return err
+	}
+
+	k.Client = client
+
+	return nil
+}
+
+// Returns the record key for an item (if a key field is defined)
+func (k *KafkaWriter) key(item *kodex.Item) ([]byte, error) {
+	if k.KeyField == "" {
+		return nil, nil
+	}
+	value, ok := item.Get(k.KeyField)
+	if !ok || value == nil {
+		return nil, nil
+	}
+	switch v := value.(type) {
+	case string:
+		return []byte(v), nil
+	case []byte:
+		return v, nil
+	default:
+		return json.Marshal(v)
+	}
+}
+
+func (k *KafkaWriter) Write(payload kodex.Payload) error {
+
+	if k.Client == nil {
+		return fmt.Errorf("Kafka writer is not set up")
+	}
+
+	headers := make([]kgo.RecordHeader, 0, len(k.Headers)+1)
+
+	for key, value := range k.Headers {
+		headers = append(headers, kgo.RecordHeader{Key: key, Value: []byte(value.(string))})
+	}
+
+	// if a config is given we add the config ID to the headers, so the consumer
+	// can know which config these items originate from
+	if k.Config != nil {
+		headers = append(headers, kgo.RecordHeader{Key: "X-KIP-Config", Value: []byte(hex.EncodeToString(k.Config.ID()))})
+	}
+
+	records := make([]*kgo.Record, 0, len(payload.Items()))
+
+	for _, item := range payload.Items() {
+		value, err := k.SerializeItem(item)
+		if err != nil {
+			return err
+		}
+		key, err := k.key(item)
+		if err != nil {
+			return err
+		}
+		records = append(records, &kgo.Record{
+			Key:     key,
+			Value:   value,
+			Headers: headers,
+		})
+	}
+
+	if len(records) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), k.Timeout)
+	defer cancel()
+
+	return k.Client.ProduceSync(ctx, records...).FirstErr()
+}
@@ -0,0 +1,85 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers
+
+import (
+	"github.com/kiprotect/go-helpers/forms"
+)
+
+var KafkaBaseForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the Kafka form",
+	Fields: append([]forms.Field{
+		{
+			Name: "brokers",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsList{
+					Validators: []forms.Validator{
+						forms.IsString{},
+					},
+				},
+			},
+		},
+		{
+			Name: "topic",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{},
+			},
+		},
+		{
+			// the item field that holds the record key
+			Name: "key-field",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "headers",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: map[string]interface{}{}},
+				forms.IsStringMap{
+					Form: &forms.Form{
+						Fields: []forms.Field{
+							{
+								Name: "*",
+								Validators: []forms.Validator{
+									forms.IsString{},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}, ValueFormatFields...),
+}
+
+var KafkaWriterForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the Kafka writer form",
+	Fields: append([]forms.Field{
+		{
+			// request timeout (in seconds)
+			Name: "timeout",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(30)},
+				forms.IsInteger{HasMin: true, Min: 1, HasMax: true, Max: 3600},
+			},
+		},
+	}, KafkaBaseForm.Fields...),
+}
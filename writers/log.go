@@ -0,0 +1,427 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+The log writer implements a durable internal channel: payloads are appended
+to a log on disk, which consists of segment files per model, named by the
+offset of their first byte. Each record consists of its length, a CRC-32
+checksum and the serialized payload. The log reader keeps a committed offset
+per consumer in the same directory, and segments that all consumers have
+read past are deleted. Only a single process should write to a given log.
+*/
+type LogWriter struct {
+	LogBase
+	SegmentSize int64
+	Sync        bool
+	log         *logFile
+}
+
+// Functionality shared by the log reader and writer
+type LogBase struct {
+	Directory string
+	Path      string
+}
+
+const LogHeaderSize = 8
+
+var ErrCorruptLogRecord = errors.New("corrupt log record")
+
+func MakeLogBase(params map[string]interface{}) LogBase {
+	return LogBase{
+		Directory: params["directory"].(string),
+	}
+}
+
+// Sets up the log directory of the given model
+func (l *LogBase) SetupWithModel(model kodex.Model) error {
+	l.Path = filepath.Join(l.Directory, model.Type(), hex.EncodeToString(model.ID()))
+	return os.MkdirAll(l.Path, 0700)
+}
+
+func LogSegmentPath(path string, base int64) string {
+	return filepath.Join(path, fmt.Sprintf("%020d.log", base))
+}
+
+// Returns the base offsets of all segments of the log, in ascending order
+func LogSegments(path string) ([]int64, error) {
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	segments := make([]int64, 0)
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".log") {
+			continue
+		}
+		base, err := strconv.ParseInt(strings.TrimSuffix(entry.Name(), ".log"), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, base)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return segments, nil
+}
+
+// Reads the record at the given position of a segment. Returns io.EOF if
+// there is no record at this position yet and io.ErrUnexpectedEOF if the
+// record is incomplete (e.g. because it is still being written).
+func ReadLogRecord(file io.ReaderAt, position int64) ([]byte, error) {
+	header := make([]byte, LogHeaderSize)
+	if n, err := file.ReadAt(header, position); err != nil {
+		if err == io.EOF && n > 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	data := make([]byte, length)
+	if _, err := file.ReadAt(data, position+LogHeaderSize); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, ErrCorruptLogRecord
+	}
+	return data, nil
+}
+
+// Returns the number of complete records in the log from the given offset on
+func CountLogRecords(path string, offset int64) (int64, error) {
+
+	segments, err := LogSegments(path)
+
+	if err != nil {
+		return 0, err
+	}
+
+	header := make([]byte, LogHeaderSize)
+
+	var count int64
+
+	for i, base := range segments {
+
+		// we skip segments that end before the offset
+		if i < len(segments)-1 && segments[i+1] <= offset {
+			continue
+		}
+
+		file, err := os.Open(LogSegmentPath(path, base))
+
+		if err != nil {
+			return 0, err
+		}
+
+		position := offset - base
+
+		if position < 0 {
+			position = 0
+		}
+
+		// we only read the headers, which tell us where the next record starts
+		for {
+			if _, err := file.ReadAt(header, position); err != nil {
+				break
+			}
+			length := int64(binary.BigEndian.Uint32(header[0:4]))
+			// we do not count the last record if it is incomplete
+			if _, err := file.ReadAt(header[:1], position+LogHeaderSize+length-1); length > 0 && err != nil {
+				break
+			}
+			position += LogHeaderSize + length
+			count++
+		}
+
+		file.Close()
+	}
+
+	return count, nil
+}
+
+func offsetPath(path, consumer string) string {
+	return filepath.Join(path, consumer+".offset")
+}
+
+// Returns the committed offset of the given consumer (0 if there is none)
+func ReadLogOffset(path, consumer string) (int64, error) {
+	data, err := ioutil.ReadFile(offsetPath(path, consumer))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// Commits the offset of the given consumer. We write the offset to a
+// temporary file first and rename it, so the offset is never lost.
+func WriteLogOffset(path, consumer string, offset int64) error {
+	tempPath := offsetPath(path, consumer) + ".tmp"
+	file, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(strconv.FormatInt(offset, 10)); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, offsetPath(path, consumer))
+}
+
+// Deletes all segments that every consumer of the log has read completely.
+// The last segment is never deleted, as the writer might still append to it.
+func CompactLog(path string) error {
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	committed := int64(math.MaxInt64)
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".offset") {
+			continue
+		}
+		offset, err := ReadLogOffset(path, strings.TrimSuffix(entry.Name(), ".offset"))
+		if err != nil {
+			return err
+		}
+		if offset < committed {
+			committed = offset
+		}
+	}
+	segments, err := LogSegments(path)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(segments)-1; i++ {
+		// a segment ends where the next one begins
+		if segments[i+1] > committed {
+			break
+		}
+		if err := os.Remove(LogSegmentPath(path, segments[i])); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// The active segment of a log, which is shared by all writers of the log
+// within this process
+type logFile struct {
+	mutex sync.Mutex
+	path  string
+	refs  int
+	file  *os.File
+	base  int64
+	size  int64
+}
+
+var logFiles = struct {
+	mutex sync.Mutex
+	files map[string]*logFile
+}{files: map[string]*logFile{}}
+
+func openLogFile(path string) (*logFile, error) {
+	logFiles.mutex.Lock()
+	defer logFiles.mutex.Unlock()
+
+	if log, ok := logFiles.files[path]; ok {
+		log.refs++
+		return log, nil
+	}
+
+	segments, err := LogSegments(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	log := &logFile{path: path, refs: 1}
+
+	if len(segments) > 0 {
+		log.base = segments[len(segments)-1]
+	}
+
+	if err := log.open(); err != nil {
+		return nil, err
+	}
+
+	logFiles.files[path] = log
+
+	return log, nil
+}
+
+func closeLogFile(log *logFile) error {
+	logFiles.mutex.Lock()
+	defer logFiles.mutex.Unlock()
+
+	log.refs--
+
+	if log.refs > 0 {
+		return nil
+	}
+
+	delete(logFiles.files, log.path)
+
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+
+	return log.file.Close()
+}
+
+// Opens the active segment. If the last record of the segment is
+// incomplete (e.g. because we crashed while writing it) we truncate it.
+func (l *logFile) open() error {
+	file, err := os.OpenFile(LogSegmentPath(l.path, l.base), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	var position int64
+	for {
+		data, err := ReadLogRecord(file, position)
+		if err == io.EOF {
+			break
+		} else if err == io.ErrUnexpectedEOF || err == ErrCorruptLogRecord {
+			kodex.Log.Warningf("Truncating incomplete record at offset %d of log %s", l.base+position, l.path)
+			if err := file.Truncate(position); err != nil {
+				file.Close()
+				return err
+			}
+			break
+		} else if err != nil {
+			file.Close()
+			return err
+		}
+		position += LogHeaderSize + int64(len(data))
+	}
+	l.file = file
+	l.size = position
+	return nil
+}
+
+func (l *logFile) append(data []byte, segmentSize int64, sync bool) error {
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	record := make([]byte, LogHeaderSize+len(data))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(data))
+	copy(record[LogHeaderSize:], data)
+
+	if l.size > 0 && l.size+int64(len(record)) > segmentSize {
+		if err := l.file.Close(); err != nil {
+			return err
+		}
+		l.base += l.size
+		if err := l.open(); err != nil {
+			return err
+		}
+	}
+
+	if n, err := l.file.Write(record); err != nil {
+		// we remove the partially written record again
+		if n > 0 {
+			l.file.Truncate(l.size)
+		}
+		return err
+	}
+
+	l.size += int64(len(record))
+
+	if sync {
+		return l.file.Sync()
+	}
+
+	return nil
+}
+
+func MakeLogWriter(config map[string]interface{}) (kodex.Writer, error) {
+	if params, err := LogWriterForm.Validate(config); err != nil {
+		return nil, err
+	} else {
+		return &LogWriter{
+			LogBase:     MakeLogBase(params),
+			SegmentSize: params["segment-size"].(int64),
+			Sync:        params["sync"].(bool),
+		}, nil
+	}
+}
+
+func (l *LogWriter) Setup(config kodex.Config) error {
+	return fmt.Errorf("the log writer can only be used as an internal channel")
+}
+
+func (l *LogWriter) SetupWithModel(model kodex.Model) error {
+	if err := l.LogBase.SetupWithModel(model); err != nil {
+		return err
+	}
+	log, err := openLogFile(l.Path)
+	if err != nil {
+		return err
+	}
+	l.log = log
+	return nil
+}
+
+func (l *LogWriter) Teardown() error {
+	if l.log == nil {
+		return nil
+	}
+	log := l.log
+	l.log = nil
+	return closeLogFile(log)
+}
+
+func (l *LogWriter) Write(payload kodex.Payload) error {
+	if l.log == nil {
+		return fmt.Errorf("log writer is not set up")
+	}
+	data, err := EncodeChannelRecord(payload)
+	if err != nil {
+		return err
+	}
+	return l.log.append(data, l.SegmentSize, l.Sync)
+}
@@ -0,0 +1,57 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers
+
+import (
+	"github.com/kiprotect/go-helpers/forms"
+)
+
+var LogBaseForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the log form",
+	Fields: []forms.Field{
+		{
+			// the directory in which the logs of all channels are stored
+			Name: "directory",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{},
+			},
+		},
+	},
+}
+
+var LogWriterForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the log writer form",
+	Fields: append([]forms.Field{
+		{
+			// segments are rotated once they reach the given size (in bytes)
+			Name: "segment-size",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(64 * 1024 * 1024)},
+				forms.IsInteger{HasMin: true, Min: 1024},
+			},
+		},
+		{
+			// flushes every record to disk before the write succeeds
+			Name: "sync",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: true},
+				forms.IsBoolean{},
+			},
+		},
+	}, LogBaseForm.Fields...),
+}
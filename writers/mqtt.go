@@ -0,0 +1,262 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers
+
+import (
+	"encoding/hex"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/kiprotect/kodex"
+	"strings"
+	"time"
+)
+
+// Functionality shared by the MQTT reader and writer
+type MQTTBase struct {
+	ValueFormat
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	QoS      byte
+	CertFile string
+	KeyFile  string
+	CAFile   string
+	Timeout  time.Duration
+	Client   mqtt.Client
+}
+
+func MakeMQTTBase(params map[string]interface{}) (MQTTBase, error) {
+
+	valueFormat, err := MakeValueFormat(params)
+
+	if err != nil {
+		return MQTTBase{}, err
+	}
+
+	base := MQTTBase{
+		ValueFormat: valueFormat,
+		Broker:      params["broker"].(string),
+		ClientID:    params["client-id"].(string),
+		Username:    params["username"].(string),
+		Password:    params["password"].(string),
+		QoS:         byte(params["qos"].(int64)),
+		CertFile:    params["cert-file"].(string),
+		KeyFile:     params["key-file"].(string),
+		CAFile:      params["ca-file"].(string),
+		Timeout:     time.Duration(params["timeout"].(int64)) * time.Second,
+	}
+
+	if base.ClientID == "" {
+		base.ClientID = "kodex-" + hex.EncodeToString(kodex.RandomID()[:8])
+	}
+
+	return base, nil
+}
+
+// Returns the client options, which can be modified before connecting
+func (m *MQTTBase) ClientOptions() (*mqtt.ClientOptions, error) {
+
+	options := mqtt.NewClientOptions()
+	options.AddBroker(m.Broker)
+	options.SetClientID(m.ClientID)
+	options.SetConnectTimeout(m.Timeout)
+	options.SetAutoReconnect(true)
+
+	if m.Username != "" {
+		options.SetUsername(m.Username)
+		options.SetPassword(m.Password)
+	}
+
+	tlsConfig, err := ClientTLSConfig(m.CertFile, m.KeyFile, m.CAFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tlsConfig != nil {
+		options.SetTLSConfig(tlsConfig)
+	}
+
+	return options, nil
+}
+
+func (m *MQTTBase) Connect(options *mqtt.ClientOptions) error {
+
+	client := mqtt.NewClient(options)
+	token := client.Connect()
+
+	if !token.WaitTimeout(m.Timeout) {
+		return fmt.Errorf("timeout while connecting to MQTT broker")
+	} else if err := token.Error(); err != nil {
+		return err
+	}
+
+	m.Client = client
+
+	return nil
+}
+
+func (m *MQTTBase) Teardown() error {
+	if m.Client != nil {
+		m.Client.Disconnect(250)
+		m.Client = nil
+	}
+	return nil
+}
+
+// A topic with placeholders for item fields, e.g. 'devices/{device}/location'
+type MQTTTopicTemplate struct {
+	// literal parts and field names alternate, starting with a literal part
+	parts []string
+}
+
+func MakeMQTTTopicTemplate(template string) (*MQTTTopicTemplate, error) {
+
+	parts := make([]string, 0)
+	rest := template
+
+	for {
+		start := strings.Index(rest, "{")
+		if start == -1 {
+			if strings.Contains(rest, "}") {
+				return nil, fmt.Errorf("unbalanced braces in topic template")
+			}
+			parts = append(parts, rest)
+			break
+		}
+		end := strings.Index(rest[start:], "}")
+		if end == -1 || strings.Contains(rest[:start], "}") {
+			return nil, fmt.Errorf("unbalanced braces in topic template")
+		}
+		field := rest[start+1 : start+end]
+		if field == "" {
+			return nil, fmt.Errorf("empty field name in topic template")
+		}
+		parts = append(parts, rest[:start], field)
+		rest = rest[start+end+1:]
+	}
+
+	for i := 0; i < len(parts); i += 2 {
+		if strings.ContainsAny(parts[i], "+#") {
+			return nil, fmt.Errorf("wildcards are not allowed in topics to publish to")
+		}
+	}
+
+	return &MQTTTopicTemplate{parts: parts}, nil
+}
+
+// Returns the topic for the given item
+func (t *MQTTTopicTemplate) Topic(item *kodex.Item) (string, error) {
+
+	var builder strings.Builder
+
+	for i, part := range t.parts {
+		if i%2 == 0 {
+			builder.WriteString(part)
+			continue
+		}
+		value, ok := item.Get(part)
+		if !ok || value == nil {
+			return "", fmt.Errorf("item field '%s' for topic is missing", part)
+		}
+		str, ok := value.(string)
+		if !ok {
+			str = fmt.Sprint(value)
+		}
+		// field values must not change the topic structure
+		if str == "" || strings.ContainsAny(str, "/+#\x00") {
+			return "", fmt.Errorf("invalid value for topic field '%s'", part)
+		}
+		builder.WriteString(str)
+	}
+
+	return builder.String(), nil
+}
+
+type MQTTWriter struct {
+	MQTTBase
+	Topic  *MQTTTopicTemplate
+	Retain bool
+}
+
+func MakeMQTTWriter(config map[string]interface{}) (kodex.Writer, error) {
+	if params, err := MQTTWriterForm.Validate(config); err != nil {
+		return nil, err
+	} else {
+		base, err := MakeMQTTBase(params)
+		if err != nil {
+			return nil, err
+		}
+		topic, err := MakeMQTTTopicTemplate(params["topic"].(string))
+		if err != nil {
+			return nil, err
+		}
+		return &MQTTWriter{
+			MQTTBase: base,
+			Topic:    topic,
+			Retain:   params["retain"].(bool),
+		}, nil
+	}
+}
+
+func (m *MQTTWriter) Setup(config kodex.Config) error {
+
+	if m.Client != nil {
+		return nil
+	}
+
+	options, err := m.ClientOptions()
+
+	if err != nil {
+		return err
+	}
+
+	return m.Connect(options)
+}
+
+func (m *MQTTWriter) Write(payload kodex.Payload) error {
+
+	if m.Client == nil {
+		return fmt.Errorf("MQTT writer is not set up")
+	}
+
+	tokens := make([]mqtt.Token, 0, len(payload.Items()))
+
+	for _, item := range payload.Items() {
+		topic, err := m.Topic.Topic(item)
+		if err != nil {
+			return err
+		}
+		value, err := m.SerializeItem(item)
+		if err != nil {
+			return err
+		}
+		tokens = append(tokens, m.Client.Publish(topic, m.QoS, m.Retain, value))
+	}
+
+	// we wait until all messages have been delivered (depending on the QoS)
+	for _, token := range tokens {
+		if !token.WaitTimeout(m.Timeout) {
+			return fmt.Errorf("timeout while publishing MQTT message")
+		} else if err := token.Error(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
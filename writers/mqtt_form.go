@@ -0,0 +1,117 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers
+
+import (
+	"github.com/kiprotect/go-helpers/forms"
+)
+
+var MQTTBaseForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the MQTT form",
+	Fields: append([]forms.Field{
+		{
+			// e.g. tcp://localhost:1883 or ssl://localhost:8883
+			Name: "broker",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{},
+			},
+		},
+		{
+			// a random client ID is generated if none is given (readers need
+			// a fixed one for persistent sessions)
+			Name: "client-id",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "username",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "password",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "qos",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(1)},
+				forms.IsInteger{HasMin: true, Min: 0, HasMax: true, Max: 2},
+			},
+		},
+		{
+			// CA for verifying the broker certificate (system CAs are used otherwise)
+			Name: "ca-file",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// client certificate (for mTLS)
+			Name: "cert-file",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "key-file",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// connection and publishing timeout (in seconds)
+			Name: "timeout",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(30)},
+				forms.IsInteger{HasMin: true, Min: 1, HasMax: true, Max: 3600},
+			},
+		},
+	}, ValueFormatFields...),
+}
+
+var MQTTWriterForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the MQTT writer form",
+	Fields: append([]forms.Field{
+		{
+			// the topic, which can contain item fields, e.g. 'devices/{device}/location'
+			Name: "topic",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "retain",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: false},
+				forms.IsBoolean{},
+			},
+		},
+	}, MQTTBaseForm.Fields...),
+}
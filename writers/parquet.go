@@ -0,0 +1,125 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers
+
+import (
+	"fmt"
+	goparquet "github.com/fraugster/parquet-go"
+	"github.com/fraugster/parquet-go/parquet"
+	"github.com/fraugster/parquet-go/parquetschema"
+	"io"
+	"strings"
+)
+
+// Encodes items as a Parquet file. The file is only complete once the
+// encoder is closed.
+type ParquetEncoder struct {
+	typedEncoder
+	output   io.Writer
+	compress bool
+	writer   *goparquet.FileWriter
+	columns  []string
+}
+
+func MakeParquetEncoder(output io.Writer, format FileFormat) *ParquetEncoder {
+	encoder := &ParquetEncoder{
+		output:   output,
+		compress: format.Compress,
+	}
+	encoder.Schema = format.Schema
+	encoder.rows = encoder
+	return encoder
+}
+
+// Returns the physical type and annotation of a column
+func parquetType(columnType string) (string, string) {
+	switch columnType {
+	case IntColumn:
+		return "int64", ""
+	case FloatColumn:
+		return "double", ""
+	case BoolColumn:
+		return "boolean", ""
+	case JSONColumn:
+		return "binary", " (JSON)"
+	default:
+		return "binary", " (STRING)"
+	}
+}
+
+func (p *ParquetEncoder) setup(schema Schema) error {
+
+	var definition strings.Builder
+
+	definition.WriteString("message item {\n")
+
+	p.columns = make([]string, len(schema))
+
+	for i, column := range schema {
+		if !columnName.MatchString(column.Name) {
+			return fmt.Errorf("'%s' is not a valid Parquet column name", column.Name)
+		}
+		p.columns[i] = column.Name
+		physicalType, annotation := parquetType(column.Type)
+		fmt.Fprintf(&definition, "  optional %s %s%s;\n", physicalType, column.Name, annotation)
+	}
+
+	definition.WriteString("}\n")
+
+	schemaDefinition, err := parquetschema.ParseSchemaDefinition(definition.String())
+
+	if err != nil {
+		return err
+	}
+
+	codec := parquet.CompressionCodec_UNCOMPRESSED
+
+	if p.compress {
+		codec = parquet.CompressionCodec_SNAPPY
+	}
+
+	p.writer = goparquet.NewFileWriter(p.output,
+		goparquet.WithSchemaDefinition(schemaDefinition),
+		goparquet.WithCompressionCodec(codec),
+		goparquet.WithCreator("kodex"),
+	)
+
+	return nil
+}
+
+func (p *ParquetEncoder) writeRow(values []interface{}) error {
+
+	row := make(map[string]interface{}, len(values))
+
+	for i, value := range values {
+		switch v := value.(type) {
+		case nil:
+			// missing values are null
+			continue
+		case string:
+			row[p.columns[i]] = []byte(v)
+		default:
+			row[p.columns[i]] = v
+		}
+	}
+
+	return p.writer.AddData(row)
+}
+
+func (p *ParquetEncoder) close() error {
+	return p.writer.Close()
+}
@@ -0,0 +1,111 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/go-redis/redis"
+	"github.com/kiprotect/kodex"
+	"time"
+)
+
+/*
+The Redis writer implements a durable internal channel based on Redis
+Streams. As Redis is shared between processes, sources, streams and
+destinations that exchange payloads through it can run in different processes
+(and on different machines).
+*/
+type RedisWriter struct {
+	RedisBase
+	MaxLength int64
+}
+
+// Functionality shared by the Redis reader and writer
+type RedisBase struct {
+	Options redis.UniversalOptions
+	Prefix  string
+	Stream  string
+	Client  redis.UniversalClient
+}
+
+func MakeRedisBase(params map[string]interface{}) RedisBase {
+	return RedisBase{
+		Options: redis.UniversalOptions{
+			Addrs:        toStringList(params["addresses"].([]interface{})),
+			Password:     params["password"].(string),
+			DB:           int(params["database"].(int64)),
+			ReadTimeout:  time.Second * 5,
+			WriteTimeout: time.Second * 5,
+		},
+		Prefix: params["prefix"].(string),
+	}
+}
+
+// Connects to Redis and determines the stream of the given model
+func (r *RedisBase) SetupWithModel(model kodex.Model) error {
+	r.Stream = fmt.Sprintf("%s:%s:%s", r.Prefix, model.Type(), hex.EncodeToString(model.ID()))
+	if r.Client != nil {
+		return nil
+	}
+	client := redis.NewUniversalClient(&r.Options)
+	if _, err := client.Ping().Result(); err != nil {
+		client.Close()
+		return err
+	}
+	r.Client = client
+	return nil
+}
+
+func (r *RedisBase) Teardown() error {
+	if r.Client != nil {
+		err := r.Client.Close()
+		r.Client = nil
+		return err
+	}
+	return nil
+}
+
+func MakeRedisWriter(config map[string]interface{}) (kodex.Writer, error) {
+	if params, err := RedisWriterForm.Validate(config); err != nil {
+		return nil, err
+	} else {
+		return &RedisWriter{
+			RedisBase: MakeRedisBase(params),
+			MaxLength: params["max-length"].(int64),
+		}, nil
+	}
+}
+
+func (r *RedisWriter) Setup(config kodex.Config) error {
+	return fmt.Errorf("the Redis writer can only be used as an internal channel")
+}
+
+func (r *RedisWriter) Write(payload kodex.Payload) error {
+	if r.Client == nil {
+		return fmt.Errorf("Redis writer is not set up")
+	}
+	data, err := EncodeChannelRecord(payload)
+	if err != nil {
+		return err
+	}
+	return r.Client.XAdd(&redis.XAddArgs{
+		Stream:       r.Stream,
+		MaxLenApprox: r.MaxLength,
+		Values:       map[string]interface{}{"payload": data},
+	}).Err()
+}
@@ -0,0 +1,75 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers
+
+import (
+	"github.com/kiprotect/go-helpers/forms"
+)
+
+var RedisBaseForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the Redis form",
+	Fields: []forms.Field{
+		{
+			Name: "addresses",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsList{
+					Validators: []forms.Validator{
+						forms.IsString{},
+					},
+				},
+			},
+		},
+		{
+			Name: "database",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(0)},
+				forms.IsInteger{HasMin: true, Min: 0, HasMax: true, Max: 100},
+			},
+		},
+		{
+			Name: "password",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// the prefix of the Redis stream keys
+			Name: "prefix",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "kodex"},
+				forms.IsString{},
+			},
+		},
+	},
+}
+
+var RedisWriterForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the Redis writer form",
+	Fields: append([]forms.Field{
+		{
+			// trims streams to (approximately) the given number of payloads
+			// (0 = never). Payloads that were trimmed before being read are lost.
+			Name: "max-length",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(0)},
+				forms.IsInteger{HasMin: true, Min: 0},
+			},
+		},
+	}, RedisBaseForm.Fields...),
+}
@@ -0,0 +1,317 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// Functionality shared by the S3 reader and writer
+type S3Base struct {
+	Endpoint  string
+	Secure    bool
+	Bucket    string
+	Prefix    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	CAFile    string
+	Timeout   time.Duration
+	Client    *minio.Client
+}
+
+func envOrValue(value, env string) (string, error) {
+	if env == "" {
+		return value, nil
+	}
+	if envValue := os.Getenv(env); envValue != "" {
+		return envValue, nil
+	}
+	return "", fmt.Errorf("environment variable '%s' is not set", env)
+}
+
+func MakeS3Base(params map[string]interface{}) (S3Base, error) {
+
+	endpoint, err := url.Parse(params["endpoint"].(string))
+
+	if err != nil {
+		return S3Base{}, err
+	}
+
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" || endpoint.Host == "" {
+		return S3Base{}, fmt.Errorf("invalid S3 endpoint, expected e.g. 'https://host:port'")
+	}
+
+	accessKey, err := envOrValue(params["access-key"].(string), params["access-key-env"].(string))
+
+	if err != nil {
+		return S3Base{}, err
+	}
+
+	secretKey, err := envOrValue(params["secret-key"].(string), params["secret-key-env"].(string))
+
+	if err != nil {
+		return S3Base{}, err
+	}
+
+	return S3Base{
+		Endpoint:  endpoint.Host,
+		Secure:    endpoint.Scheme == "https",
+		Bucket:    params["bucket"].(string),
+		Prefix:    params["prefix"].(string),
+		Region:    params["region"].(string),
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		CAFile:    params["ca-file"].(string),
+		Timeout:   time.Duration(params["timeout"].(int64)) * time.Second,
+	}, nil
+}
+
+func (s *S3Base) Connect() error {
+
+	if s.Client != nil {
+		return nil
+	}
+
+	transport, err := minio.DefaultTransport(s.Secure)
+
+	if err != nil {
+		return err
+	}
+
+	tlsConfig, err := ClientTLSConfig("", "", s.CAFile)
+
+	if err != nil {
+		return err
+	}
+
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	client, err := minio.New(s.Endpoint, &minio.Options{
+		Creds:     credentials.NewStaticV4(s.AccessKey, s.SecretKey, ""),
+		Secure:    s.Secure,
+		Region:    s.Region,
+		Transport: transport,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	s.Client = client
+
+	return nil
+}
+
+func (s *S3Base) Context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), s.Timeout)
+}
+
+/*
+The S3 writer collects items in an object and uploads it once it reaches a
+given size or after a given interval, whichever happens first. Remaining
+items are uploaded when the writer is torn down.
+*/
+type S3Writer struct {
+	S3Base
+	FileFormat
+	BaseName       string
+	RotateInterval time.Duration
+	MaxObjectSize  int
+	mutex          sync.Mutex
+	buffer         *bytes.Buffer
+	encoder        ItemEncoder
+	started        time.Time
+	stop           chan bool
+	done           chan bool
+}
+
+func MakeS3Writer(config map[string]interface{}) (kodex.Writer, error) {
+	if params, err := S3WriterForm.Validate(config); err != nil {
+		return nil, err
+	} else {
+		base, err := MakeS3Base(params)
+		if err != nil {
+			return nil, err
+		}
+		format, err := MakeFileFormat(params)
+		if err != nil {
+			return nil, err
+		}
+		writer := &S3Writer{
+			S3Base:         base,
+			FileFormat:     format,
+			BaseName:       params["base-name"].(string),
+			RotateInterval: time.Duration(params["rotate-interval"].(int64)) * time.Second,
+			MaxObjectSize:  int(params["max-object-size"].(int64)),
+		}
+		return writer, nil
+	}
+}
+
+func (s *S3Writer) Setup(config kodex.Config) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.stop != nil {
+		return nil
+	}
+
+	if err := s.Connect(); err != nil {
+		return err
+	}
+
+	s.stop = make(chan bool)
+	s.done = make(chan bool)
+
+	go s.rotate(s.stop, s.done)
+
+	return nil
+}
+
+// Uploads objects once their rotation interval has passed, even if no
+// more items are written.
+func (s *S3Writer) rotate(stop, done chan bool) {
+	defer close(done)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.mutex.Lock()
+			if s.buffer != nil && time.Since(s.started) >= s.RotateInterval {
+				if err := s.upload(); err != nil {
+					kodex.Log.Errorf("Cannot upload S3 object: %v", err)
+				}
+			}
+			s.mutex.Unlock()
+		}
+	}
+}
+
+// Returns the key for a new object
+func (s *S3Writer) key(t time.Time) string {
+	return fmt.Sprintf("%s%s-%s-%s.%s", s.Prefix, s.BaseName, t.UTC().Format("20060102T150405Z"), hex.EncodeToString(kodex.RandomID()[:4]), s.Extension())
+}
+
+// Uploads the current object (the caller must hold the mutex)
+func (s *S3Writer) upload() error {
+
+	if s.buffer == nil {
+		return nil
+	}
+
+	if s.encoder != nil {
+		if err := s.encoder.Close(); err != nil {
+			return err
+		}
+		s.encoder = nil
+	}
+
+	options := minio.PutObjectOptions{
+		ContentType: s.ContentType(),
+	}
+
+	ctx, cancel := s.Context()
+	defer cancel()
+
+	data := s.buffer.Bytes()
+
+	// if the upload fails, we keep the object and try again later
+	if _, err := s.Client.PutObject(ctx, s.Bucket, s.key(s.started), bytes.NewReader(data), int64(len(data)), options); err != nil {
+		return err
+	}
+
+	s.buffer = nil
+
+	return nil
+}
+
+func (s *S3Writer) Write(payload kodex.Payload) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.Client == nil {
+		return fmt.Errorf("S3 writer is not set up")
+	}
+
+	for _, item := range payload.Items() {
+
+		if s.buffer != nil && s.encoder == nil {
+			// a previous upload failed, we try again first
+			if err := s.upload(); err != nil {
+				return err
+			}
+		}
+
+		if s.buffer == nil {
+			s.buffer = &bytes.Buffer{}
+			s.encoder = MakeItemEncoder(s.buffer, s.FileFormat)
+			s.started = time.Now()
+		}
+
+		if err := s.encoder.Encode(item); err != nil {
+			return err
+		}
+
+		if s.encoder.Size() >= s.MaxObjectSize || time.Since(s.started) >= s.RotateInterval {
+			if err := s.upload(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *S3Writer) Teardown() error {
+
+	s.mutex.Lock()
+	stop, done := s.stop, s.done
+	s.stop, s.done = nil, nil
+	s.mutex.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.Client == nil {
+		return nil
+	}
+
+	return s.upload()
+}
@@ -0,0 +1,135 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers
+
+import (
+	"github.com/kiprotect/go-helpers/forms"
+)
+
+var S3BaseForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the S3 form",
+	Fields: []forms.Field{
+		{
+			// e.g. https://s3.eu-central-1.amazonaws.com or http://localhost:9000
+			Name: "endpoint",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{MinLength: 1},
+			},
+		},
+		{
+			Name: "bucket",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{MinLength: 1},
+			},
+		},
+		{
+			// only objects with this key prefix are read (or written)
+			Name: "prefix",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "region",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "access-key",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// the name of an environment variable that holds the access key
+			Name: "access-key-env",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "secret-key",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// the name of an environment variable that holds the secret key
+			Name: "secret-key-env",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// CA for verifying the endpoint certificate (system CAs are used otherwise)
+			Name: "ca-file",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// timeout for individual requests (in seconds)
+			Name: "timeout",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(60)},
+				forms.IsInteger{HasMin: true, Min: 1, HasMax: true, Max: 3600},
+			},
+		},
+	},
+}
+
+var S3WriterForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the S3 writer form",
+	Fields: append([]forms.Field{
+		{
+			// object keys start with the prefix and the base name, followed
+			// by the time and a random suffix
+			Name: "base-name",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{MinLength: 1},
+			},
+		},
+		{
+			// an object is uploaded at the latest this many seconds after
+			// its first item was written...
+			Name: "rotate-interval",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(60)},
+				forms.IsInteger{HasMin: true, Min: 1, HasMax: true, Max: 86400},
+			},
+		},
+		{
+			// ...or once it reaches this size (in bytes, before compression)
+			Name: "max-object-size",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(64 * 1024 * 1024)},
+				forms.IsInteger{HasMin: true, Min: 1, HasMax: true, Max: 5 * 1024 * 1024 * 1024},
+			},
+		},
+	}, append(FileFormatFields, S3BaseForm.Fields...)...),
+}
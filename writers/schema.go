@@ -0,0 +1,243 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"math"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// Column types of the typed file formats (Parquet and Avro). All columns
+// are nullable, 'json' columns contain JSON-encoded values as strings.
+const (
+	StringColumn = "string"
+	IntColumn    = "int"
+	FloatColumn  = "float"
+	BoolColumn   = "bool"
+	JSONColumn   = "json"
+)
+
+var ColumnTypes = []interface{}{StringColumn, IntColumn, FloatColumn, BoolColumn, JSONColumn}
+
+// Column names that are valid in both Parquet and Avro schemas
+var columnName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type SchemaColumn struct {
+	Name string
+	Type string
+}
+
+// A schema for typed file formats, with columns sorted by name.
+type Schema []SchemaColumn
+
+// Makes a schema from a map of column names and types, as declared in a
+// blueprint (e.g. {'device': 'string', 'lat': 'float'})
+func MakeSchema(columns map[string]interface{}) (Schema, error) {
+
+	schema := make(Schema, 0, len(columns))
+
+	for name, columnType := range columns {
+		strType, ok := columnType.(string)
+		if !ok {
+			return nil, fmt.Errorf("type of column '%s' is not a string", name)
+		}
+		valid := false
+		for _, validType := range ColumnTypes {
+			if strType == validType {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("invalid type '%s' for column '%s'", strType, name)
+		}
+		schema = append(schema, SchemaColumn{Name: name, Type: strType})
+	}
+
+	sort.Slice(schema, func(i, j int) bool { return schema[i].Name < schema[j].Name })
+
+	return schema, nil
+}
+
+func inferColumnType(value interface{}) string {
+	switch value.(type) {
+	case string, time.Time:
+		return StringColumn
+	case int, int8, int16, int32, int64, uint8, uint16, uint32:
+		return IntColumn
+	case float32, float64:
+		return FloatColumn
+	case bool:
+		return BoolColumn
+	default:
+		return JSONColumn
+	}
+}
+
+// Infers a schema from a batch of items. Fields without non-null values
+// become string columns.
+func InferSchema(items []*kodex.Item) (Schema, error) {
+
+	columns := make(map[string]interface{})
+
+	for _, item := range items {
+		for name, value := range item.All() {
+			if value == nil {
+				if _, ok := columns[name]; !ok {
+					columns[name] = nil
+				}
+				continue
+			}
+			columnType := inferColumnType(value)
+			if existingType, ok := columns[name]; ok && existingType != nil && existingType != columnType {
+				if existingType == IntColumn && columnType == FloatColumn {
+					columns[name] = FloatColumn
+				} else if existingType != FloatColumn || columnType != IntColumn {
+					return nil, fmt.Errorf("cannot infer schema: field '%s' has values of type %s and %s", name, existingType, columnType)
+				}
+				continue
+			}
+			columns[name] = columnType
+		}
+	}
+
+	for name, columnType := range columns {
+		if columnType == nil {
+			columns[name] = StringColumn
+		}
+	}
+
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("cannot infer schema: items have no fields")
+	}
+
+	return MakeSchema(columns)
+}
+
+// Converts a value to the given column type
+func columnValue(value interface{}, columnType string) (interface{}, error) {
+
+	if value == nil {
+		return nil, nil
+	}
+
+	switch columnType {
+	case StringColumn:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case time.Time:
+			return v.UTC().Format(time.RFC3339Nano), nil
+		}
+	case IntColumn:
+		switch v := value.(type) {
+		case int:
+			return int64(v), nil
+		case int8:
+			return int64(v), nil
+		case int16:
+			return int64(v), nil
+		case int32:
+			return int64(v), nil
+		case int64:
+			return v, nil
+		case uint8:
+			return int64(v), nil
+		case uint16:
+			return int64(v), nil
+		case uint32:
+			return int64(v), nil
+		case float64:
+			// JSON numbers are decoded as floats
+			if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+				return int64(v), nil
+			}
+		}
+	case FloatColumn:
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case float32:
+			return float64(v), nil
+		case int:
+			return float64(v), nil
+		case int64:
+			return float64(v), nil
+		case int32:
+			return float64(v), nil
+		}
+	case BoolColumn:
+		if v, ok := value.(bool); ok {
+			return v, nil
+		}
+	case JSONColumn:
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return string(data), nil
+	}
+
+	return nil, fmt.Errorf("expected a value of type %s, got %T (%v)", columnType, value, value)
+}
+
+// Returns the values of an item in the order of the schema columns, converted
+// to the column types. Fails if the item has fields that are not in the schema
+// or values that do not match the column types.
+func (s Schema) Values(item *kodex.Item) ([]interface{}, error) {
+
+	all := item.All()
+	values := make([]interface{}, len(s))
+	found := 0
+
+	for i, column := range s {
+		value, ok := all[column.Name]
+		if !ok {
+			continue
+		}
+		found++
+		if converted, err := columnValue(value, column.Type); err != nil {
+			return nil, fmt.Errorf("field '%s': %v", column.Name, err)
+		} else {
+			values[i] = converted
+		}
+	}
+
+	if found < len(all) {
+		for name := range all {
+			if !s.has(name) {
+				return nil, fmt.Errorf("field '%s' is not part of the schema", name)
+			}
+		}
+	}
+
+	return values, nil
+}
+
+func (s Schema) has(name string) bool {
+	for _, column := range s {
+		if column.Name == name {
+			return true
+		}
+	}
+	return false
+}
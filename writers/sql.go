@@ -0,0 +1,335 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/kiprotect/kodex"
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Functionality shared by the SQL reader and writer
+type SQLBase struct {
+	Driver  string
+	DSN     string
+	Timeout time.Duration
+	DB      *sql.DB
+}
+
+func MakeSQLBase(params map[string]interface{}) SQLBase {
+	return SQLBase{
+		Driver:  params["driver"].(string),
+		DSN:     params["dsn"].(string),
+		Timeout: time.Duration(params["timeout"].(int64)) * time.Second,
+	}
+}
+
+func (s *SQLBase) Open() error {
+
+	if s.DB != nil {
+		return nil
+	}
+
+	db, err := sql.Open(s.Driver, s.DSN)
+
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := s.Context()
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return err
+	}
+
+	s.DB = db
+
+	return nil
+}
+
+func (s *SQLBase) Teardown() error {
+	if s.DB != nil {
+		err := s.DB.Close()
+		s.DB = nil
+		return err
+	}
+	return nil
+}
+
+func (s *SQLBase) Context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), s.Timeout)
+}
+
+// Returns the placeholder for the i-th (starting at 1) query argument
+func (s *SQLBase) Placeholder(i int) string {
+	if s.Driver == "postgres" {
+		return fmt.Sprintf("$%d", i)
+	}
+	return "?"
+}
+
+// Quotes a (validated) identifier, which can include a schema
+func (s *SQLBase) Quote(identifier string) string {
+	parts := strings.Split(identifier, ".")
+	for i, part := range parts {
+		if s.Driver == "mysql" {
+			parts[i] = "`" + part + "`"
+		} else {
+			parts[i] = `"` + part + `"`
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// Converts a value returned by the database into an item value
+func SQLValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	default:
+		return v
+	}
+}
+
+// Converts an item value into a query argument
+func sqlArgument(value interface{}) (interface{}, error) {
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		// structured values are stored as JSON
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return string(data), nil
+	default:
+		return value, nil
+	}
+}
+
+type SQLWriter struct {
+	SQLBase
+	Table        string
+	Columns      []string
+	Fields       []string
+	Mode         string
+	KeyColumns   []string
+	MaxBatchSize int
+}
+
+func MakeSQLWriter(config map[string]interface{}) (kodex.Writer, error) {
+	if params, err := SQLWriterForm.Validate(config); err != nil {
+		return nil, err
+	} else {
+
+		columnsMap := params["columns"].(map[string]interface{})
+
+		if len(columnsMap) == 0 {
+			return nil, fmt.Errorf("at least one column is required")
+		}
+
+		columns := make([]string, 0, len(columnsMap))
+
+		for column := range columnsMap {
+			if _, err := IsSQLIdentifier.Validate(column, nil); err != nil {
+				return nil, fmt.Errorf("invalid column name '%s'", column)
+			}
+			columns = append(columns, column)
+		}
+
+		// we sort the columns to always generate the same statements
+		sort.Strings(columns)
+
+		fields := make([]string, len(columns))
+
+		for i, column := range columns {
+			field, ok := columnsMap[column].(string)
+			if !ok {
+				return nil, fmt.Errorf("field for column '%s' is not a string", column)
+			}
+			fields[i] = field
+		}
+
+		keyColumns := toStringList(params["key-columns"].([]interface{}))
+
+		for _, keyColumn := range keyColumns {
+			if _, ok := columnsMap[keyColumn]; !ok {
+				return nil, fmt.Errorf("key column '%s' is not mapped to a field", keyColumn)
+			}
+		}
+
+		mode := params["mode"].(string)
+
+		if mode == "upsert" && len(keyColumns) == 0 {
+			return nil, fmt.Errorf("upserts require a list of key columns")
+		}
+
+		maxBatchSize := int(params["max-batch-size"].(int64))
+
+		// we stay below the limit for query arguments of all databases
+		if maxBatchSize*len(columns) > 30000 {
+			maxBatchSize = 30000 / len(columns)
+		}
+
+		return &SQLWriter{
+			SQLBase:      MakeSQLBase(params),
+			Table:        params["table"].(string),
+			Columns:      columns,
+			Fields:       fields,
+			Mode:         mode,
+			KeyColumns:   keyColumns,
+			MaxBatchSize: maxBatchSize,
+		}, nil
+	}
+}
+
+func (s *SQLWriter) Setup(config kodex.Config) error {
+	return s.Open()
+}
+
+func (s *SQLWriter) isKeyColumn(column string) bool {
+	for _, keyColumn := range s.KeyColumns {
+		if keyColumn == column {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the clause that turns an insert into an upsert
+func (s *SQLWriter) upsertClause() string {
+
+	updates := make([]string, 0, len(s.Columns))
+
+	for _, column := range s.Columns {
+		if s.isKeyColumn(column) {
+			continue
+		}
+		quoted := s.Quote(column)
+		if s.Driver == "mysql" {
+			updates = append(updates, fmt.Sprintf("%s = VALUES(%s)", quoted, quoted))
+		} else {
+			updates = append(updates, fmt.Sprintf("%s = excluded.%s", quoted, quoted))
+		}
+	}
+
+	if s.Driver == "mysql" {
+		if len(updates) == 0 {
+			// MySQL has no "DO NOTHING", so we perform a no-op update instead
+			quoted := s.Quote(s.KeyColumns[0])
+			updates = append(updates, fmt.Sprintf("%s = %s", quoted, quoted))
+		}
+		return " ON DUPLICATE KEY UPDATE " + strings.Join(updates, ", ")
+	}
+
+	keyColumns := make([]string, len(s.KeyColumns))
+
+	for i, keyColumn := range s.KeyColumns {
+		keyColumns[i] = s.Quote(keyColumn)
+	}
+
+	if len(updates) == 0 {
+		return fmt.Sprintf(" ON CONFLICT (%s) DO NOTHING", strings.Join(keyColumns, ", "))
+	}
+
+	return fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s", strings.Join(keyColumns, ", "), strings.Join(updates, ", "))
+}
+
+// Returns the statement and arguments for inserting the given items
+func (s *SQLWriter) Statement(items []*kodex.Item) (string, []interface{}, error) {
+
+	columns := make([]string, len(s.Columns))
+
+	for i, column := range s.Columns {
+		columns[i] = s.Quote(column)
+	}
+
+	rows := make([]string, 0, len(items))
+	args := make([]interface{}, 0, len(items)*len(s.Columns))
+
+	for _, item := range items {
+		placeholders := make([]string, len(s.Fields))
+		for i, field := range s.Fields {
+			value, _ := item.Get(field)
+			arg, err := sqlArgument(value)
+			if err != nil {
+				return "", nil, err
+			}
+			args = append(args, arg)
+			placeholders[i] = s.Placeholder(len(args))
+		}
+		rows = append(rows, "("+strings.Join(placeholders, ", ")+")")
+	}
+
+	statement := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", s.Quote(s.Table), strings.Join(columns, ", "), strings.Join(rows, ", "))
+
+	if s.Mode == "upsert" {
+		statement += s.upsertClause()
+	}
+
+	return statement, args, nil
+}
+
+func (s *SQLWriter) Write(payload kodex.Payload) error {
+
+	if s.DB == nil {
+		return fmt.Errorf("SQL writer is not set up")
+	}
+
+	items := payload.Items()
+
+	if len(items) == 0 {
+		return nil
+	}
+
+	ctx, cancel := s.Context()
+	defer cancel()
+
+	// all items of a payload are written in a single transaction
+	tx, err := s.DB.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(items); i += s.MaxBatchSize {
+		end := i + s.MaxBatchSize
+		if end > len(items) {
+			end = len(items)
+		}
+		statement, args, err := s.Statement(items[i:end])
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, statement, args...); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,207 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+The syslog writer sends items as syslog messages (see SyslogMessage) via
+UDP, TCP or TLS. Missing fields are filled in from the writer's defaults.
+With TCP and TLS, a failed write is retried once on a new connection, so
+messages can be delivered twice.
+*/
+type SyslogWriter struct {
+	Address  string
+	Protocol string
+	Format   string
+	Framing  string
+	Defaults SyslogMessage
+	CertFile string
+	KeyFile  string
+	CAFile   string
+	Timeout  time.Duration
+	conn     net.Conn
+	mutex    sync.Mutex
+}
+
+func MakeSyslogWriter(config map[string]interface{}) (kodex.Writer, error) {
+	if params, err := SyslogWriterForm.Validate(config); err != nil {
+		return nil, err
+	} else {
+		hostname := params["hostname"].(string)
+		if hostname == "" {
+			if hostname, err = os.Hostname(); err != nil {
+				return nil, err
+			}
+		}
+		writer := &SyslogWriter{
+			Address:  params["address"].(string),
+			Protocol: params["protocol"].(string),
+			Format:   params["format"].(string),
+			Framing:  params["framing"].(string),
+			Defaults: SyslogMessage{
+				Hostname: hostname,
+				App:      params["app"].(string),
+				Facility: int(params["facility"].(int64)),
+				Severity: int(params["severity"].(int64)),
+			},
+			CertFile: params["cert-file"].(string),
+			KeyFile:  params["key-file"].(string),
+			CAFile:   params["ca-file"].(string),
+			Timeout:  time.Duration(params["timeout"].(int64)) * time.Second,
+		}
+		if writer.Protocol != "tls" && (writer.CertFile != "" || writer.CAFile != "") {
+			return nil, fmt.Errorf("certificates can only be used with the 'tls' protocol")
+		}
+		return writer, nil
+	}
+}
+
+func (s *SyslogWriter) connect() error {
+
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+
+	var conn net.Conn
+	var err error
+
+	switch s.Protocol {
+	case "tls":
+		var tlsConfig *tls.Config
+		if tlsConfig, err = ClientTLSConfig(s.CertFile, s.KeyFile, s.CAFile); err != nil {
+			return err
+		}
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: s.Timeout}, "tcp", s.Address, tlsConfig)
+	default:
+		conn, err = net.DialTimeout(s.Protocol, s.Address, s.Timeout)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	s.conn = conn
+
+	return nil
+}
+
+func (s *SyslogWriter) Setup(config kodex.Config) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conn != nil {
+		return nil
+	}
+	return s.connect()
+}
+
+func (s *SyslogWriter) Teardown() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// Frames a message for TCP and TLS (RFC 6587)
+func (s *SyslogWriter) frame(message []byte) []byte {
+	if s.Framing == "newline" {
+		// messages must not contain the delimiter
+		return append(bytes.ReplaceAll(message, []byte("\n"), []byte(" ")), '\n')
+	}
+	return append([]byte(fmt.Sprintf("%d ", len(message))), message...)
+}
+
+func (s *SyslogWriter) send(messages [][]byte) error {
+
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+
+	if err := s.conn.SetWriteDeadline(time.Now().Add(s.Timeout)); err != nil {
+		return err
+	}
+
+	if s.Protocol == "udp" {
+		// every message is sent as a single datagram
+		for _, message := range messages {
+			if _, err := s.conn.Write(message); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var buffer bytes.Buffer
+
+	for _, message := range messages {
+		buffer.Write(s.frame(message))
+	}
+
+	_, err := s.conn.Write(buffer.Bytes())
+
+	return err
+}
+
+func (s *SyslogWriter) Write(payload kodex.Payload) error {
+
+	messages := make([][]byte, 0, len(payload.Items()))
+
+	for _, item := range payload.Items() {
+		message, err := MakeSyslogMessage(item, &s.Defaults)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, message.Format(s.Format))
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.send(messages); err != nil {
+		if s.Protocol == "udp" {
+			return err
+		}
+		// the connection might have been closed by the server, so we retry
+		// once with a new connection
+		kodex.Log.Warningf("Cannot send syslog messages (%v), reconnecting...", err)
+		if err := s.connect(); err != nil {
+			return err
+		}
+		return s.send(messages)
+	}
+
+	return nil
+}
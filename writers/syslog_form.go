@@ -0,0 +1,120 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers
+
+import (
+	"github.com/kiprotect/go-helpers/forms"
+)
+
+var SyslogWriterForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the syslog writer form",
+	Fields: []forms.Field{
+		{
+			// the address of the syslog server (e.g. 'logs.example.com:514')
+			Name: "address",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{MinLength: 1},
+			},
+		},
+		{
+			Name: "protocol",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "udp"},
+				forms.IsIn{Choices: []interface{}{"udp", "tcp", "tls"}},
+			},
+		},
+		{
+			Name: "format",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: RFC5424},
+				forms.IsIn{Choices: []interface{}{RFC5424, RFC3164}},
+			},
+		},
+		{
+			// how messages are delimited with TCP and TLS (RFC 6587)
+			Name: "framing",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "octet-counting"},
+				forms.IsIn{Choices: []interface{}{"octet-counting", "newline"}},
+			},
+		},
+		{
+			// used for items without a hostname (defaults to the local hostname)
+			Name: "hostname",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// used for items without an app name
+			Name: "app",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "kodex"},
+				forms.IsString{},
+			},
+		},
+		{
+			// used for items without a facility (1 = user-level messages)
+			Name: "facility",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(1)},
+				forms.IsInteger{HasMin: true, Min: 0, HasMax: true, Max: 23},
+			},
+		},
+		{
+			// used for items without a severity (6 = informational)
+			Name: "severity",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(6)},
+				forms.IsInteger{HasMin: true, Min: 0, HasMax: true, Max: 7},
+			},
+		},
+		{
+			// client certificate for TLS (optional)
+			Name: "cert-file",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "key-file",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// CA for verifying the server certificate (system CAs are used otherwise)
+			Name: "ca-file",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// timeout for connecting and sending (in seconds)
+			Name: "timeout",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: int64(30)},
+				forms.IsInteger{HasMin: true, Min: 1, HasMax: true, Max: 3600},
+			},
+		},
+	},
+}
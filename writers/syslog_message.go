@@ -0,0 +1,526 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers
+
+import (
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Syslog message formats
+const (
+	RFC5424 = "rfc5424"
+	RFC3164 = "rfc3164"
+)
+
+const (
+	rfc5424Time = "2006-01-02T15:04:05.999999Z07:00"
+	rfc3164Time = "Jan _2 15:04:05"
+)
+
+/*
+A syslog message. As an item, a message consists of the fields 'facility',
+'severity', 'timestamp' (RFC 3339), 'hostname', 'app', 'procid', 'msgid',
+'structured_data' (a map of SD-IDs to maps of parameters) and 'msg'. Empty
+fields are omitted.
+*/
+type SyslogMessage struct {
+	Facility       int
+	Severity       int
+	Timestamp      time.Time
+	Hostname       string
+	App            string
+	ProcID         string
+	MsgID          string
+	StructuredData map[string]map[string]string
+	Message        string
+}
+
+// Parses a message in RFC 5424 or RFC 3164 format (which is detected
+// automatically). RFC 3164 timestamps don't contain a year, we assume that
+// they lie in the past.
+func ParseSyslogMessage(data []byte) (*SyslogMessage, error) {
+
+	str := strings.TrimRight(string(data), "\r\n\x00")
+
+	if !strings.HasPrefix(str, "<") {
+		return nil, fmt.Errorf("syslog message does not start with a priority")
+	}
+
+	end := strings.Index(str, ">")
+
+	if end < 2 || end > 4 {
+		return nil, fmt.Errorf("invalid syslog priority")
+	}
+
+	priority, err := strconv.Atoi(str[1:end])
+
+	if err != nil || priority < 0 || priority > 191 {
+		return nil, fmt.Errorf("invalid syslog priority")
+	}
+
+	message := &SyslogMessage{
+		Facility: priority / 8,
+		Severity: priority % 8,
+	}
+
+	rest := str[end+1:]
+
+	if strings.HasPrefix(rest, "1 ") {
+		err = message.parseRFC5424(rest[2:])
+	} else {
+		message.parseRFC3164(rest, time.Now())
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// Returns the next space-delimited field of an RFC 5424 header
+func nextField(str string) (string, string, error) {
+	i := strings.Index(str, " ")
+	if i <= 0 {
+		return "", "", fmt.Errorf("incomplete RFC 5424 header")
+	}
+	if str[:i] == "-" {
+		return "", str[i+1:], nil
+	}
+	return str[:i], str[i+1:], nil
+}
+
+func (m *SyslogMessage) parseRFC5424(str string) error {
+
+	var timestamp string
+	var err error
+
+	fields := []*string{&timestamp, &m.Hostname, &m.App, &m.ProcID, &m.MsgID}
+
+	for _, field := range fields {
+		if *field, str, err = nextField(str); err != nil {
+			return err
+		}
+	}
+
+	if timestamp != "" {
+		if m.Timestamp, err = time.Parse(time.RFC3339Nano, timestamp); err != nil {
+			return fmt.Errorf("invalid RFC 5424 timestamp: %v", err)
+		}
+	}
+
+	if str, err = m.parseStructuredData(str); err != nil {
+		return err
+	}
+
+	if str != "" {
+		if str[0] != ' ' {
+			return fmt.Errorf("invalid RFC 5424 structured data")
+		}
+		// the message can start with a UTF-8 byte order mark
+		m.Message = strings.TrimPrefix(str[1:], "\ufeff")
+	}
+
+	return nil
+}
+
+// Parses structured data (e.g. '[id key="value"][id2 ...]') and returns
+// the rest of the string
+func (m *SyslogMessage) parseStructuredData(str string) (string, error) {
+
+	if strings.HasPrefix(str, "-") {
+		return str[1:], nil
+	}
+
+	invalid := fmt.Errorf("invalid RFC 5424 structured data")
+
+	for strings.HasPrefix(str, "[") {
+
+		end := strings.IndexAny(str, " ]")
+
+		if end <= 1 {
+			return "", invalid
+		}
+
+		id := str[1:end]
+		params := make(map[string]string)
+		str = str[end:]
+
+		for strings.HasPrefix(str, " ") {
+
+			eq := strings.Index(str, "=\"")
+
+			if eq <= 1 {
+				return "", invalid
+			}
+
+			name := str[1:eq]
+			str = str[eq+2:]
+
+			var value strings.Builder
+			closed := false
+
+			for i := 0; i < len(str); i++ {
+				if str[i] == '\\' && i+1 < len(str) && strings.IndexByte("\"\\]", str[i+1]) != -1 {
+					value.WriteByte(str[i+1])
+					i++
+				} else if str[i] == '"' {
+					str = str[i+1:]
+					closed = true
+					break
+				} else {
+					value.WriteByte(str[i])
+				}
+			}
+
+			if !closed {
+				return "", invalid
+			}
+
+			params[name] = value.String()
+		}
+
+		if !strings.HasPrefix(str, "]") {
+			return "", invalid
+		}
+
+		str = str[1:]
+
+		if m.StructuredData == nil {
+			m.StructuredData = make(map[string]map[string]string)
+		}
+
+		m.StructuredData[id] = params
+	}
+
+	if m.StructuredData == nil {
+		return "", invalid
+	}
+
+	return str, nil
+}
+
+// RFC 3164 only describes existing practice, so we parse messages leniently:
+// the timestamp, hostname and tag are optional.
+func (m *SyslogMessage) parseRFC3164(str string, now time.Time) {
+
+	hasTimestamp := false
+
+	if len(str) >= len(rfc3164Time) {
+		if timestamp, err := time.ParseInLocation(rfc3164Time, str[:len(rfc3164Time)], now.Location()); err == nil {
+			m.Timestamp = timestamp.AddDate(now.Year(), 0, 0)
+			// timestamps shouldn't lie in the future
+			if m.Timestamp.After(now.Add(24 * time.Hour)) {
+				m.Timestamp = m.Timestamp.AddDate(-1, 0, 0)
+			}
+			str = strings.TrimPrefix(str[len(rfc3164Time):], " ")
+			hasTimestamp = true
+		}
+	}
+
+	if !hasTimestamp {
+		// some senders use RFC 3339 timestamps instead
+		if i := strings.Index(str, " "); i > 0 {
+			if timestamp, err := time.Parse(time.RFC3339Nano, str[:i]); err == nil {
+				m.Timestamp = timestamp
+				str = str[i+1:]
+				hasTimestamp = true
+			}
+		}
+	}
+
+	// without a timestamp, there's no hostname either
+	if hasTimestamp && !isSyslogTag(firstWord(str)) {
+		if i := strings.Index(str, " "); i > 0 {
+			m.Hostname = str[:i]
+			str = str[i+1:]
+		}
+	}
+
+	if tag := firstWord(str); isSyslogTag(tag) {
+		tag = strings.TrimSuffix(tag, ":")
+		if i := strings.Index(tag, "["); i > 0 {
+			m.ProcID = strings.TrimSuffix(tag[i+1:], "]")
+			tag = tag[:i]
+		}
+		m.App = tag
+		str = strings.TrimPrefix(str[len(firstWord(str)):], " ")
+	}
+
+	m.Message = str
+}
+
+func firstWord(str string) string {
+	if i := strings.Index(str, " "); i >= 0 {
+		return str[:i]
+	}
+	return str
+}
+
+// Tags look like 'app:' or 'app[pid]:'
+func isSyslogTag(word string) bool {
+	if !strings.HasSuffix(word, ":") || len(word) < 2 {
+		return false
+	}
+	word = strings.TrimSuffix(word, ":")
+	if i := strings.Index(word, "["); i >= 0 {
+		return i > 0 && strings.HasSuffix(word, "]")
+	}
+	return !strings.ContainsAny(word, "[]")
+}
+
+func (m *SyslogMessage) Item() *kodex.Item {
+
+	values := map[string]interface{}{
+		"facility": int64(m.Facility),
+		"severity": int64(m.Severity),
+	}
+
+	if !m.Timestamp.IsZero() {
+		values["timestamp"] = m.Timestamp.Format(time.RFC3339Nano)
+	}
+
+	for key, value := range map[string]string{
+		"hostname": m.Hostname,
+		"app":      m.App,
+		"procid":   m.ProcID,
+		"msgid":    m.MsgID,
+		"msg":      m.Message,
+	} {
+		if value != "" {
+			values[key] = value
+		}
+	}
+
+	if len(m.StructuredData) > 0 {
+		structuredData := make(map[string]interface{}, len(m.StructuredData))
+		for id, params := range m.StructuredData {
+			sdParams := make(map[string]interface{}, len(params))
+			for name, value := range params {
+				sdParams[name] = value
+			}
+			structuredData[id] = sdParams
+		}
+		values["structured_data"] = structuredData
+	}
+
+	return kodex.MakeItem(values)
+}
+
+func syslogString(item *kodex.Item, field string) (string, error) {
+	value, ok := item.Get(field)
+	if !ok || value == nil {
+		return "", nil
+	}
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case int, int64, float64, bool:
+		return fmt.Sprint(v), nil
+	}
+	return "", fmt.Errorf("syslog field '%s' must be a string", field)
+}
+
+func syslogInt(item *kodex.Item, field string, defaultValue, max int) (int, error) {
+	value, ok := item.Get(field)
+	if !ok || value == nil {
+		return defaultValue, nil
+	}
+	var i int
+	switch v := value.(type) {
+	case int:
+		i = v
+	case int64:
+		i = int(v)
+	case float64:
+		i = int(v)
+	case string:
+		var err error
+		if i, err = strconv.Atoi(v); err != nil {
+			return 0, fmt.Errorf("syslog field '%s' must be a number", field)
+		}
+	default:
+		return 0, fmt.Errorf("syslog field '%s' must be a number", field)
+	}
+	if i < 0 || i > max {
+		return 0, fmt.Errorf("syslog field '%s' is out of range", field)
+	}
+	return i, nil
+}
+
+// Makes a message from an item, using the given message for default values
+func MakeSyslogMessage(item *kodex.Item, defaults *SyslogMessage) (*SyslogMessage, error) {
+
+	var err error
+
+	message := &SyslogMessage{}
+
+	if message.Facility, err = syslogInt(item, "facility", defaults.Facility, 23); err != nil {
+		return nil, err
+	}
+
+	if message.Severity, err = syslogInt(item, "severity", defaults.Severity, 7); err != nil {
+		return nil, err
+	}
+
+	fields := map[string]*string{
+		"hostname": &message.Hostname,
+		"app":      &message.App,
+		"procid":   &message.ProcID,
+		"msgid":    &message.MsgID,
+		"msg":      &message.Message,
+	}
+
+	for field, value := range fields {
+		if *value, err = syslogString(item, field); err != nil {
+			return nil, err
+		}
+	}
+
+	if message.Hostname == "" {
+		message.Hostname = defaults.Hostname
+	}
+
+	if message.App == "" {
+		message.App = defaults.App
+	}
+
+	if timestamp, err := syslogString(item, "timestamp"); err != nil {
+		return nil, err
+	} else if timestamp != "" {
+		if message.Timestamp, err = time.Parse(time.RFC3339Nano, timestamp); err != nil {
+			return nil, fmt.Errorf("invalid syslog timestamp: %v", err)
+		}
+	} else {
+		message.Timestamp = time.Now()
+	}
+
+	if value, ok := item.Get("structured_data"); ok && value != nil {
+		structuredData, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("syslog field 'structured_data' must be a map")
+		}
+		message.StructuredData = make(map[string]map[string]string, len(structuredData))
+		for id, params := range structuredData {
+			paramsMap, ok := params.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("structured data element '%s' must be a map", id)
+			}
+			message.StructuredData[id] = make(map[string]string, len(paramsMap))
+			for name, value := range paramsMap {
+				message.StructuredData[id][name] = fmt.Sprint(value)
+			}
+		}
+	}
+
+	return message, nil
+}
+
+// Header fields and SD names may only contain printable ASCII characters
+// (without spaces) and have a maximum length
+func syslogHeaderField(value string, maxLength int, exclude string) string {
+	if value == "" {
+		return "-"
+	}
+	field := []byte(value)
+	for i, c := range field {
+		if c < 33 || c > 126 || strings.IndexByte(exclude, c) != -1 {
+			field[i] = '_'
+		}
+	}
+	if len(field) > maxLength {
+		field = field[:maxLength]
+	}
+	return string(field)
+}
+
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+func (m *SyslogMessage) priority() int {
+	return m.Facility*8 + m.Severity
+}
+
+// Formats the message in the given format. Structured data can't be
+// represented in the RFC 3164 format and is dropped.
+func (m *SyslogMessage) Format(format string) []byte {
+
+	var builder strings.Builder
+
+	if format == RFC3164 {
+
+		fmt.Fprintf(&builder, "<%d>%s %s ", m.priority(), m.Timestamp.Format(rfc3164Time), syslogHeaderField(m.Hostname, 255, ""))
+
+		if m.App != "" {
+			builder.WriteString(syslogHeaderField(m.App, 32, "[]:"))
+			if m.ProcID != "" {
+				fmt.Fprintf(&builder, "[%s]", syslogHeaderField(m.ProcID, 128, "[]"))
+			}
+			builder.WriteString(": ")
+		}
+
+		builder.WriteString(m.Message)
+
+		return []byte(builder.String())
+	}
+
+	timestamp := "-"
+
+	if !m.Timestamp.IsZero() {
+		timestamp = m.Timestamp.Format(rfc5424Time)
+	}
+
+	fmt.Fprintf(&builder, "<%d>1 %s %s %s %s %s ", m.priority(), timestamp,
+		syslogHeaderField(m.Hostname, 255, ""),
+		syslogHeaderField(m.App, 48, ""),
+		syslogHeaderField(m.ProcID, 128, ""),
+		syslogHeaderField(m.MsgID, 32, ""))
+
+	if len(m.StructuredData) == 0 {
+		builder.WriteString("-")
+	} else {
+		ids := make([]string, 0, len(m.StructuredData))
+		for id := range m.StructuredData {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			params := m.StructuredData[id]
+			names := make([]string, 0, len(params))
+			for name := range params {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			fmt.Fprintf(&builder, "[%s", syslogHeaderField(id, 32, `= ]"`))
+			for _, name := range names {
+				fmt.Fprintf(&builder, ` %s="%s"`, syslogHeaderField(name, 32, `= ]"`), sdEscaper.Replace(params[name]))
+			}
+			builder.WriteString("]")
+		}
+	}
+
+	if m.Message != "" {
+		builder.WriteString(" ")
+		builder.WriteString(m.Message)
+	}
+
+	return []byte(builder.String())
+}
@@ -0,0 +1,131 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers
+
+import (
+	"fmt"
+	"github.com/kiprotect/kodex"
+)
+
+// Writes rows of typed formats such as Parquet or Avro
+type rowWriter interface {
+	setup(Schema) error
+	writeRow([]interface{}) error
+	close() error
+}
+
+// Functionality shared by the encoders of typed formats. If no schema is
+// declared, items are held back until the first flush and the schema is
+// inferred from them.
+type typedEncoder struct {
+	Schema  Schema
+	rows    rowWriter
+	pending []*kodex.Item
+	started bool
+	size    int
+}
+
+func valueSize(value interface{}) int {
+	switch v := value.(type) {
+	case string:
+		return len(v)
+	case bool:
+		return 1
+	default:
+		return 8
+	}
+}
+
+func (t *typedEncoder) Encode(item *kodex.Item) error {
+
+	for _, value := range item.All() {
+		t.size += valueSize(value)
+	}
+
+	if !t.started {
+		if t.Schema == nil {
+			t.pending = append(t.pending, item)
+			return nil
+		}
+		if err := t.start(); err != nil {
+			return err
+		}
+	}
+
+	return t.write(item)
+}
+
+func (t *typedEncoder) start() error {
+
+	if t.Schema == nil {
+		schema, err := InferSchema(t.pending)
+		if err != nil {
+			return err
+		}
+		t.Schema = schema
+	}
+
+	if err := t.rows.setup(t.Schema); err != nil {
+		return err
+	}
+
+	t.started = true
+	pending := t.pending
+	t.pending = nil
+
+	for _, item := range pending {
+		if err := t.write(item); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *typedEncoder) write(item *kodex.Item) error {
+	values, err := t.Schema.Values(item)
+	if err != nil {
+		return fmt.Errorf("item does not match the schema: %v", err)
+	}
+	return t.rows.writeRow(values)
+}
+
+func (t *typedEncoder) Flush() error {
+	if !t.started && len(t.pending) > 0 {
+		return t.start()
+	}
+	return nil
+}
+
+func (t *typedEncoder) Close() error {
+	if !t.started && t.Schema != nil {
+		// we write an empty file with the declared schema
+		if err := t.start(); err != nil {
+			return err
+		}
+	} else if err := t.Flush(); err != nil {
+		return err
+	}
+	if !t.started {
+		return nil
+	}
+	return t.rows.close()
+}
+
+func (t *typedEncoder) Size() int {
+	return t.size
+}
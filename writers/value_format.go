@@ -0,0 +1,144 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2022  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/kodex"
+)
+
+var ValueFormatFields = []forms.Field{
+	{
+		Name: "format",
+		Validators: []forms.Validator{
+			forms.IsOptional{Default: "json"},
+			forms.IsIn{Choices: []interface{}{"json", "csv"}},
+		},
+	},
+	{
+		// the columns of CSV values (required for the CSV format)
+		Name: "csv-columns",
+		Validators: []forms.Validator{
+			forms.IsOptional{Default: []interface{}{}},
+			forms.IsList{
+				Validators: []forms.Validator{
+					forms.IsString{},
+				},
+			},
+		},
+	},
+}
+
+// Serializes items as individual message values (e.g. Kafka records or MQTT
+// messages) and parses them again.
+type ValueFormat struct {
+	Format     string
+	CSVColumns []string
+}
+
+func toStringList(values []interface{}) []string {
+	strings := make([]string, len(values))
+	for i, value := range values {
+		strings[i] = value.(string)
+	}
+	return strings
+}
+
+func MakeValueFormat(params map[string]interface{}) (ValueFormat, error) {
+
+	format := ValueFormat{
+		Format:     params["format"].(string),
+		CSVColumns: toStringList(params["csv-columns"].([]interface{})),
+	}
+
+	if format.Format == "csv" && len(format.CSVColumns) == 0 {
+		return format, fmt.Errorf("the CSV format requires a list of CSV columns")
+	}
+
+	return format, nil
+}
+
+// Serializes an item as a message value
+func (v *ValueFormat) SerializeItem(item *kodex.Item) ([]byte, error) {
+	switch v.Format {
+	case "csv":
+		record := make([]string, len(v.CSVColumns))
+		for i, column := range v.CSVColumns {
+			if value, ok := item.Get(column); ok && value != nil {
+				if str, ok := value.(string); ok {
+					record[i] = str
+				} else {
+					record[i] = fmt.Sprint(value)
+				}
+			}
+		}
+		buf := &bytes.Buffer{}
+		writer := csv.NewWriter(buf)
+		if err := writer.Write(record); err != nil {
+			return nil, err
+		}
+		writer.Flush()
+		return bytes.TrimRight(buf.Bytes(), "\r\n"), writer.Error()
+	default:
+		return item.Serialize(v.Format)
+	}
+}
+
+// Parses items from a message value. JSON values can contain a single
+// object or a list of objects, CSV values one or more lines.
+func (v *ValueFormat) ParseValue(value []byte) ([]*kodex.Item, error) {
+	switch v.Format {
+	case "csv":
+		reader := csv.NewReader(bytes.NewReader(value))
+		reader.FieldsPerRecord = len(v.CSVColumns)
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		items := make([]*kodex.Item, len(records))
+		for i, record := range records {
+			itemMap := make(map[string]interface{}, len(v.CSVColumns))
+			for j, column := range v.CSVColumns {
+				itemMap[column] = record[j]
+			}
+			items[i] = kodex.MakeItem(itemMap)
+		}
+		return items, nil
+	default:
+		trimmed := bytes.TrimSpace(value)
+		if len(trimmed) > 0 && trimmed[0] == '[' {
+			itemMaps := make([]map[string]interface{}, 0)
+			if err := json.Unmarshal(trimmed, &itemMaps); err != nil {
+				return nil, err
+			}
+			items := make([]*kodex.Item, len(itemMaps))
+			for i, itemMap := range itemMaps {
+				items[i] = kodex.MakeItem(itemMap)
+			}
+			return items, nil
+		}
+		itemMap := make(map[string]interface{})
+		if err := json.Unmarshal(trimmed, &itemMap); err != nil {
+			return nil, err
+		}
+		return []*kodex.Item{kodex.MakeItem(itemMap)}, nil
+	}
+}
@@ -53,4 +53,44 @@ var Writers = kodex.WriterDefinitions{
 		Form:     CountWriterForm,
 		Internal: true,
 	},
+	"kafka": kodex.WriterDefinition{
+		Maker:    MakeKafkaWriter,
+		Form:     KafkaWriterForm,
+		Internal: false,
+	},
+	"mqtt": kodex.WriterDefinition{
+		Maker:    MakeMQTTWriter,
+		Form:     MQTTWriterForm,
+		Internal: false,
+	},
+	"sql": kodex.WriterDefinition{
+		Maker:    MakeSQLWriter,
+		Form:     SQLWriterForm,
+		Internal: false,
+	},
+	"s3": kodex.WriterDefinition{
+		Maker:    MakeS3Writer,
+		Form:     S3WriterForm,
+		Internal: false,
+	},
+	"syslog": kodex.WriterDefinition{
+		Maker:    MakeSyslogWriter,
+		Form:     SyslogWriterForm,
+		Internal: false,
+	},
+	"log": kodex.WriterDefinition{
+		Maker:    MakeLogWriter,
+		Form:     LogWriterForm,
+		Internal: true,
+	},
+	"redis": kodex.WriterDefinition{
+		Maker:    MakeRedisWriter,
+		Form:     RedisWriterForm,
+		Internal: false,
+	},
+	"stream": kodex.WriterDefinition{
+		Maker:    kodex.MakeStreamWriter,
+		Form:     kodex.StreamWriterForm,
+		Internal: false,
+	},
 }